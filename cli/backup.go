@@ -7,7 +7,11 @@ import (
 )
 
 func BackupCmd(d daemon.Daemon) *cobra.Command {
-	var instanceId string
+	var (
+		instanceId string
+		force      bool
+		exclude    []string
+	)
 	cmd := cobra.Command{
 		Use:   "backup <instance-id>",
 		Short: "Backup an instance",
@@ -17,7 +21,10 @@ func BackupCmd(d daemon.Daemon) *cobra.Command {
 			instanceId = args[0]
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			backupId, err := d.Backup(instanceId)
+			backupId, err := d.BackupWithOptions(instanceId, daemon.BackupOptions{
+				Force:   force,
+				Exclude: exclude,
+			})
 			if err != nil {
 				return err
 			}
@@ -25,6 +32,8 @@ func BackupCmd(d daemon.Daemon) *cobra.Command {
 			return nil
 		},
 	}
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "backup the instance even if it is locked by another process. The backup may be inconsistent.")
+	cmd.Flags().StringSliceVarP(&exclude, "exclude", "e", nil, "pattern of the instance data paths to leave out of the backup, relative to the instance directory. Volumes are always backed up whole. Can be specified multiple times")
 
 	// Add ls subcommand
 	lsCmd := BackupLsCmd(d)
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/NethermindEth/eigenlayer/cli/mocks"
+	"github.com/NethermindEth/eigenlayer/pkg/daemon"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackup(t *testing.T) {
+	ts := []struct {
+		name   string
+		args   []string
+		err    error
+		mocker func(d *mocks.MockDaemon)
+	}{
+		{
+			name: "no arguments",
+			args: []string{},
+			err:  errors.New("requires at least 1 arg(s), only received 0"),
+		},
+		{
+			name: "backup",
+			args: []string{"mock-avs-default"},
+			mocker: func(d *mocks.MockDaemon) {
+				d.EXPECT().BackupWithOptions("mock-avs-default", daemon.BackupOptions{}).Return("backup-id", nil)
+			},
+		},
+		{
+			name: "forced backup",
+			args: []string{"mock-avs-default", "--force"},
+			mocker: func(d *mocks.MockDaemon) {
+				d.EXPECT().BackupWithOptions("mock-avs-default", daemon.BackupOptions{Force: true}).Return("backup-id", nil)
+			},
+		},
+		{
+			name: "backup with excluded paths",
+			args: []string{"mock-avs-default", "--exclude", "logs", "-e", "*.tmp"},
+			mocker: func(d *mocks.MockDaemon) {
+				d.EXPECT().BackupWithOptions("mock-avs-default", daemon.BackupOptions{Exclude: []string{"logs", "*.tmp"}}).Return("backup-id", nil)
+			},
+		},
+		{
+			name: "backup error",
+			args: []string{"mock-avs-default"},
+			err:  errors.New("backup error"),
+			mocker: func(d *mocks.MockDaemon) {
+				d.EXPECT().BackupWithOptions("mock-avs-default", daemon.BackupOptions{}).Return("", errors.New("backup error"))
+			},
+		},
+	}
+	for _, tt := range ts {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := gomock.NewController(t)
+			d := mocks.NewMockDaemon(controller)
+			if tt.mocker != nil {
+				tt.mocker(d)
+			}
+
+			backupCmd := BackupCmd(d)
+			backupCmd.SetArgs(tt.args)
+			err := backupCmd.Execute()
+
+			if tt.err != nil {
+				assert.EqualError(t, err, tt.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -1,15 +1,17 @@
 package backup
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"time"
 
-	"github.com/NethermindEth/docker-volumes-snapshotter/pkg/backuptar"
 	"github.com/NethermindEth/eigenlayer/internal/compose"
 	"github.com/NethermindEth/eigenlayer/internal/data"
 	"github.com/NethermindEth/eigenlayer/internal/docker"
+	"github.com/NethermindEth/eigenlayer/internal/utils"
 	"github.com/compose-spec/compose-go/types"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
@@ -33,6 +35,8 @@ type BackupManager struct {
 	dockerMgr  *docker.DockerManager
 	composeMgr *compose.ComposeManager
 	fs         afero.Fs
+	force      bool
+	exclude    []string
 }
 
 func NewBackupManager(fs afero.Fs, dataDir *data.DataDir, dockerMgr *docker.DockerManager, composeMgr *compose.ComposeManager) *BackupManager {
@@ -44,10 +48,37 @@ func NewBackupManager(fs afero.Fs, dataDir *data.DataDir, dockerMgr *docker.Dock
 	}
 }
 
+// SetForce sets whether instances locked by another process are backed up
+// anyway, with a warning, instead of failing with data.ErrInstanceBusy.
+func (b *BackupManager) SetForce(force bool) {
+	b.force = force
+}
+
+// SetExclude sets the patterns of the instance data paths left out of the
+// backups, relative to the instance directory. See utils.ExcludedPath for
+// their syntax. Volumes are always backed up whole. Malformed patterns are
+// rejected with an error wrapping filepath.ErrBadPattern, leaving the previous
+// patterns in place.
+func (b *BackupManager) SetExclude(patterns []string) error {
+	if err := utils.ValidateExcludePatterns(patterns); err != nil {
+		return err
+	}
+	b.exclude = patterns
+	return nil
+}
+
 // BackupInstance creates a backup of the instance with the given ID.
 func (b *BackupManager) BackupInstance(instanceId string) (string, error) {
+	return b.BackupInstanceContext(context.Background(), instanceId)
+}
+
+// BackupInstanceContext is like BackupInstance, but aborts between backup
+// steps, and between the entries of the instance directory, once ctx is done,
+// returning the context error. The partial backup is removed if the backup
+// fails.
+func (b *BackupManager) BackupInstanceContext(ctx context.Context, instanceId string) (backupId string, err error) {
 	if !b.dataDir.HasInstance(instanceId) {
-		return "", fmt.Errorf("%w: instance %s", data.ErrInstanceNotFound, instanceId)
+		return "", &data.InstanceNotFoundError{Id: instanceId}
 	}
 	instance, err := b.dataDir.Instance(instanceId)
 	if err != nil {
@@ -57,10 +88,6 @@ func (b *BackupManager) BackupInstance(instanceId string) (string, error) {
 	if err := b.buildSnapshotterImage(); err != nil {
 		return "", err
 	}
-	instanceProject, err := instance.ComposeProject()
-	if err != nil {
-		return "", err
-	}
 
 	backup := &data.Backup{
 		InstanceId: instanceId,
@@ -70,13 +97,57 @@ func (b *BackupManager) BackupInstance(instanceId string) (string, error) {
 		Url:        instance.URL,
 	}
 
-	err = b.dataDir.InitBackup(backup)
+	if b.force {
+		err = b.dataDir.InitBackupForce(backup)
+	} else {
+		err = b.dataDir.InitBackup(backup)
+	}
 	if err != nil {
 		return "", err
 	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		err = data.WrapDiskFull(err)
+		if removeErr := b.dataDir.RemoveBackup(backup.Id()); removeErr != nil {
+			log.Warnf("Failed to remove partial backup %s: %v", backup.Id(), removeErr)
+		}
+	}()
+
+	// The instance of a forced backup may be locked by another process, so
+	// its project is read without waiting for the instance lock.
+	var instanceProject *types.Project
+	if b.force {
+		instanceProject, err = instance.ComposeProjectUnlocked()
+	} else {
+		instanceProject, err = instance.ComposeProject()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Hold a shared lock on the instance during the backup, so its state
+	// doesn't change while being backed up, while readers are not blocked.
+	// Forced backups are meant for instances locked by another process, so
+	// they don't wait for the lock.
+	if !b.force {
+		l, lockErr := b.dataDir.RLockInstance(ctx, instanceId)
+		if lockErr != nil {
+			return "", lockErr
+		}
+		defer func() {
+			if unlockErr := l.Unlock(); err == nil {
+				err = unlockErr
+			}
+		}()
+	}
 
 	// Add volumes of each service
 	for _, service := range instanceProject.Services {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
 		err := b.backupInstanceServiceVolumes(service, backup)
 		if err != nil {
 			return "", err
@@ -84,21 +155,42 @@ func (b *BackupManager) BackupInstance(instanceId string) (string, error) {
 	}
 
 	// Add instance data
-	err = b.backupInstanceData(instanceId, backup)
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	err = b.backupInstanceData(ctx, instanceId, backup)
 	if err != nil {
 		return "", err
 	}
 
 	// Add timestamp
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	err = b.addTimestamp(backup)
 	if err != nil {
 		return "", err
 	}
 
+	// Write manifest
+	err = b.dataDir.WriteBackupManifest(backup)
+	if err != nil {
+		return "", err
+	}
+
 	return backup.Id(), nil
 }
 
+// RestoreInstance restores the backup with the given ID.
 func (b *BackupManager) RestoreInstance(backupId string) error {
+	return b.RestoreInstanceContext(context.Background(), backupId)
+}
+
+// RestoreInstanceContext is like RestoreInstance, but aborts between restore
+// steps, and between the entries of the instance directory, once ctx is done,
+// returning the context error. An aborted restore leaves the instance
+// directory as it was.
+func (b *BackupManager) RestoreInstanceContext(ctx context.Context, backupId string) (err error) {
 	backup, err := b.dataDir.Backup(backupId)
 	if err != nil {
 		return err
@@ -106,13 +198,27 @@ func (b *BackupManager) RestoreInstance(backupId string) error {
 
 	log.Infof("Restoring backup INSTANCE_ID: %s, VERSION: %s, COMMIT: %s", backup.InstanceId, backup.Version, backup.Commit)
 
-	backupPath := b.dataDir.BackupPath(backup.Id())
+	backupPath, release, err := b.dataDir.LocalBackupPath(backup.Id())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		releaseErr := release()
+		if err == nil {
+			err = releaseErr
+		}
+	}()
+
+	err = b.checkManifest(backup)
 	if err != nil {
 		return err
 	}
 
 	// Restore instance data
-	err = b.restoreInstanceData(backup.InstanceId, backupPath)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err = b.restoreInstanceData(ctx, backup.InstanceId, backupPath)
 	if err != nil {
 		return err
 	}
@@ -141,6 +247,9 @@ func (b *BackupManager) RestoreInstance(backupId string) error {
 
 	// Restore volumes of each service
 	for _, service := range instanceProject.Services {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		err := b.restoreInstanceServiceVolumes(service, backupPath)
 		if err != nil {
 			return err
@@ -150,20 +259,13 @@ func (b *BackupManager) RestoreInstance(backupId string) error {
 	return nil
 }
 
-func (b *BackupManager) backupInstanceData(instanceId string, backup *data.Backup) error {
+func (b *BackupManager) backupInstanceData(ctx context.Context, instanceId string, backup *data.Backup) error {
 	log.Info("Backing up instance data...")
-	backupPath := b.dataDir.BackupPath(backup.Id())
-
 	instancePath, err := b.dataDir.InstancePath(instanceId)
 	if err != nil {
 		return err
 	}
-	backupWriter, err := backuptar.NewBackupWriter(backupPath)
-	if err != nil {
-		return err
-	}
-	defer backupWriter.Close()
-	return backupWriter.AddDir(instancePath, filepath.Join("data"))
+	return b.dataDir.AddBackupDirContext(ctx, backup.Id(), instancePath, "data", b.exclude...)
 }
 
 func (b *BackupManager) backupInstanceServiceVolumes(service types.ServiceConfig, backup *data.Backup) (err error) {
@@ -171,7 +273,7 @@ func (b *BackupManager) backupInstanceServiceVolumes(service types.ServiceConfig
 		return nil
 	}
 	log.Infof("Backing up %d volumes from service \"%s\"...", len(service.Volumes), service.Name)
-	backupPath := b.dataDir.BackupPath(backup.Id())
+	backupPath := b.dataDir.BackupWritePath(backup.Id())
 
 	volumes := make([]string, 0, len(service.Volumes))
 	for _, v := range service.Volumes {
@@ -215,7 +317,6 @@ func (b *BackupManager) backupInstanceServiceVolumes(service types.ServiceConfig
 
 func (b *BackupManager) addTimestamp(backup *data.Backup) error {
 	log.Infof("Adding timestamp %s...", backup.Timestamp.Format(time.DateTime))
-	backupPath := b.dataDir.BackupPath(backup.Id())
 
 	timestampTmp, err := afero.TempFile(b.fs, afero.GetTempDir(b.fs, ""), "backup-timestamp-*")
 	if err != nil {
@@ -229,17 +330,40 @@ func (b *BackupManager) addTimestamp(backup *data.Backup) error {
 		return err
 	}
 
-	backupWriter, err := backuptar.NewBackupWriter(backupPath)
+	return b.dataDir.AddBackupFile(backup.Id(), timestampTmp.Name(), "timestamp")
+}
+
+// checkManifest compares the backup against its manifest, logging a warning
+// on any mismatch. Backups without manifest are accepted as they are.
+func (b *BackupManager) checkManifest(backup *data.Backup) error {
+	manifest, err := b.dataDir.BackupManifest(backup.Id())
 	if err != nil {
+		if errors.Is(err, data.ErrBackupManifestNotFound) {
+			log.Debugf("Backup %s has no manifest", backup.Id())
+			return nil
+		}
 		return err
 	}
-	defer backupWriter.Close()
-
-	return backupWriter.AddFile(timestampTmp.Name(), "timestamp")
+	checksum, err := b.dataDir.BackupChecksum(backup.Id())
+	if err != nil {
+		return err
+	}
+	if checksum != manifest.Checksum {
+		log.Warnf("Backup %s checksum does not match its manifest", backup.Id())
+	}
+	instance, err := manifest.Instance()
+	if err != nil {
+		return err
+	}
+	if instance.Version != backup.Version || instance.Commit != backup.Commit {
+		log.Warnf("Backup %s version mismatch: manifest has VERSION: %s, COMMIT: %s but the archive has VERSION: %s, COMMIT: %s",
+			backup.Id(), instance.Version, instance.Commit, backup.Version, backup.Commit)
+	}
+	return nil
 }
 
-func (b *BackupManager) restoreInstanceData(instanceId string, backupPath string) error {
-	return b.dataDir.ReplaceInstanceDirFromTar(instanceId, backupPath, "data")
+func (b *BackupManager) restoreInstanceData(ctx context.Context, instanceId string, backupPath string) error {
+	return b.dataDir.ReplaceInstanceDirFromTarContext(ctx, instanceId, backupPath, "data")
 }
 
 func (b *BackupManager) restoreInstanceServiceVolumes(service types.ServiceConfig, backupPath string) error {
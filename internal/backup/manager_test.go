@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NethermindEth/eigenlayer/internal/compose"
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/internal/docker"
+	"github.com/NethermindEth/eigenlayer/internal/docker/mocks"
+	"github.com/NethermindEth/eigenlayer/internal/locker"
+	"github.com/docker/docker/api/types"
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestBackupManager returns a backup manager of a data dir with a single
+// instance without volumes, so the backups don't need docker.
+func newTestBackupManager(t *testing.T) (*BackupManager, *data.DataDir, string) {
+	t.Helper()
+	fs := afero.NewOsFs()
+	dataDir, err := data.NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	instanceId, err := dataDir.InitInstance(&data.Instance{
+		Name:    "mock-avs",
+		Tag:     "default",
+		URL:     "https://github.com/NethermindEth/mock-avs-pkg",
+		Version: "v5.5.0",
+		Profile: "option-returner",
+	})
+	require.NoError(t, err)
+	instancePath, err := dataDir.InstancePath(instanceId)
+	require.NoError(t, err)
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "docker-compose.yml"), []byte("services:\n  main:\n    image: busybox\n"), 0o644))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, ".env"), nil, 0o644))
+
+	dockerClient := mocks.NewMockAPIClient(gomock.NewController(t))
+	dockerClient.EXPECT().ImageInspectWithRaw(gomock.Any(), SnapshotterImage).Return(types.ImageInspect{}, nil, nil).AnyTimes()
+	backupMgr := NewBackupManager(fs, dataDir, docker.NewDockerManager(dockerClient), compose.NewComposeManager(nil))
+	return backupMgr, dataDir, instanceId
+}
+
+func TestBackupInstanceForce(t *testing.T) {
+	backupMgr, dataDir, instanceId := newTestBackupManager(t)
+	instancePath, err := dataDir.InstancePath(instanceId)
+	require.NoError(t, err)
+
+	// Another process writing to the instance
+	instanceLock := locker.NewFLock().New(filepath.Join(instancePath, ".lock"))
+	require.NoError(t, instanceLock.Lock())
+	defer instanceLock.Unlock()
+
+	_, err = backupMgr.BackupInstance(instanceId)
+	require.ErrorIs(t, err, data.ErrInstanceBusy)
+	backups, err := dataDir.BackupList()
+	require.NoError(t, err)
+	assert.Empty(t, backups)
+
+	backupMgr.SetForce(true)
+	backupId, err := backupMgr.BackupInstance(instanceId)
+	require.NoError(t, err)
+	exists, err := dataDir.HasBackup(backupId)
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestBackupInstanceExclude(t *testing.T) {
+	backupMgr, dataDir, instanceId := newTestBackupManager(t)
+	instancePath, err := dataDir.InstancePath(instanceId)
+	require.NoError(t, err)
+	fs := afero.NewOsFs()
+	require.NoError(t, fs.MkdirAll(filepath.Join(instancePath, "logs"), 0o755))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "logs", "node.log"), []byte("log"), 0o644))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "keep.txt"), []byte("keep"), 0o644))
+
+	// Invalid patterns are rejected, keeping the previous ones
+	require.NoError(t, backupMgr.SetExclude([]string{"logs"}))
+	err = backupMgr.SetExclude([]string{"["})
+	require.ErrorIs(t, err, filepath.ErrBadPattern)
+
+	backupId, err := backupMgr.BackupInstance(instanceId)
+	require.NoError(t, err)
+	f, err := os.Open(dataDir.BackupPath(backupId))
+	require.NoError(t, err)
+	defer f.Close()
+	var names []string
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+	}
+	assert.Contains(t, names, "data/keep.txt")
+	assert.Contains(t, names, "data/docker-compose.yml")
+	assert.NotContains(t, names, "data/logs")
+	assert.NotContains(t, names, "data/logs/node.log")
+}
@@ -0,0 +1,45 @@
+package data
+
+import "time"
+
+// LifecycleInfo is the age information of an instance, for retention and
+// alerting policies. Unknown times are zero.
+type LifecycleInfo struct {
+	// CreatedAt is when the instance was installed.
+	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt is when the configuration of the instance was last written.
+	UpdatedAt time.Time `json:"updated_at"`
+	// LastBackupAt is the timestamp of the newest backup of the instance.
+	LastBackupAt time.Time `json:"last_backup_at"`
+}
+
+// InstanceLifecycle returns the lifecycle information of the instance with the
+// given id. Instances installed before their creation time was recorded have
+// zero CreatedAt and UpdatedAt, and instances without backups a zero
+// LastBackupAt.
+func (d *DataDir) InstanceLifecycle(instanceId string) (LifecycleInfo, error) {
+	if !d.HasInstance(instanceId) {
+		return LifecycleInfo{}, &InstanceNotFoundError{Id: instanceId}
+	}
+	instance, err := d.Instance(instanceId)
+	if err != nil {
+		return LifecycleInfo{}, err
+	}
+	var info LifecycleInfo
+	if instance.CreatedAt != nil {
+		info.CreatedAt = *instance.CreatedAt
+	}
+	if instance.UpdatedAt != nil {
+		info.UpdatedAt = *instance.UpdatedAt
+	}
+	backups, err := d.BackupList()
+	if err != nil {
+		return LifecycleInfo{}, err
+	}
+	for _, b := range backups {
+		if b.InstanceId == instanceId && b.Timestamp.After(info.LastBackupAt) {
+			info.LastBackupAt = b.Timestamp
+		}
+	}
+	return info, nil
+}
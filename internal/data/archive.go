@@ -0,0 +1,654 @@
+package data
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/NethermindEth/eigenlayer/internal/locker"
+	"github.com/spf13/afero"
+)
+
+// archiveManifestName is the name of the manifest entry, always the first one
+// in a data directory archive.
+const archiveManifestName = "manifest.json"
+
+// archivedDirs are the data directory trees included in a data directory
+// archive, along with the directories of the monitoring stacks. The temp
+// directory is left out on purpose.
+var archivedDirs = []string{nodesDirName, pluginsDir}
+
+// ArchiveManifest describes the content of a data directory archive.
+type ArchiveManifest struct {
+	Timestamp time.Time `json:"timestamp"`
+	Instances []string  `json:"instances"`
+}
+
+// BackupAll writes a gzip compressed tar archive of the nodes, monitoring and
+// plugin trees of the data directory to w, including the named monitoring
+// stacks. Each instance is archived while holding a shared lock on it, and each
+// monitoring stack while holding its lock.
+// Lock files and instances pending removal are not archived.
+func (d *DataDir) BackupAll(w io.Writer) error {
+	return d.BackupAllContext(context.Background(), w)
+}
+
+// BackupAllContext is like BackupAll, but stops between archive entries once
+// ctx is done, returning the context error. What was already written to w is
+// left to the caller.
+func (d *DataDir) BackupAllContext(ctx context.Context, w io.Writer) error {
+	return d.BackupAllWithOptions(ctx, w, BackupAllOptions{})
+}
+
+// BackupAllOptions are the settings of a data directory archive.
+type BackupAllOptions struct {
+	// CompressionLevel is the gzip compression level of the archive, from
+	// gzip.BestSpeed to gzip.BestCompression. Zero means
+	// gzip.DefaultCompression, a balance between speed and size.
+	CompressionLevel int
+}
+
+// BackupAllWithOptions is like BackupAllContext, with the given options. A
+// compression level out of range returns ErrInvalidCompressionLevel, before
+// anything is written to w.
+func (d *DataDir) BackupAllWithOptions(ctx context.Context, w io.Writer, opts BackupAllOptions) (err error) {
+	level := opts.CompressionLevel
+	if level == 0 {
+		level = gzip.DefaultCompression
+	} else if level < gzip.BestSpeed || level > gzip.BestCompression {
+		return fmt.Errorf("%w: %d is not between %d and %d", ErrInvalidCompressionLevel, level, gzip.BestSpeed, gzip.BestCompression)
+	}
+	ctx, done, err := d.startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	instances, err := d.ListInstances()
+	if err != nil {
+		return err
+	}
+	manifest := ArchiveManifest{
+		Timestamp: d.now(),
+		Instances: make([]string, 0, len(instances)),
+	}
+	for _, instance := range instances {
+		manifest.Instances = append(manifest.Instances, InstanceId(instance.Name, instance.Tag))
+	}
+
+	gw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(gw)
+	defer func() {
+		if closeErr := tw.Close(); err == nil {
+			err = closeErr
+		}
+		if closeErr := gw.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err = tw.WriteHeader(&tar.Header{
+		Name:    archiveManifestName,
+		Mode:    0o644,
+		Size:    int64(len(manifestData)),
+		ModTime: manifest.Timestamp,
+	}); err != nil {
+		return err
+	}
+	if _, err = tw.Write(manifestData); err != nil {
+		return err
+	}
+
+	for _, instanceId := range manifest.Instances {
+		if err = d.archiveInstance(ctx, tw, instanceId); err != nil {
+			return err
+		}
+	}
+
+	rootEntries, err := afero.ReadDir(d.fs, d.path)
+	if err != nil {
+		return err
+	}
+	for _, dirEntry := range rootEntries {
+		if !dirEntry.IsDir() || !isMonitoringStackDir(dirEntry.Name()) {
+			continue
+		}
+		stack := newMonitoringStack(filepath.Join(d.path, dirEntry.Name()), d.fs, d.locker)
+		err = stack.WithLock(func(*LockedMonitoringStack) error {
+			return d.archiveDir(ctx, tw, dirEntry.Name())
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return d.archiveDir(ctx, tw, pluginsDir)
+}
+
+func (d *DataDir) archiveInstance(ctx context.Context, tw *tar.Writer, instanceId string) (err error) {
+	l, err := d.rlockInstance(instanceId)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		unlockErr := l.Unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+	return d.archiveDir(ctx, tw, filepath.Join(nodesDirName, instanceId))
+}
+
+// archiveDir adds the tree at the given path, relative to the data directory,
+// to the archive. It does nothing if the path does not exist.
+func (d *DataDir) archiveDir(ctx context.Context, tw *tar.Writer, dir string) error {
+	root := filepath.Join(d.path, dir)
+	ok, err := afero.DirExists(d.fs, root)
+	if err != nil || !ok {
+		return err
+	}
+	return afero.Walk(d.fs, root, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if info.Name() == ".lock" {
+			return nil
+		}
+		if info.IsDir() && strings.HasSuffix(info.Name(), deletingSuffix) {
+			return filepath.SkipDir
+		}
+		relPath, err := filepath.Rel(d.path, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := d.fs.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// RestoreAll unpacks an archive created by BackupAll into the data directory.
+// It fails with ErrInstanceAlreadyExists if any of the archived instances is
+// already installed, and validates every restored instance. Restored instances
+// are written while holding their lock. An archive with an invalid instance ID
+// in its manifest, or with instance files of instances not in its manifest,
+// returns ErrInvalidDataDirArchive, and the restore fails with an error
+// matching os.ErrExist instead of overwriting an existing file.
+func (d *DataDir) RestoreAll(r io.Reader) error {
+	return d.RestoreAllContext(context.Background(), r)
+}
+
+// RestoreAllContext is like RestoreAll, but stops between archive entries once
+// ctx is done, returning the context error. On failure, the restored instances
+// and any other file created by the restore are removed.
+func (d *DataDir) RestoreAllContext(ctx context.Context, r io.Reader) (err error) {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	ctx, done, err := d.startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidDataDirArchive, err)
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+
+	header, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidDataDirArchive, err)
+	}
+	if header.Name != archiveManifestName {
+		return fmt.Errorf("%w: missing %s", ErrInvalidDataDirArchive, archiveManifestName)
+	}
+	var manifest ArchiveManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidDataDirArchive, err)
+	}
+	instances := make(map[string]bool, len(manifest.Instances))
+	for _, instanceId := range manifest.Instances {
+		if err := validateIdPart("instance id", instanceId); err != nil || !filepath.IsLocal(instanceId) {
+			return fmt.Errorf("%w: invalid instance id %q", ErrInvalidDataDirArchive, instanceId)
+		}
+		if instances[instanceId] {
+			return fmt.Errorf("%w: duplicate instance %s", ErrInvalidDataDirArchive, instanceId)
+		}
+		instances[instanceId] = true
+		if d.HasInstance(instanceId) {
+			return fmt.Errorf("%w: %s", ErrInstanceAlreadyExists, instanceId)
+		}
+	}
+
+	// The files and directories created by the restore, parents first, so
+	// they are removed in reverse order on failure
+	var created []string
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, instanceId := range manifest.Instances {
+			created = append(created, filepath.Join(nodesDirName, instanceId))
+		}
+		for i := len(created) - 1; i >= 0; i-- {
+			if removeErr := d.fs.RemoveAll(filepath.Join(d.path, created[i])); removeErr != nil {
+				err = errors.Join(err, removeErr)
+			}
+		}
+	}()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidDataDirArchive, err)
+		}
+		names, err := d.restoreEntry(tr, header, instances)
+		created = append(created, names...)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, instanceId := range manifest.Instances {
+		if _, err := d.Instance(instanceId); err != nil {
+			return fmt.Errorf("restoring instance %s: %w", instanceId, err)
+		}
+	}
+	return nil
+}
+
+// restoreEntry restores a single archive entry. Instance entries must be of
+// one of the given archived instances, and existing files are never
+// overwritten. It returns the paths of the restored file and of the
+// directories created for it, parents first, relative to the data directory.
+func (d *DataDir) restoreEntry(tr *tar.Reader, header *tar.Header, instances map[string]bool) ([]string, error) {
+	name := filepath.FromSlash(strings.TrimSuffix(header.Name, "/"))
+	if !filepath.IsLocal(name) || !isArchivedPath(name) {
+		return nil, fmt.Errorf("%w: unexpected entry %s", ErrInvalidDataDirArchive, header.Name)
+	}
+	parts := strings.SplitN(filepath.ToSlash(name), "/", 3)
+	if len(parts) > 1 && parts[0] == nodesDirName && !instances[parts[1]] {
+		return nil, fmt.Errorf("%w: entry %s is not of an archived instance", ErrInvalidDataDirArchive, header.Name)
+	}
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return d.restoreDir(name)
+	case tar.TypeReg:
+		created, err := d.restoreDir(filepath.Dir(name))
+		if err != nil {
+			return created, err
+		}
+		if err := d.restoreFile(tr, name, header.FileInfo().Mode().Perm()); err != nil {
+			return created, err
+		}
+		return append(created, name), nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported entry type for %s", ErrInvalidDataDirArchive, header.Name)
+	}
+}
+
+// restoreDir creates the directory at the given path, relative to the data
+// directory, with its missing parents, and returns the paths of the created
+// directories, parents first. Nothing is returned if it fails, so no existing
+// path is mistaken for a created one.
+func (d *DataDir) restoreDir(name string) ([]string, error) {
+	var created []string
+	for dir := name; dir != "."; dir = filepath.Dir(dir) {
+		exists, err := afero.Exists(d.fs, filepath.Join(d.path, dir))
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			break
+		}
+		created = append([]string{dir}, created...)
+	}
+	if err := d.fs.MkdirAll(filepath.Join(d.path, name), 0o755); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// restoreFile writes the file at the given path, relative to the data
+// directory, failing with an error matching os.ErrExist if the file already
+// exists. Files inside an instance directory are written while holding the
+// instance lock. A partially written file is removed.
+func (d *DataDir) restoreFile(r io.Reader, name string, perm os.FileMode) (err error) {
+	parts := strings.SplitN(filepath.ToSlash(name), "/", 3)
+	if len(parts) == 3 && parts[0] == nodesDirName {
+		l := d.locker.New(filepath.Join(d.path, nodesDirName, parts[1], ".lock"))
+		if err := l.Lock(); err != nil {
+			return err
+		}
+		defer func() {
+			unlockErr := l.Unlock()
+			if err == nil {
+				err = unlockErr
+			}
+		}()
+	}
+	path := filepath.Join(d.path, name)
+	f, err := d.fs.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Join(err, d.fs.Remove(path))
+	}
+	return nil
+}
+
+func isArchivedPath(name string) bool {
+	top := strings.SplitN(filepath.ToSlash(name), "/", 2)[0]
+	if isMonitoringStackDir(top) {
+		return true
+	}
+	for _, dir := range archivedDirs {
+		if top == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// instanceBackupDataDir is the directory of an instance backup archive holding
+// the instance directory.
+const instanceBackupDataDir = "data"
+
+// RestoreInstanceFrom restores the instance with the given id from an instance
+// backup archive read from r, such as a backup streamed from stdin or from the
+// network, without a local copy of the archive. The instance directory, under
+// data/ in the archive, is extracted to a temp directory and its state is
+// validated before it is moved into place, so a failed restore leaves the
+// instance as it was. The restored state must be of the given instance. An
+// existing instance is only replaced if force is true, otherwise an
+// ErrInstanceAlreadyExists error is returned.
+func (d *DataDir) RestoreInstanceFrom(r io.Reader, targetInstanceId string, force bool) error {
+	return d.restoreInstance(r, targetInstanceId, force, func(instance *Instance) error {
+		if instance.ID() != targetInstanceId {
+			return fmt.Errorf("%w: the backup is of instance %s, not %s", ErrInvalidInstance, instance.ID(), targetInstanceId)
+		}
+		return nil
+	})
+}
+
+// RestoreInstanceFromAs restores the instance backup archive read from r as
+// the instance with the given name and tag, and returns the id of the restored
+// instance, which may differ from the id of the instance the backup was taken
+// from. The name and tag of the restored state are rewritten and the lock file
+// of the instance is regenerated, otherwise it works like RestoreInstanceFrom.
+func (d *DataDir) RestoreInstanceFromAs(r io.Reader, name, tag string, force bool) (string, error) {
+	if err := validateIdPart("name", name); err != nil {
+		return "", err
+	}
+	if err := validateIdPart("tag", tag); err != nil {
+		return "", err
+	}
+	targetInstanceId := InstanceId(name, tag)
+	err := d.restoreInstance(r, targetInstanceId, force, func(instance *Instance) error {
+		if instance.Name == name && instance.Tag == tag {
+			return nil
+		}
+		instance.Name = name
+		instance.Tag = tag
+		instance.syncState = d.syncWrites
+		instance.compactState = d.compactState
+		if err := instance.validate(); err != nil {
+			return err
+		}
+		lockPath := filepath.Join(instance.path, ".lock")
+		if err := d.fs.Remove(lockPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if !isLockless(d.locker) {
+			f, err := d.fs.Create(lockPath)
+			if err != nil {
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		}
+		return instance.saveState()
+	})
+	if err != nil {
+		return "", err
+	}
+	return targetInstanceId, nil
+}
+
+// restoreInstance restores the instance with the given id from the instance
+// backup archive read from r. The instance extracted to the staging directory
+// is passed to prepare, which checks or rewrites it before it is moved into
+// place.
+func (d *DataDir) restoreInstance(r io.Reader, targetInstanceId string, force bool, prepare func(*Instance) error) (err error) {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	ctx, done, err := d.startOperation(context.Background())
+	if err != nil {
+		return err
+	}
+	defer done()
+	instancePath := filepath.Join(d.NodesPath(), targetInstanceId)
+	if err := checkInstancePath(d.NodesPath(), instancePath); err != nil {
+		return err
+	}
+	if !force && d.HasInstance(targetInstanceId) {
+		return fmt.Errorf("%w: %s", ErrInstanceAlreadyExists, targetInstanceId)
+	}
+
+	stagingId := "restore-" + targetInstanceId
+	stagingPath, err := d.InitTemp(stagingId)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		removeErr := d.RemoveTemp(stagingId)
+		if err == nil {
+			err = removeErr
+		}
+	}()
+	if err := d.extractInstanceBackup(ctx, r, stagingPath); err != nil {
+		return err
+	}
+	instance, err := newInstance(stagingPath, d.fs, d.locker)
+	if err != nil {
+		return err
+	}
+	if err := prepare(instance); err != nil {
+		return err
+	}
+	unlock, err := d.lockInstanceDir(targetInstanceId)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		unlockErr := unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+	if err := d.replaceInstanceDir(targetInstanceId, stagingPath, force); err != nil {
+		return err
+	}
+	return updateLabelIndex(d.fs, d.NodesPath(), targetInstanceId, instance.Labels, false, d.syncWrites)
+}
+
+// extractInstanceBackup extracts the instance directory of the instance
+// backup archive read from r to dst. The other entries of the archive are
+// skipped, and entries escaping the instance directory are rejected.
+func (d *DataDir) extractInstanceBackup(ctx context.Context, r io.Reader, dst string) error {
+	return d.extractTarDir(ctx, r, instanceBackupDataDir, dst)
+}
+
+// extractTarDir extracts the srcDir directory of the tar archive read from r
+// to dst, checking ctx before each entry.
+func (d *DataDir) extractTarDir(ctx context.Context, r io.Reader, srcDir, dst string) error {
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidBackupArchive, err)
+		}
+		name, ok := strings.CutPrefix(strings.TrimSuffix(header.Name, "/"), srcDir+"/")
+		if !ok {
+			continue
+		}
+		name = filepath.FromSlash(name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("%w: unexpected entry %s", ErrInvalidBackupArchive, header.Name)
+		}
+		target := filepath.Join(dst, name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := d.fs.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := d.fs.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := extractFile(d.fs, tr, target, header.FileInfo().Mode().Perm()); err != nil {
+				return WrapDiskFull(err)
+			}
+		default:
+			return fmt.Errorf("%w: unsupported entry type for %s", ErrInvalidBackupArchive, header.Name)
+		}
+	}
+}
+
+func extractFile(fs afero.Fs, r io.Reader, path string, perm os.FileMode) (err error) {
+	f, err := fs.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// lockInstanceDir takes the locks for replacing the directory of the instance
+// with the given id, like UpgradeInstance: the instance lock exclusively, if
+// the instance exists, and then the data dir lock shared. The returned
+// function releases both.
+func (d *DataDir) lockInstanceDir(instanceId string) (func() error, error) {
+	var instanceLock locker.Locker
+	instancePath := filepath.Join(d.NodesPath(), instanceId)
+	exists, err := afero.DirExists(d.fs, instancePath)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		instanceLock = d.locker.New(filepath.Join(instancePath, ".lock"))
+		if err := instanceLock.Lock(); err != nil {
+			return nil, err
+		}
+	}
+	dataDirLock, err := d.rlockDataDir()
+	if err != nil {
+		if instanceLock != nil {
+			err = errors.Join(err, instanceLock.Unlock())
+		}
+		return nil, err
+	}
+	return func() error {
+		err := dataDirLock.Unlock()
+		if instanceLock != nil {
+			err = errors.Join(err, instanceLock.Unlock())
+		}
+		return err
+	}, nil
+}
+
+// replaceInstanceDir moves the directory at srcPath into place as the
+// directory of the instance with the given id. An existing instance directory
+// is only replaced if force is true, and is put back if the move fails.
+func (d *DataDir) replaceInstanceDir(instanceId, srcPath string, force bool) error {
+	instancePath := filepath.Join(d.NodesPath(), instanceId)
+	exists, err := afero.DirExists(d.fs, instancePath)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := d.fs.MkdirAll(d.NodesPath(), 0o755); err != nil {
+			return err
+		}
+		return d.fs.Rename(srcPath, instancePath)
+	}
+	if !force {
+		return fmt.Errorf("%w: %s", ErrInstanceAlreadyExists, instanceId)
+	}
+	deletingPath := instancePath + deletingSuffix
+	if err := d.fs.RemoveAll(deletingPath); err != nil {
+		return err
+	}
+	if err := d.fs.Rename(instancePath, deletingPath); err != nil {
+		return err
+	}
+	if err := d.fs.Rename(srcPath, instancePath); err != nil {
+		return errors.Join(err, d.fs.Rename(deletingPath, instancePath))
+	}
+	if err := d.fs.RemoveAll(deletingPath); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInstancePendingRemoval, instanceId, err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package data
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// auditLogName is the name of the audit log file in the data dir root.
+const auditLogName = "audit.log"
+
+// AuditAction is the kind of change recorded by an AuditEntry.
+type AuditAction string
+
+const (
+	AuditInstanceInstalled      AuditAction = "instance-installed"
+	AuditInstanceUpdated        AuditAction = "instance-updated"
+	AuditInstanceRemoved        AuditAction = "instance-removed"
+	AuditBackupCreated          AuditAction = "backup-created"
+	AuditBackupPruned           AuditAction = "backup-pruned"
+	AuditMonitoringStackCreated AuditAction = "monitoring-stack-created"
+	AuditMonitoringStackRemoved AuditAction = "monitoring-stack-removed"
+)
+
+// AuditEntry is a change to the data dir, recorded in its audit log.
+type AuditEntry struct {
+	Time   time.Time   `json:"time"`
+	Action AuditAction `json:"action"`
+	// Target is the id of the changed instance or backup, or the name of the
+	// changed monitoring stack, empty for the default one.
+	Target string `json:"target"`
+	// PID is the id of the process that made the change.
+	PID int `json:"pid"`
+	// User is the user that made the change, set by WithAuditUser.
+	User string `json:"user,omitempty"`
+}
+
+// auditMu serializes the writes to the audit logs of the process, so entries
+// are never interleaved.
+var auditMu sync.Mutex
+
+// WithAuditUser sets the user recorded in the audit log entries, along with
+// the process id.
+func WithAuditUser(user string) DataDirOption {
+	return func(d *DataDir) {
+		d.auditUser = user
+	}
+}
+
+// AuditLogPath returns the path of the audit log of the data dir, a file with
+// an AuditEntry in JSON per line.
+func (d *DataDir) AuditLogPath() string {
+	return filepath.Join(d.path, auditLogName)
+}
+
+// audit appends an entry to the audit log. Writing is best effort: failures
+// are logged, never returned, so they don't break the audited operation.
+func (d *DataDir) audit(action AuditAction, target string) {
+	if d.readOnly {
+		return
+	}
+	entry := AuditEntry{
+		Time:   d.now().UTC(),
+		Action: action,
+		Target: target,
+		PID:    os.Getpid(),
+		User:   d.auditUser,
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logrus.Warnf("Failed to write audit log entry: %v", err)
+		return
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	f, err := d.fs.OpenFile(d.AuditLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		logrus.Warnf("Failed to write audit log entry: %v", err)
+		return
+	}
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logrus.Warnf("Failed to write audit log entry: %v", err)
+	}
+}
+
+// AuditEntries returns the entries of the audit log recorded at or after
+// since, oldest first. Lines that can't be parsed, like one cut short by a
+// crash, are skipped. A data dir without audit log has no entries.
+func (d *DataDir) AuditEntries(since time.Time) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	f, err := d.fs.Open(d.AuditLogPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logrus.Debugf("Skipping invalid audit log entry: %v", err)
+			continue
+		}
+		if entry.Time.Before(since) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
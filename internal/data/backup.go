@@ -1,9 +1,13 @@
 package data
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -25,6 +29,33 @@ type Backup struct {
 	Version    string
 	Commit     string
 	Url        string
+	// Checksum is the SHA-256 of the backup archive, loaded from the backup
+	// manifest. It is empty for backups without manifest.
+	Checksum string
+}
+
+// BackupManifest is the metadata stored alongside a backup archive as a
+// <backup_id>.json file.
+type BackupManifest struct {
+	InstanceId string          `json:"instance_id"`
+	State      json.RawMessage `json:"state"`
+	Timestamp  time.Time       `json:"timestamp"`
+	Checksum   string          `json:"checksum"`
+}
+
+// Instance returns the instance stored in the manifest state.
+func (m *BackupManifest) Instance() (*Instance, error) {
+	var instance Instance
+	if err := json.Unmarshal(m.State, &instance); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBackupManifest, err)
+	}
+	return &instance, nil
+}
+
+// TimestampedBackupId returns the id of a timestamped backup, formatted as
+// <instance_id>-<RFC3339 timestamp>.
+func TimestampedBackupId(instanceId string, timestamp time.Time) string {
+	return instanceId + "-" + timestamp.UTC().Format(time.RFC3339)
 }
 
 func (b *Backup) Id() string {
@@ -84,8 +115,13 @@ func loadBackupTarStateJson(fs afero.Fs, tarPath string) (*Instance, error) {
 	defer stateTmp.Close()
 	defer fs.Remove(stateTmp.Name())
 
-	// Load state.json
-	err = backuptar.ExtractFile(tarPath, "data/state.json", stateTmp.Name())
+	// Load state.json, or the compressed state.json.gz
+	compressed := false
+	err = backuptar.ExtractFile(tarPath, "data/"+stateFileName, stateTmp.Name())
+	if errors.Is(err, backuptar.ErrFileNotFound) {
+		compressed = true
+		err = backuptar.ExtractFile(tarPath, "data/"+compressedStateFileName, stateTmp.Name())
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -95,7 +131,16 @@ func loadBackupTarStateJson(fs afero.Fs, tarPath string) (*Instance, error) {
 		return nil, err
 	}
 
-	stateData, err := io.ReadAll(stateTmp)
+	var stateReader io.Reader = stateTmp
+	if compressed {
+		gr, err := gzip.NewReader(stateTmp)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		stateReader = gr
+	}
+	stateData, err := io.ReadAll(stateReader)
 	if err != nil {
 		return nil, err
 	}
@@ -142,6 +187,69 @@ func loadBackupTarTimestamp(fs afero.Fs, tarPath string) (time.Time, error) {
 	return time.Unix(timestampInt, 0), nil
 }
 
+// readBackupArchive loads the information of a backup from its archive read
+// from r, like BackupFromTar, in a single pass over the archive.
+func readBackupArchive(r io.Reader) (*Backup, error) {
+	var state, compressedState, timestampData []byte
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var dst *[]byte
+		switch header.Name {
+		case "data/" + stateFileName:
+			dst = &state
+		case "data/" + compressedStateFileName:
+			dst = &compressedState
+		case "timestamp":
+			dst = &timestampData
+		}
+		if dst == nil || *dst != nil {
+			continue
+		}
+		if *dst, err = io.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+
+	if state == nil && compressedState != nil {
+		gr, err := gzip.NewReader(bytes.NewReader(compressedState))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		if state, err = io.ReadAll(gr); err != nil {
+			return nil, err
+		}
+	}
+	if state == nil {
+		return nil, fmt.Errorf("%w: data/%s", backuptar.ErrFileNotFound, stateFileName)
+	}
+	if timestampData == nil {
+		return nil, fmt.Errorf("%w: timestamp", backuptar.ErrFileNotFound)
+	}
+	var instance Instance
+	if err := json.Unmarshal(state, &instance); err != nil {
+		return nil, err
+	}
+	timestampInt, err := strconv.ParseInt(string(timestampData), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &Backup{
+		InstanceId: instance.ID(),
+		Timestamp:  time.Unix(timestampInt, 0),
+		Version:    instance.Version,
+		Commit:     instance.Commit,
+		Url:        instance.URL,
+	}, nil
+}
+
 func ParseBackupName(backupName string) (instanceId string, timestamp time.Time, err error) {
 	match := backupFileNameRegex.FindStringSubmatch(backupName)
 	if len(match) != 3 {
@@ -0,0 +1,53 @@
+package data
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// WithBackupRoot makes the data dir keep its backups in the directory at path
+// instead of the backup directory inside the data dir, for instance on a
+// separate backup volume. A leading ~ is expanded as in NewDataDir. The
+// directory is created if needed, and NewDataDir returns ErrInvalidBackupRoot
+// if it is not writable.
+func WithBackupRoot(path string) DataDirOption {
+	return func(d *DataDir) {
+		d.backupRoot = path
+	}
+}
+
+// initBackupRoot resolves the path of the backup root set by WithBackupRoot,
+// and checks that the backups can be written to it. Read-only data dirs don't
+// write backups, so their backup root is not checked.
+func (d *DataDir) initBackupRoot() error {
+	if d.backupRoot == "" {
+		return nil
+	}
+	path, err := expandHome(d.backupRoot)
+	if err != nil {
+		return err
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return err
+	}
+	if path, err = canonicalPath(d.fs, path); err != nil {
+		return err
+	}
+	d.backupRoot = path
+	if d.readOnly {
+		return nil
+	}
+	if err := d.fs.MkdirAll(path, 0o755); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidBackupRoot, path, err)
+	}
+	probe, err := afero.TempFile(d.fs, path, ".eigen-probe-*")
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidBackupRoot, path, err)
+	}
+	if err := probe.Close(); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidBackupRoot, path, err)
+	}
+	return d.fs.Remove(probe.Name())
+}
@@ -0,0 +1,96 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// InitInstancesOptions are the settings of InitInstancesWithOptions.
+type InitInstancesOptions struct {
+	// Concurrency is the maximum number of instances installed in parallel.
+	// Zero means the number of CPUs.
+	Concurrency int
+	// Atomic removes the installed instances of the batch if any instance
+	// fails, so either all or none of them are installed.
+	Atomic bool
+}
+
+// InstallResult is the outcome of the install of an instance of a batch.
+type InstallResult struct {
+	InstanceId string
+	// Err is nil if the instance was installed. With InitInstancesOptions.Atomic,
+	// the instances removed after another failure have ErrInstallRolledBack.
+	Err error
+}
+
+// InitInstances installs the instances concurrently, like InitInstance. A
+// failure doesn't stop the install of the other instances. It returns the
+// result of each instance, in the order of the instances, and the errors of
+// the failed ones joined.
+func (d *DataDir) InitInstances(instances []*Instance) ([]InstallResult, error) {
+	return d.InitInstancesWithOptions(instances, InitInstancesOptions{})
+}
+
+// InitInstancesWithOptions is like InitInstances, with the given options. An
+// instance whose id repeats an earlier one of the batch fails with
+// ErrInstanceAlreadyExists.
+func (d *DataDir) InitInstancesWithOptions(instances []*Instance, opts InitInstancesOptions) ([]InstallResult, error) {
+	if err := d.checkWritable(); err != nil {
+		return nil, err
+	}
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	results := make([]InstallResult, len(instances))
+	seen := make(map[string]bool, len(instances))
+	indexes := make(chan int)
+	var waitGroup sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for i := range indexes {
+				_, results[i].Err = d.InitInstance(instances[i])
+			}
+		}()
+	}
+	for i, instance := range instances {
+		results[i].InstanceId = InstanceId(instance.Name, instance.Tag)
+		// Two installs of the same id would race, so only the first is done
+		if seen[results[i].InstanceId] {
+			results[i].Err = fmt.Errorf("%w: %s is repeated in the batch", ErrInstanceAlreadyExists, results[i].InstanceId)
+			continue
+		}
+		seen[results[i].InstanceId] = true
+		indexes <- i
+	}
+	close(indexes)
+	waitGroup.Wait()
+
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("failed installing instance %s: %w", result.InstanceId, result.Err))
+		}
+	}
+	if len(errs) > 0 && opts.Atomic {
+		for i := range results {
+			if results[i].Err != nil {
+				continue
+			}
+			if err := d.RemoveInstance(results[i].InstanceId, true); err != nil {
+				errs = append(errs, fmt.Errorf("failed rolling back instance %s: %w", results[i].InstanceId, err))
+				continue
+			}
+			results[i].Err = fmt.Errorf("%w: %s", ErrInstallRolledBack, results[i].InstanceId)
+		}
+	}
+	return results, errors.Join(errs...)
+}
@@ -0,0 +1,295 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// staleTempAge is the age after which Check reports a temporary directory as
+// stale. Temporary directories only live during an operation, like an install.
+const staleTempAge = 24 * time.Hour
+
+// CheckKind is the kind of a problem found by DataDir.Check.
+type CheckKind string
+
+const (
+	// CheckInvalidInstance is an instance directory with a missing or invalid
+	// state file.
+	CheckInvalidInstance CheckKind = "invalid-instance"
+	// CheckPendingRemoval is an instance directory left behind by a failed
+	// removal, which GC removes.
+	CheckPendingRemoval CheckKind = "pending-removal"
+	// CheckOrphanedPluginContext is a plugin image context of an instance that
+	// is not installed.
+	CheckOrphanedPluginContext CheckKind = "orphaned-plugin-context"
+	// CheckStaleTemp is a temporary directory older than a day, which
+	// PruneTempDirs removes.
+	CheckStaleTemp CheckKind = "stale-temp"
+	// CheckCorruptBackup is a backup that can't be read, or that doesn't match
+	// its manifest.
+	CheckCorruptBackup CheckKind = "corrupt-backup"
+	// CheckMissingMonitoringConfig is a monitoring stack directory missing the
+	// files of an installed stack.
+	CheckMissingMonitoringConfig CheckKind = "missing-monitoring-config"
+	// CheckMismatchedInstanceId is an instance directory whose name is not the
+	// id of its state, a sign of manual renaming, which RepairInstanceIds
+	// fixes.
+	CheckMismatchedInstanceId CheckKind = "mismatched-instance-id"
+	// CheckStaleLabelIndex is a label index missing or not matching the labels
+	// of the instances, which GC rebuilds.
+	CheckStaleLabelIndex CheckKind = "stale-label-index"
+	// CheckNonconformingInstance is an instance installed before a rule of
+	// new instances was added, like the allowlist of names and tags, which
+	// still loads but would be rejected if installed today.
+	CheckNonconformingInstance CheckKind = "nonconforming-instance"
+)
+
+// checkKinds are all the kinds of problems found by DataDir.Check.
+var checkKinds = []CheckKind{
+	CheckInvalidInstance,
+	CheckPendingRemoval,
+	CheckOrphanedPluginContext,
+	CheckStaleTemp,
+	CheckCorruptBackup,
+	CheckMissingMonitoringConfig,
+	CheckMismatchedInstanceId,
+	CheckStaleLabelIndex,
+	CheckNonconformingInstance,
+}
+
+// CheckProblem is a problem found by DataDir.Check.
+type CheckProblem struct {
+	Kind CheckKind `json:"kind"`
+	// Path is the path of the file or directory with the problem.
+	Path   string `json:"path"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// CheckReport is the result of DataDir.Check.
+type CheckReport struct {
+	Problems []CheckProblem `json:"problems"`
+}
+
+// OK returns true if no problems were found.
+func (r *CheckReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// ByKind returns the problems of the given kind.
+func (r *CheckReport) ByKind(kind CheckKind) []CheckProblem {
+	var problems []CheckProblem
+	for _, p := range r.Problems {
+		if p.Kind == kind {
+			problems = append(problems, p)
+		}
+	}
+	return problems
+}
+
+// checkReportJSON is the JSON encoding of a CheckReport.
+type checkReportJSON struct {
+	Healthy  bool                             `json:"healthy"`
+	Problems map[CheckKind][]checkProblemJSON `json:"problems"`
+}
+
+type checkProblemJSON struct {
+	Path   string `json:"path"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// JSON encodes the report for machines, like CI gates. The encoding is stable:
+//
+//   - healthy is true if no problems were found.
+//   - problems has a list for each kind of problem, like "corrupt-backup",
+//     which is empty if there is none. Each problem has the path of the file
+//     or directory with the problem and, if known, a detail.
+//
+// New kinds of problems may be added, but the existing fields don't change.
+func (r *CheckReport) JSON() ([]byte, error) {
+	out := checkReportJSON{
+		Healthy:  r.OK(),
+		Problems: make(map[CheckKind][]checkProblemJSON, len(checkKinds)),
+	}
+	for _, kind := range checkKinds {
+		out.Problems[kind] = []checkProblemJSON{}
+	}
+	for _, p := range r.Problems {
+		out.Problems[p.Kind] = append(out.Problems[p.Kind], checkProblemJSON{Path: p.Path, Detail: p.Detail})
+	}
+	return json.Marshal(out)
+}
+
+func (r *CheckReport) add(kind CheckKind, path string, err error) {
+	problem := CheckProblem{Kind: kind, Path: path}
+	if err != nil {
+		problem.Detail = err.Error()
+	}
+	r.Problems = append(r.Problems, problem)
+}
+
+// Check looks for problems in the data directory without modifying it:
+// instances with a missing or invalid state, or not following the rules of
+// new instances, leftovers of failed removals, orphaned plugin contexts, stale
+// temporary directories, corrupt backups, an incomplete monitoring stack and a
+// stale label index. The problems found are in the report, the returned error
+// is only for failures running the checks.
+func (d *DataDir) Check() (CheckReport, error) {
+	var report CheckReport
+	if err := d.checkOpen(); err != nil {
+		return report, err
+	}
+	instanceIds, err := d.checkInstances(&report)
+	if err != nil {
+		return report, err
+	}
+	checks := []func(*CheckReport) error{
+		func(r *CheckReport) error { return d.checkPluginContexts(r, instanceIds) },
+		d.checkTemp,
+		d.checkBackups,
+		d.checkMonitoringStack,
+		d.checkLabelIndex,
+	}
+	for _, check := range checks {
+		if err := check(&report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// checkInstances reports the instance directories that can't be loaded, and
+// returns the ids of all the instance directories.
+func (d *DataDir) checkInstances(r *CheckReport) (map[string]bool, error) {
+	instanceIds := make(map[string]bool)
+	dirEntries, err := readDirIfExists(d.fs, d.NodesPath())
+	if err != nil {
+		return nil, err
+	}
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		path := filepath.Join(d.NodesPath(), dirEntry.Name())
+		if strings.HasSuffix(dirEntry.Name(), deletingSuffix) {
+			r.add(CheckPendingRemoval, path, nil)
+			continue
+		}
+		instanceIds[dirEntry.Name()] = true
+		instance, err := d.Instance(dirEntry.Name())
+		if err != nil {
+			r.add(CheckInvalidInstance, path, err)
+			continue
+		}
+		if instance.ID() != dirEntry.Name() {
+			r.add(CheckMismatchedInstanceId, path, fmt.Errorf("state is of instance %s", instance.ID()))
+		}
+		if err := instance.validate(); err != nil {
+			r.add(CheckNonconformingInstance, path, err)
+		}
+	}
+	return instanceIds, nil
+}
+
+// checkPluginContexts reports the plugin contexts whose instance directory
+// doesn't exist.
+func (d *DataDir) checkPluginContexts(r *CheckReport, instanceIds map[string]bool) error {
+	dirEntries, err := readDirIfExists(d.fs, d.PluginDirPath())
+	if err != nil {
+		return err
+	}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || filepath.Ext(dirEntry.Name()) != ".tar" {
+			continue
+		}
+		if !instanceIds[strings.TrimSuffix(dirEntry.Name(), ".tar")] {
+			r.add(CheckOrphanedPluginContext, filepath.Join(d.PluginDirPath(), dirEntry.Name()), nil)
+		}
+	}
+	return nil
+}
+
+// checkTemp reports the temporary directories not modified for longer than
+// staleTempAge.
+func (d *DataDir) checkTemp(r *CheckReport) error {
+	tempPath := filepath.Join(d.path, tempDir)
+	dirEntries, err := readDirIfExists(d.fs, tempPath)
+	if err != nil {
+		return err
+	}
+	cutoff := d.now().Add(-staleTempAge)
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() && dirEntry.ModTime().Before(cutoff) {
+			r.add(CheckStaleTemp, filepath.Join(tempPath, dirEntry.Name()), nil)
+		}
+	}
+	return nil
+}
+
+// checkBackups reports the backups that can't be loaded, and those whose
+// checksum doesn't match their manifest.
+func (d *DataDir) checkBackups(r *CheckReport) error {
+	dirEntries, err := d.backupStorage().List()
+	if err != nil {
+		return err
+	}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || filepath.Ext(dirEntry.Name()) != ".tar" {
+			continue
+		}
+		path := filepath.Join(d.BackupDirPath(), dirEntry.Name())
+		if _, err := d.storedBackup(dirEntry.Name()); err != nil {
+			r.add(CheckCorruptBackup, path, err)
+			continue
+		}
+		backupId := strings.TrimSuffix(dirEntry.Name(), ".tar")
+		manifest, err := d.BackupManifest(backupId)
+		if err != nil {
+			if !errors.Is(err, ErrBackupManifestNotFound) {
+				r.add(CheckCorruptBackup, path, err)
+			}
+			continue
+		}
+		checksum, err := d.BackupChecksum(backupId)
+		if err != nil {
+			r.add(CheckCorruptBackup, path, err)
+		} else if checksum != manifest.Checksum {
+			r.add(CheckCorruptBackup, path, errors.New("checksum does not match the backup manifest"))
+		}
+	}
+	return nil
+}
+
+// checkMonitoringStack reports the missing files of the monitoring stack, if
+// its directory exists.
+func (d *DataDir) checkMonitoringStack(r *CheckReport) error {
+	ok, err := d.HasMonitoringStack()
+	if err != nil || !ok {
+		return err
+	}
+	for _, file := range monitoringStackFiles {
+		path := filepath.Join(d.MonitoringPath(), file)
+		if _, err := d.fs.Stat(path); err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+			r.add(CheckMissingMonitoringConfig, path, nil)
+		}
+	}
+	return nil
+}
+
+// readDirIfExists is like afero.ReadDir, but a missing directory is empty.
+func readDirIfExists(fs afero.Fs, path string) ([]os.FileInfo, error) {
+	dirEntries, err := afero.ReadDir(fs, path)
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	}
+	return dirEntries, err
+}
@@ -0,0 +1,25 @@
+package data
+
+import "time"
+
+// Clock provides the current time. It allows to freeze the time in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+// WithClock sets the clock used by the DataDir. A nil clock means the real
+// clock, which is the default.
+func WithClock(clock Clock) DataDirOption {
+	return func(d *DataDir) {
+		d.clock = clock
+	}
+}
+
+// now returns the current time from the DataDir clock, falling back to the
+// real clock if none is set.
+func (d *DataDir) now() time.Time {
+	if d.clock == nil {
+		return time.Now()
+	}
+	return d.clock.Now()
+}
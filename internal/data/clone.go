@@ -0,0 +1,151 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/NethermindEth/eigenlayer/internal/utils"
+	"github.com/spf13/afero"
+)
+
+// CloneOptions are the options of CloneInstance.
+type CloneOptions struct {
+	// Tag is the tag of the clone, which gives its id together with the name
+	// of the source instance.
+	Tag string
+	// Exclude are filepath.Match patterns of the paths, relative to the
+	// instance directory, left out of the clone, like "logs/*" or
+	// "data/chaindata". Excluding a directory excludes its whole tree, see
+	// utils.ExcludedPath.
+	Exclude []string
+}
+
+// CloneInstance copies the instance with the given id to a new instance with
+// the tag given in the options, and returns the id of the clone. The source is
+// read while holding a shared lock on it. The clone is not in maintenance mode,
+// whatever the state of the source. If an instance with the id of the clone
+// already exists, ErrInstanceAlreadyExists is returned.
+func (d *DataDir) CloneInstance(instanceId string, opts CloneOptions) (cloneId string, err error) {
+	if err := d.checkWritable(); err != nil {
+		return "", err
+	}
+	if err := d.checkOpen(); err != nil {
+		return "", err
+	}
+	if err := utils.ValidateExcludePatterns(opts.Exclude); err != nil {
+		return "", err
+	}
+	if err := validateIdPart("tag", opts.Tag); err != nil {
+		return "", err
+	}
+	source, err := d.Instance(instanceId)
+	if err != nil {
+		return "", err
+	}
+	cloneId = InstanceId(source.Name, opts.Tag)
+	if d.HasInstance(cloneId) {
+		return "", fmt.Errorf("%w: %s", ErrInstanceAlreadyExists, cloneId)
+	}
+
+	l, err := d.rlockInstance(instanceId)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		unlockErr := l.Unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+
+	clonePath := filepath.Join(d.path, nodesDirName, cloneId)
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, d.fs.RemoveAll(clonePath))
+		}
+	}()
+	if err = d.copyInstanceDir(source.path, clonePath, opts.Exclude); err != nil {
+		return "", err
+	}
+
+	// Write the state of the clone, with a new lock file
+	stateData, err := json.Marshal(source)
+	if err != nil {
+		return "", err
+	}
+	var clone Instance
+	if err = json.Unmarshal(stateData, &clone); err != nil {
+		return "", err
+	}
+	clone.Tag = opts.Tag
+	clone.Maintenance = false
+	clone.compressState = d.compressState
+	clone.syncState = d.syncWrites
+	clone.compactState = d.compactState
+	if err = clone.init(d.NodesPath(), clonePath, d.fs, d.locker); err != nil {
+		return "", err
+	}
+	return cloneId, nil
+}
+
+// copyInstanceDir copies the instance directory at src to dst, leaving out the
+// lock and state files, and the paths matching the exclude patterns.
+func (d *DataDir) copyInstanceDir(src, dst string, exclude []string) error {
+	return afero.Walk(d.fs, src, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		switch relPath {
+		case ".lock", stateFileName, compressedStateFileName:
+			return nil
+		}
+		if slices.Contains(altStateFileNames, relPath) {
+			return nil
+		}
+		if utils.ExcludedPath(exclude, relPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, relPath)
+		if info.IsDir() {
+			return d.fs.MkdirAll(target, info.Mode().Perm())
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(d.fs, path, target, info.Mode().Perm())
+	})
+}
+
+// copyFile copies the file at src to dst, with the given permissions.
+func copyFile(fs afero.Fs, src, dst string, perm os.FileMode) (err error) {
+	in, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := fs.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := out.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	_, err = io.Copy(out, in)
+	return err
+}
@@ -1,14 +1,24 @@
 package data
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/NethermindEth/docker-volumes-snapshotter/pkg/backuptar"
 	"github.com/NethermindEth/eigenlayer/internal/locker"
 	"github.com/NethermindEth/eigenlayer/internal/package_handler"
+	"github.com/NethermindEth/eigenlayer/internal/utils"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
 )
@@ -22,20 +32,205 @@ const (
 
 const monitoringStackDirName = "monitoring"
 
+// deletingSuffix marks an instance directory whose removal is in progress or
+// failed halfway.
+const deletingSuffix = ".deleting"
+
+const (
+	// instanceReadLockTimeout is the maximum time to wait for a shared lock on
+	// an instance while listing instances.
+	instanceReadLockTimeout = time.Second
+	// instanceReadLockRetryDelay is the delay between shared lock attempts.
+	instanceReadLockRetryDelay = 50 * time.Millisecond
+	// instanceBusyProbeTimeout is the time given to the probe of the instance
+	// lock before a backup, which is just long enough for a single attempt.
+	instanceBusyProbeTimeout = 10 * time.Millisecond
+)
+
+const (
+	// monitoringStackLockTimeout is the maximum time to wait for the monitoring
+	// stack lock before removing the stack.
+	monitoringStackLockTimeout = time.Second
+	// monitoringStackLockRetryDelay is the delay between lock attempts.
+	monitoringStackLockRetryDelay = 50 * time.Millisecond
+)
+
 // DataDir is the directory where all the data is stored.
 type DataDir struct {
 	path   string
 	fs     afero.Fs
 	locker locker.Locker
+	// tempQuota is the maximum size in bytes of the temp directory. Zero
+	// means unlimited.
+	tempQuota int64
+	clock     Clock
+	// compressState makes new instances store their state gzip-compressed.
+	compressState bool
+	// compactState makes the instance states be written in compact JSON.
+	compactState bool
+	// syncWrites makes instance state and backup writes be flushed to disk.
+	syncWrites bool
+	// diskUsageConcurrency is the maximum number of instance directories
+	// walked in parallel by DiskUsage. Zero means the number of CPUs.
+	diskUsageConcurrency int
+	// forceInit allows initializing the data dir in a directory that doesn't
+	// look like one.
+	forceInit bool
+	// readOnly makes the methods changing the data dir return ErrReadOnly.
+	readOnly bool
+	// layoutVersion is the layout version of the data dir, read from its
+	// marker.
+	layoutVersion int
+	// spaceCheck makes backups and installs check the free disk space first.
+	spaceCheck bool
+	// freeSpace replaces the measure of the free disk space, if set.
+	freeSpace func() (uint64, error)
+	// backupRoot is the directory holding the backups, if not the backup
+	// directory inside the data dir.
+	backupRoot string
+	// auditUser is the user recorded in the audit log entries.
+	auditUser string
+	// maxBackupBytes is the maximum size in bytes of a backup archive. Zero
+	// means unlimited.
+	maxBackupBytes int64
+	// backupStore is the store of the committed backups, if not the backup
+	// directory.
+	backupStore Store
+	// stagedBackups maps the ids of the backups being written to the paths of
+	// their staging archives, guarded by stagingMu.
+	stagingMu     sync.Mutex
+	stagedBackups map[string]string
+	// lifecycleMu guards closed and done. done is closed by Close, to cancel
+	// the running operations, which are tracked by operations.
+	lifecycleMu sync.Mutex
+	closed      bool
+	done        chan struct{}
+	operations  sync.WaitGroup
+}
+
+// DataDirOption is an optional setting of a DataDir.
+type DataDirOption func(*DataDir)
+
+// WithTempQuota sets the maximum size in bytes of the temp directory. A quota
+// of 0 means unlimited, which is the default.
+func WithTempQuota(quota int64) DataDirOption {
+	return func(d *DataDir) {
+		d.tempQuota = quota
+	}
+}
+
+// WithMaxBackupBytes sets the maximum size in bytes of a backup archive. The
+// backups growing larger while being written are aborted with
+// ErrBackupTooLarge, and their partial archive removed. A maximum of 0 means
+// unlimited, which is the default.
+func WithMaxBackupBytes(max int64) DataDirOption {
+	return func(d *DataDir) {
+		d.maxBackupBytes = max
+	}
+}
+
+// WithCompressedState makes new instances store their state gzip-compressed
+// in state.json.gz, instead of state.json. Existing instances keep the form
+// they were created with. Both forms are always readable.
+func WithCompressedState() DataDirOption {
+	return func(d *DataDir) {
+		d.compressState = true
+	}
+}
+
+// WithCompactState makes the instance states be written in compact JSON,
+// instead of indented for readability, for space-sensitive deployments. Both
+// forms are always readable.
+func WithCompactState() DataDirOption {
+	return func(d *DataDir) {
+		d.compactState = true
+	}
+}
+
+// WithSync makes the instance state and backup writes be flushed to disk,
+// together with the parent directory of the written files, so they survive a
+// crash or power loss right after the write. It is off by default, as it
+// slows down every write. It is a no-op on file systems that don't support
+// syncing.
+func WithSync() DataDirOption {
+	return func(d *DataDir) {
+		d.syncWrites = true
+	}
 }
 
-// NewDataDir creates a new DataDir instance with the given path as root.
-func NewDataDir(path string, fs afero.Fs, locker locker.Locker) (*DataDir, error) {
+// NewDataDir creates a new DataDir instance with the given path as root. A
+// leading ~ in the path is expanded to the user's home directory, and relative
+// paths are resolved against the working directory. The ~user form is not
+// supported. The data dir is created if needed, unless WithReadOnly is given,
+// and identified by a marker file. A directory without marker that contains
+// files unrelated to a data dir returns ErrNotDataDir, unless WithForceInit is
+// given.
+func NewDataDir(path string, fs afero.Fs, locker locker.Locker, opts ...DataDirOption) (*DataDir, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
 	absPath, err := filepath.Abs(path)
 	if err != nil {
 		return nil, err
 	}
-	return &DataDir{path: absPath, fs: fs, locker: locker}, nil
+	absPath, err = canonicalPath(fs, absPath)
+	if err != nil {
+		return nil, err
+	}
+	d := &DataDir{path: absPath, fs: fs, locker: locker}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if err := d.initMarker(); err != nil {
+		return nil, err
+	}
+	if err := d.initBackupRoot(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// expandHome replaces a leading ~ in the given path with the user's home
+// directory. Paths starting with ~user return ErrInvalidDataDirPath.
+func expandHome(path string) (string, error) {
+	if !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+	if path != "~" && !os.IsPathSeparator(path[1]) {
+		return "", fmt.Errorf("%w: %s: ~user paths are not supported", ErrInvalidDataDirPath, path)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// canonicalPath resolves the symlinks in the given absolute path, so the data
+// dir paths are stable when the data dir is reached through a symlink. Only the
+// existing part of the path is resolved, the rest is kept as it is. Paths on
+// filesystems other than the OS one are returned unchanged.
+func canonicalPath(fs afero.Fs, path string) (string, error) {
+	if _, ok := fs.(*afero.OsFs); !ok {
+		return path, nil
+	}
+	existing, rest := path, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return path, nil
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
 }
 
 // Path returns the path of the data dir.
@@ -43,10 +238,40 @@ func (d *DataDir) Path() string {
 	return d.path
 }
 
+// Fs returns the filesystem used by the data dir.
+func (d *DataDir) Fs() afero.Fs {
+	return d.fs
+}
+
+// NodesPath returns the path of the directory holding the instances.
+func (d *DataDir) NodesPath() string {
+	return filepath.Join(d.path, nodesDirName)
+}
+
+// BackupDirPath returns the path of the directory holding the backups, the
+// one set by WithBackupRoot if any.
+func (d *DataDir) BackupDirPath() string {
+	if d.backupRoot != "" {
+		return d.backupRoot
+	}
+	return filepath.Join(d.path, backupDir)
+}
+
+// PluginDirPath returns the path of the directory holding the plugin image
+// contexts.
+func (d *DataDir) PluginDirPath() string {
+	return filepath.Join(d.path, pluginsDir)
+}
+
+// MonitoringPath returns the path of the monitoring stack directory.
+func (d *DataDir) MonitoringPath() string {
+	return filepath.Join(d.path, monitoringStackDirName)
+}
+
 // NewDataDirDefault creates a new DataDir instance with the default path as root.
 // Default path is $XDG_DATA_HOME/.eigen or $HOME/.local/share/.eigen if $XDG_DATA_HOME is not set
 // as defined in the XDG Base Directory Specification
-func NewDataDirDefault(fs afero.Fs, locker locker.Locker) (*DataDir, error) {
+func NewDataDirDefault(fs afero.Fs, locker locker.Locker, opts ...DataDirOption) (*DataDir, error) {
 	userDataHome := os.Getenv("XDG_DATA_HOME")
 	if userDataHome == "" {
 		userHome, err := os.UserHomeDir()
@@ -56,41 +281,242 @@ func NewDataDirDefault(fs afero.Fs, locker locker.Locker) (*DataDir, error) {
 		userDataHome = filepath.Join(userHome, ".local", "share")
 	}
 	dataDir := filepath.Join(userDataHome, ".eigen")
-	err := fs.MkdirAll(dataDir, 0o755)
+	// NewDataDir creates the data dir, unless it is read-only
+	return NewDataDir(dataDir, fs, locker, opts...)
+}
+
+// Instance returns the instance with the given id.
+func (d *DataDir) Instance(instanceId string) (*Instance, error) {
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+	instancePath := filepath.Join(d.path, nodesDirName, instanceId)
+	instance, err := newInstance(instancePath, d.fs, d.locker)
 	if err != nil {
 		return nil, err
 	}
-
-	return NewDataDir(dataDir, fs, locker)
+	instance.syncState = d.syncWrites
+	instance.compactState = d.compactState
+	return instance, nil
 }
 
-// Instance returns the instance with the given id.
-func (d *DataDir) Instance(instanceId string) (*Instance, error) {
+// RawInstanceState returns the stored state of the instance with the given id
+// as it is, without validating it, so states that don't validate can still be
+// inspected. Compressed states are returned decompressed.
+func (d *DataDir) RawInstanceState(instanceId string) ([]byte, error) {
 	instancePath := filepath.Join(d.path, nodesDirName, instanceId)
-	return newInstance(instancePath, d.fs, d.locker)
+	if _, err := d.fs.Stat(instancePath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, &InstanceNotFoundError{Id: instanceId}
+		}
+		return nil, err
+	}
+	stateData, _, err := readStateFile(d.fs, instancePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w %s: state.json not found", ErrInvalidInstanceDir, instancePath)
+		}
+		return nil, err
+	}
+	return stateData, nil
 }
 
 type AddInstanceOptions struct {
 	URL            string
 	Version        string
+	Commit         string
+	Digest         string
 	Profile        string
 	Tag            string
 	PackageHandler *package_handler.PackageHandler
 	Env            map[string]string
 }
 
-// InitInstance initializes a new instance. If an instance with the same id already
-// exists, an error is returned.
-func (d *DataDir) InitInstance(instance *Instance) error {
-	instancePath := filepath.Join(d.path, nodesDirName, InstanceId(instance.Name, instance.Tag))
-	_, err := d.fs.Stat(instancePath)
+// InitInstance initializes a new instance and returns its id. If an instance
+// with the same id already exists, an error is returned.
+func (d *DataDir) InitInstance(instance *Instance) (_ string, err error) {
+	if err := d.checkWritable(); err != nil {
+		return "", err
+	}
+	if err := d.checkOpen(); err != nil {
+		return "", err
+	}
+	instanceId := InstanceId(instance.Name, instance.Tag)
+	instancePath := filepath.Join(d.path, nodesDirName, instanceId)
+	_, err = d.fs.Stat(instancePath)
 	if err != nil && os.IsNotExist(err) {
-		return instance.init(instancePath, d.fs, d.locker)
+		// Invalid instances fail without waiting for the data dir lock
+		if err := instance.validate(); err != nil {
+			return "", err
+		}
+		l, err := d.rlockDataDir()
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			unlockErr := l.Unlock()
+			if err == nil {
+				err = unlockErr
+			}
+		}()
+		instance.compressState = d.compressState
+		instance.syncState = d.syncWrites
+		instance.compactState = d.compactState
+		if instance.CreatedAt == nil {
+			now := d.now().UTC()
+			instance.CreatedAt, instance.UpdatedAt = &now, &now
+		}
+		if err := instance.init(d.NodesPath(), instancePath, d.fs, d.locker); err != nil {
+			return "", err
+		}
+		d.audit(AuditInstanceInstalled, instanceId)
+		return instanceId, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%w: %s", ErrInstanceAlreadyExists, instanceId)
+}
+
+// UpsertInstance installs the instance if it doesn't exist yet. Otherwise it
+// compares the fingerprint of the given instance with the stored one, and only
+// rewrites the stored state when they differ. Volatile state, such as the
+// maintenance flag and the labels, is kept from the stored instance, as is its
+// creation time, while its update time is set. It returns whether anything was
+// written.
+func (d *DataDir) UpsertInstance(instance *Instance) (changed bool, err error) {
+	if err := d.checkWritable(); err != nil {
+		return false, err
+	}
+	if err := d.checkOpen(); err != nil {
+		return false, err
+	}
+	instanceId := InstanceId(instance.Name, instance.Tag)
+	if !d.HasInstance(instanceId) {
+		_, err := d.InitInstance(instance)
+		return true, err
 	}
+	stored, err := d.Instance(instanceId)
 	if err != nil {
+		return false, err
+	}
+	storedFingerprint, err := stored.Fingerprint()
+	if err != nil {
+		return false, err
+	}
+	fingerprint, err := instance.Fingerprint()
+	if err != nil {
+		return false, err
+	}
+	if fingerprint == storedFingerprint {
+		return false, nil
+	}
+
+	if err = instance.validate(); err != nil {
+		return false, err
+	}
+	instance.path = stored.path
+	instance.fs = d.fs
+	instance.locker = d.locker.New(filepath.Join(stored.path, ".lock"))
+	instance.Maintenance = stored.Maintenance
+	instance.Labels = stored.Labels
+	now := d.now().UTC()
+	instance.CreatedAt, instance.UpdatedAt = stored.CreatedAt, &now
+	instance.compressState = stored.compressState
+	instance.syncState = d.syncWrites
+	instance.compactState = d.compactState
+	if err = instance.lock(); err != nil {
+		return false, err
+	}
+	defer func() {
+		unlockErr := instance.unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+	if err = instance.saveState(); err != nil {
+		return false, err
+	}
+	d.audit(AuditInstanceUpdated, instanceId)
+	return true, nil
+}
+
+// RepairInstance fills the empty required fields of the stored state of the
+// instance with the given id from defaults, and writes the repaired state. It
+// works on the raw state, so states that don't validate can be repaired, and
+// it never overwrites a non-empty field or drops unknown fields. The repaired
+// state must validate, and its name and tag must still match the instance id.
+func (d *DataDir) RepairInstance(instanceId string, defaults Instance) (err error) {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if err := d.checkOpen(); err != nil {
+		return err
+	}
+	instancePath := filepath.Join(d.path, nodesDirName, instanceId)
+	if _, err = d.fs.Stat(instancePath); err != nil {
+		if os.IsNotExist(err) {
+			return &InstanceNotFoundError{Id: instanceId}
+		}
 		return err
 	}
-	return fmt.Errorf("%w: %s", ErrInstanceAlreadyExists, InstanceId(instance.Name, instance.Tag))
+	l := d.locker.New(filepath.Join(instancePath, ".lock"))
+	if err = l.Lock(); err != nil {
+		return err
+	}
+	defer func() {
+		unlockErr := l.Unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+
+	stateData, compressed, err := readStateFile(d.fs, instancePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w %s: state.json not found", ErrInvalidInstanceDir, instancePath)
+		}
+		return err
+	}
+	var state map[string]interface{}
+	if err = json.Unmarshal(stateData, &state); err != nil {
+		return fmt.Errorf("%w %s: invalid state.json file: %s", ErrInvalidInstance, instancePath, err)
+	}
+	isEmpty := func(key string) bool {
+		value, _ := state[key].(string)
+		return value == ""
+	}
+	fill := func(key, value string) {
+		if isEmpty(key) && value != "" {
+			state[key] = value
+		}
+	}
+	fill("name", defaults.Name)
+	fill("url", defaults.URL)
+	if isEmpty("commit") {
+		fill("version", defaults.Version)
+	}
+	fill("profile", defaults.Profile)
+	fill("tag", defaults.Tag)
+
+	repairedData, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	var repaired Instance
+	if err = json.Unmarshal(repairedData, &repaired); err != nil {
+		return fmt.Errorf("%w %s: invalid state.json file: %s", ErrInvalidInstance, instancePath, err)
+	}
+	if err = repaired.validateStored(); err != nil {
+		return err
+	}
+	if repaired.ID() != instanceId {
+		return fmt.Errorf("%w: repaired name and tag give id %s instead of %s", ErrInvalidInstance, repaired.ID(), instanceId)
+	}
+	if repairedData, err = marshalState(state, d.compactState); err != nil {
+		return err
+	}
+	return writeStateFile(d.fs, instancePath, repairedData, compressed, d.syncWrites)
 }
 
 // HasInstance returns true if an instance with the given id already exists in the
@@ -101,61 +527,293 @@ func (d *DataDir) HasInstance(instanceId string) bool {
 	return err == nil
 }
 
+// NextAvailableTag returns the lowest <prefix>-N tag, starting at N=1, that no
+// instance of the given name uses yet.
+func (d *DataDir) NextAvailableTag(name, prefix string) (string, error) {
+	if err := validateIdPart("name", name); err != nil {
+		return "", err
+	}
+	if err := validateIdPart("tag prefix", prefix); err != nil {
+		return "", err
+	}
+	dirEntries, err := afero.ReadDir(d.fs, d.NodesPath())
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	ids := make(map[string]bool, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		ids[dirEntry.Name()] = true
+	}
+	for n := 1; ; n++ {
+		tag := fmt.Sprintf("%s-%d", prefix, n)
+		if !ids[InstanceId(name, tag)] {
+			return tag, nil
+		}
+	}
+}
+
 // InstancePath return the path to the directory of the instance with the given id.
 func (d *DataDir) InstancePath(instanceId string) (string, error) {
 	instancePath := filepath.Join(d.path, nodesDirName, instanceId)
 	_, err := d.fs.Stat(instancePath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return "", ErrInstanceNotFound
+			return "", &InstanceNotFoundError{Id: instanceId}
 		}
 		return "", err
 	}
 	return instancePath, nil
 }
 
+// ReplaceInstanceDirFromTar replaces the directory of the instance with the
+// given id with the srcPath directory of the tar archive at tarPath.
 func (d *DataDir) ReplaceInstanceDirFromTar(instanceId, tarPath, srcPath string) error {
-	// Clear instance dir
+	return d.ReplaceInstanceDirFromTarContext(context.Background(), instanceId, tarPath, srcPath)
+}
+
+// ReplaceInstanceDirFromTarContext is like ReplaceInstanceDirFromTar, but
+// stops before the next entry once ctx is done, returning the context error.
+// The directory is extracted to a temp directory first and only then moved
+// into place, so a cancelled or failed extraction leaves the instance as it
+// was.
+func (d *DataDir) ReplaceInstanceDirFromTarContext(ctx context.Context, instanceId, tarPath, srcPath string) (err error) {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	ctx, done, err := d.startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
 	instancePath := filepath.Join(d.path, nodesDirName, instanceId)
-	err := d.fs.RemoveAll(instancePath)
+	if err := checkInstancePath(d.NodesPath(), instancePath); err != nil {
+		return err
+	}
+	f, err := d.fs.Open(tarPath)
 	if err != nil {
 		return err
 	}
-	// Create instance dir
-	err = d.fs.MkdirAll(instancePath, 0o755)
+	defer f.Close()
+
+	stagingId := "restore-" + instanceId
+	stagingPath, err := d.InitTemp(stagingId)
 	if err != nil {
 		return err
 	}
-	return backuptar.ExtractDir(tarPath, srcPath, instancePath)
+	defer func() {
+		removeErr := d.RemoveTemp(stagingId)
+		if err == nil {
+			err = removeErr
+		}
+	}()
+	if err := d.extractTarDir(ctx, f, srcPath, stagingPath); err != nil {
+		return err
+	}
+	unlock, err := d.lockInstanceDir(instanceId)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		unlockErr := unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+	return d.replaceInstanceDir(instanceId, stagingPath, true)
 }
 
-// RemoveInstance removes the instance with the given id.
-func (d *DataDir) RemoveInstance(instanceId string) error {
+// RemoveInstance removes the instance with the given id. Instances in
+// maintenance mode, or other instances depend on, are not removed unless force
+// is true.
+func (d *DataDir) RemoveInstance(instanceId string, force bool) (err error) {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if err := d.checkOpen(); err != nil {
+		return err
+	}
 	instancePath := filepath.Join(d.path, nodesDirName, instanceId)
 	instanceDir, err := d.fs.Stat(instancePath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("%w: %s", ErrInstanceNotFound, instanceId)
+			return &InstanceNotFoundError{Id: instanceId}
 		}
 		return err
 	}
 	if !instanceDir.IsDir() {
 		return fmt.Errorf("%s is not a directory", instanceId)
 	}
-	return d.fs.RemoveAll(instancePath)
+	if !force {
+		if err := d.checkMaintenance(instanceId); err != nil {
+			return err
+		}
+		dependents, err := d.Dependents(instanceId)
+		if err != nil {
+			return err
+		}
+		if len(dependents) > 0 {
+			return fmt.Errorf("%w: %s is required by %s", ErrInstanceHasDependents, instanceId, strings.Join(dependents, ", "))
+		}
+	}
+	l, err := d.rlockDataDir()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		unlockErr := l.Unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+	// Mark the instance as being deleted first, so a failed removal leaves a
+	// detectable leftover instead of a half-removed instance.
+	deletingPath := instancePath + deletingSuffix
+	exists, err := afero.DirExists(d.fs, deletingPath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		// Leftover from a previous failed removal
+		if err := d.fs.RemoveAll(deletingPath); err != nil {
+			return err
+		}
+	}
+	if err := d.fs.Rename(instancePath, deletingPath); err != nil {
+		return err
+	}
+	if err := d.fs.RemoveAll(deletingPath); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInstancePendingRemoval, instanceId, err)
+	}
+	d.audit(AuditInstanceRemoved, instanceId)
+	return updateLabelIndex(d.fs, d.NodesPath(), instanceId, nil, true, d.syncWrites)
+}
+
+// Dependents returns the ids of the instances depending on the instance with
+// the given id, sorted. Like checkMaintenance, the states are read without
+// loading the instances, and instances without a readable state are skipped,
+// so a broken instance doesn't prevent removing the others.
+func (d *DataDir) Dependents(instanceId string) ([]string, error) {
+	dirEntries, err := afero.ReadDir(d.fs, d.NodesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var dependents []string
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() || dirEntry.Name() == instanceId || strings.HasSuffix(dirEntry.Name(), deletingSuffix) {
+			continue
+		}
+		stateData, _, err := readStateFile(d.fs, filepath.Join(d.NodesPath(), dirEntry.Name()))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		var state struct {
+			DependsOn []string `json:"depends_on"`
+		}
+		if err := json.Unmarshal(stateData, &state); err != nil {
+			continue
+		}
+		if slices.Contains(state.DependsOn, instanceId) {
+			dependents = append(dependents, dirEntry.Name())
+		}
+	}
+	return dependents, nil
+}
+
+// checkMaintenance returns an ErrInstanceInMaintenance error if the instance
+// with the given id is in maintenance mode. Instances without a readable
+// state.json are not considered in maintenance, so they can be cleaned up.
+func (d *DataDir) checkMaintenance(instanceId string) error {
+	stateData, _, err := readStateFile(d.fs, filepath.Join(d.path, nodesDirName, instanceId))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var state struct {
+		Maintenance bool `json:"maintenance"`
+	}
+	if err := json.Unmarshal(stateData, &state); err != nil {
+		return nil
+	}
+	if state.Maintenance {
+		return fmt.Errorf("%w: %s", ErrInstanceInMaintenance, instanceId)
+	}
+	return nil
+}
+
+// GC finishes the removal of instances that were marked as being deleted by a
+// failed RemoveInstance call, and rebuilds the label index if it is stale.
+func (d *DataDir) GC() error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if err := d.checkOpen(); err != nil {
+		return err
+	}
+	nodesDirPath := filepath.Join(d.path, nodesDirName)
+	dirEntries, err := afero.ReadDir(d.fs, nodesDirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() && strings.HasSuffix(dirEntry.Name(), deletingSuffix) {
+			logrus.Debugf("Removing leftover instance directory %s", dirEntry.Name())
+			if err := d.fs.RemoveAll(filepath.Join(nodesDirPath, dirEntry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return d.rebuildLabelIndex()
 }
 
 // InitTemp creates a new temporary directory for the given id. If already exists,
-// an error is returned.
+// as a leftover of an interrupted run, an empty directory is reused as it is,
+// while the content of a partially filled one is removed. If the temp
+// directory quota is already used, an ErrTempQuotaExceeded error is returned.
 func (d *DataDir) InitTemp(id string) (string, error) {
+	if err := d.checkWritable(); err != nil {
+		return "", err
+	}
+	if err := d.checkOpen(); err != nil {
+		return "", err
+	}
 	tempPath := filepath.Join(d.path, tempDir, id)
-	_, err := d.fs.Stat(tempPath)
+	if d.tempQuota > 0 {
+		usage, err := d.tempUsage(tempPath)
+		if err != nil {
+			return "", err
+		}
+		if usage >= d.tempQuota {
+			return "", fmt.Errorf("%w: %d bytes used of %d", ErrTempQuotaExceeded, usage, d.tempQuota)
+		}
+	}
+	info, err := d.fs.Stat(tempPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return tempPath, d.fs.MkdirAll(tempPath, 0o755)
 		}
 		return "", err
 	}
+	if info.IsDir() {
+		empty, err := afero.IsEmpty(d.fs, tempPath)
+		if err != nil {
+			return "", err
+		}
+		if empty {
+			logrus.Debugf("Reusing empty temp dir %s", id)
+			return tempPath, nil
+		}
+	}
 	// Clear temp dir if it already exists
 	logrus.Debugf("Temp dir %s already exists, removing its content", id)
 	err = d.fs.RemoveAll(tempPath)
@@ -165,54 +823,287 @@ func (d *DataDir) InitTemp(id string) (string, error) {
 	return tempPath, d.fs.MkdirAll(tempPath, 0o755)
 }
 
-// RemoveTemp removes the temporary directory with the given id.
-func (d *DataDir) RemoveTemp(id string) error {
-	return d.fs.RemoveAll(filepath.Join(d.path, tempDir, id))
+// tempUsage returns the size in bytes of the temp directory, without counting
+// the files under exclude as they are going to be removed.
+func (d *DataDir) tempUsage(exclude string) (int64, error) {
+	var usage int64
+	err := afero.Walk(d.fs, filepath.Join(d.path, tempDir), func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if path == exclude && info.IsDir() {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() {
+			usage += info.Size()
+		}
+		return nil
+	})
+	return usage, err
+}
+
+// RemoveTemp removes the temporary directory with the given id.
+func (d *DataDir) RemoveTemp(id string) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	return d.fs.RemoveAll(filepath.Join(d.path, tempDir, id))
+}
+
+// PruneTempDirs removes the temporary directories not modified for longer than
+// maxAge. It returns the ids of the removed directories.
+func (d *DataDir) PruneTempDirs(maxAge time.Duration) ([]string, error) {
+	if err := d.checkWritable(); err != nil {
+		return nil, err
+	}
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+	tempEntries, err := afero.ReadDir(d.fs, filepath.Join(d.path, tempDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cutoff := d.now().Add(-maxAge)
+	var removed []string
+	for _, tempEntry := range tempEntries {
+		if !tempEntry.IsDir() || !tempEntry.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := d.RemoveTemp(tempEntry.Name()); err != nil {
+			return removed, err
+		}
+		removed = append(removed, tempEntry.Name())
+	}
+	return removed, nil
+}
+
+// TempPath returns the path to the temporary directory with the given id.
+func (d *DataDir) TempPath(id string) (string, error) {
+	tempPath := filepath.Join(d.path, tempDir, id)
+	tempStat, err := d.fs.Stat(tempPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", &TempNotFoundError{Id: id}
+		}
+		return "", err
+	}
+	if !tempStat.IsDir() {
+		return "", ErrTempIsNotDir
+	}
+	return tempPath, nil
+}
+
+// BackupList returns the list of paths to all the backups.
+func (d *DataDir) BackupList() ([]Backup, error) {
+	if !d.readOnly {
+		if err := d.initBackupDir(); err != nil {
+			return nil, err
+		}
+	}
+	backupFiles, err := d.backupStorage().List()
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []Backup
+	for _, backupFile := range backupFiles {
+		if !backupFile.IsDir() && filepath.Ext(backupFile.Name()) == ".tar" {
+			b, err := d.storedBackup(backupFile.Name())
+			if err != nil {
+				return nil, err
+			}
+			// The file name is the backup id, which is not derived from the
+			// backup content for timestamped backups.
+			b.id = strings.TrimSuffix(backupFile.Name(), ".tar")
+			// Enrich the backup with its manifest, if any. Backups created
+			// before manifests were introduced don't have one.
+			manifest, err := d.BackupManifest(b.Id())
+			if err == nil {
+				b.Checksum = manifest.Checksum
+			} else if !errors.Is(err, ErrBackupManifestNotFound) {
+				return nil, err
+			}
+			backups = append(backups, *b)
+		}
+	}
+	return backups, nil
+}
+
+// BackupsByInstance returns the backups grouped by instance id.
+func (d *DataDir) BackupsByInstance() (map[string][]Backup, error) {
+	backups, err := d.BackupList()
+	if err != nil {
+		return nil, err
+	}
+	grouped := make(map[string][]Backup)
+	for _, b := range backups {
+		grouped[b.InstanceId] = append(grouped[b.InstanceId], b)
+	}
+	return grouped, nil
+}
+
+// LatestBackup returns the id of the newest backup of the given instance, by
+// backup timestamp. It returns ErrBackupNotFound if the instance has no
+// backups.
+func (d *DataDir) LatestBackup(instanceId string) (string, error) {
+	backups, err := d.BackupList()
+	if err != nil {
+		return "", err
+	}
+	var latest *Backup
+	for i, b := range backups {
+		if b.InstanceId != instanceId {
+			continue
+		}
+		if latest == nil || b.Timestamp.After(latest.Timestamp) {
+			latest = &backups[i]
+		}
+	}
+	if latest == nil {
+		return "", fmt.Errorf("%w: no backups of instance %s", ErrBackupNotFound, instanceId)
+	}
+	return latest.Id(), nil
+}
+
+// PruneBackups removes the backups older than maxAge, along with their
+// manifests. It returns the ids of the removed backups.
+func (d *DataDir) PruneBackups(maxAge time.Duration) ([]string, error) {
+	result, err := d.PruneBackupsWithOptions(maxAge, PruneOptions{})
+	return result.Removed, err
+}
+
+// PruneOptions are the settings of PruneBackupsWithOptions.
+type PruneOptions struct {
+	// KeepLastVerified is the number of most recent verified backups of each
+	// instance that are never pruned, whatever their age. A backup is verified
+	// if its archive matches the checksum of its manifest. When set, the
+	// backups of an instance without any verified backup are not pruned. Zero
+	// disables the verification.
+	KeepLastVerified int
 }
 
-// TempPath returns the path to the temporary directory with the given id.
-func (d *DataDir) TempPath(id string) (string, error) {
-	tempPath := filepath.Join(d.path, tempDir, id)
-	tempStat, err := d.fs.Stat(tempPath)
+// PruneResult is the outcome of PruneBackupsWithOptions.
+type PruneResult struct {
+	// Removed are the ids of the removed backups.
+	Removed []string
+	// VerifiedKept are the ids of the verified backups kept by
+	// PruneOptions.KeepLastVerified, newest first for each instance.
+	VerifiedKept []string
+}
+
+// PruneBackupsWithOptions is like PruneBackups, but with the given options,
+// so a corrupt recent backup doesn't cause the good older ones to be pruned.
+// The instances without any verified backup left are skipped, and returned as
+// an ErrNoVerifiedBackup error once the other instances are pruned.
+func (d *DataDir) PruneBackupsWithOptions(maxAge time.Duration, opts PruneOptions) (PruneResult, error) {
+	var result PruneResult
+	if err := d.checkWritable(); err != nil {
+		return result, err
+	}
+	if err := d.checkOpen(); err != nil {
+		return result, err
+	}
+	backupsByInstance, err := d.BackupsByInstance()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return "", ErrTempDirDoesNotExist
-		}
-		return "", err
+		return result, err
 	}
-	if !tempStat.IsDir() {
-		return "", ErrTempIsNotDir
+	instanceIds := make([]string, 0, len(backupsByInstance))
+	for instanceId := range backupsByInstance {
+		instanceIds = append(instanceIds, instanceId)
 	}
-	return tempPath, nil
+	sort.Strings(instanceIds)
+
+	cutoff := d.now().Add(-maxAge)
+	var errs []error
+	for _, instanceId := range instanceIds {
+		backups := backupsByInstance[instanceId]
+		keep := make(map[string]bool)
+		if opts.KeepLastVerified > 0 {
+			// Keep the newest verified backups
+			sort.Slice(backups, func(i, j int) bool {
+				return backups[i].Timestamp.After(backups[j].Timestamp)
+			})
+			for _, backup := range backups {
+				if len(keep) == opts.KeepLastVerified {
+					break
+				}
+				verified, err := d.verifyBackup(backup.Id())
+				if err != nil {
+					return result, err
+				}
+				if verified {
+					keep[backup.Id()] = true
+					result.VerifiedKept = append(result.VerifiedKept, backup.Id())
+				}
+			}
+			if len(keep) == 0 {
+				errs = append(errs, fmt.Errorf("%w: %s", ErrNoVerifiedBackup, instanceId))
+				continue
+			}
+		}
+		for _, backup := range backups {
+			if keep[backup.Id()] || !backup.Timestamp.Before(cutoff) {
+				continue
+			}
+			if err := d.RemoveBackup(backup.Id()); err != nil {
+				return result, err
+			}
+			d.audit(AuditBackupPruned, backup.Id())
+			result.Removed = append(result.Removed, backup.Id())
+		}
+	}
+	return result, errors.Join(errs...)
 }
 
-// BackupList returns the list of paths to all the backups.
-func (d *DataDir) BackupList() ([]Backup, error) {
-	err := d.initBackupDir()
-	if err != nil {
-		return nil, err
+// verifyBackup returns true if the archive of the backup with the given id
+// matches the checksum of its manifest. Backups without manifest or with an
+// invalid one are not verified.
+func (d *DataDir) verifyBackup(backupId string) (bool, error) {
+	manifest, err := d.BackupManifest(backupId)
+	if errors.Is(err, ErrBackupManifestNotFound) || errors.Is(err, ErrInvalidBackupManifest) {
+		return false, nil
+	} else if err != nil {
+		return false, err
 	}
-	backupFiles, err := afero.ReadDir(d.fs, d.backupsDir())
+	checksum, err := d.BackupChecksum(backupId)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
+	return manifest.Checksum != "" && checksum == manifest.Checksum, nil
+}
 
-	var backups []Backup
-	for _, backupFile := range backupFiles {
-		if !backupFile.IsDir() && filepath.Ext(backupFile.Name()) == ".tar" {
-			b, err := BackupFromTar(d.fs, filepath.Join(d.backupsDir(), backupFile.Name()))
-			if err != nil {
-				return nil, err
-			}
-			backups = append(backups, *b)
-		}
+// RemoveBackup removes the backup archive and its manifest. Missing files are
+// ignored. A backup being written by this data dir, not committed yet, only has
+// its staging archive removed.
+func (d *DataDir) RemoveBackup(backupId string) error {
+	if err := d.checkWritable(); err != nil {
+		return err
 	}
-	return backups, nil
+	if err := d.checkOpen(); err != nil {
+		return err
+	}
+	if staged, err := d.removeStagedBackup(backupId); staged {
+		return err
+	}
+	store := d.backupStorage()
+	if err := store.Remove(backupArchiveName(backupId)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := store.Remove(backupManifestName(backupId)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
 }
 
 // BackupSize returns the size in bytes of the backup with the given id.
 func (d *DataDir) BackupSize(backupId string) (int64, error) {
-	backupStat, err := d.fs.Stat(d.BackupPath(backupId))
+	backupStat, err := d.backupStorage().Stat(backupArchiveName(backupId))
 	if err != nil {
 		return -1, err
 	}
@@ -220,7 +1111,7 @@ func (d *DataDir) BackupSize(backupId string) (int64, error) {
 }
 
 // Backup returns the backup with the given id. If the backup does not exist,
-// an ErrBackupNotFound error is returned.
+// a BackupNotFoundError error is returned.
 func (d *DataDir) Backup(backupId string) (*Backup, error) {
 	backups, err := d.BackupList()
 	if err != nil {
@@ -231,14 +1122,18 @@ func (d *DataDir) Backup(backupId string) (*Backup, error) {
 			return &backup, nil
 		}
 	}
-	return nil, ErrBackupNotFound
+	return nil, &BackupNotFoundError{Id: backupId}
 }
 
-// HasBackup returns true if the backup with the given id exists.
+// HasBackup returns true if the backup with the given id exists, or is being
+// written by this data dir.
 func (d *DataDir) HasBackup(backupId string) (bool, error) {
-	_, err := d.fs.Stat(d.BackupPath(backupId))
+	if _, ok := d.stagedBackup(backupId); ok {
+		return true, nil
+	}
+	_, err := d.backupStorage().Stat(backupArchiveName(backupId))
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, os.ErrNotExist) {
 			return false, nil
 		}
 		return false, err
@@ -246,14 +1141,36 @@ func (d *DataDir) HasBackup(backupId string) (bool, error) {
 	return true, nil
 }
 
-// BackupPath returns the path to the backup with the given id.
+// BackupPath returns the path to the backup with the given id, in the backup
+// directory. Backups kept in another store, see WithBackupStore, have no path,
+// see LocalBackupPath instead.
 func (d *DataDir) BackupPath(backupId string) string {
-	return filepath.Join(d.path, backupDir, backupId+".tar")
+	return filepath.Join(d.BackupDirPath(), backupArchiveName(backupId))
 }
 
 // InitBackup initialized a new backup. If a backup with the same id already
-// exists, an ErrBackupAlreadyExists error is returned.
+// exists, an ErrBackupAlreadyExists error is returned. If the instance of the
+// backup is locked by another process, which may be writing to it, an
+// ErrInstanceBusy error is returned, as the backup could be inconsistent. The
+// backup is written to a staging archive, see BackupWritePath, until it is
+// committed by WriteBackupManifest.
 func (d *DataDir) InitBackup(b *Backup) error {
+	return d.initBackup(b, false)
+}
+
+// InitBackupForce is like InitBackup, but only warns if the instance of the
+// backup is locked by another process.
+func (d *DataDir) InitBackupForce(b *Backup) error {
+	return d.initBackup(b, true)
+}
+
+func (d *DataDir) initBackup(b *Backup, force bool) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if err := d.checkOpen(); err != nil {
+		return err
+	}
 	// Check if backup already exists
 	exists, err := d.HasBackup(b.Id())
 	if err != nil {
@@ -262,22 +1179,226 @@ func (d *DataDir) InitBackup(b *Backup) error {
 	if exists {
 		return fmt.Errorf("%w: %s", ErrBackupAlreadyExists, b.Id())
 	}
+	// Check the instance is not being written
+	if b.InstanceId != "" && d.HasInstance(b.InstanceId) {
+		busy, err := d.InstanceBusy(b.InstanceId)
+		if err != nil {
+			return err
+		}
+		if busy {
+			if !force {
+				return fmt.Errorf("%w: %s", ErrInstanceBusy, b.InstanceId)
+			}
+			logrus.Warnf("Instance %s is locked by another process, the backup may be inconsistent", b.InstanceId)
+		}
+		if d.spaceCheck {
+			instance, err := d.Instance(b.InstanceId)
+			if err != nil {
+				return err
+			}
+			size, err := instance.DiskUsage()
+			if err != nil {
+				return err
+			}
+			if err := d.checkSpace(size); err != nil {
+				return err
+			}
+		}
+	}
 	// Create backup directory if it does not exist
 	err = d.initBackupDir()
 	if err != nil {
 		return err
 	}
-	// Initialize backup tar file
-	return backuptar.InitBackupTar(d.BackupPath(b.Id()))
-	// return utils.TarInit(d.fs, d.BackupPath(b.Id()))
+	// Initialize the backup tar file in a staging archive, moved to the
+	// backup path by CommitBackup once complete
+	stagingPath, err := d.stageBackup(b.Id())
+	if err != nil {
+		return WrapDiskFull(err)
+	}
+	if err = backuptar.InitBackupTar(stagingPath); err != nil {
+		return d.abortBackup(b.Id(), err)
+	}
+	return nil
 }
 
-func (d *DataDir) backupsDir() string {
-	return filepath.Join(d.path, backupDir)
+// AddBackupFile appends the file at srcPath to the archive of the backup with
+// the given id, as archivePath. If it fails, the partial backup is removed so
+// it isn't mistaken for a complete one, and a full disk returns ErrDiskFull.
+func (d *DataDir) AddBackupFile(backupId, srcPath, archivePath string) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if err := d.checkOpen(); err != nil {
+		return err
+	}
+	if err := utils.TarAddFileLimited(d.fs, d.BackupWritePath(backupId), srcPath, archivePath, d.maxBackupBytes); err != nil {
+		return d.abortBackup(backupId, err)
+	}
+	return nil
+}
+
+// AddBackupDir is like AddBackupFile, but appends the directory tree at srcDir
+// under archiveDir. The paths matching the exclude patterns are left out, see
+// utils.ExcludedPath.
+func (d *DataDir) AddBackupDir(backupId, srcDir, archiveDir string, exclude ...string) error {
+	return d.AddBackupDirContext(context.Background(), backupId, srcDir, archiveDir, exclude...)
+}
+
+// AddBackupDirContext is like AddBackupDir, but stops before the next entry
+// once ctx is done, removing the partial backup and returning the context
+// error.
+func (d *DataDir) AddBackupDirContext(ctx context.Context, backupId, srcDir, archiveDir string, exclude ...string) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if err := d.checkOpen(); err != nil {
+		return err
+	}
+	if err := utils.TarAddDirLimitedContext(ctx, d.fs, d.BackupWritePath(backupId), srcDir, archiveDir, d.maxBackupBytes, exclude...); err != nil {
+		return d.abortBackup(backupId, err)
+	}
+	return nil
+}
+
+// abortBackup removes the partial backup with the given id after the backup
+// failed with err, which is returned wrapped with ErrDiskFull if the disk is
+// full, or with ErrBackupTooLarge if the archive exceeded the maximum backup
+// size.
+func (d *DataDir) abortBackup(backupId string, err error) error {
+	if errors.Is(err, utils.ErrTarTooLarge) {
+		err = fmt.Errorf("%w: %s exceeds %d bytes", ErrBackupTooLarge, backupId, d.maxBackupBytes)
+	}
+	if removeErr := d.RemoveBackup(backupId); removeErr != nil {
+		err = errors.Join(err, removeErr)
+	}
+	return WrapDiskFull(err)
+}
+
+// InstanceBusy reports whether the instance with the given id is locked for
+// writing by another process. It doesn't wait for the lock to be released.
+func (d *DataDir) InstanceBusy(instanceId string) (bool, error) {
+	l := d.locker.New(filepath.Join(d.path, nodesDirName, instanceId, ".lock"))
+	ctx, cancel := context.WithTimeout(context.Background(), instanceBusyProbeTimeout)
+	defer cancel()
+	locked, err := l.TryRLockContext(ctx, instanceBusyProbeTimeout)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return false, err
+	}
+	if !locked {
+		return true, nil
+	}
+	return false, l.Unlock()
+}
+
+// InitTimestampedBackup initializes a new backup of the given instance, named
+// after the instance id and the current time, so that successive backups of
+// the same instance don't collide. If a backup with the same name already
+// exists, it returns ErrBackupAlreadyExists.
+func (d *DataDir) InitTimestampedBackup(instanceId string) (*Backup, error) {
+	instance, err := d.Instance(instanceId)
+	if err != nil {
+		return nil, err
+	}
+	timestamp := d.now().Truncate(time.Second)
+	b := &Backup{
+		id:         TimestampedBackupId(instanceId, timestamp),
+		InstanceId: instanceId,
+		Timestamp:  timestamp,
+		Version:    instance.Version,
+		Commit:     instance.Commit,
+		Url:        instance.URL,
+	}
+	if err := d.InitBackup(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// BackupManifestPath returns the path to the manifest of the backup with the
+// given id.
+func (d *DataDir) BackupManifestPath(backupId string) string {
+	return filepath.Join(d.BackupDirPath(), backupManifestName(backupId))
+}
+
+// WriteBackupManifest commits the given backup with CommitBackup and writes its
+// manifest next to its archive. The manifest records the state.json of the
+// source instance and the checksum of the archive, so it must be written once
+// the archive is complete.
+func (d *DataDir) WriteBackupManifest(b *Backup) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if err := d.checkOpen(); err != nil {
+		return err
+	}
+	state, _, err := readStateFile(d.fs, filepath.Join(d.path, nodesDirName, b.InstanceId))
+	if err != nil {
+		return err
+	}
+	checksum, err := d.BackupChecksum(b.Id())
+	if err != nil {
+		return err
+	}
+	manifest := BackupManifest{
+		InstanceId: b.InstanceId,
+		State:      state,
+		Timestamp:  b.Timestamp,
+		Checksum:   checksum,
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = d.CommitBackup(b); err != nil {
+		return err
+	}
+	if d.syncWrites && d.backupStore == nil {
+		// The manifest marks the archive as complete, so flush the archive first
+		if err = syncPath(d.fs, d.BackupPath(b.Id())); err != nil {
+			return err
+		}
+	}
+	if err = d.writeBackupManifestFile(b.Id(), manifestData); err != nil {
+		return WrapDiskFull(err)
+	}
+	b.Checksum = checksum
+	return nil
+}
+
+// BackupChecksum returns the hex encoded SHA-256 of the backup archive with the
+// given id, or of its staging archive if the backup is being written.
+func (d *DataDir) BackupChecksum(backupId string) (string, error) {
+	if staging, ok := d.stagedBackup(backupId); ok {
+		return fileChecksum(d.fs, staging)
+	}
+	r, err := d.backupStorage().Open(backupArchiveName(backupId))
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	return readerChecksum(r)
+}
+
+// BackupManifest returns the manifest of the backup with the given id. If the
+// backup has no manifest, an ErrBackupManifestNotFound error is returned.
+func (d *DataDir) BackupManifest(backupId string) (*BackupManifest, error) {
+	manifestData, err := d.readBackupManifestFile(backupId)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrBackupManifestNotFound, backupId)
+		}
+		return nil, err
+	}
+	var manifest BackupManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBackupManifest, err)
+	}
+	return &manifest, nil
 }
 
 func (d *DataDir) initBackupDir() error {
-	backupDirPath := d.backupsDir()
+	backupDirPath := d.BackupDirPath()
 	ok, err := afero.DirExists(d.fs, backupDirPath)
 	if err != nil {
 		return err
@@ -291,14 +1412,34 @@ func (d *DataDir) initBackupDir() error {
 	return nil
 }
 
+// HasMonitoringStack returns true if the monitoring stack directory exists.
+// Unlike MonitoringStack, it never creates the stack. Its path is given by
+// MonitoringPath.
+func (d *DataDir) HasMonitoringStack() (bool, error) {
+	return afero.DirExists(d.fs, d.MonitoringPath())
+}
+
 // MonitoringStack checks if a monitoring stack directory exists in the data directory.
 // If the directory does not exist, it creates it and initializes a new MonitoringStack instance.
 // If the directory exists, it simply returns a new MonitoringStack instance.
 // It returns an error if there is any issue accessing or creating the directory, or initializing the MonitoringStack.
 func (d *DataDir) MonitoringStack() (*MonitoringStack, error) {
-	monitoringStackPath := filepath.Join(d.path, monitoringStackDirName)
-	_, err := d.fs.Stat(monitoringStackPath)
+	return d.MonitoringStackNamed("")
+}
+
+// MonitoringStackNamed is like MonitoringStack, but for the monitoring stack
+// with the given name. Named stacks live in their own directory, isolated from
+// the default stack, which is the one with the empty name.
+func (d *DataDir) MonitoringStackNamed(name string) (*MonitoringStack, error) {
+	monitoringStackPath, err := d.monitoringStackPath(name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = d.fs.Stat(monitoringStackPath)
 	if os.IsNotExist(err) {
+		if err = d.checkWritable(); err != nil {
+			return nil, err
+		}
 		if err = d.fs.MkdirAll(monitoringStackPath, 0o755); err != nil {
 			return nil, err
 		}
@@ -307,6 +1448,7 @@ func (d *DataDir) MonitoringStack() (*MonitoringStack, error) {
 		if err = monitoringStack.Init(); err != nil {
 			return nil, err
 		}
+		d.audit(AuditMonitoringStackCreated, name)
 		return monitoringStack, nil
 	} else if err != nil {
 		return nil, err
@@ -315,88 +1457,288 @@ func (d *DataDir) MonitoringStack() (*MonitoringStack, error) {
 	return newMonitoringStack(monitoringStackPath, d.fs, d.locker), nil
 }
 
+// monitoringStackPath returns the directory of the monitoring stack with the
+// given name. The default stack, with the empty name, is in the monitoring
+// directory, and named stacks in monitoring-<name> directories.
+func (d *DataDir) monitoringStackPath(name string) (string, error) {
+	if name == "" {
+		return filepath.Join(d.path, monitoringStackDirName), nil
+	}
+	if !instanceIdPartRegex.MatchString(name) || strings.Contains(name, "..") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidMonitoringStackName, name)
+	}
+	return filepath.Join(d.path, monitoringStackDirName+"-"+name), nil
+}
+
 // RemoveMonitoringStack removes the monitoring stack directory from the data directory.
 // It returns an error if there is any issue accessing or removing the directory.
+//
+// The directory is removed while holding the monitoring stack lock. If the lock
+// is not released within a short timeout, it returns ErrMonitoringStackLocked.
+// The monitoring services must be stopped before removing the stack, as the
+// lock only guards against concurrent changes to the stack files.
 func (d *DataDir) RemoveMonitoringStack() error {
-	monitoringStackPath := filepath.Join(d.path, monitoringStackDirName)
-	_, err := d.fs.Stat(monitoringStackPath)
+	return d.RemoveMonitoringStackNamed("")
+}
+
+// RemoveMonitoringStackNamed is like RemoveMonitoringStack, but for the
+// monitoring stack with the given name.
+func (d *DataDir) RemoveMonitoringStackNamed(name string) (err error) {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	monitoringStackPath, err := d.monitoringStackPath(name)
+	if err != nil {
+		return err
+	}
+	_, err = d.fs.Stat(monitoringStackPath)
 	if os.IsNotExist(err) {
 		return fmt.Errorf("%w: %s", ErrMonitoringStackNotFound, monitoringStackPath)
 	} else if err != nil {
 		return err
 	}
 
-	return d.fs.RemoveAll(monitoringStackPath)
+	l := d.locker.New(filepath.Join(monitoringStackPath, ".lock"))
+	ctx, cancel := context.WithTimeout(context.Background(), monitoringStackLockTimeout)
+	defer cancel()
+	locked, err := l.TryLockContext(ctx, monitoringStackLockRetryDelay)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if !locked {
+		return fmt.Errorf("%w: %s", ErrMonitoringStackLocked, monitoringStackPath)
+	}
+	defer func() {
+		unlockErr := l.Unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+
+	if err = d.fs.RemoveAll(monitoringStackPath); err != nil {
+		return err
+	}
+	d.audit(AuditMonitoringStackRemoved, name)
+	return nil
 }
 
-// ListInstances returns the ID list of all the installed instances.
-func (d *DataDir) ListInstances() ([]Instance, error) {
-	nodesDirPath := filepath.Join(d.path, nodesDirName)
-	_, err := d.fs.Stat(nodesDirPath)
+// ListInstances returns all the installed instances. Each instance is read
+// while holding a shared lock on it, so a concurrent writer can't be observed
+// halfway. Instances that can't be locked in time are skipped and reported in
+// the logs.
+//
+// Every returned instance is owned by the caller and has its own lock, so
+// instances from different calls exclude each other as expected. Instances
+// must not be copied by value, as copies would share the same lock.
+func (d *DataDir) ListInstances() ([]*Instance, error) {
+	instances := make([]*Instance, 0)
+	err := d.WalkInstances(func(instance *Instance) error {
+		instances = append(instances, instance)
+		return nil
+	})
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Return empty list if the nodes directory does not exist
-			return []Instance{}, nil
-		}
 		return nil, err
 	}
-	dirEntries, err := afero.ReadDir(d.fs, nodesDirPath)
+	return instances, nil
+}
+
+// WalkInstances calls fn for each installed instance, loading one instance at
+// a time in the same way as ListInstances. The walk stops at the first error
+// returned by fn, which is returned by WalkInstances, unless it is ErrStopWalk,
+// which stops the walk without error.
+func (d *DataDir) WalkInstances(fn func(*Instance) error) error {
+	if err := d.checkOpen(); err != nil {
+		return err
+	}
+	dirEntries, err := afero.ReadDir(d.fs, d.NodesPath())
 	if err != nil {
-		return nil, err
+		if os.IsNotExist(err) {
+			// Nothing to walk if the nodes directory does not exist
+			return nil
+		}
+		return err
 	}
-	instances := make([]Instance, 0)
 	for _, dirEntry := range dirEntries {
-		if dirEntry.IsDir() {
-			instance, err := d.Instance(dirEntry.Name())
-			if err != nil {
-				return nil, err
+		if !dirEntry.IsDir() || strings.HasSuffix(dirEntry.Name(), deletingSuffix) {
+			continue
+		}
+		instance, err := d.readInstance(dirEntry.Name())
+		if err != nil {
+			if errors.Is(err, ErrInstanceLockTimeout) {
+				logrus.Warnf("Skipping instance %s: %v", dirEntry.Name(), err)
+				continue
+			}
+			return err
+		}
+		if instance.ID() != dirEntry.Name() {
+			logrus.Warnf("Instance directory %s holds instance %s, run a repair to rename it", dirEntry.Name(), instance.ID())
+		}
+		if err := fn(instance); err != nil {
+			if errors.Is(err, ErrStopWalk) {
+				return nil
 			}
-			instances = append(instances, *instance)
+			return err
 		}
 	}
-	return instances, nil
+	return nil
 }
 
-// SavePluginImageContext saves the plugin image context to the data dir as a tar file.
-func (d *DataDir) SavePluginImageContext(id string, ctx io.ReadCloser) (err error) {
-	defer ctx.Close()
-	err = d.fs.MkdirAll(filepath.Join(d.path, pluginsDir), 0o755)
+// CountInstances returns the number of instance directories containing a
+// state file, compressed or not, without loading the instances. It returns 0 if the nodes
+// directory does not exist.
+func (d *DataDir) CountInstances() (int, error) {
+	if err := d.checkOpen(); err != nil {
+		return 0, err
+	}
+	dirEntries, err := afero.ReadDir(d.fs, d.NodesPath())
 	if err != nil {
-		return err
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	count := 0
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() || strings.HasSuffix(dirEntry.Name(), deletingSuffix) {
+			continue
+		}
+		ok, err := hasStateFile(d.fs, filepath.Join(d.NodesPath(), dirEntry.Name()))
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ListFilter selects instances by their state fields. Empty fields match any
+// value.
+type ListFilter struct {
+	Name    string
+	Tag     string
+	Profile string
+	URL     string
+}
+
+// Match returns true if the given instance matches the filter.
+func (f ListFilter) Match(i *Instance) bool {
+	return (f.Name == "" || f.Name == i.Name) &&
+		(f.Tag == "" || f.Tag == i.Tag) &&
+		(f.Profile == "" || f.Profile == i.Profile) &&
+		(f.URL == "" || f.URL == i.URL)
+}
+
+// RemoveInstances removes all the instances matching the given filter. A
+// failure removing an instance doesn't stop the removal of the others, all the
+// errors are returned joined. It returns the ids of the removed instances, or
+// of the instances that would be removed if dryRun is true.
+func (d *DataDir) RemoveInstances(filter ListFilter, dryRun bool) ([]string, error) {
+	if !dryRun {
+		if err := d.checkWritable(); err != nil {
+			return nil, err
+		}
 	}
-	ctxF, err := d.fs.Create(filepath.Join(d.pluginDir(), id+".tar"))
+	instances, err := d.ListInstances()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	var (
+		removed []string
+		errs    []error
+	)
+	for _, instance := range instances {
+		if !filter.Match(instance) {
+			continue
+		}
+		if !dryRun {
+			if err := d.RemoveInstance(instance.ID(), false); err != nil {
+				errs = append(errs, fmt.Errorf("failed removing instance %s: %w", instance.ID(), err))
+				continue
+			}
+		}
+		removed = append(removed, instance.ID())
+	}
+	return removed, errors.Join(errs...)
+}
+
+// readInstance loads the instance with the given id while holding a shared
+// lock on it.
+func (d *DataDir) readInstance(instanceId string) (instance *Instance, err error) {
+	l, err := d.rlockInstance(instanceId)
+	if err != nil {
+		return nil, err
 	}
 	defer func() {
-		errClose := ctxF.Close()
-		if err != nil {
-			err = errClose
+		unlockErr := l.Unlock()
+		if err == nil {
+			err = unlockErr
 		}
 	}()
-	_, err = io.Copy(ctxF, ctx)
-	return err
+	return d.Instance(instanceId)
+}
+
+// rlockInstance takes a shared lock on the instance with the given id. The
+// caller must unlock the returned locker.
+func (d *DataDir) rlockInstance(instanceId string) (locker.Locker, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), instanceReadLockTimeout)
+	defer cancel()
+	return d.RLockInstance(ctx, instanceId)
+}
+
+// RLockInstance takes a shared lock on the instance with the given id, waiting
+// until ctx is done for the processes writing to the instance to finish. The
+// caller must unlock the returned locker.
+//
+// The instance lock is a readers-writer lock: the instance methods changing
+// its state, such as SetLabel, take it exclusively, while readers, such as
+// ListInstances and backups, share it. So a long backup holding a shared lock
+// doesn't block the readers, but the changes to the instance wait for the
+// backup to finish. If ctx is done before the lock is taken, it returns
+// ErrInstanceLockTimeout.
+func (d *DataDir) RLockInstance(ctx context.Context, instanceId string) (locker.Locker, error) {
+	l := d.locker.New(filepath.Join(d.path, nodesDirName, instanceId, ".lock"))
+	locked, err := l.TryRLockContext(ctx, instanceReadLockRetryDelay)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+		return nil, err
+	}
+	if !locked {
+		return nil, fmt.Errorf("%w: %s", ErrInstanceLockTimeout, instanceId)
+	}
+	return l, nil
+}
+
+// SavePluginImageContext saves the plugin image context to the data dir as a
+// tar file, along with its metadata, see PluginContextInfo.
+func (d *DataDir) SavePluginImageContext(id string, ctx io.ReadCloser) error {
+	return d.SavePluginImageContextFrom(id, "", ctx)
 }
 
 // GetPluginContext returns the plugin image context tar file.
 func (d *DataDir) GetPluginContext(id string) (io.ReadCloser, error) {
-	return d.fs.Open(filepath.Join(d.pluginDir(), id+".tar"))
+	return d.fs.Open(d.pluginContextPath(id))
 }
 
-// RemovePluginContext removes the plugin image context tar file. If the file
-// does not exist, it return nil.
+// RemovePluginContext removes the plugin image context tar file, with its
+// metadata. If the file does not exist, it return nil.
 func (d *DataDir) RemovePluginContext(id string) error {
-	fileName := filepath.Join(d.pluginDir(), id+".tar")
-	exist, err := afero.Exists(d.fs, fileName)
-	if err != nil {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if err := d.checkOpen(); err != nil {
 		return err
 	}
-	if exist {
-		return d.fs.Remove(fileName)
+	for _, fileName := range []string{d.pluginContextPath(id), d.pluginMetaPath(id)} {
+		exist, err := afero.Exists(d.fs, fileName)
+		if err != nil {
+			return err
+		}
+		if exist {
+			if err := d.fs.Remove(fileName); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
-
-func (d *DataDir) pluginDir() string {
-	return filepath.Join(d.path, pluginsDir)
-}
@@ -2,11 +2,22 @@ package data
 
 import (
 	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -14,6 +25,7 @@ import (
 	"github.com/NethermindEth/eigenlayer/internal/locker"
 	"github.com/NethermindEth/eigenlayer/internal/locker/mocks"
 	"github.com/golang/mock/gomock"
+	"github.com/prometheus/common/expfmt"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -52,9 +64,10 @@ func TestNewDataDir(t *testing.T) {
 				name: "path to absolute",
 				path: testDir,
 				dataDir: &DataDir{
-					path:   absPath,
-					fs:     fs,
-					locker: locker,
+					path:          absPath,
+					fs:            fs,
+					locker:        locker,
+					layoutVersion: LayoutVersionNodes,
 				},
 				locker: locker,
 				err:    nil,
@@ -76,6 +89,75 @@ func TestNewDataDir(t *testing.T) {
 	}
 }
 
+func TestNewDataDirPathExpansion(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		path     string
+		wantPath string
+		wantErr  error
+	}{
+		{
+			name:     "tilde",
+			path:     "~/mydata",
+			wantPath: filepath.Join(home, "mydata"),
+		},
+		{
+			name:     "only tilde",
+			path:     "~",
+			wantPath: home,
+		},
+		{
+			name:     "relative",
+			path:     filepath.Join("mydata", "nested"),
+			wantPath: filepath.Join(wd, "mydata", "nested"),
+		},
+		{
+			name:     "absolute",
+			path:     "/data/mydata",
+			wantPath: "/data/mydata",
+		},
+		{
+			name:    "tilde user",
+			path:    "~other/mydata",
+			wantErr: ErrInvalidDataDirPath,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataDir, err := NewDataDir(tt.path, afero.NewMemMapFs(), locker.NewFLock())
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantPath, dataDir.Path())
+		})
+	}
+}
+
+func TestDataDir_Paths(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	dataDir, err := NewDataDir("/data", fs, locker.NewFLock())
+	require.NoError(t, err)
+
+	assert.Equal(t, fs, dataDir.Fs())
+	for _, path := range []string{
+		dataDir.NodesPath(),
+		dataDir.BackupDirPath(),
+		dataDir.PluginDirPath(),
+		dataDir.MonitoringPath(),
+	} {
+		rel, err := filepath.Rel(dataDir.Path(), path)
+		require.NoError(t, err)
+		assert.True(t, filepath.IsLocal(rel), "%s is not under %s", path, dataDir.Path())
+	}
+}
+
 func TestDataDir_Instance(t *testing.T) {
 	fs := afero.NewOsFs()
 
@@ -253,6 +335,9 @@ func TestDataDir_InitInstance(t *testing.T) {
 			// Create a mock locker
 			ctrl := gomock.NewController(t)
 			locker := mocks.NewMockLocker(ctrl)
+			locker.EXPECT().New(filepath.Join(path, dataDirLockName)).Return(locker)
+			locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+			locker.EXPECT().Unlock().Return(nil)
 			locker.EXPECT().New(filepath.Join(path, nodesDirName, "mock-avs-default", ".lock")).Return(locker)
 
 			return testCase{
@@ -281,11 +366,13 @@ func TestDataDir_InitInstance(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			dataDir, err := NewDataDir(tc.path, fs, tc.locker)
 			assert.NoError(t, err)
-			err = dataDir.InitInstance(tc.instance)
+			instanceId, err := dataDir.InitInstance(tc.instance)
 			if tc.err != nil {
 				assert.ErrorIs(t, err, tc.err)
+				assert.Empty(t, instanceId)
 			} else {
 				assert.NoError(t, err)
+				assert.Equal(t, InstanceId(tc.instance.Name, tc.instance.Tag), instanceId)
 			}
 			if tc.afterCheck != nil {
 				tc.afterCheck(t)
@@ -294,6 +381,143 @@ func TestDataDir_InitInstance(t *testing.T) {
 	}
 }
 
+func TestDataDir_UpsertInstance(t *testing.T) {
+	newMockAvs := func() *Instance {
+		return &Instance{
+			Name:    "mock-avs",
+			Tag:     "default",
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Profile: "option-returner",
+		}
+	}
+
+	tc := []struct {
+		name        string
+		setup       func(t *testing.T, dataDir *DataDir)
+		instance    func() *Instance
+		wantChanged bool
+	}{
+		{
+			name:        "new instance",
+			instance:    newMockAvs,
+			wantChanged: true,
+		},
+		{
+			name: "no change",
+			setup: func(t *testing.T, dataDir *DataDir) {
+				_, err := dataDir.InitInstance(newMockAvs())
+				require.NoError(t, err)
+			},
+			instance:    newMockAvs,
+			wantChanged: false,
+		},
+		{
+			name: "changed",
+			setup: func(t *testing.T, dataDir *DataDir) {
+				_, err := dataDir.InitInstance(newMockAvs())
+				require.NoError(t, err)
+			},
+			instance: func() *Instance {
+				i := newMockAvs()
+				i.Profile = "health-checker"
+				return i
+			},
+			wantChanged: true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewOsFs()
+			dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+			require.NoError(t, err)
+			if tt.setup != nil {
+				tt.setup(t, dataDir)
+			}
+			instance := tt.instance()
+			stateBefore, _ := afero.ReadFile(fs, filepath.Join(dataDir.NodesPath(), "mock-avs-default", "state.json"))
+
+			changed, err := dataDir.UpsertInstance(instance)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantChanged, changed)
+
+			stored, err := dataDir.Instance("mock-avs-default")
+			require.NoError(t, err)
+			assert.Equal(t, instance.Profile, stored.Profile)
+			if !tt.wantChanged {
+				stateAfter, err := afero.ReadFile(fs, filepath.Join(dataDir.NodesPath(), "mock-avs-default", "state.json"))
+				require.NoError(t, err)
+				assert.Equal(t, stateBefore, stateAfter)
+			}
+		})
+	}
+}
+
+func TestDataDir_RawInstanceState(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	dataDir, err := NewDataDir("/data", fs, locker.NewFLock())
+	require.NoError(t, err)
+	instancePath := filepath.Join(dataDir.NodesPath(), "mock-avs-default")
+	require.NoError(t, fs.MkdirAll(instancePath, 0o755))
+	// A legacy state that doesn't validate, with unusual formatting
+	state := []byte("{\n  \"name\": \"mock-avs\",\n  \"tag\": \"default\"\n}\n")
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "state.json"), state, 0o644))
+
+	raw, err := dataDir.RawInstanceState("mock-avs-default")
+	require.NoError(t, err)
+	assert.Equal(t, state, raw)
+
+	_, err = dataDir.RawInstanceState("mock-avs-missing")
+	assert.ErrorIs(t, err, ErrInstanceNotFound)
+	require.NoError(t, fs.MkdirAll(filepath.Join(dataDir.NodesPath(), "no-state"), 0o755))
+	_, err = dataDir.RawInstanceState("no-state")
+	assert.ErrorIs(t, err, ErrInvalidInstanceDir)
+}
+
+func TestDataDir_RepairInstance(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	instancePath := filepath.Join(dataDir.NodesPath(), "mock-avs-default")
+	require.NoError(t, fs.MkdirAll(instancePath, 0o755))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, ".lock"), nil, 0o644))
+	// State missing the profile, with a field unknown to this version
+	state := `{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","tag":"default","monitoring":{"targets":[{"service":"main","port":"8080","path":"/metrics"}]},"future":"kept"}`
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "state.json"), []byte(state), 0o644))
+	_, err = dataDir.Instance("mock-avs-default")
+	require.ErrorIs(t, err, ErrInvalidInstance)
+
+	// Defaults without the missing field don't repair it
+	err = dataDir.RepairInstance("mock-avs-default", Instance{Version: "v9.9.9"})
+	require.ErrorIs(t, err, ErrInvalidInstance)
+
+	err = dataDir.RepairInstance("mock-avs-default", Instance{
+		Name:    "other-avs",
+		Version: "v9.9.9",
+		Profile: "option-returner",
+	})
+	require.NoError(t, err)
+
+	instance, err := dataDir.Instance("mock-avs-default")
+	require.NoError(t, err)
+	assert.Equal(t, "option-returner", instance.Profile)
+	// Non-empty fields are untouched
+	assert.Equal(t, "mock-avs", instance.Name)
+	assert.Equal(t, "v5.5.1", instance.Version)
+	assert.Equal(t, []MonitoringTarget{{Service: "main", Port: "8080", Path: "/metrics"}}, instance.MonitoringTargets.Targets)
+	repaired, err := afero.ReadFile(fs, filepath.Join(instancePath, "state.json"))
+	require.NoError(t, err)
+	var expected map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(state), &expected))
+	expected["profile"] = "option-returner"
+	expectedData, err := json.Marshal(expected)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(expectedData), string(repaired))
+
+	err = dataDir.RepairInstance("mock-avs-missing", Instance{Profile: "option-returner"})
+	assert.ErrorIs(t, err, ErrInstanceNotFound)
+}
+
 func TestDataDir_HasInstance(t *testing.T) {
 	type testCase struct {
 		name       string
@@ -392,6 +616,9 @@ func TestDataDir_RemoveInstance(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
+			locker.EXPECT().New(filepath.Join(dataDir.Path(), dataDirLockName)).Return(locker)
+			locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+			locker.EXPECT().Unlock().Return(nil)
 			return testCase{
 				name:       "success",
 				dataDir:    dataDir,
@@ -427,7 +654,7 @@ func TestDataDir_RemoveInstance(t *testing.T) {
 	}
 	for _, tc := range ts {
 		t.Run(tc.name, func(t *testing.T) {
-			err := tc.dataDir.RemoveInstance(tc.instanceId)
+			err := tc.dataDir.RemoveInstance(tc.instanceId, false)
 			if tc.err == nil {
 				assert.NoError(t, err)
 			} else {
@@ -437,6 +664,73 @@ func TestDataDir_RemoveInstance(t *testing.T) {
 	}
 }
 
+// removeAllErrFs is an afero.Fs that fails removing the paths ending with the
+// given suffix.
+type removeAllErrFs struct {
+	afero.Fs
+	suffix string
+}
+
+func (f *removeAllErrFs) RemoveAll(path string) error {
+	if strings.HasSuffix(path, f.suffix) {
+		return errors.New("device or resource busy")
+	}
+	return f.Fs.RemoveAll(path)
+}
+
+func TestDataDir_RemoveInstancePartialFailure(t *testing.T) {
+	osFs := afero.NewOsFs()
+	testDir := t.TempDir()
+	instancePath := filepath.Join(testDir, nodesDirName, "mock-avs-default")
+	require.NoError(t, osFs.MkdirAll(instancePath, 0o755))
+	state := `{"name":"mock-avs","url":"` + common.MockAvsPkg.Repo() + `","version":"` + common.MockAvsPkg.Version() + `","profile":"option-returner","tag":"default"}`
+	require.NoError(t, afero.WriteFile(osFs, filepath.Join(instancePath, "state.json"), []byte(state), 0o644))
+
+	dataDir, err := NewDataDir(testDir, &removeAllErrFs{Fs: osFs, suffix: "mock-avs-default" + deletingSuffix}, locker.NewFLock())
+	require.NoError(t, err)
+
+	err = dataDir.RemoveInstance("mock-avs-default", false)
+	require.ErrorIs(t, err, ErrInstancePendingRemoval)
+
+	// The instance is marked instead of half removed
+	assert.NoDirExists(t, instancePath)
+	assert.FileExists(t, filepath.Join(instancePath+deletingSuffix, "state.json"))
+	assert.False(t, dataDir.HasInstance("mock-avs-default"))
+	instances, err := dataDir.ListInstances()
+	require.NoError(t, err)
+	assert.Empty(t, instances)
+
+	// GC finishes the removal
+	dataDir, err = NewDataDir(testDir, osFs, locker.NewFLock())
+	require.NoError(t, err)
+	require.NoError(t, dataDir.GC())
+	assert.NoDirExists(t, instancePath+deletingSuffix)
+}
+
+func TestDataDir_NextAvailableTag(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	dataDir, err := NewDataDir("/data", fs, locker.NewFLock())
+	require.NoError(t, err)
+
+	// No instances yet
+	tag, err := dataDir.NextAvailableTag("mock-avs", "copy")
+	require.NoError(t, err)
+	assert.Equal(t, "copy-1", tag)
+
+	for _, id := range []string{"mock-avs-copy-1", "mock-avs-copy-2", "mock-avs-copy-4", "other-avs-copy-3", "mock-avs-default"} {
+		require.NoError(t, fs.MkdirAll(filepath.Join(dataDir.NodesPath(), id), 0o755))
+	}
+	tag, err = dataDir.NextAvailableTag("mock-avs", "copy")
+	require.NoError(t, err)
+	assert.Equal(t, "copy-3", tag)
+	tag, err = dataDir.NextAvailableTag("other-avs", "copy")
+	require.NoError(t, err)
+	assert.Equal(t, "copy-1", tag)
+
+	_, err = dataDir.NextAvailableTag("mock-avs", "bad/prefix")
+	assert.ErrorIs(t, err, ErrInvalidInstance)
+}
+
 func TestDataDir_InstancePath(t *testing.T) {
 	fs := afero.NewOsFs()
 
@@ -544,6 +838,75 @@ func TestDataDir_InitTemp(t *testing.T) {
 				wantErr: nil,
 			}
 		}(),
+		func() tc {
+			path := t.TempDir()
+			leftoverPath := filepath.Join(path, tempDir, "temp-dir-id")
+			err := fs.MkdirAll(leftoverPath, 0o755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			staleTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+			err = fs.Chtimes(leftoverPath, staleTime, staleTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return tc{
+				name: "stale empty leftover",
+				path: path,
+				id:   "temp-dir-id",
+				want: leftoverPath,
+				check: func(t *testing.T) {
+					// Reused, not recreated
+					info, err := fs.Stat(leftoverPath)
+					require.NoError(t, err)
+					assert.True(t, info.ModTime().Equal(staleTime))
+				},
+			}
+		}(),
+		func() tc {
+			path := t.TempDir()
+			leftoverPath := filepath.Join(path, tempDir, "temp-dir-id")
+			err := fs.MkdirAll(filepath.Join(leftoverPath, "partial"), 0o755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = afero.WriteFile(fs, filepath.Join(leftoverPath, "partial", "file"), []byte("partial"), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return tc{
+				name: "partial leftover",
+				path: path,
+				id:   "temp-dir-id",
+				want: leftoverPath,
+				check: func(t *testing.T) {
+					empty, err := afero.IsEmpty(fs, leftoverPath)
+					require.NoError(t, err)
+					assert.True(t, empty)
+				},
+			}
+		}(),
+		func() tc {
+			path := t.TempDir()
+			leftoverPath := filepath.Join(path, tempDir, "temp-dir-id")
+			err := fs.MkdirAll(filepath.Join(path, tempDir), 0o755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = afero.WriteFile(fs, leftoverPath, []byte("partial"), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return tc{
+				name: "file leftover",
+				path: path,
+				id:   "temp-dir-id",
+				want: leftoverPath,
+				check: func(t *testing.T) {
+					assert.DirExists(t, leftoverPath)
+				},
+			}
+		}(),
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -567,6 +930,65 @@ func TestDataDir_InitTemp(t *testing.T) {
 	}
 }
 
+func TestDataDir_InitTempQuota(t *testing.T) {
+	fs := afero.NewOsFs()
+
+	tests := []struct {
+		name     string
+		usage    int
+		quota    int64
+		wantErr  error
+		existing string
+	}{
+		{
+			name:  "under quota",
+			usage: 50,
+			quota: 100,
+		},
+		{
+			name:    "quota reached",
+			usage:   100,
+			quota:   100,
+			wantErr: ErrTempQuotaExceeded,
+		},
+		{
+			name:  "unlimited",
+			usage: 1000,
+			quota: 0,
+		},
+		{
+			name:     "reused temp dir is not counted",
+			usage:    100,
+			quota:    100,
+			existing: "temp-dir-id",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := t.TempDir()
+			existing := tt.existing
+			if existing == "" {
+				existing = "other-temp-dir"
+			}
+			err := fs.MkdirAll(filepath.Join(path, tempDir, existing), 0o755)
+			require.NoError(t, err)
+			err = afero.WriteFile(fs, filepath.Join(path, tempDir, existing, "data"), make([]byte, tt.usage), 0o644)
+			require.NoError(t, err)
+
+			dataDir, err := NewDataDir(path, fs, nil, WithTempQuota(tt.quota))
+			require.NoError(t, err)
+			got, err := dataDir.InitTemp("temp-dir-id")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.NoDirExists(t, filepath.Join(path, tempDir, "temp-dir-id"))
+			} else {
+				require.NoError(t, err)
+				assert.DirExists(t, got)
+			}
+		})
+	}
+}
+
 func TestDataDir_RemoveTemp(t *testing.T) {
 	fs := afero.NewOsFs()
 
@@ -903,7 +1325,7 @@ func TestDataDir_InitBackup(t *testing.T) {
 				assert.ErrorIs(t, err, tt.err)
 			} else {
 				require.NoError(t, err)
-				bStat, err := d.fs.Stat(d.BackupPath(backup.Id()))
+				bStat, err := d.fs.Stat(d.BackupWritePath(backup.Id()))
 				require.NoError(t, err)
 				require.Equal(t, bStat.Mode(), os.FileMode(0o644))
 				require.Equal(t, bStat.Size(), int64(1024))
@@ -1058,7 +1480,7 @@ func TestDataDir_BackupList(t *testing.T) {
 			for _, d := range tt.data {
 				err = dataDir.InitBackup(&d.backup)
 				require.NoError(t, err)
-				backupTarPath := dataDir.BackupPath(d.backup.Id())
+				backupTarPath := dataDir.BackupWritePath(d.backup.Id())
 				backupTarFile, err := fs.OpenFile(backupTarPath, os.O_WRONLY, 0o644)
 				require.NoError(t, err)
 				tarWriter := tar.NewWriter(backupTarFile)
@@ -1066,6 +1488,8 @@ func TestDataDir_BackupList(t *testing.T) {
 				tarAddTimestamp(t, tarWriter, d.timestamp)
 				err = tarWriter.Close()
 				require.NoError(t, err)
+				require.NoError(t, backupTarFile.Close())
+				require.NoError(t, dataDir.CommitBackup(&d.backup))
 				backups = append(backups, d.backup)
 			}
 
@@ -1086,6 +1510,68 @@ func TestDataDir_BackupList(t *testing.T) {
 	}
 }
 
+func TestDataDir_WriteBackupManifest(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDirPath := t.TempDir()
+	dataDir, err := NewDataDir(dataDirPath, fs, locker.NewFLock())
+	require.NoError(t, err)
+
+	state := []byte(`{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","commit":"d5af645fffb93e8263b099082a4f512e1917d0af","profile":"option-returner","tag":"default"}`)
+	instancePath := filepath.Join(dataDirPath, nodesDirName, "mock-avs-default")
+	require.NoError(t, fs.MkdirAll(instancePath, 0o755))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "state.json"), state, 0o644))
+
+	backup := Backup{
+		InstanceId: "mock-avs-default",
+		Timestamp:  time.Unix(1696420902, 0),
+		Version:    "v5.5.1",
+		Commit:     "d5af645fffb93e8263b099082a4f512e1917d0af",
+		Url:        "https://github.com/NethermindEth/mock-avs-pkg",
+	}
+	require.NoError(t, dataDir.InitBackup(&backup))
+	backupTarFile, err := fs.OpenFile(dataDir.BackupWritePath(backup.Id()), os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	tarWriter := tar.NewWriter(backupTarFile)
+	tarAddStateJson(t, tarWriter, state)
+	tarAddTimestamp(t, tarWriter, backup.Timestamp)
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, backupTarFile.Close())
+
+	err = dataDir.WriteBackupManifest(&backup)
+	require.NoError(t, err)
+
+	// Check the manifest file
+	manifestData, err := afero.ReadFile(fs, filepath.Join(dataDirPath, backupDir, backup.Id()+".json"))
+	require.NoError(t, err)
+	var manifest BackupManifest
+	require.NoError(t, json.Unmarshal(manifestData, &manifest))
+	tarData, err := afero.ReadFile(fs, dataDir.BackupPath(backup.Id()))
+	require.NoError(t, err)
+	checksum := sha256.Sum256(tarData)
+	assert.Equal(t, "mock-avs-default", manifest.InstanceId)
+	assert.True(t, backup.Timestamp.Equal(manifest.Timestamp))
+	assert.Equal(t, hex.EncodeToString(checksum[:]), manifest.Checksum)
+	assert.JSONEq(t, string(state), string(manifest.State))
+	instance, err := manifest.Instance()
+	require.NoError(t, err)
+	assert.Equal(t, "v5.5.1", instance.Version)
+
+	// The backup list is enriched with the manifest
+	backups, err := dataDir.BackupList()
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	assert.Equal(t, manifest.Checksum, backups[0].Checksum)
+}
+
+func TestDataDir_BackupManifestNotFound(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, nil)
+	require.NoError(t, err)
+
+	_, err = dataDir.BackupManifest("legacy")
+	assert.ErrorIs(t, err, ErrBackupManifestNotFound)
+}
+
 func TestRemoveMonitoringStack(t *testing.T) {
 	// Create monitoring stack
 	// Create a memory filesystem
@@ -1094,7 +1580,9 @@ func TestRemoveMonitoringStack(t *testing.T) {
 	// Create a mock locker
 	ctrl := gomock.NewController(t)
 	locker := mocks.NewMockLocker(ctrl)
-	locker.EXPECT().New(filepath.Join("/monitoring", ".lock")).Return(locker)
+	locker.EXPECT().New(filepath.Join("/monitoring", ".lock")).Return(locker).Times(2)
+	locker.EXPECT().TryLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+	locker.EXPECT().Unlock().Return(nil)
 
 	// Create a data dir
 	dataDir, err := NewDataDir("/", fs, locker)
@@ -1113,6 +1601,32 @@ func TestRemoveMonitoringStack(t *testing.T) {
 	assert.False(t, exists)
 }
 
+func TestRemoveMonitoringStackLocked(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	_, err = dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	// Hold the monitoring stack lock
+	l := locker.NewFLock().New(filepath.Join(dataDir.Path(), monitoringStackDirName, ".lock"))
+	require.NoError(t, l.Lock())
+
+	err = dataDir.RemoveMonitoringStack()
+	require.ErrorIs(t, err, ErrMonitoringStackLocked)
+	exists, err := afero.DirExists(fs, filepath.Join(dataDir.Path(), monitoringStackDirName))
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// The stack is removed once the lock is released
+	require.NoError(t, l.Unlock())
+	err = dataDir.RemoveMonitoringStack()
+	require.NoError(t, err)
+	exists, err = afero.DirExists(fs, filepath.Join(dataDir.Path(), monitoringStackDirName))
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
 func TestRemoveMonitoringStackError(t *testing.T) {
 	// Create monitoring stack
 	// Create a memory filesystem
@@ -1159,3 +1673,3840 @@ func tarAddTimestamp(t *testing.T, tarWriter *tar.Writer, timestamp time.Time) {
 	_, err = tarWriter.Write([]byte(data))
 	require.NoError(t, err)
 }
+
+func TestDataDir_ListInstancesConcurrentWrite(t *testing.T) {
+	fs := afero.NewOsFs()
+	path := t.TempDir()
+	instancePath := filepath.Join(path, nodesDirName, "mock-avs-default")
+	require.NoError(t, fs.MkdirAll(instancePath, 0o755))
+	state := `{"name":"mock-avs","url":"` + common.MockAvsPkg.Repo() + `","version":"` + common.MockAvsPkg.Version() + `","profile":"option-returner","tag":"default"}`
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "state.json"), []byte(state), 0o644))
+
+	dataDir, err := NewDataDir(path, fs, locker.NewFLock())
+	require.NoError(t, err)
+
+	// Writer rewrites state.json in two steps while holding the instance lock,
+	// leaving a torn file in between.
+	started := make(chan struct{})
+	done := make(chan struct{})
+	writerErr := make(chan error, 1)
+	go func() {
+		defer close(writerErr)
+		l := locker.NewFLock().New(filepath.Join(instancePath, ".lock"))
+		close(started)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := l.Lock(); err != nil {
+				writerErr <- err
+				return
+			}
+			err := afero.WriteFile(fs, filepath.Join(instancePath, "state.json"), []byte(state[:len(state)/2]), 0o644)
+			if err == nil {
+				time.Sleep(time.Millisecond)
+				err = afero.WriteFile(fs, filepath.Join(instancePath, "state.json"), []byte(state), 0o644)
+			}
+			if unlockErr := l.Unlock(); err == nil {
+				err = unlockErr
+			}
+			if err != nil {
+				writerErr <- err
+				return
+			}
+		}
+	}()
+
+	<-started
+	for deadline := time.Now().Add(200 * time.Millisecond); time.Now().Before(deadline); {
+		instances, err := dataDir.ListInstances()
+		require.NoError(t, err)
+		for _, instance := range instances {
+			assert.Equal(t, "mock-avs-default", instance.ID())
+			assert.Equal(t, common.MockAvsPkg.Version(), instance.Version)
+		}
+	}
+	close(done)
+	require.NoError(t, <-writerErr)
+}
+
+func TestDataDir_ListInstancesLockTimeout(t *testing.T) {
+	fs := afero.NewOsFs()
+	path := t.TempDir()
+	instancePath := filepath.Join(path, nodesDirName, "mock-avs-default")
+	require.NoError(t, fs.MkdirAll(instancePath, 0o755))
+	state := `{"name":"mock-avs","url":"` + common.MockAvsPkg.Repo() + `","version":"` + common.MockAvsPkg.Version() + `","profile":"option-returner","tag":"default"}`
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "state.json"), []byte(state), 0o644))
+
+	dataDir, err := NewDataDir(path, fs, locker.NewFLock())
+	require.NoError(t, err)
+
+	// Hold the instance lock during the listing
+	l := locker.NewFLock().New(filepath.Join(instancePath, ".lock"))
+	require.NoError(t, l.Lock())
+	defer l.Unlock()
+
+	instances, err := dataDir.ListInstances()
+	require.NoError(t, err)
+	assert.Empty(t, instances)
+}
+
+func TestDataDir_ListInstancesLockOwnership(t *testing.T) {
+	fs := afero.NewOsFs()
+	path := t.TempDir()
+	instancePath := filepath.Join(path, nodesDirName, "mock-avs-default")
+	require.NoError(t, fs.MkdirAll(instancePath, 0o755))
+	state := `{"name":"mock-avs","url":"` + common.MockAvsPkg.Repo() + `","version":"` + common.MockAvsPkg.Version() + `","profile":"option-returner","tag":"default"}`
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "state.json"), []byte(state), 0o644))
+
+	dataDir, err := NewDataDir(path, fs, locker.NewFLock())
+	require.NoError(t, err)
+
+	first, err := dataDir.ListInstances()
+	require.NoError(t, err)
+	require.Len(t, first, 1)
+	second, err := dataDir.ListInstances()
+	require.NoError(t, err)
+	require.Len(t, second, 1)
+
+	require.NoError(t, first[0].lock())
+	locked := make(chan error, 1)
+	go func() {
+		locked <- second[0].lock()
+	}()
+
+	// The second instance must wait for the first one to be unlocked
+	select {
+	case <-locked:
+		t.Fatal("second instance locked while the first one holds the lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.NoError(t, first[0].unlock())
+	select {
+	case err := <-locked:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("second instance not locked after the first one was unlocked")
+	}
+	require.NoError(t, second[0].unlock())
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestDataDir_PruneTempDirs(t *testing.T) {
+	fs := afero.NewOsFs()
+	now := time.Unix(1696420902, 0)
+	maxAge := time.Hour
+
+	path := t.TempDir()
+	ages := map[string]time.Duration{
+		"fresh":    time.Minute,
+		"boundary": maxAge,
+		"expired":  maxAge + time.Second,
+	}
+	for id, age := range ages {
+		tempPath := filepath.Join(path, tempDir, id)
+		require.NoError(t, fs.MkdirAll(tempPath, 0o755))
+		require.NoError(t, fs.Chtimes(tempPath, now.Add(-age), now.Add(-age)))
+	}
+
+	dataDir, err := NewDataDir(path, fs, nil, WithClock(fakeClock{now: now}))
+	require.NoError(t, err)
+	removed, err := dataDir.PruneTempDirs(maxAge)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"expired"}, removed)
+	assert.DirExists(t, filepath.Join(path, tempDir, "fresh"))
+	assert.DirExists(t, filepath.Join(path, tempDir, "boundary"))
+	assert.NoDirExists(t, filepath.Join(path, tempDir, "expired"))
+}
+
+func TestDataDir_PruneBackups(t *testing.T) {
+	fs := afero.NewOsFs()
+	now := time.Unix(1696420902, 0)
+	maxAge := 24 * time.Hour
+
+	dataDir, err := NewDataDir(t.TempDir(), fs, nil, WithClock(fakeClock{now: now}))
+	require.NoError(t, err)
+
+	newBackup := func(tag string, age time.Duration) Backup {
+		b := Backup{
+			InstanceId: "mock-avs-" + tag,
+			Timestamp:  now.Add(-age),
+			Version:    "v5.5.1",
+			Commit:     "d5af645fffb93e8263b099082a4f512e1917d0af",
+			Url:        "https://github.com/NethermindEth/mock-avs-pkg",
+		}
+		require.NoError(t, dataDir.InitBackup(&b))
+		backupTarFile, err := fs.OpenFile(dataDir.BackupWritePath(b.Id()), os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		tarWriter := tar.NewWriter(backupTarFile)
+		tarAddStateJson(t, tarWriter, []byte(`{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","commit":"d5af645fffb93e8263b099082a4f512e1917d0af","profile":"option-returner","tag":"`+tag+`"}`))
+		tarAddTimestamp(t, tarWriter, b.Timestamp)
+		require.NoError(t, tarWriter.Close())
+		require.NoError(t, backupTarFile.Close())
+		require.NoError(t, dataDir.CommitBackup(&b))
+		return b
+	}
+	boundary := newBackup("boundary", maxAge)
+	expired := newBackup("expired", maxAge+time.Second)
+
+	removed, err := dataDir.PruneBackups(maxAge)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{expired.Id()}, removed)
+	assert.FileExists(t, dataDir.BackupPath(boundary.Id()))
+	assert.NoFileExists(t, dataDir.BackupPath(expired.Id()))
+}
+
+func TestDataDir_PruneBackupsVerified(t *testing.T) {
+	fs := afero.NewOsFs()
+	now := time.Unix(1696420902, 0)
+	maxAge := 24 * time.Hour
+
+	dataDir, err := NewDataDir(t.TempDir(), fs, nil, WithClock(fakeClock{now: now}))
+	require.NoError(t, err)
+
+	// newBackup creates a backup of the instance with the given tag, with a
+	// manifest whose checksum matches the archive unless it is corrupt.
+	newBackup := func(tag string, age time.Duration, corrupt bool) Backup {
+		b := Backup{
+			InstanceId: "mock-avs-" + tag,
+			Timestamp:  now.Add(-age),
+			Version:    "v5.5.1",
+			Commit:     "d5af645fffb93e8263b099082a4f512e1917d0af",
+			Url:        "https://github.com/NethermindEth/mock-avs-pkg",
+		}
+		require.NoError(t, dataDir.InitBackup(&b))
+		backupTarFile, err := fs.OpenFile(dataDir.BackupWritePath(b.Id()), os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		tarWriter := tar.NewWriter(backupTarFile)
+		tarAddStateJson(t, tarWriter, []byte(`{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","commit":"d5af645fffb93e8263b099082a4f512e1917d0af","profile":"option-returner","tag":"`+tag+`"}`))
+		tarAddTimestamp(t, tarWriter, b.Timestamp)
+		require.NoError(t, tarWriter.Close())
+		require.NoError(t, backupTarFile.Close())
+		require.NoError(t, dataDir.CommitBackup(&b))
+
+		checksum, err := dataDir.BackupChecksum(b.Id())
+		require.NoError(t, err)
+		if corrupt {
+			checksum = strings.Repeat("0", len(checksum))
+		}
+		manifest, err := json.Marshal(BackupManifest{InstanceId: b.InstanceId, Timestamp: b.Timestamp, Checksum: checksum})
+		require.NoError(t, err)
+		require.NoError(t, afero.WriteFile(fs, dataDir.BackupManifestPath(b.Id()), manifest, 0o644))
+		return b
+	}
+	// The newest backup is corrupt, the older valid one is expired
+	oldValid := newBackup("default", maxAge+time.Hour, false)
+	newestCorrupt := newBackup("default", time.Hour, true)
+	// Another instance with a single expired and corrupt backup
+	onlyCorrupt := newBackup("other", maxAge+time.Hour, true)
+
+	result, err := dataDir.PruneBackupsWithOptions(maxAge, PruneOptions{KeepLastVerified: 1})
+	require.ErrorIs(t, err, ErrNoVerifiedBackup)
+	assert.ErrorContains(t, err, "mock-avs-other")
+	assert.Empty(t, result.Removed)
+	assert.Equal(t, []string{oldValid.Id()}, result.VerifiedKept)
+	for _, b := range []Backup{oldValid, newestCorrupt, onlyCorrupt} {
+		assert.FileExists(t, dataDir.BackupPath(b.Id()))
+	}
+
+	// Once a newer valid backup exists, the older one is pruned
+	newValid := newBackup("default", 2*time.Hour, false)
+	result, err = dataDir.PruneBackupsWithOptions(maxAge, PruneOptions{KeepLastVerified: 1})
+	require.ErrorIs(t, err, ErrNoVerifiedBackup)
+	assert.Equal(t, []string{oldValid.Id()}, result.Removed)
+	assert.Equal(t, []string{newValid.Id()}, result.VerifiedKept)
+	assert.NoFileExists(t, dataDir.BackupPath(oldValid.Id()))
+	assert.FileExists(t, dataDir.BackupPath(newestCorrupt.Id()))
+
+	// Without verification, only the age matters
+	result, err = dataDir.PruneBackupsWithOptions(maxAge, PruneOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{onlyCorrupt.Id()}, result.Removed)
+	assert.Empty(t, result.VerifiedKept)
+}
+
+func TestDataDir_RemoveInstanceMaintenance(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+
+	newTestInstance := func(tag string) *Instance {
+		instance := &Instance{
+			Name:    "mock-avs",
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Profile: "option-returner",
+			Tag:     tag,
+		}
+		_, err = dataDir.InitInstance(instance)
+		require.NoError(t, err)
+		require.NoError(t, instance.SetMaintenance(true))
+		return instance
+	}
+
+	t.Run("rejected until cleared", func(t *testing.T) {
+		instance := newTestInstance("default")
+
+		// The flag is persisted
+		loaded, err := dataDir.Instance(instance.ID())
+		require.NoError(t, err)
+		assert.True(t, loaded.Maintenance)
+
+		err = dataDir.RemoveInstance(instance.ID(), false)
+		require.ErrorIs(t, err, ErrInstanceInMaintenance)
+		assert.True(t, dataDir.HasInstance(instance.ID()))
+
+		require.NoError(t, loaded.SetMaintenance(false))
+		require.NoError(t, dataDir.RemoveInstance(instance.ID(), false))
+		assert.False(t, dataDir.HasInstance(instance.ID()))
+	})
+	t.Run("forced", func(t *testing.T) {
+		instance := newTestInstance("forced")
+
+		require.NoError(t, dataDir.RemoveInstance(instance.ID(), true))
+		assert.False(t, dataDir.HasInstance(instance.ID()))
+	})
+}
+
+func TestDataDir_RemoveInstances(t *testing.T) {
+	fs := afero.NewOsFs()
+
+	setup := func(t *testing.T) *DataDir {
+		dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+		require.NoError(t, err)
+		for tag, profile := range map[string]string{
+			"first":  "option-returner",
+			"second": "option-returner",
+			"third":  "health-checker",
+		} {
+			_, err := dataDir.InitInstance(&Instance{
+				Name:    "mock-avs",
+				URL:     common.MockAvsPkg.Repo(),
+				Version: common.MockAvsPkg.Version(),
+				Profile: profile,
+				Tag:     tag,
+			})
+			require.NoError(t, err)
+		}
+		return dataDir
+	}
+
+	t.Run("by profile", func(t *testing.T) {
+		dataDir := setup(t)
+		removed, err := dataDir.RemoveInstances(ListFilter{Profile: "option-returner"}, false)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"mock-avs-first", "mock-avs-second"}, removed)
+		assert.False(t, dataDir.HasInstance("mock-avs-first"))
+		assert.False(t, dataDir.HasInstance("mock-avs-second"))
+		assert.True(t, dataDir.HasInstance("mock-avs-third"))
+	})
+	t.Run("dry run", func(t *testing.T) {
+		dataDir := setup(t)
+		removed, err := dataDir.RemoveInstances(ListFilter{Profile: "option-returner"}, true)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"mock-avs-first", "mock-avs-second"}, removed)
+		assert.True(t, dataDir.HasInstance("mock-avs-first"))
+		assert.True(t, dataDir.HasInstance("mock-avs-second"))
+	})
+	t.Run("failure does not abort", func(t *testing.T) {
+		dataDir := setup(t)
+		instance, err := dataDir.Instance("mock-avs-first")
+		require.NoError(t, err)
+		require.NoError(t, instance.SetMaintenance(true))
+
+		removed, err := dataDir.RemoveInstances(ListFilter{Name: "mock-avs"}, false)
+		require.ErrorIs(t, err, ErrInstanceInMaintenance)
+		assert.ElementsMatch(t, []string{"mock-avs-second", "mock-avs-third"}, removed)
+		assert.True(t, dataDir.HasInstance("mock-avs-first"))
+	})
+}
+
+// newArchiveTestDataDir returns a data dir with two instances, a monitoring
+// stack, a plugin context and a temp dir.
+func newArchiveTestDataDir(t *testing.T, fs afero.Fs) *DataDir {
+	t.Helper()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+
+	for _, tag := range []string{"default", "second"} {
+		_, err = dataDir.InitInstance(&Instance{
+			Name:    "mock-avs",
+			Tag:     tag,
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Profile: "option-returner",
+		})
+		require.NoError(t, err)
+	}
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+	require.NoError(t, stack.WriteFile(".env", []byte("KEY=value")))
+	namedStack, err := dataDir.MonitoringStackNamed("holesky")
+	require.NoError(t, err)
+	require.NoError(t, namedStack.WriteFile(".env", []byte("KEY=holesky")))
+	require.NoError(t, dataDir.SavePluginImageContext("plugin", io.NopCloser(strings.NewReader("plugin context"))))
+	_, err = dataDir.InitTemp("temp")
+	require.NoError(t, err)
+	return dataDir
+}
+
+func TestDataDir_BackupAll(t *testing.T) {
+	fs := afero.NewOsFs()
+	srcDataDir := newArchiveTestDataDir(t, fs)
+
+	var archive bytes.Buffer
+	require.NoError(t, srcDataDir.BackupAll(&archive))
+
+	dstPath := t.TempDir()
+	dstDataDir, err := NewDataDir(dstPath, fs, locker.NewFLock())
+	require.NoError(t, err)
+	require.NoError(t, dstDataDir.RestoreAll(bytes.NewReader(archive.Bytes())))
+
+	srcInstances, err := srcDataDir.ListInstances()
+	require.NoError(t, err)
+	dstInstances, err := dstDataDir.ListInstances()
+	require.NoError(t, err)
+	require.Len(t, dstInstances, len(srcInstances))
+	for i := range srcInstances {
+		srcFingerprint, err := srcInstances[i].Fingerprint()
+		require.NoError(t, err)
+		dstFingerprint, err := dstInstances[i].Fingerprint()
+		require.NoError(t, err)
+		assert.Equal(t, srcFingerprint, dstFingerprint)
+	}
+
+	env, err := afero.ReadFile(fs, filepath.Join(dstPath, monitoringStackDirName, ".env"))
+	require.NoError(t, err)
+	assert.Equal(t, "KEY=value", string(env))
+	env, err = afero.ReadFile(fs, filepath.Join(dstPath, monitoringStackDirName+"-holesky", ".env"))
+	require.NoError(t, err)
+	assert.Equal(t, "KEY=holesky", string(env))
+	pluginContext, err := afero.ReadFile(fs, filepath.Join(dstPath, pluginsDir, "plugin.tar"))
+	require.NoError(t, err)
+	assert.Equal(t, "plugin context", string(pluginContext))
+	exists, err := afero.Exists(fs, filepath.Join(dstPath, tempDir))
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// Restoring again must not overwrite the restored instances.
+	err = dstDataDir.RestoreAll(bytes.NewReader(archive.Bytes()))
+	assert.ErrorIs(t, err, ErrInstanceAlreadyExists)
+}
+
+func TestDataDir_BackupAllCompressionLevel(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir := newArchiveTestDataDir(t, fs)
+	// Compressible data, which compresses better with more effort
+	var data strings.Builder
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&data, "block %d height %d hash %x\n", i, i*i%7919, i*31%65521)
+	}
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(dataDir.NodesPath(), "mock-avs-default", "data.log"), []byte(data.String()), 0o644))
+
+	backup := func(level int) (*bytes.Buffer, error) {
+		var archive bytes.Buffer
+		err := dataDir.BackupAllWithOptions(context.Background(), &archive, BackupAllOptions{CompressionLevel: level})
+		return &archive, err
+	}
+	fast, err := backup(gzip.BestSpeed)
+	require.NoError(t, err)
+	small, err := backup(gzip.BestCompression)
+	require.NoError(t, err)
+	assert.Greater(t, fast.Len(), small.Len())
+	defaultLevel, err := backup(0)
+	require.NoError(t, err)
+	assert.Less(t, defaultLevel.Len(), fast.Len())
+
+	// Every level restores the same data
+	for _, archive := range []*bytes.Buffer{fast, small, defaultLevel} {
+		dstPath := t.TempDir()
+		dstDataDir, err := NewDataDir(dstPath, fs, locker.NewFLock())
+		require.NoError(t, err)
+		require.NoError(t, dstDataDir.RestoreAll(archive))
+		restored, err := afero.ReadFile(fs, filepath.Join(dstPath, nodesDirName, "mock-avs-default", "data.log"))
+		require.NoError(t, err)
+		assert.Equal(t, data.String(), string(restored))
+	}
+
+	for _, level := range []int{gzip.NoCompression - 1, gzip.BestCompression + 1, gzip.HuffmanOnly} {
+		archive, err := backup(level)
+		assert.ErrorIs(t, err, ErrInvalidCompressionLevel, "level %d", level)
+		assert.Zero(t, archive.Len())
+	}
+}
+
+func TestDataDir_InitBackupBusyInstance(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDirPath := t.TempDir()
+	dataDir, err := NewDataDir(dataDirPath, fs, locker.NewFLock())
+	require.NoError(t, err)
+	instancePath := filepath.Join(dataDirPath, nodesDirName, "mock-avs-default")
+	require.NoError(t, fs.MkdirAll(instancePath, 0o755))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, ".lock"), nil, 0o644))
+
+	// Another process writing to the instance
+	instanceLock := locker.NewFLock().New(filepath.Join(instancePath, ".lock"))
+	require.NoError(t, instanceLock.Lock())
+
+	busy, err := dataDir.InstanceBusy("mock-avs-default")
+	require.NoError(t, err)
+	assert.True(t, busy)
+
+	backup := Backup{InstanceId: "mock-avs-default", Timestamp: time.Unix(1696420902, 0)}
+	err = dataDir.InitBackup(&backup)
+	require.ErrorIs(t, err, ErrInstanceBusy)
+	exists, err := dataDir.HasBackup(backup.Id())
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, dataDir.InitBackupForce(&backup))
+	exists, err = dataDir.HasBackup(backup.Id())
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// Once released, the instance is no longer busy
+	require.NoError(t, instanceLock.Unlock())
+	busy, err = dataDir.InstanceBusy("mock-avs-default")
+	require.NoError(t, err)
+	assert.False(t, busy)
+}
+
+func TestDataDir_InitTimestampedBackup(t *testing.T) {
+	fs := afero.NewOsFs()
+	clock := &fakeClock{now: time.Unix(1696420902, 0)}
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock(), WithClock(clock))
+	require.NoError(t, err)
+	instance := &Instance{
+		Name:    "mock-avs",
+		Tag:     "default",
+		URL:     common.MockAvsPkg.Repo(),
+		Version: common.MockAvsPkg.Version(),
+		Profile: "option-returner",
+	}
+	_, err = dataDir.InitInstance(instance)
+	require.NoError(t, err)
+	state, err := afero.ReadFile(fs, filepath.Join(dataDir.NodesPath(), "mock-avs-default", "state.json"))
+	require.NoError(t, err)
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		backup, err := dataDir.InitTimestampedBackup("mock-avs-default")
+		require.NoError(t, err)
+		assert.Equal(t, TimestampedBackupId("mock-avs-default", clock.now), backup.Id())
+		backupTarFile, err := fs.OpenFile(dataDir.BackupWritePath(backup.Id()), os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		tarWriter := tar.NewWriter(backupTarFile)
+		tarAddStateJson(t, tarWriter, state)
+		tarAddTimestamp(t, tarWriter, backup.Timestamp)
+		require.NoError(t, tarWriter.Close())
+		require.NoError(t, backupTarFile.Close())
+		require.NoError(t, dataDir.CommitBackup(backup))
+		ids = append(ids, backup.Id())
+
+		clock.now = clock.now.Add(2 * time.Second)
+	}
+	assert.Equal(t, "mock-avs-default-2023-10-04T12:01:42Z", ids[0])
+	assert.NotEqual(t, ids[0], ids[1])
+
+	for _, id := range ids {
+		exists, err := dataDir.HasBackup(id)
+		require.NoError(t, err)
+		assert.True(t, exists)
+	}
+	grouped, err := dataDir.BackupsByInstance()
+	require.NoError(t, err)
+	require.Len(t, grouped, 1)
+	require.Len(t, grouped["mock-avs-default"], 2)
+	assert.ElementsMatch(t, ids, []string{grouped["mock-avs-default"][0].Id(), grouped["mock-avs-default"][1].Id()})
+}
+
+func TestDataDir_LatestBackup(t *testing.T) {
+	fs := afero.NewOsFs()
+	clock := &fakeClock{now: time.Unix(1696420902, 0)}
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock(), WithClock(clock))
+	require.NoError(t, err)
+
+	_, err = dataDir.LatestBackup("mock-avs-default")
+	require.ErrorIs(t, err, ErrBackupNotFound)
+
+	state := []byte(`{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","profile":"option-returner","tag":"default"}`)
+	otherState := []byte(`{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","profile":"option-returner","tag":"other"}`)
+	addBackup := func(instanceId string, state []byte, timestamp time.Time) string {
+		backup := Backup{InstanceId: instanceId, Timestamp: timestamp}
+		require.NoError(t, dataDir.InitBackup(&backup))
+		backupTarFile, err := fs.OpenFile(dataDir.BackupWritePath(backup.Id()), os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		tarWriter := tar.NewWriter(backupTarFile)
+		tarAddStateJson(t, tarWriter, state)
+		tarAddTimestamp(t, tarWriter, backup.Timestamp)
+		require.NoError(t, tarWriter.Close())
+		require.NoError(t, backupTarFile.Close())
+		require.NoError(t, dataDir.CommitBackup(&backup))
+		return backup.Id()
+	}
+	addBackup("mock-avs-default", state, clock.now)
+	newest := addBackup("mock-avs-default", state, clock.now.Add(2*time.Hour))
+	addBackup("mock-avs-default", state, clock.now.Add(time.Hour))
+	// A newer backup of another instance is ignored
+	addBackup("mock-avs-other", otherState, clock.now.Add(3*time.Hour))
+
+	latest, err := dataDir.LatestBackup("mock-avs-default")
+	require.NoError(t, err)
+	assert.Equal(t, newest, latest)
+
+	_, err = dataDir.LatestBackup("mock-avs-missing")
+	assert.ErrorIs(t, err, ErrBackupNotFound)
+}
+
+// cancelingReader cancels the context once more than limit bytes were read.
+type cancelingReader struct {
+	r      io.Reader
+	limit  int
+	read   int
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += n
+	if r.read > r.limit {
+		r.cancel()
+	}
+	return n, err
+}
+
+func TestDataDir_RestoreAllCanceled(t *testing.T) {
+	fs := afero.NewOsFs()
+	srcDataDir := newArchiveTestDataDir(t, fs)
+	// Add incompressible data to the first instance, so the archive is read in
+	// several chunks.
+	data := make([]byte, 1<<20)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(srcDataDir.NodesPath(), "mock-avs-default", "data.bin"), data, 0o644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, srcDataDir.BackupAllContext(ctx, io.Discard), context.Canceled)
+
+	var archive bytes.Buffer
+	require.NoError(t, srcDataDir.BackupAll(&archive))
+
+	dstDataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	r := &cancelingReader{r: &archive, limit: archive.Len() / 2, cancel: cancel}
+	err = dstDataDir.RestoreAllContext(ctx, r)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Nothing restored is left behind, not even the directories created for
+	// the restored files.
+	assert.NoDirExists(t, dstDataDir.NodesPath())
+	exists, err := afero.Exists(fs, filepath.Join(dstDataDir.PluginDirPath(), "plugin.tar"))
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+// testDataDirArchive returns a data directory archive with the given manifest
+// instances and files, as pairs of names and contents.
+func testDataDirArchive(t *testing.T, instances []string, files ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	manifest, err := json.Marshal(ArchiveManifest{Timestamp: time.Unix(1696420902, 0), Instances: instances})
+	require.NoError(t, err)
+	files = append([]string{archiveManifestName, string(manifest)}, files...)
+	for i := 0; i < len(files); i += 2 {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: files[i], Mode: 0o644, Size: int64(len(files[i+1]))}))
+		_, err := tw.Write([]byte(files[i+1]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func TestDataDir_RestoreAllInvalidArchive(t *testing.T) {
+	state := `{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","profile":"option-returner","tag":"default"}`
+	liveState := `{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","profile":"option-returner","tag":"live"}`
+	tests := []struct {
+		name      string
+		instances []string
+		files     []string
+		wantErr   error
+	}{
+		{
+			name:      "unlisted instance",
+			instances: []string{"mock-avs-default"},
+			files: []string{
+				"nodes/mock-avs-default/state.json", state,
+				"nodes/mock-avs-live/state.json", `{}`,
+			},
+			wantErr: ErrInvalidDataDirArchive,
+		},
+		{
+			name:      "parent instance id",
+			instances: []string{".."},
+			wantErr:   ErrInvalidDataDirArchive,
+		},
+		{
+			name:      "traversal instance id",
+			instances: []string{"../monitoring"},
+			wantErr:   ErrInvalidDataDirArchive,
+		},
+		{
+			name:      "nested instance id",
+			instances: []string{"mock-avs-live/data"},
+			wantErr:   ErrInvalidDataDirArchive,
+		},
+		{
+			name:      "existing file",
+			instances: []string{"mock-avs-default"},
+			files: []string{
+				"nodes/mock-avs-default/state.json", state,
+				"monitoring/.env", "KEY=archived",
+			},
+			wantErr: os.ErrExist,
+		},
+		{
+			name:      "existing file after new directories",
+			instances: []string{"mock-avs-default"},
+			files: []string{
+				"nodes/mock-avs-default/config/config.yml", "key: value\n",
+				"plugin/new/plugin.tar", "context",
+				"monitoring/.env", "KEY=archived",
+			},
+			wantErr: os.ErrExist,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewOsFs()
+			dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+			require.NoError(t, err)
+			livePath := filepath.Join(dataDir.NodesPath(), "mock-avs-live")
+			require.NoError(t, fs.MkdirAll(livePath, 0o755))
+			require.NoError(t, afero.WriteFile(fs, filepath.Join(livePath, ".lock"), nil, 0o644))
+			require.NoError(t, afero.WriteFile(fs, filepath.Join(livePath, "state.json"), []byte(liveState), 0o644))
+			stack, err := dataDir.MonitoringStack()
+			require.NoError(t, err)
+			require.NoError(t, stack.WriteFile(".env", []byte("KEY=value")))
+
+			err = dataDir.RestoreAll(bytes.NewReader(testDataDirArchive(t, tt.instances, tt.files...)))
+			require.ErrorIs(t, err, tt.wantErr)
+
+			// The live data is left untouched, and nothing restored is left
+			// behind.
+			live, err := afero.ReadFile(fs, filepath.Join(livePath, "state.json"))
+			require.NoError(t, err)
+			assert.Equal(t, liveState, string(live))
+			env, err := stack.ReadFile(".env")
+			require.NoError(t, err)
+			assert.Equal(t, "KEY=value", string(env))
+			assert.False(t, dataDir.HasInstance("mock-avs-default"))
+			instances, err := dataDir.ListInstances()
+			require.NoError(t, err)
+			require.Len(t, instances, 1)
+			assert.Equal(t, "live", instances[0].Tag)
+			nodes, err := afero.ReadDir(fs, dataDir.NodesPath())
+			require.NoError(t, err)
+			require.Len(t, nodes, 1)
+			assert.Equal(t, "mock-avs-live", nodes[0].Name())
+			assert.NoDirExists(t, filepath.Join(dataDir.PluginDirPath(), "new"))
+		})
+	}
+}
+
+func TestDataDir_CountInstances(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+
+	// Missing nodes directory
+	count, err := dataDir.CountInstances()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	for _, tag := range []string{"default", "second"} {
+		_, err = dataDir.InitInstance(&Instance{
+			Name:    "mock-avs",
+			Tag:     tag,
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Profile: "option-returner",
+		})
+		require.NoError(t, err)
+	}
+	// Stray entries are ignored
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(dataDir.NodesPath(), "stray-file"), []byte("stray"), 0o644))
+	require.NoError(t, fs.MkdirAll(filepath.Join(dataDir.NodesPath(), "no-state"), 0o755))
+	require.NoError(t, fs.MkdirAll(filepath.Join(dataDir.NodesPath(), "old-default"+deletingSuffix), 0o755))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(dataDir.NodesPath(), "old-default"+deletingSuffix, "state.json"), []byte("{}"), 0o644))
+
+	count, err = dataDir.CountInstances()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestDataDir_Symlink(t *testing.T) {
+	fs := afero.NewOsFs()
+	realPath, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	linkPath := filepath.Join(t.TempDir(), ".eigen")
+	require.NoError(t, os.Symlink(realPath, linkPath))
+
+	dataDir, err := NewDataDir(linkPath, fs, locker.NewFLock())
+	require.NoError(t, err)
+	assert.Equal(t, realPath, dataDir.Path())
+
+	_, err = dataDir.InitInstance(&Instance{
+		Name:    "mock-avs",
+		Tag:     "default",
+		URL:     common.MockAvsPkg.Repo(),
+		Version: common.MockAvsPkg.Version(),
+		Profile: "option-returner",
+	})
+	require.NoError(t, err)
+	instancePath, err := dataDir.InstancePath("mock-avs-default")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(realPath, nodesDirName, "mock-avs-default"), instancePath)
+	assert.FileExists(t, filepath.Join(realPath, nodesDirName, "mock-avs-default", "state.json"))
+
+	// The part of the path that does not exist yet is kept as it is
+	dataDir, err = NewDataDir(filepath.Join(linkPath, "missing", "data"), fs, locker.NewFLock())
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(realPath, "missing", "data"), dataDir.Path())
+}
+
+func TestDataDir_WalkInstances(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+
+	// Missing nodes directory
+	err = dataDir.WalkInstances(func(*Instance) error {
+		t.Fatal("unexpected instance")
+		return nil
+	})
+	require.NoError(t, err)
+
+	for _, tag := range []string{"a", "b", "c"} {
+		_, err = dataDir.InitInstance(&Instance{
+			Name:    "mock-avs",
+			Tag:     tag,
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Profile: "option-returner",
+		})
+		require.NoError(t, err)
+	}
+
+	var walked []string
+	err = dataDir.WalkInstances(func(instance *Instance) error {
+		walked = append(walked, instance.Tag)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, walked)
+
+	// Stop early without error
+	walked = nil
+	err = dataDir.WalkInstances(func(instance *Instance) error {
+		walked = append(walked, instance.Tag)
+		if instance.Tag == "b" {
+			return ErrStopWalk
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, walked)
+
+	// Stop early with the callback error
+	walked = nil
+	callbackErr := errors.New("callback error")
+	err = dataDir.WalkInstances(func(instance *Instance) error {
+		walked = append(walked, instance.Tag)
+		return callbackErr
+	})
+	assert.ErrorIs(t, err, callbackErr)
+	assert.Equal(t, []string{"a"}, walked)
+}
+
+func TestMonitoringStackNamed(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+
+	staging, err := dataDir.MonitoringStackNamed("staging")
+	require.NoError(t, err)
+	prod, err := dataDir.MonitoringStackNamed("prod")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dataDir.Path(), "monitoring-staging"), staging.Path())
+	assert.Equal(t, filepath.Join(dataDir.Path(), "monitoring-prod"), prod.Path())
+
+	// Stacks are independent
+	require.NoError(t, staging.WriteFile(".env", []byte("ENV=staging")))
+	require.NoError(t, prod.WriteFile(".env", []byte("ENV=prod")))
+	data, err := staging.ReadFile(".env")
+	require.NoError(t, err)
+	assert.Equal(t, "ENV=staging", string(data))
+
+	require.NoError(t, dataDir.RemoveMonitoringStackNamed("staging"))
+	exists, err := afero.DirExists(fs, staging.Path())
+	require.NoError(t, err)
+	assert.False(t, exists)
+	data, err = prod.ReadFile(".env")
+	require.NoError(t, err)
+	assert.Equal(t, "ENV=prod", string(data))
+
+	// The default stack is not a named one
+	exists, err = afero.DirExists(fs, dataDir.MonitoringPath())
+	require.NoError(t, err)
+	assert.False(t, exists)
+	err = dataDir.RemoveMonitoringStackNamed("staging")
+	assert.ErrorIs(t, err, ErrMonitoringStackNotFound)
+
+	// Names must be a single path element
+	_, err = dataDir.MonitoringStackNamed("../nodes")
+	assert.ErrorIs(t, err, ErrInvalidMonitoringStackName)
+}
+
+func TestHasMonitoringStack(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+
+	// Absent, and checking does not create it
+	ok, err := dataDir.HasMonitoringStack()
+	require.NoError(t, err)
+	assert.False(t, ok)
+	exists, err := afero.Exists(fs, dataDir.MonitoringPath())
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// Present
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+	assert.Equal(t, dataDir.MonitoringPath(), stack.Path())
+	ok, err = dataDir.HasMonitoringStack()
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestDataDir_CompressedState(t *testing.T) {
+	tc := []struct {
+		name        string
+		opts        []DataDirOption
+		wantFile    string
+		missingFile string
+	}{
+		{
+			name:        "uncompressed",
+			wantFile:    "state.json",
+			missingFile: "state.json.gz",
+		},
+		{
+			name:        "compressed",
+			opts:        []DataDirOption{WithCompressedState()},
+			wantFile:    "state.json.gz",
+			missingFile: "state.json",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewOsFs()
+			dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock(), tt.opts...)
+			require.NoError(t, err)
+			instance := &Instance{
+				Name:    "mock-avs",
+				Tag:     "default",
+				URL:     common.MockAvsPkg.Repo(),
+				Version: common.MockAvsPkg.Version(),
+				Profile: "option-returner",
+			}
+			_, err = dataDir.InitInstance(instance)
+			require.NoError(t, err)
+			instancePath := filepath.Join(dataDir.NodesPath(), "mock-avs-default")
+			assert.FileExists(t, filepath.Join(instancePath, tt.wantFile))
+			assert.NoFileExists(t, filepath.Join(instancePath, tt.missingFile))
+
+			// Round trip
+			stored, err := dataDir.Instance("mock-avs-default")
+			require.NoError(t, err)
+			assert.Equal(t, instance.Profile, stored.Profile)
+			require.NoError(t, stored.SetMaintenance(true))
+			assert.FileExists(t, filepath.Join(instancePath, tt.wantFile))
+			assert.NoFileExists(t, filepath.Join(instancePath, tt.missingFile))
+			stored, err = dataDir.Instance("mock-avs-default")
+			require.NoError(t, err)
+			assert.True(t, stored.Maintenance)
+			count, err := dataDir.CountInstances()
+			require.NoError(t, err)
+			assert.Equal(t, 1, count)
+		})
+	}
+
+	t.Run("auto-detection", func(t *testing.T) {
+		fs := afero.NewOsFs()
+		dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+		require.NoError(t, err)
+		_, err = dataDir.InitInstance(&Instance{
+			Name:    "mock-avs",
+			Tag:     "default",
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Profile: "option-returner",
+		})
+		require.NoError(t, err)
+		instancePath := filepath.Join(dataDir.NodesPath(), "mock-avs-default")
+
+		// Compress the state by hand, the compressed form is detected
+		stateData, err := afero.ReadFile(fs, filepath.Join(instancePath, "state.json"))
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		_, err = gw.Write(stateData)
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+		require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "state.json.gz"), buf.Bytes(), 0o644))
+		require.NoError(t, fs.Remove(filepath.Join(instancePath, "state.json")))
+
+		stored, err := dataDir.Instance("mock-avs-default")
+		require.NoError(t, err)
+		assert.Equal(t, "option-returner", stored.Profile)
+
+		// The detected form is kept on save
+		require.NoError(t, stored.SetMaintenance(true))
+		assert.FileExists(t, filepath.Join(instancePath, "state.json.gz"))
+		assert.NoFileExists(t, filepath.Join(instancePath, "state.json"))
+	})
+}
+
+// syncRecordingFs records the names of the files synced through it.
+type syncRecordingFs struct {
+	afero.Fs
+	synced []string
+}
+
+func (fs *syncRecordingFs) Open(name string) (afero.File, error) {
+	f, err := fs.Fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &syncRecordingFile{File: f, fs: fs}, nil
+}
+
+func (fs *syncRecordingFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	f, err := fs.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &syncRecordingFile{File: f, fs: fs}, nil
+}
+
+type syncRecordingFile struct {
+	afero.File
+	fs *syncRecordingFs
+}
+
+func (f *syncRecordingFile) Sync() error {
+	f.fs.synced = append(f.fs.synced, filepath.Clean(f.Name()))
+	return f.File.Sync()
+}
+
+func TestDataDir_Sync(t *testing.T) {
+	for _, sync := range []bool{false, true} {
+		t.Run(fmt.Sprintf("sync=%t", sync), func(t *testing.T) {
+			fs := &syncRecordingFs{Fs: afero.NewOsFs()}
+			var opts []DataDirOption
+			if sync {
+				opts = append(opts, WithSync())
+			}
+			dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock(), opts...)
+			require.NoError(t, err)
+			instancePath := filepath.Join(dataDir.NodesPath(), "mock-avs-default")
+
+			_, err = dataDir.InitInstance(&Instance{
+				Name:    "mock-avs",
+				Tag:     "default",
+				URL:     common.MockAvsPkg.Repo(),
+				Version: common.MockAvsPkg.Version(),
+				Profile: "option-returner",
+			})
+			require.NoError(t, err)
+			instance, err := dataDir.Instance("mock-avs-default")
+			require.NoError(t, err)
+			require.NoError(t, instance.SetMaintenance(true))
+
+			backup := Backup{InstanceId: "mock-avs-default", Timestamp: time.Unix(1696420902, 0)}
+			require.NoError(t, dataDir.InitBackup(&backup))
+			require.NoError(t, dataDir.WriteBackupManifest(&backup))
+
+			if !sync {
+				assert.Empty(t, fs.synced)
+				return
+			}
+			// The temporary state files and the instance directory are synced
+			// on init and on save
+			var stateSyncs, dirSyncs int
+			for _, name := range fs.synced {
+				if strings.HasPrefix(name, filepath.Join(instancePath, ".state.json-")) {
+					stateSyncs++
+				}
+				if name == instancePath {
+					dirSyncs++
+				}
+			}
+			assert.Equal(t, 2, stateSyncs)
+			assert.Equal(t, 2, dirSyncs)
+			assert.Contains(t, fs.synced, dataDir.BackupPath(backup.Id()))
+			assert.Contains(t, fs.synced, filepath.Clean(dataDir.BackupDirPath()))
+
+			// No temporary files are left behind
+			entries, err := afero.ReadDir(fs, instancePath)
+			require.NoError(t, err)
+			for _, entry := range entries {
+				assert.NotContains(t, entry.Name(), ".tmp")
+			}
+		})
+	}
+}
+
+// newDiskUsageTestDataDir creates a data dir with the given number of
+// instance directories, and returns it with their total file size.
+func newDiskUsageTestDataDir(t testing.TB, fs afero.Fs, instances int, opts ...DataDirOption) (*DataDir, int64) {
+	dataDir, err := NewDataDir("/data", fs, locker.NewFLock(), opts...)
+	require.NoError(t, err)
+	var total int64
+	for i := 0; i < instances; i++ {
+		instancePath := filepath.Join(dataDir.NodesPath(), fmt.Sprintf("mock-avs-tag%d", i))
+		for j := 0; j <= i%4; j++ {
+			data := bytes.Repeat([]byte("x"), 100*(i+1)+j)
+			require.NoError(t, fs.MkdirAll(filepath.Join(instancePath, "sub"), 0o755))
+			require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "sub", fmt.Sprintf("file%d", j)), data, 0o644))
+			total += int64(len(data))
+		}
+	}
+	return dataDir, total
+}
+
+func TestDataDir_DiskUsage(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	dataDir, total := newDiskUsageTestDataDir(t, fs, 50, WithDiskUsageConcurrency(1))
+	serial, err := dataDir.DiskUsage()
+	require.NoError(t, err)
+	assert.Equal(t, total, serial)
+
+	parallelDataDir, err := NewDataDir("/data", fs, locker.NewFLock(), WithDiskUsageConcurrency(8))
+	require.NoError(t, err)
+	parallel, err := parallelDataDir.DiskUsage()
+	require.NoError(t, err)
+	assert.Equal(t, serial, parallel)
+
+	// Per instance usage
+	instanceFs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(instanceFs, "/instance/state.json", []byte("{}"), 0o644))
+	require.NoError(t, afero.WriteFile(instanceFs, "/instance/.env", []byte("A=1\n"), 0o644))
+	instance := &Instance{path: "/instance", fs: instanceFs}
+	size, err := instance.DiskUsage()
+	require.NoError(t, err)
+	assert.EqualValues(t, 6, size)
+
+	// Empty data dir
+	emptyDataDir, err := NewDataDir("/empty", fs, locker.NewFLock())
+	require.NoError(t, err)
+	size, err = emptyDataDir.DiskUsage()
+	require.NoError(t, err)
+	assert.Zero(t, size)
+}
+
+// failingOpenFs fails to open the paths containing failPart.
+type failingOpenFs struct {
+	afero.Fs
+	failPart string
+}
+
+func (fs *failingOpenFs) Open(name string) (afero.File, error) {
+	if strings.Contains(name, fs.failPart) {
+		return nil, errors.New("open failed")
+	}
+	return fs.Fs.Open(name)
+}
+
+func TestDataDir_DiskUsageError(t *testing.T) {
+	memFs := afero.NewMemMapFs()
+	_, _ = newDiskUsageTestDataDir(t, memFs, 20)
+	fs := &failingOpenFs{Fs: memFs, failPart: "mock-avs-tag7"}
+	dataDir, err := NewDataDir("/data", fs, locker.NewFLock(), WithDiskUsageConcurrency(4))
+	require.NoError(t, err)
+	_, err = dataDir.DiskUsage()
+	assert.ErrorContains(t, err, "open failed")
+
+	// A canceled context stops the walks
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = dataDir.DiskUsageContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func BenchmarkDataDir_DiskUsage(b *testing.B) {
+	for _, concurrency := range []int{1, 8} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			dataDir, _ := newDiskUsageTestDataDir(b, afero.NewMemMapFs(), 500, WithDiskUsageConcurrency(concurrency))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := dataDir.DiskUsage(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDataDir_MigrateLayout(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDirPath := t.TempDir()
+	state := []byte(`{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","commit":"d5af645fffb93e8263b099082a4f512e1917d0af","profile":"option-returner","tag":"default"}`)
+	// A stray instance left in the root by an early version
+	legacyPath := filepath.Join(dataDirPath, "mock-avs-default")
+	require.NoError(t, fs.MkdirAll(legacyPath, 0o755))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(legacyPath, "state.json"), state, 0o644))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(legacyPath, ".lock"), nil, 0o644))
+	// Reserved directories are never moved, even with a state file
+	require.NoError(t, fs.MkdirAll(filepath.Join(dataDirPath, backupDir), 0o755))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(dataDirPath, backupDir, "state.json"), state, 0o644))
+
+	dataDir, err := NewDataDir(dataDirPath, fs, locker.NewFLock())
+	require.NoError(t, err)
+	instances, err := dataDir.ListInstances()
+	require.NoError(t, err)
+	assert.Empty(t, instances)
+
+	migrated, err := dataDir.MigrateLayout()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mock-avs-default"}, migrated)
+	assert.NoDirExists(t, legacyPath)
+	assert.FileExists(t, filepath.Join(dataDirPath, backupDir, "state.json"))
+
+	instances, err = dataDir.ListInstances()
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	assert.Equal(t, "mock-avs-default", instances[0].ID())
+
+	// Idempotent
+	migrated, err = dataDir.MigrateLayout()
+	require.NoError(t, err)
+	assert.Empty(t, migrated)
+
+	// A root instance conflicting with a migrated one is left in place
+	require.NoError(t, fs.MkdirAll(legacyPath, 0o755))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(legacyPath, "state.json"), state, 0o644))
+	migrated, err = dataDir.MigrateLayout()
+	assert.ErrorIs(t, err, ErrInstanceAlreadyExists)
+	assert.Empty(t, migrated)
+	assert.DirExists(t, legacyPath)
+}
+
+func TestDataDir_Marker(t *testing.T) {
+	t.Run("written on creation", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		clock := &fakeClock{now: time.Unix(1696420902, 0)}
+		ok, err := IsDataDir(fs, "/data")
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		_, err = NewDataDir("/data", fs, locker.NewFLock(), WithClock(clock))
+		require.NoError(t, err)
+		ok, err = IsDataDir(fs, "/data")
+		require.NoError(t, err)
+		assert.True(t, ok)
+		markerData, err := afero.ReadFile(fs, "/data/.eigen-datadir")
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"schema_version":1,"created_at":"2023-10-04T12:01:42Z","layout_version":2}`, string(markerData))
+
+		// Opening the data dir again keeps the marker
+		clock.now = clock.now.Add(time.Hour)
+		_, err = NewDataDir("/data", fs, locker.NewFLock(), WithClock(clock))
+		require.NoError(t, err)
+		markerAfter, err := afero.ReadFile(fs, "/data/.eigen-datadir")
+		require.NoError(t, err)
+		assert.Equal(t, markerData, markerAfter)
+	})
+	t.Run("existing data dir without marker", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		require.NoError(t, fs.MkdirAll("/data/nodes/mock-avs-default", 0o755))
+		require.NoError(t, fs.MkdirAll("/data/monitoring-staging", 0o755))
+		_, err := NewDataDir("/data", fs, locker.NewFLock())
+		require.NoError(t, err)
+		ok, err := IsDataDir(fs, "/data")
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+	t.Run("unrelated directory", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		require.NoError(t, afero.WriteFile(fs, "/shared/notes.txt", []byte("notes"), 0o644))
+		_, err := NewDataDir("/shared", fs, locker.NewFLock())
+		require.ErrorIs(t, err, ErrNotDataDir)
+		ok, err := IsDataDir(fs, "/shared")
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		// Forced
+		_, err = NewDataDir("/shared", fs, locker.NewFLock(), WithForceInit())
+		require.NoError(t, err)
+		ok, err = IsDataDir(fs, "/shared")
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+	t.Run("invalid marker", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		require.NoError(t, afero.WriteFile(fs, "/data/.eigen-datadir", []byte("not json"), 0o644))
+		_, err := IsDataDir(fs, "/data")
+		assert.ErrorIs(t, err, ErrNotDataDir)
+	})
+}
+
+func TestDataDir_LayoutVersion(t *testing.T) {
+	t.Run("new data dir", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		dataDir, err := NewDataDir("/data", fs, locker.NewFLock())
+		require.NoError(t, err)
+		assert.Equal(t, LayoutVersionNodes, dataDir.LayoutVersion())
+
+		// Read back from the marker
+		dataDir, err = NewDataDir("/data", fs, locker.NewFLock(), WithReadOnly())
+		require.NoError(t, err)
+		assert.Equal(t, LayoutVersionNodes, dataDir.LayoutVersion())
+	})
+	t.Run("marker without layout version", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		require.NoError(t, afero.WriteFile(fs, "/data/.eigen-datadir", []byte(`{"schema_version":1,"created_at":"2023-10-04T12:01:42Z"}`), 0o644))
+		dataDir, err := NewDataDir("/data", fs, locker.NewFLock())
+		require.NoError(t, err)
+		assert.Equal(t, LayoutVersionLegacy, dataDir.LayoutVersion())
+	})
+	t.Run("legacy data dir", func(t *testing.T) {
+		fs := afero.NewOsFs()
+		dataDirPath := t.TempDir()
+		state := []byte(`{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","commit":"d5af645fffb93e8263b099082a4f512e1917d0af","profile":"option-returner","tag":"default"}`)
+		legacyPath := filepath.Join(dataDirPath, "mock-avs-default")
+		require.NoError(t, fs.MkdirAll(legacyPath, 0o755))
+		require.NoError(t, afero.WriteFile(fs, filepath.Join(legacyPath, "state.json"), state, 0o644))
+
+		dataDir, err := NewDataDir(dataDirPath, fs, locker.NewFLock())
+		require.NoError(t, err)
+		assert.Equal(t, LayoutVersionLegacy, dataDir.LayoutVersion())
+		markerBefore, err := readMarker(fs, dataDirPath)
+		require.NoError(t, err)
+
+		_, err = dataDir.MigrateLayout()
+		require.NoError(t, err)
+		assert.Equal(t, LayoutVersionNodes, dataDir.LayoutVersion())
+		markerAfter, err := readMarker(fs, dataDirPath)
+		require.NoError(t, err)
+		assert.Equal(t, LayoutVersionNodes, markerAfter.LayoutVersion)
+		assert.Equal(t, markerBefore.CreatedAt, markerAfter.CreatedAt)
+
+		dataDir, err = NewDataDir(dataDirPath, fs, locker.NewFLock())
+		require.NoError(t, err)
+		assert.Equal(t, LayoutVersionNodes, dataDir.LayoutVersion())
+	})
+}
+
+// fullDiskFs fails the writes with ENOSPC once more than free bytes were
+// written through it.
+type fullDiskFs struct {
+	afero.Fs
+	free int64
+}
+
+func (fs *fullDiskFs) Create(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o666)
+}
+
+func (fs *fullDiskFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	f, err := fs.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &fullDiskFile{File: f, fs: fs}, nil
+}
+
+type fullDiskFile struct {
+	afero.File
+	fs *fullDiskFs
+}
+
+func (f *fullDiskFile) Write(p []byte) (int, error) {
+	if int64(len(p)) > f.fs.free {
+		n, _ := f.File.Write(p[:f.fs.free])
+		f.fs.free = 0
+		return n, &os.PathError{Op: "write", Path: f.Name(), Err: syscall.ENOSPC}
+	}
+	f.fs.free -= int64(len(p))
+	return f.File.Write(p)
+}
+
+func TestDataDir_BackupDiskFull(t *testing.T) {
+	fs := &fullDiskFs{Fs: afero.NewOsFs(), free: 1 << 30}
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	instancePath := filepath.Join(dataDir.NodesPath(), "mock-avs-default")
+	require.NoError(t, fs.MkdirAll(filepath.Join(instancePath, "logs"), 0o755))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "logs", "node.log"), make([]byte, 64<<10), 0o644))
+
+	backup := Backup{InstanceId: "mock-avs-default", Timestamp: time.Unix(1696420902, 0)}
+	require.NoError(t, dataDir.InitBackup(&backup))
+
+	// The disk fills up while archiving the instance
+	fs.free = 4 << 10
+	err = dataDir.AddBackupDir(backup.Id(), instancePath, "data")
+	require.ErrorIs(t, err, ErrDiskFull)
+	assert.ErrorIs(t, err, syscall.ENOSPC)
+
+	exists, err := dataDir.HasBackup(backup.Id())
+	require.NoError(t, err)
+	assert.False(t, exists, "partial backup left behind")
+	assert.NoFileExists(t, dataDir.BackupManifestPath(backup.Id()))
+
+	// Other errors are not reported as a full disk
+	assert.Nil(t, WrapDiskFull(nil))
+	assert.NotErrorIs(t, WrapDiskFull(os.ErrPermission), ErrDiskFull)
+}
+
+func TestDataDir_MaxBackupBytes(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock(), WithMaxBackupBytes(32<<10))
+	require.NoError(t, err)
+	instancePath := filepath.Join(dataDir.NodesPath(), "mock-avs-default")
+	require.NoError(t, fs.MkdirAll(filepath.Join(instancePath, "logs"), 0o755))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, ".env"), []byte("A=1\n"), 0o644))
+	stagingFiles := func() []string {
+		entries, err := afero.ReadDir(fs, dataDir.BackupDirPath())
+		require.NoError(t, err)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names
+	}
+
+	// Under the limit
+	backup := Backup{InstanceId: "mock-avs-default", Timestamp: time.Unix(1696420902, 0)}
+	require.NoError(t, dataDir.InitBackup(&backup))
+	require.NoError(t, dataDir.AddBackupDir(backup.Id(), instancePath, "data"))
+	require.NoError(t, dataDir.CommitBackup(&backup))
+	require.NoError(t, dataDir.RemoveBackup(backup.Id()))
+
+	// A runaway log exceeds the limit while archiving the instance
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "logs", "node.log"), make([]byte, 1<<20), 0o644))
+	require.NoError(t, dataDir.InitBackup(&backup))
+	err = dataDir.AddBackupDir(backup.Id(), instancePath, "data")
+	require.ErrorIs(t, err, ErrBackupTooLarge)
+	exists, err := dataDir.HasBackup(backup.Id())
+	require.NoError(t, err)
+	assert.False(t, exists, "partial backup left behind")
+	assert.Empty(t, stagingFiles())
+	err = dataDir.AddBackupFile(backup.Id(), filepath.Join(instancePath, ".env"), ".env")
+	assert.Error(t, err, "adding to an aborted backup")
+
+	// Archives written otherwise are checked on commit
+	require.NoError(t, dataDir.InitBackup(&backup))
+	require.NoError(t, afero.WriteFile(fs, dataDir.BackupWritePath(backup.Id()), make([]byte, 64<<10), 0o644))
+	err = dataDir.CommitBackup(&backup)
+	require.ErrorIs(t, err, ErrBackupTooLarge)
+	assert.Empty(t, stagingFiles())
+	assert.NoFileExists(t, dataDir.BackupPath(backup.Id()))
+}
+
+// cancelingFs cancels a context when the file named cancelName is opened.
+type cancelingFs struct {
+	afero.Fs
+	cancelName string
+	cancel     context.CancelFunc
+}
+
+func (fs *cancelingFs) Open(name string) (afero.File, error) {
+	if filepath.Base(name) == fs.cancelName {
+		fs.cancel()
+	}
+	return fs.Fs.Open(name)
+}
+
+func (fs *cancelingFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if filepath.Base(name) == fs.cancelName {
+		fs.cancel()
+	}
+	return fs.Fs.OpenFile(name, flag, perm)
+}
+
+func TestDataDir_BackupRestoreCanceled(t *testing.T) {
+	fs := &cancelingFs{Fs: afero.NewOsFs(), cancel: func() {}}
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	instancePath := filepath.Join(dataDir.NodesPath(), "mock-avs-default")
+	require.NoError(t, fs.MkdirAll(instancePath, 0o755))
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, name), []byte(name), 0o644))
+	}
+	backup := Backup{InstanceId: "mock-avs-default", Timestamp: time.Unix(1696420902, 0)}
+
+	// The backup is canceled while archiving the instance
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fs.cancelName, fs.cancel = "b.txt", cancel
+	require.NoError(t, dataDir.InitBackup(&backup))
+	err = dataDir.AddBackupDirContext(ctx, backup.Id(), instancePath, "data")
+	require.ErrorIs(t, err, context.Canceled)
+	exists, err := dataDir.HasBackup(backup.Id())
+	require.NoError(t, err)
+	assert.False(t, exists, "partial backup left behind")
+	assert.NoFileExists(t, dataDir.BackupWritePath(backup.Id()))
+	assert.NoFileExists(t, dataDir.BackupPath(backup.Id()))
+
+	// A complete backup to restore from
+	fs.cancelName = ""
+	require.NoError(t, dataDir.InitBackup(&backup))
+	require.NoError(t, dataDir.AddBackupDir(backup.Id(), instancePath, "data"))
+	require.NoError(t, dataDir.CommitBackup(&backup))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "b.txt"), []byte("changed"), 0o644))
+	require.NoError(t, fs.Remove(filepath.Join(instancePath, "c.txt")))
+
+	// The restore is canceled while extracting the instance
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	fs.cancelName, fs.cancel = "b.txt", cancel
+	err = dataDir.ReplaceInstanceDirFromTarContext(ctx, "mock-avs-default", dataDir.BackupPath(backup.Id()), "data")
+	require.ErrorIs(t, err, context.Canceled)
+	assert.NoFileExists(t, filepath.Join(instancePath, "c.txt"), "the instance directory must be left as it was")
+	content, err := afero.ReadFile(fs, filepath.Join(instancePath, "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "changed", string(content))
+	_, err = dataDir.TempPath("restore-mock-avs-default")
+	assert.ErrorAs(t, err, new(*TempNotFoundError), "partially restored directory left behind")
+	assert.NoDirExists(t, instancePath+deletingSuffix)
+
+	// Without cancelation the backup is restored
+	fs.cancelName = ""
+	require.NoError(t, dataDir.ReplaceInstanceDirFromTarContext(context.Background(), "mock-avs-default", dataDir.BackupPath(backup.Id()), "data"))
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		content, err := afero.ReadFile(fs, filepath.Join(instancePath, name))
+		require.NoError(t, err)
+		assert.Equal(t, name, string(content))
+	}
+}
+
+func TestDataDir_Close(t *testing.T) {
+	dataDir, err := NewDataDir("/data", afero.NewMemMapFs(), locker.NewFLock())
+	require.NoError(t, err)
+
+	// Close cancels the running operations and waits for them
+	ctx, done, err := dataDir.startOperation(context.Background())
+	require.NoError(t, err)
+	closed := make(chan error)
+	go func() {
+		closed <- dataDir.Close()
+	}()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("running operation not canceled")
+	}
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the running operation was done")
+	case <-time.After(50 * time.Millisecond):
+	}
+	done()
+	require.NoError(t, <-closed)
+
+	// The data dir is unusable after Close
+	_, err = dataDir.ListInstances()
+	assert.ErrorIs(t, err, ErrDataDirClosed)
+	_, err = dataDir.Instance("mock-avs-default")
+	assert.ErrorIs(t, err, ErrDataDirClosed)
+	_, err = dataDir.DiskUsage()
+	assert.ErrorIs(t, err, ErrDataDirClosed)
+	err = dataDir.BackupAll(io.Discard)
+	assert.ErrorIs(t, err, ErrDataDirClosed)
+	assert.NoError(t, dataDir.Close(), "closing twice")
+
+	backup := &Backup{InstanceId: "mock-avs-default", Timestamp: time.Now()}
+	calls := map[string]func() error{
+		"InitTemp": func() error {
+			_, err := dataDir.InitTemp("new")
+			return err
+		},
+		"PruneTempDirs": func() error {
+			_, err := dataDir.PruneTempDirs(0)
+			return err
+		},
+		"UpsertInstance": func() error {
+			_, err := dataDir.UpsertInstance(&Instance{Name: "mock-avs", Tag: "default"})
+			return err
+		},
+		"RepairInstance": func() error {
+			return dataDir.RepairInstance("mock-avs-default", Instance{})
+		},
+		"GC": dataDir.GC,
+		"InitBackup": func() error {
+			return dataDir.InitBackup(backup)
+		},
+		"AddBackupFile": func() error {
+			return dataDir.AddBackupFile(backup.Id(), "/data/file", "file")
+		},
+		"AddBackupDir": func() error {
+			return dataDir.AddBackupDir(backup.Id(), "/data/dir", "dir")
+		},
+		"WriteBackupManifest": func() error {
+			return dataDir.WriteBackupManifest(backup)
+		},
+		"CommitBackup": func() error {
+			return dataDir.CommitBackup(backup)
+		},
+		"RemoveBackup": func() error {
+			return dataDir.RemoveBackup(backup.Id())
+		},
+		"PruneBackupsWithOptions": func() error {
+			_, err := dataDir.PruneBackupsWithOptions(0, PruneOptions{})
+			return err
+		},
+		"ReplaceInstanceDirFromTar": func() error {
+			return dataDir.ReplaceInstanceDirFromTar("mock-avs-default", "/data/backup.tar", "data")
+		},
+		"SavePluginImageContextFrom": func() error {
+			return dataDir.SavePluginImageContextFrom("plugin", "image", io.NopCloser(strings.NewReader("context")))
+		},
+		"RemovePluginContext": func() error {
+			return dataDir.RemovePluginContext("plugin")
+		},
+		"MigrateLayout": func() error {
+			_, err := dataDir.MigrateLayout()
+			return err
+		},
+		"RepairInstanceIds": func() error {
+			_, err := dataDir.RepairInstanceIds()
+			return err
+		},
+		"CountInstances": func() error {
+			_, err := dataDir.CountInstances()
+			return err
+		},
+	}
+	for name, call := range calls {
+		assert.ErrorIs(t, call(), ErrDataDirClosed, name)
+	}
+}
+
+func TestDataDir_CloneInstance(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	instanceId, err := dataDir.InitInstance(&Instance{
+		Name:    "mock-avs",
+		Tag:     "default",
+		URL:     common.MockAvsPkg.Repo(),
+		Version: common.MockAvsPkg.Version(),
+		Profile: "option-returner",
+	})
+	require.NoError(t, err)
+	instancePath := filepath.Join(dataDir.NodesPath(), instanceId)
+	files := map[string]string{
+		".env":                      "KEY=value\n",
+		"docker-compose.yml":        "services: {}\n",
+		"logs/node.log":             "log line\n",
+		"data/chaindata/000001.ldb": "chain",
+		"data/keystore/key.json":    "{}",
+	}
+	for name, content := range files {
+		path := filepath.Join(instancePath, name)
+		require.NoError(t, fs.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, afero.WriteFile(fs, path, []byte(content), 0o644))
+	}
+	source, err := dataDir.Instance(instanceId)
+	require.NoError(t, err)
+	require.NoError(t, source.SetMaintenance(true))
+
+	_, err = dataDir.CloneInstance(instanceId, CloneOptions{Tag: "copy", Exclude: []string{"[bad"}})
+	require.ErrorIs(t, err, filepath.ErrBadPattern)
+
+	cloneId, err := dataDir.CloneInstance(instanceId, CloneOptions{
+		Tag:     "copy",
+		Exclude: []string{"logs/*", "data/chaindata"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "mock-avs-copy", cloneId)
+	clonePath := filepath.Join(dataDir.NodesPath(), cloneId)
+	for _, name := range []string{".env", "docker-compose.yml", "data/keystore/key.json"} {
+		content, err := afero.ReadFile(fs, filepath.Join(clonePath, name))
+		require.NoError(t, err)
+		assert.Equal(t, files[name], string(content))
+	}
+	assert.NoFileExists(t, filepath.Join(clonePath, "logs", "node.log"))
+	assert.DirExists(t, filepath.Join(clonePath, "logs"))
+	assert.NoDirExists(t, filepath.Join(clonePath, "data", "chaindata"))
+	assert.FileExists(t, filepath.Join(clonePath, ".lock"))
+
+	clone, err := dataDir.Instance(cloneId)
+	require.NoError(t, err)
+	assert.Equal(t, "copy", clone.Tag)
+	assert.Equal(t, source.URL, clone.URL)
+	assert.False(t, clone.Maintenance)
+
+	// The source is untouched
+	_, err = afero.ReadFile(fs, filepath.Join(instancePath, "logs", "node.log"))
+	assert.NoError(t, err)
+
+	_, err = dataDir.CloneInstance(instanceId, CloneOptions{Tag: "copy"})
+	assert.ErrorIs(t, err, ErrInstanceAlreadyExists)
+}
+
+func TestDataDir_Check(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDirPath := t.TempDir()
+	dataDir, err := NewDataDir(dataDirPath, fs, locker.NewFLock())
+	require.NoError(t, err)
+
+	// A healthy data dir has no problems
+	report, err := dataDir.Check()
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+
+	writeFile := func(path string, data []byte) string {
+		fullPath := filepath.Join(dataDirPath, path)
+		require.NoError(t, fs.MkdirAll(filepath.Dir(fullPath), 0o755))
+		require.NoError(t, afero.WriteFile(fs, fullPath, data, 0o644))
+		return fullPath
+	}
+	state := []byte(`{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","profile":"option-returner","tag":"default"}`)
+
+	// Instances: one valid, one with an invalid state, one without state, and
+	// a leftover of a failed removal
+	writeFile(filepath.Join(nodesDirName, "mock-avs-default", "state.json"), state)
+	writeFile(filepath.Join(nodesDirName, "mock-avs-invalid", "state.json"), []byte(`{"name":"mock-avs"`))
+	require.NoError(t, fs.MkdirAll(filepath.Join(dataDirPath, nodesDirName, "mock-avs-empty"), 0o755))
+	require.NoError(t, fs.MkdirAll(filepath.Join(dataDirPath, nodesDirName, "mock-avs-old"+deletingSuffix), 0o755))
+
+	// Plugin contexts: one of an installed instance and an orphaned one
+	writeFile(filepath.Join(pluginsDir, "mock-avs-default.tar"), []byte("context"))
+	orphanedContext := writeFile(filepath.Join(pluginsDir, "mock-avs-removed.tar"), []byte("context"))
+
+	// Temp dirs: a fresh one and a stale one
+	require.NoError(t, fs.MkdirAll(filepath.Join(dataDirPath, tempDir, "fresh"), 0o755))
+	staleTemp := filepath.Join(dataDirPath, tempDir, "stale")
+	require.NoError(t, fs.MkdirAll(staleTemp, 0o755))
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, fs.Chtimes(staleTemp, old, old))
+
+	// Backups: a valid one, an unreadable one and one not matching its manifest
+	newBackupTar := func(name string) string {
+		var buf bytes.Buffer
+		tarWriter := tar.NewWriter(&buf)
+		tarAddStateJson(t, tarWriter, state)
+		tarAddTimestamp(t, tarWriter, time.Unix(1696420902, 0))
+		require.NoError(t, tarWriter.Close())
+		return writeFile(filepath.Join(backupDir, name+".tar"), buf.Bytes())
+	}
+	newBackupTar("valid")
+	corruptTar := writeFile(filepath.Join(backupDir, "corrupt.tar"), []byte("not a tar"))
+	tamperedTar := newBackupTar("tampered")
+	manifest, err := json.Marshal(BackupManifest{InstanceId: "mock-avs-default", State: state, Checksum: "0000"})
+	require.NoError(t, err)
+	writeFile(filepath.Join(backupDir, "tampered.json"), manifest)
+
+	// Monitoring stack without its files
+	require.NoError(t, fs.MkdirAll(dataDir.MonitoringPath(), 0o755))
+
+	report, err = dataDir.Check()
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+
+	paths := func(kind CheckKind) []string {
+		var paths []string
+		for _, p := range report.ByKind(kind) {
+			paths = append(paths, p.Path)
+		}
+		return paths
+	}
+	nodesPath := filepath.Join(dataDirPath, nodesDirName)
+	assert.ElementsMatch(t, []string{filepath.Join(nodesPath, "mock-avs-empty"), filepath.Join(nodesPath, "mock-avs-invalid")}, paths(CheckInvalidInstance))
+	assert.Equal(t, []string{filepath.Join(nodesPath, "mock-avs-old"+deletingSuffix)}, paths(CheckPendingRemoval))
+	assert.Equal(t, []string{orphanedContext}, paths(CheckOrphanedPluginContext))
+	assert.Equal(t, []string{staleTemp}, paths(CheckStaleTemp))
+	assert.ElementsMatch(t, []string{corruptTar, tamperedTar}, paths(CheckCorruptBackup))
+	assert.ElementsMatch(t, []string{
+		filepath.Join(dataDir.MonitoringPath(), ".env"),
+		filepath.Join(dataDir.MonitoringPath(), "docker-compose.yml"),
+	}, paths(CheckMissingMonitoringConfig))
+	assert.Len(t, report.Problems, 9)
+	for _, p := range report.ByKind(CheckInvalidInstance) {
+		assert.NotEmpty(t, p.Detail)
+	}
+
+	// Nothing was modified
+	exists, err := afero.DirExists(fs, staleTemp)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = afero.Exists(fs, orphanedContext)
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+// newInstanceBackupStream returns an instance backup archive with the given
+// files of the instance directory.
+func newInstanceBackupStream(t *testing.T, files map[string]string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "data/", Typeflag: tar.TypeDir, Mode: 0o755}))
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	tarAddTimestamp(t, tw, time.Unix(1696420902, 0))
+	require.NoError(t, tw.Close())
+	return &buf
+}
+
+func TestDataDir_RestoreInstanceFrom(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDirPath := t.TempDir()
+	dataDir, err := NewDataDir(dataDirPath, fs, locker.NewFLock())
+	require.NoError(t, err)
+	state := `{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","profile":"option-returner","tag":"default"}`
+	instancePath := filepath.Join(dataDirPath, nodesDirName, "mock-avs-default")
+
+	// Restore into an empty data dir
+	backup := newInstanceBackupStream(t, map[string]string{
+		"data/state.json":          state,
+		"data/.env":                "MAIN_PORT=8080\n",
+		"data/config/config.yml":   "key: value\n",
+		"volumes/main/data/db.ldb": "volume data",
+	})
+	require.NoError(t, dataDir.RestoreInstanceFrom(backup, "mock-avs-default", false))
+	instance, err := dataDir.Instance("mock-avs-default")
+	require.NoError(t, err)
+	assert.Equal(t, "v5.5.1", instance.Version)
+	content, err := afero.ReadFile(fs, filepath.Join(instancePath, "config", "config.yml"))
+	require.NoError(t, err)
+	assert.Equal(t, "key: value\n", string(content))
+	// Only the instance directory is restored, and the staging dir is removed
+	exists, err := afero.Exists(fs, filepath.Join(instancePath, "volumes"))
+	require.NoError(t, err)
+	assert.False(t, exists)
+	tempEntries, err := afero.ReadDir(fs, filepath.Join(dataDirPath, tempDir))
+	require.NoError(t, err)
+	assert.Empty(t, tempEntries)
+
+	// Existing instances are only replaced with force
+	backup = newInstanceBackupStream(t, map[string]string{
+		"data/state.json": state,
+		"data/.env":       "MAIN_PORT=9090\n",
+	})
+	err = dataDir.RestoreInstanceFrom(bytes.NewReader(backup.Bytes()), "mock-avs-default", false)
+	require.ErrorIs(t, err, ErrInstanceAlreadyExists)
+	require.NoError(t, dataDir.RestoreInstanceFrom(bytes.NewReader(backup.Bytes()), "mock-avs-default", true))
+	content, err = afero.ReadFile(fs, filepath.Join(instancePath, ".env"))
+	require.NoError(t, err)
+	assert.Equal(t, "MAIN_PORT=9090\n", string(content))
+	exists, err = afero.Exists(fs, filepath.Join(instancePath, "config"))
+	require.NoError(t, err)
+	assert.False(t, exists, "files of the replaced instance left behind")
+
+	// The instance is only replaced once its writer is done
+	writerLock := locker.NewFLock().New(filepath.Join(instancePath, ".lock"))
+	require.NoError(t, writerLock.Lock())
+	restored := make(chan error)
+	go func() {
+		restored <- dataDir.RestoreInstanceFrom(bytes.NewReader(backup.Bytes()), "mock-avs-default", true)
+	}()
+	select {
+	case err := <-restored:
+		t.Fatalf("instance replaced while locked by its writer: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.NoError(t, writerLock.Unlock())
+	require.NoError(t, <-restored)
+
+	// Failed restores leave the instance as it was
+	tests := []struct {
+		name       string
+		instanceId string
+		files      map[string]string
+		wantErr    error
+	}{
+		{
+			name:       "zip slip",
+			instanceId: "mock-avs-default",
+			files:      map[string]string{"data/state.json": state, "data/../../evil": "evil"},
+			wantErr:    ErrInvalidBackupArchive,
+		},
+		{
+			name:       "missing state",
+			instanceId: "mock-avs-default",
+			files:      map[string]string{"data/.env": "MAIN_PORT=1\n"},
+			wantErr:    ErrInvalidInstanceDir,
+		},
+		{
+			name:       "invalid state",
+			instanceId: "mock-avs-default",
+			files:      map[string]string{"data/state.json": `{"name":"mock-avs","tag":"default"}`},
+			wantErr:    ErrInvalidInstance,
+		},
+		{
+			name:       "state of another instance",
+			instanceId: "mock-avs-other",
+			files:      map[string]string{"data/state.json": state},
+			wantErr:    ErrInvalidInstance,
+		},
+		{
+			name:       "target outside the nodes directory",
+			instanceId: "../mock-avs-default",
+			files:      map[string]string{"data/state.json": state},
+			wantErr:    ErrInvalidInstanceDir,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dataDir.RestoreInstanceFrom(newInstanceBackupStream(t, tt.files), tt.instanceId, true)
+			require.ErrorIs(t, err, tt.wantErr)
+			content, err := afero.ReadFile(fs, filepath.Join(instancePath, ".env"))
+			require.NoError(t, err)
+			assert.Equal(t, "MAIN_PORT=9090\n", string(content))
+			exists, err := afero.Exists(fs, filepath.Join(dataDirPath, "evil"))
+			require.NoError(t, err)
+			assert.False(t, exists)
+			exists, err = afero.DirExists(fs, filepath.Join(dataDirPath, nodesDirName, "mock-avs-other"))
+			require.NoError(t, err)
+			assert.False(t, exists)
+		})
+	}
+}
+
+func TestDataDir_RestoreInstanceFromAs(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDirPath := t.TempDir()
+	dataDir, err := NewDataDir(dataDirPath, fs, locker.NewFLock())
+	require.NoError(t, err)
+	state := `{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","profile":"option-returner","tag":"default","labels":{"env":"prod"}}`
+	backup := newInstanceBackupStream(t, map[string]string{
+		"data/state.json": state,
+		"data/.lock":      "stale",
+		"data/.env":       "MAIN_PORT=8080\n",
+	})
+
+	// Restore under a new tag
+	instanceId, err := dataDir.RestoreInstanceFromAs(bytes.NewReader(backup.Bytes()), "mock-avs", "restored", false)
+	require.NoError(t, err)
+	assert.Equal(t, "mock-avs-restored", instanceId)
+	instance, err := dataDir.Instance(instanceId)
+	require.NoError(t, err)
+	assert.Equal(t, "mock-avs", instance.Name)
+	assert.Equal(t, "restored", instance.Tag)
+	assert.Equal(t, "v5.5.1", instance.Version)
+	assert.False(t, dataDir.HasInstance("mock-avs-default"))
+	instancePath := filepath.Join(dataDirPath, nodesDirName, instanceId)
+	content, err := afero.ReadFile(fs, filepath.Join(instancePath, ".lock"))
+	require.NoError(t, err)
+	assert.Empty(t, content, "lock file not regenerated")
+	content, err = afero.ReadFile(fs, filepath.Join(instancePath, ".env"))
+	require.NoError(t, err)
+	assert.Equal(t, "MAIN_PORT=8080\n", string(content))
+	ids, err := dataDir.FindInstancesByLabel("env", "prod")
+	require.NoError(t, err)
+	assert.Equal(t, []string{instanceId}, ids)
+
+	// Restore under a new name
+	instanceId, err = dataDir.RestoreInstanceFromAs(bytes.NewReader(backup.Bytes()), "other-avs", "default", false)
+	require.NoError(t, err)
+	assert.Equal(t, "other-avs-default", instanceId)
+	instance, err = dataDir.Instance(instanceId)
+	require.NoError(t, err)
+	assert.Equal(t, "other-avs", instance.Name)
+
+	// Existing targets are only replaced with force
+	_, err = dataDir.RestoreInstanceFromAs(bytes.NewReader(backup.Bytes()), "mock-avs", "restored", false)
+	require.ErrorIs(t, err, ErrInstanceAlreadyExists)
+	_, err = dataDir.RestoreInstanceFromAs(bytes.NewReader(backup.Bytes()), "mock-avs", "restored", true)
+	require.NoError(t, err)
+
+	// Invalid targets are rejected
+	_, err = dataDir.RestoreInstanceFromAs(bytes.NewReader(backup.Bytes()), "mock-avs", "../evil", true)
+	require.ErrorIs(t, err, ErrInvalidInstance)
+}
+
+func TestDataDir_UpgradeInstance(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDirPath := t.TempDir()
+	dataDir, err := NewDataDir(dataDirPath, fs, locker.NewFLock())
+	require.NoError(t, err)
+	_, err = dataDir.InitInstance(&Instance{
+		Name:    "mock-avs",
+		Tag:     "default",
+		URL:     common.MockAvsPkg.Repo(),
+		Version: "v5.4.0",
+		Profile: "option-returner",
+	})
+	require.NoError(t, err)
+	instancePath := filepath.Join(dataDirPath, nodesDirName, "mock-avs-default")
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, ".env"), []byte("MAIN_PORT=8080\n"), 0o644))
+
+	// checkUnchanged checks the instance was left as it was, and the working
+	// copy removed
+	checkUnchanged := func(t *testing.T) {
+		instance, err := dataDir.Instance("mock-avs-default")
+		require.NoError(t, err)
+		assert.Equal(t, "v5.4.0", instance.Version)
+		content, err := afero.ReadFile(fs, filepath.Join(instancePath, ".env"))
+		require.NoError(t, err)
+		assert.Equal(t, "MAIN_PORT=8080\n", string(content))
+		exists, err := afero.Exists(fs, filepath.Join(instancePath, "migrated"))
+		require.NoError(t, err)
+		assert.False(t, exists)
+		tempEntries, err := afero.ReadDir(fs, filepath.Join(dataDirPath, tempDir))
+		require.NoError(t, err)
+		assert.Empty(t, tempEntries)
+	}
+
+	// Failed upgrades leave the instance unchanged
+	tests := []struct {
+		name    string
+		build   func(workDir string) error
+		wantErr error
+	}{
+		{
+			name: "build error",
+			build: func(workDir string) error {
+				if err := afero.WriteFile(fs, filepath.Join(workDir, "migrated"), nil, 0o644); err != nil {
+					return err
+				}
+				if err := afero.WriteFile(fs, filepath.Join(workDir, ".env"), []byte("MAIN_PORT=9090\n"), 0o644); err != nil {
+					return err
+				}
+				return ErrInvalidInstance
+			},
+			wantErr: ErrInvalidInstance,
+		},
+		{
+			name: "invalid state",
+			build: func(workDir string) error {
+				return afero.WriteFile(fs, filepath.Join(workDir, stateFileName), []byte(`{"name":"mock-avs","tag":"default"}`), 0o644)
+			},
+			wantErr: ErrInvalidInstance,
+		},
+		{
+			name: "state of another instance",
+			build: func(workDir string) error {
+				state := `{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","profile":"option-returner","tag":"other"}`
+				return afero.WriteFile(fs, filepath.Join(workDir, stateFileName), []byte(state), 0o644)
+			},
+			wantErr: ErrInvalidInstance,
+		},
+		{
+			name: "state only valid as stored",
+			build: func(workDir string) error {
+				state := `{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"latest","profile":"option-returner","tag":"default"}`
+				return afero.WriteFile(fs, filepath.Join(workDir, stateFileName), []byte(state), 0o644)
+			},
+			wantErr: ErrInvalidInstance,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dataDir.UpgradeInstance("mock-avs-default", tt.build)
+			require.ErrorIs(t, err, tt.wantErr)
+			checkUnchanged(t)
+		})
+	}
+
+	// The upgraded working copy is swapped in
+	err = dataDir.UpgradeInstance("mock-avs-default", func(workDir string) error {
+		upgraded, err := newInstance(workDir, fs, locker.NewFLock())
+		if err != nil {
+			return err
+		}
+		upgraded.Version = "v5.5.1"
+		if err := upgraded.saveState(); err != nil {
+			return err
+		}
+		return afero.WriteFile(fs, filepath.Join(workDir, "migrated"), nil, 0o644)
+	})
+	require.NoError(t, err)
+	instance, err := dataDir.Instance("mock-avs-default")
+	require.NoError(t, err)
+	assert.Equal(t, "v5.5.1", instance.Version)
+	for _, name := range []string{".env", ".lock", "migrated"} {
+		exists, err := afero.Exists(fs, filepath.Join(instancePath, name))
+		require.NoError(t, err)
+		assert.True(t, exists, name)
+	}
+	exists, err := afero.Exists(fs, instancePath+deletingSuffix)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// The swap waits for a consistent listing holding the data dir lock
+	listLock := locker.NewFLock().New(filepath.Join(dataDirPath, dataDirLockName))
+	require.NoError(t, listLock.Lock())
+	upgraded := make(chan error)
+	go func() {
+		upgraded <- dataDir.UpgradeInstance("mock-avs-default", func(string) error { return nil })
+	}()
+	select {
+	case err := <-upgraded:
+		t.Fatalf("upgrade swapped the instance while the data dir was locked: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.NoError(t, listLock.Unlock())
+	require.NoError(t, <-upgraded)
+
+	err = dataDir.UpgradeInstance("mock-avs-missing", func(string) error { return nil })
+	assert.ErrorIs(t, err, ErrInstanceNotFound)
+}
+
+func TestDataDir_Dependents(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDirPath := t.TempDir()
+	dataDir, err := NewDataDir(dataDirPath, fs, locker.NewFLock())
+	require.NoError(t, err)
+
+	newInstance := func(tag string, dependsOn ...string) *Instance {
+		return &Instance{
+			Name:      "mock-avs",
+			Tag:       tag,
+			URL:       common.MockAvsPkg.Repo(),
+			Version:   common.MockAvsPkg.Version(),
+			Profile:   "option-returner",
+			DependsOn: dependsOn,
+		}
+	}
+	_, err = dataDir.InitInstance(newInstance("primary"))
+	require.NoError(t, err)
+	_, err = dataDir.InitInstance(newInstance("sidecar", "mock-avs-primary"))
+	require.NoError(t, err)
+	_, err = dataDir.InitInstance(newInstance("metrics", "mock-avs-primary", "mock-avs-sidecar"))
+	require.NoError(t, err)
+	// Dependencies must be other valid instance ids
+	_, err = dataDir.InitInstance(newInstance("self", "mock-avs-self"))
+	assert.ErrorIs(t, err, ErrInvalidInstance)
+	_, err = dataDir.InitInstance(newInstance("escape", "../mock-avs-primary"))
+	assert.ErrorIs(t, err, ErrInvalidInstance)
+	// An unreadable instance doesn't break the query
+	brokenPath := filepath.Join(dataDirPath, nodesDirName, "mock-avs-broken")
+	require.NoError(t, fs.MkdirAll(brokenPath, 0o755))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(brokenPath, "state.json"), []byte("{"), 0o644))
+
+	dependents, err := dataDir.Dependents("mock-avs-primary")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mock-avs-metrics", "mock-avs-sidecar"}, dependents)
+	dependents, err = dataDir.Dependents("mock-avs-sidecar")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mock-avs-metrics"}, dependents)
+	dependents, err = dataDir.Dependents("mock-avs-metrics")
+	require.NoError(t, err)
+	assert.Empty(t, dependents)
+
+	// Instances with dependents are only removed with force
+	err = dataDir.RemoveInstance("mock-avs-sidecar", false)
+	require.ErrorIs(t, err, ErrInstanceHasDependents)
+	assert.ErrorContains(t, err, "mock-avs-metrics")
+	assert.True(t, dataDir.HasInstance("mock-avs-sidecar"))
+	require.NoError(t, dataDir.RemoveInstance("mock-avs-metrics", false))
+	require.NoError(t, dataDir.RemoveInstance("mock-avs-sidecar", false))
+	_, err = dataDir.InitInstance(newInstance("sidecar", "mock-avs-primary"))
+	require.NoError(t, err)
+	require.NoError(t, dataDir.RemoveInstance("mock-avs-primary", true))
+	assert.False(t, dataDir.HasInstance("mock-avs-primary"))
+}
+
+func TestDataDir_ReadOnly(t *testing.T) {
+	fs := afero.NewOsFs()
+
+	t.Run("nothing created", func(t *testing.T) {
+		dataDirPath := filepath.Join(t.TempDir(), "data")
+		dataDir, err := NewDataDir(dataDirPath, fs, locker.NewFLock(), WithReadOnly())
+		require.NoError(t, err)
+		assert.True(t, dataDir.ReadOnly())
+		exists, err := afero.Exists(fs, dataDirPath)
+		require.NoError(t, err)
+		assert.False(t, exists, "read-only data dir created")
+
+		// Reads of the missing data dir are empty
+		instances, err := dataDir.ListInstances()
+		require.NoError(t, err)
+		assert.Empty(t, instances)
+		backups, err := dataDir.BackupList()
+		require.NoError(t, err)
+		assert.Empty(t, backups)
+		_, err = dataDir.MonitoringStack()
+		assert.ErrorIs(t, err, ErrReadOnly)
+		exists, err = afero.Exists(fs, dataDirPath)
+		require.NoError(t, err)
+		assert.False(t, exists, "read-only data dir created")
+	})
+
+	t.Run("writes blocked", func(t *testing.T) {
+		dataDirPath := t.TempDir()
+		writable, err := NewDataDir(dataDirPath, fs, locker.NewFLock())
+		require.NoError(t, err)
+		instance := &Instance{
+			Name:    "mock-avs",
+			Tag:     "default",
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Profile: "option-returner",
+		}
+		instanceId, err := writable.InitInstance(instance)
+		require.NoError(t, err)
+		_, err = writable.InitTemp("existing")
+		require.NoError(t, err)
+
+		dataDir, err := NewDataDir(dataDirPath, fs, locker.NewFLock(), WithReadOnly())
+		require.NoError(t, err)
+		newInstance := *instance
+		newInstance.Tag = "other"
+		mutations := map[string]func() error{
+			"InitInstance": func() error {
+				_, err := dataDir.InitInstance(&newInstance)
+				return err
+			},
+			"InitInstances": func() error {
+				_, err := dataDir.InitInstances([]*Instance{&newInstance})
+				return err
+			},
+			"UpsertInstance": func() error {
+				_, err := dataDir.UpsertInstance(&newInstance)
+				return err
+			},
+			"RepairInstance": func() error {
+				return dataDir.RepairInstance(instanceId, Instance{})
+			},
+			"RemoveInstance": func() error {
+				return dataDir.RemoveInstance(instanceId, true)
+			},
+			"RemoveInstances": func() error {
+				_, err := dataDir.RemoveInstances(ListFilter{}, false)
+				return err
+			},
+			"CloneInstance": func() error {
+				_, err := dataDir.CloneInstance(instanceId, CloneOptions{Tag: "clone"})
+				return err
+			},
+			"GC": dataDir.GC,
+			"InitTemp": func() error {
+				_, err := dataDir.InitTemp("new")
+				return err
+			},
+			"RemoveTemp": func() error {
+				return dataDir.RemoveTemp("existing")
+			},
+			"PruneTempDirs": func() error {
+				_, err := dataDir.PruneTempDirs(0)
+				return err
+			},
+			"InitBackup": func() error {
+				return dataDir.InitBackup(&Backup{InstanceId: instanceId, Timestamp: time.Now()})
+			},
+			"InitTimestampedBackup": func() error {
+				_, err := dataDir.InitTimestampedBackup(instanceId)
+				return err
+			},
+			"CommitBackup": func() error {
+				return dataDir.CommitBackup(&Backup{InstanceId: instanceId, Timestamp: time.Now()})
+			},
+			"PruneBackups": func() error {
+				_, err := dataDir.PruneBackups(0)
+				return err
+			},
+			"RemoveBackup": func() error {
+				return dataDir.RemoveBackup("backup")
+			},
+			"MonitoringStack": func() error {
+				_, err := dataDir.MonitoringStack()
+				return err
+			},
+			"RemoveMonitoringStack": dataDir.RemoveMonitoringStack,
+			"SavePluginImageContext": func() error {
+				return dataDir.SavePluginImageContext("plugin", io.NopCloser(strings.NewReader("context")))
+			},
+			"RemovePluginContext": func() error {
+				return dataDir.RemovePluginContext("plugin")
+			},
+			"MigrateLayout": func() error {
+				_, err := dataDir.MigrateLayout()
+				return err
+			},
+			"RepairInstanceIds": func() error {
+				_, err := dataDir.RepairInstanceIds()
+				return err
+			},
+			"RestoreAll": func() error {
+				return dataDir.RestoreAll(strings.NewReader(""))
+			},
+			"RestoreInstanceFrom": func() error {
+				return dataDir.RestoreInstanceFrom(strings.NewReader(""), instanceId, true)
+			},
+			"RestoreInstanceFromAs": func() error {
+				_, err := dataDir.RestoreInstanceFromAs(strings.NewReader(""), "mock-avs", "restored", true)
+				return err
+			},
+			"UpgradeInstance": func() error {
+				return dataDir.UpgradeInstance(instanceId, func(string) error { return nil })
+			},
+		}
+		for name, mutation := range mutations {
+			assert.ErrorIs(t, mutation(), ErrReadOnly, name)
+		}
+
+		// Nothing changed, and reads still work
+		assert.True(t, dataDir.HasInstance(instanceId))
+		assert.False(t, dataDir.HasInstance(InstanceId(newInstance.Name, newInstance.Tag)))
+		got, err := dataDir.Instance(instanceId)
+		require.NoError(t, err)
+		assert.Equal(t, instance.Version, got.Version)
+		instances, err := dataDir.ListInstances()
+		require.NoError(t, err)
+		assert.Len(t, instances, 1)
+		removed, err := dataDir.RemoveInstances(ListFilter{}, true)
+		require.NoError(t, err)
+		assert.Equal(t, []string{instanceId}, removed)
+		tempPath, err := dataDir.TempPath("existing")
+		require.NoError(t, err)
+		assert.DirExists(t, tempPath)
+		backups, err := dataDir.BackupList()
+		require.NoError(t, err)
+		assert.Empty(t, backups)
+		assert.NoDirExists(t, filepath.Join(dataDirPath, backupDir))
+		assert.NoDirExists(t, filepath.Join(dataDirPath, monitoringStackDirName))
+		report, err := dataDir.Check()
+		require.NoError(t, err)
+		assert.True(t, report.OK())
+	})
+}
+
+func TestDataDir_RLockInstance(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	instanceId, err := dataDir.InitInstance(&Instance{
+		Name:    "mock-avs",
+		Tag:     "default",
+		URL:     common.MockAvsPkg.Repo(),
+		Version: common.MockAvsPkg.Version(),
+		Profile: "option-returner",
+	})
+	require.NoError(t, err)
+	instance, err := dataDir.Instance(instanceId)
+	require.NoError(t, err)
+
+	// A backup holds a shared lock
+	backupLock, err := dataDir.RLockInstance(context.Background(), instanceId)
+	require.NoError(t, err)
+
+	// Readers proceed concurrently
+	instances, err := dataDir.ListInstances()
+	require.NoError(t, err)
+	assert.Len(t, instances, 1)
+	otherLock, err := dataDir.RLockInstance(context.Background(), instanceId)
+	require.NoError(t, err)
+	require.NoError(t, otherLock.Unlock())
+
+	// Updates wait for the backup to finish
+	updated := make(chan error, 1)
+	go func() {
+		updated <- instance.SetLabel("env", "prod")
+	}()
+	select {
+	case err := <-updated:
+		t.Fatalf("update not blocked by the backup: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	require.NoError(t, backupLock.Unlock())
+	select {
+	case err := <-updated:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("update still blocked after the backup")
+	}
+
+	// Backups wait for the updates to finish, until the context is done
+	writeLock := locker.NewFLock().New(filepath.Join(dataDir.NodesPath(), instanceId, ".lock"))
+	require.NoError(t, writeLock.Lock())
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = dataDir.RLockInstance(ctx, instanceId)
+	assert.ErrorIs(t, err, ErrInstanceLockTimeout)
+	require.NoError(t, writeLock.Unlock())
+	stored, err := dataDir.Instance(instanceId)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod"}, stored.Labels)
+}
+
+func TestDataDir_Watch(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	newInstance := func(tag string) *Instance {
+		return &Instance{
+			Name:    "mock-avs",
+			Tag:     tag,
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Profile: "option-returner",
+		}
+	}
+	_, err = dataDir.InitInstance(newInstance("existing"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := dataDir.Watch(ctx)
+	require.NoError(t, err)
+	nextEvent := func() InstanceEvent {
+		t.Helper()
+		select {
+		case event, ok := <-events:
+			require.True(t, ok, "events channel closed")
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event received")
+			return InstanceEvent{}
+		}
+	}
+
+	// The many writes of an install give a single event
+	_, err = dataDir.InitInstance(newInstance("default"))
+	require.NoError(t, err)
+	assert.Equal(t, InstanceEvent{Type: InstanceAdded, InstanceId: "mock-avs-default"}, nextEvent())
+
+	instance, err := dataDir.Instance("mock-avs-existing")
+	require.NoError(t, err)
+	require.NoError(t, instance.SetLabel("env", "prod"))
+	assert.Equal(t, InstanceEvent{Type: InstanceModified, InstanceId: "mock-avs-existing"}, nextEvent())
+
+	// Reading the instances takes their locks, without changing them
+	_, err = dataDir.ListInstances()
+	require.NoError(t, err)
+	require.NoError(t, dataDir.RemoveInstance("mock-avs-default", false))
+	assert.Equal(t, InstanceEvent{Type: InstanceRemoved, InstanceId: "mock-avs-default"}, nextEvent())
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %v", event)
+	case <-time.After(3 * watchDebounce):
+	}
+
+	// The channel is closed once the context is done
+	cancel()
+	select {
+	case event, ok := <-events:
+		assert.False(t, ok, "unexpected event %v", event)
+	case <-time.After(5 * time.Second):
+		t.Fatal("events channel not closed")
+	}
+
+	memDataDir, err := NewDataDir("/", afero.NewMemMapFs(), locker.NewFLock())
+	require.NoError(t, err)
+	_, err = memDataDir.Watch(context.Background())
+	assert.ErrorIs(t, err, ErrWatchUnsupported)
+}
+
+func TestDataDir_WatchStart(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	newInstance := func(tag string) *Instance {
+		return &Instance{
+			Name:    "mock-avs",
+			Tag:     tag,
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Profile: "option-returner",
+		}
+	}
+	_, err = dataDir.InitInstance(newInstance("existing"))
+	require.NoError(t, err)
+	_, err = dataDir.InitInstance(newInstance("removed"))
+	require.NoError(t, err)
+
+	// Instances added and removed after the initial listing, before the
+	// nodes directory is watched
+	known, err := dataDir.watchedInstanceDirs()
+	require.NoError(t, err)
+	_, err = dataDir.InitInstance(newInstance("added"))
+	require.NoError(t, err)
+	require.NoError(t, dataDir.RemoveInstance("mock-avs-removed", false))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := dataDir.watchFrom(ctx, known)
+	require.NoError(t, err)
+	var got []InstanceEvent
+	for len(got) < 2 {
+		select {
+		case event := <-events:
+			got = append(got, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("missing events, got %v", got)
+		}
+	}
+	assert.Equal(t, []InstanceEvent{
+		{Type: InstanceAdded, InstanceId: "mock-avs-added"},
+		{Type: InstanceRemoved, InstanceId: "mock-avs-removed"},
+	}, got)
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %v", event)
+	case <-time.After(3 * watchDebounce):
+	}
+}
+
+func TestDataDir_MetricsText(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	for tag, profile := range map[string]string{"first": "option-returner", "second": "option-returner", "third": "health-checker"} {
+		_, err = dataDir.InitInstance(&Instance{
+			Name:    "mock-avs",
+			Tag:     tag,
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Profile: profile,
+		})
+		require.NoError(t, err)
+	}
+	for i := 0; i < 2; i++ {
+		b := Backup{InstanceId: "mock-avs-first", Timestamp: time.Unix(1696420902+int64(i), 0)}
+		require.NoError(t, dataDir.InitBackup(&b))
+		backupTarFile, err := fs.OpenFile(dataDir.BackupWritePath(b.Id()), os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		tarWriter := tar.NewWriter(backupTarFile)
+		tarAddStateJson(t, tarWriter, []byte(`{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","profile":"option-returner","tag":"first"}`))
+		tarAddTimestamp(t, tarWriter, b.Timestamp)
+		require.NoError(t, tarWriter.Close())
+		require.NoError(t, backupTarFile.Close())
+		require.NoError(t, dataDir.CommitBackup(&b))
+	}
+
+	text, err := dataDir.MetricsText()
+	require.NoError(t, err)
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(text))
+	require.NoError(t, err)
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	assert.ElementsMatch(t, []string{"eigen_instances_total", "eigen_backups_total", "eigen_backups_bytes", "eigen_datadir_bytes"}, names)
+
+	instances := make(map[string]float64)
+	for _, metric := range families["eigen_instances_total"].GetMetric() {
+		require.Len(t, metric.GetLabel(), 1)
+		assert.Equal(t, "profile", metric.GetLabel()[0].GetName())
+		instances[metric.GetLabel()[0].GetValue()] = metric.GetGauge().GetValue()
+	}
+	assert.Equal(t, map[string]float64{"option-returner": 2, "health-checker": 1}, instances)
+	backups := families["eigen_backups_total"].GetMetric()
+	require.Len(t, backups, 1)
+	assert.Equal(t, "mock-avs-first", backups[0].GetLabel()[0].GetValue())
+	assert.Equal(t, float64(2), backups[0].GetGauge().GetValue())
+	assert.Positive(t, families["eigen_backups_bytes"].GetMetric()[0].GetGauge().GetValue())
+	usage, err := dataDir.DiskUsage()
+	require.NoError(t, err)
+	assert.Equal(t, float64(usage), families["eigen_datadir_bytes"].GetMetric()[0].GetGauge().GetValue())
+}
+
+func TestDataDir_Lockless(t *testing.T) {
+	memFs := afero.NewMemMapFs()
+	writable, err := NewDataDir("/data", memFs, locker.NewNoLock())
+	require.NoError(t, err)
+	instanceId, err := writable.InitInstance(&Instance{
+		Name:    "mock-avs",
+		Tag:     "default",
+		URL:     common.MockAvsPkg.Repo(),
+		Version: common.MockAvsPkg.Version(),
+		Profile: "option-returner",
+	})
+	require.NoError(t, err)
+	exists, err := afero.Exists(memFs, filepath.Join("/data", nodesDirName, instanceId, ".lock"))
+	require.NoError(t, err)
+	assert.False(t, exists, "lock file created")
+
+	// The snapshot on read-only media is readable without locks
+	dataDir, err := NewDataDir("/data", afero.NewReadOnlyFs(memFs), locker.NewNoLock(), WithReadOnly())
+	require.NoError(t, err)
+	instances, err := dataDir.ListInstances()
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	assert.Equal(t, instanceId, instances[0].ID())
+	instances, err = dataDir.ListInstancesConsistent(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, instances, 1)
+	instance, err := dataDir.Instance(instanceId)
+	require.NoError(t, err)
+	assert.Equal(t, "option-returner", instance.Profile)
+	_, err = dataDir.RawInstanceState(instanceId)
+	require.NoError(t, err)
+	dependents, err := dataDir.Dependents(instanceId)
+	require.NoError(t, err)
+	assert.Empty(t, dependents)
+	busy, err := dataDir.InstanceBusy(instanceId)
+	require.NoError(t, err)
+	assert.False(t, busy)
+
+	// Changes need an exclusive lock
+	err = instance.SetLabel("env", "prod")
+	assert.ErrorIs(t, err, locker.ErrLockless)
+	exists, err = afero.Exists(memFs, filepath.Join("/data", nodesDirName, instanceId, ".lock"))
+	require.NoError(t, err)
+	assert.False(t, exists, "lock file created")
+}
+
+func TestDataDir_VerifyInstance(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	instanceId, err := dataDir.InitInstance(&Instance{
+		Name:    "mock-avs",
+		Tag:     "default",
+		URL:     common.MockAvsPkg.Repo(),
+		Version: common.MockAvsPkg.Version(),
+		Profile: "option-returner",
+	})
+	require.NoError(t, err)
+	instancePath, err := dataDir.InstancePath(instanceId)
+	require.NoError(t, err)
+
+	// Without manifest
+	err = dataDir.VerifyInstance(instanceId)
+	assert.ErrorIs(t, err, ErrInstanceManifestNotFound)
+
+	// Intact
+	envData := []byte("KEY=value\n")
+	profileData := []byte("name: option-returner\n")
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, ".env"), envData, 0o644))
+	require.NoError(t, fs.MkdirAll(filepath.Join(instancePath, "option-returner"), 0o755))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "option-returner", "profile.yml"), profileData, 0o644))
+	checksum := func(data []byte) string {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	manifestData, err := json.Marshal(InstanceManifest{Files: map[string]string{
+		".env":                        checksum(envData),
+		"option-returner/profile.yml": checksum(profileData),
+	}})
+	require.NoError(t, err)
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "manifest.json"), manifestData, 0o644))
+	require.NoError(t, dataDir.VerifyInstance(instanceId))
+
+	// Corrupted
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, ".env"), []byte("KEY=other\n"), 0o644))
+	require.NoError(t, fs.Remove(filepath.Join(instancePath, "option-returner", "profile.yml")))
+	err = dataDir.VerifyInstance(instanceId)
+	require.ErrorIs(t, err, ErrInstanceCorrupted)
+	var integrityErr *InstanceIntegrityError
+	require.ErrorAs(t, err, &integrityErr)
+	assert.Equal(t, []string{"option-returner/profile.yml"}, integrityErr.Missing)
+	assert.Equal(t, []string{".env"}, integrityErr.Altered)
+
+	// Paths outside the instance directory
+	manifestData, err = json.Marshal(InstanceManifest{Files: map[string]string{"../other/.env": checksum(envData)}})
+	require.NoError(t, err)
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "manifest.json"), manifestData, 0o644))
+	err = dataDir.VerifyInstance(instanceId)
+	assert.ErrorIs(t, err, ErrInvalidInstanceManifest)
+
+	err = dataDir.VerifyInstance("missing-default")
+	assert.ErrorIs(t, err, ErrInstanceNotFound)
+}
+
+func TestDataDir_InitInstances(t *testing.T) {
+	newInstances := func(tags ...string) []*Instance {
+		instances := make([]*Instance, 0, len(tags))
+		for _, tag := range tags {
+			instances = append(instances, &Instance{
+				Name:    "mock-avs",
+				Tag:     tag,
+				URL:     common.MockAvsPkg.Repo(),
+				Version: common.MockAvsPkg.Version(),
+				Profile: "option-returner",
+			})
+		}
+		return instances
+	}
+	resultErrs := func(results []InstallResult) map[string]error {
+		errs := make(map[string]error, len(results))
+		for _, result := range results {
+			errs[result.InstanceId] = result.Err
+		}
+		return errs
+	}
+
+	t.Run("partial", func(t *testing.T) {
+		dataDir, err := NewDataDir(t.TempDir(), afero.NewOsFs(), locker.NewFLock())
+		require.NoError(t, err)
+		_, err = dataDir.InitInstance(newInstances("existing")[0])
+		require.NoError(t, err)
+
+		results, err := dataDir.InitInstancesWithOptions(newInstances("a", "existing", "b", "a", "c"), InitInstancesOptions{Concurrency: 2})
+		assert.ErrorIs(t, err, ErrInstanceAlreadyExists)
+		require.Len(t, results, 5)
+		ids := make([]string, 0, len(results))
+		for _, result := range results {
+			ids = append(ids, result.InstanceId)
+		}
+		assert.Equal(t, []string{"mock-avs-a", "mock-avs-existing", "mock-avs-b", "mock-avs-a", "mock-avs-c"}, ids)
+		assert.NoError(t, results[0].Err)
+		assert.ErrorIs(t, results[1].Err, ErrInstanceAlreadyExists)
+		assert.NoError(t, results[2].Err)
+		assert.ErrorIs(t, results[3].Err, ErrInstanceAlreadyExists)
+		assert.NoError(t, results[4].Err)
+		instances, err := dataDir.ListInstances()
+		require.NoError(t, err)
+		assert.Len(t, instances, 4)
+	})
+	t.Run("atomic", func(t *testing.T) {
+		dataDir, err := NewDataDir(t.TempDir(), afero.NewOsFs(), locker.NewFLock())
+		require.NoError(t, err)
+		_, err = dataDir.InitInstance(newInstances("existing")[0])
+		require.NoError(t, err)
+
+		results, err := dataDir.InitInstancesWithOptions(newInstances("a", "existing", "b"), InitInstancesOptions{Atomic: true})
+		assert.ErrorIs(t, err, ErrInstanceAlreadyExists)
+		errs := resultErrs(results)
+		assert.ErrorIs(t, errs["mock-avs-a"], ErrInstallRolledBack)
+		assert.ErrorIs(t, errs["mock-avs-existing"], ErrInstanceAlreadyExists)
+		assert.ErrorIs(t, errs["mock-avs-b"], ErrInstallRolledBack)
+		assert.False(t, dataDir.HasInstance("mock-avs-a"))
+		assert.False(t, dataDir.HasInstance("mock-avs-b"))
+		assert.True(t, dataDir.HasInstance("mock-avs-existing"))
+
+		// Nothing rolled back without failures
+		results, err = dataDir.InitInstancesWithOptions(newInstances("a", "b"), InitInstancesOptions{Atomic: true})
+		require.NoError(t, err)
+		for _, err := range resultErrs(results) {
+			assert.NoError(t, err)
+		}
+		instances, err := dataDir.ListInstances()
+		require.NoError(t, err)
+		assert.Len(t, instances, 3)
+	})
+}
+
+func TestDataDir_MismatchedInstanceId(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDirPath := t.TempDir()
+	dataDir, err := NewDataDir(dataDirPath, fs, locker.NewFLock())
+	require.NoError(t, err)
+	nodesPath := filepath.Join(dataDirPath, nodesDirName)
+	writeState := func(dirName, tag string) {
+		state := fmt.Sprintf(`{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","profile":"option-returner","tag":%q}`, tag)
+		require.NoError(t, fs.MkdirAll(filepath.Join(nodesPath, dirName), 0o755))
+		require.NoError(t, afero.WriteFile(fs, filepath.Join(nodesPath, dirName, "state.json"), []byte(state), 0o644))
+	}
+	// A renamed instance, and a copy of an instance under another name
+	writeState("mock-avs-renamed", "default")
+	require.NoError(t, fs.MkdirAll(filepath.Join(dataDirPath, pluginsDir), 0o755))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(dataDirPath, pluginsDir, "mock-avs-renamed.tar"), []byte("context"), 0o644))
+	writeState("mock-avs-other", "other")
+	writeState("mock-avs-copy", "other")
+
+	report, err := dataDir.Check()
+	require.NoError(t, err)
+	var paths []string
+	for _, problem := range report.ByKind(CheckMismatchedInstanceId) {
+		paths = append(paths, problem.Path)
+	}
+	assert.ElementsMatch(t, []string{filepath.Join(nodesPath, "mock-avs-renamed"), filepath.Join(nodesPath, "mock-avs-copy")}, paths)
+
+	renamed, err := dataDir.RepairInstanceIds()
+	assert.ErrorIs(t, err, ErrInstanceAlreadyExists)
+	assert.Equal(t, []string{"mock-avs-default"}, renamed)
+	assert.DirExists(t, filepath.Join(nodesPath, "mock-avs-default"))
+	assert.NoDirExists(t, filepath.Join(nodesPath, "mock-avs-renamed"))
+	assert.FileExists(t, filepath.Join(dataDirPath, pluginsDir, "mock-avs-default.tar"))
+	assert.DirExists(t, filepath.Join(nodesPath, "mock-avs-copy"))
+
+	report, err = dataDir.Check()
+	require.NoError(t, err)
+	require.Len(t, report.ByKind(CheckMismatchedInstanceId), 1)
+	assert.Equal(t, filepath.Join(nodesPath, "mock-avs-copy"), report.ByKind(CheckMismatchedInstanceId)[0].Path)
+}
+
+func TestDataDir_ListInstancesConsistent(t *testing.T) {
+	dataDir, err := NewDataDir(t.TempDir(), afero.NewOsFs(), locker.NewFLock())
+	require.NoError(t, err)
+	newInstance := func(tag string) *Instance {
+		return &Instance{
+			Name:    "mock-avs",
+			Tag:     tag,
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Profile: "option-returner",
+		}
+	}
+	_, err = dataDir.InitInstance(newInstance("first"))
+	require.NoError(t, err)
+
+	// An install in progress holds the data dir lock shared
+	installLock, err := dataDir.rlockDataDir()
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = dataDir.ListInstancesConsistent(ctx)
+	assert.ErrorIs(t, err, ErrDataDirLockTimeout)
+
+	// The list waits for the install to finish
+	type listResult struct {
+		instances []*Instance
+		err       error
+	}
+	listed := make(chan listResult, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		instances, err := dataDir.ListInstancesConsistent(ctx)
+		listed <- listResult{instances, err}
+	}()
+	_, err = dataDir.InitInstance(newInstance("second"))
+	require.NoError(t, err)
+	select {
+	case result := <-listed:
+		t.Fatalf("list not blocked by the install: %v", result.err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	require.NoError(t, installLock.Unlock())
+	var result listResult
+	select {
+	case result = <-listed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("list still blocked after the install")
+	}
+	require.NoError(t, result.err)
+	ids := make([]string, 0, len(result.instances))
+	for _, instance := range result.instances {
+		ids = append(ids, instance.ID())
+	}
+	assert.Equal(t, []string{"mock-avs-first", "mock-avs-second"}, ids)
+
+	// Installs and removals don't wait for each other
+	installLock, err = dataDir.rlockDataDir()
+	require.NoError(t, err)
+	require.NoError(t, dataDir.RemoveInstance("mock-avs-first", false))
+	require.NoError(t, installLock.Unlock())
+	instances, err := dataDir.ListInstancesConsistent(context.Background())
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	assert.Equal(t, "mock-avs-second", instances[0].ID())
+}
+
+func TestMoveDataDir(t *testing.T) {
+	fs := afero.NewOsFs()
+	newPopulatedDataDir := func(t *testing.T) (string, string) {
+		oldPath := filepath.Join(t.TempDir(), "old")
+		dataDir, err := NewDataDir(oldPath, fs, locker.NewFLock())
+		require.NoError(t, err)
+		instanceId, err := dataDir.InitInstance(&Instance{
+			Name:    "mock-avs",
+			Tag:     "default",
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Profile: "option-returner",
+		})
+		require.NoError(t, err)
+		instancePath, err := dataDir.InstancePath(instanceId)
+		require.NoError(t, err)
+		env := fmt.Sprintf("DATA_DIR=%s/data\nOTHER=%s-backup\n", instancePath, oldPath)
+		require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, ".env"), []byte(env), 0o644))
+		_, err = dataDir.MonitoringStack()
+		require.NoError(t, err)
+		compose := fmt.Sprintf("volumes:\n  - %s/prometheus:/etc/prometheus\n", dataDir.MonitoringPath())
+		require.NoError(t, afero.WriteFile(fs, filepath.Join(dataDir.MonitoringPath(), "docker-compose.yml"), []byte(compose), 0o644))
+		return oldPath, instanceId
+	}
+
+	t.Run("rename", func(t *testing.T) {
+		oldPath, instanceId := newPopulatedDataDir(t)
+		newPath := filepath.Join(t.TempDir(), "disk", "new")
+		dataDir, err := MoveDataDir(fs, oldPath, newPath, locker.NewFLock())
+		require.NoError(t, err)
+		assert.Equal(t, newPath, dataDir.Path())
+		assert.NoDirExists(t, oldPath)
+
+		instances, err := dataDir.ListInstances()
+		require.NoError(t, err)
+		require.Len(t, instances, 1)
+		assert.Equal(t, instanceId, instances[0].ID())
+		instancePath := filepath.Join(newPath, nodesDirName, instanceId)
+		assert.FileExists(t, filepath.Join(instancePath, ".lock"))
+		require.NoError(t, instances[0].SetLabel("env", "prod"))
+
+		env, err := afero.ReadFile(fs, filepath.Join(instancePath, ".env"))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("DATA_DIR=%s/data\nOTHER=%s-backup\n", instancePath, oldPath), string(env))
+		compose, err := afero.ReadFile(fs, filepath.Join(dataDir.MonitoringPath(), "docker-compose.yml"))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("volumes:\n  - %s/prometheus:/etc/prometheus\n", dataDir.MonitoringPath()), string(compose))
+	})
+	t.Run("existing copy", func(t *testing.T) {
+		oldPath, instanceId := newPopulatedDataDir(t)
+		newPath := filepath.Join(t.TempDir(), "new")
+		require.NoError(t, copyTree(fs, oldPath, newPath))
+		require.NoError(t, afero.WriteFile(fs, filepath.Join(newPath, nodesDirName, instanceId, "extra"), nil, 0o644))
+		_, err := MoveDataDir(fs, oldPath, newPath, locker.NewFLock())
+		assert.ErrorIs(t, err, ErrDataDirCopyMismatch)
+
+		require.NoError(t, fs.Remove(filepath.Join(newPath, nodesDirName, instanceId, "extra")))
+		dataDir, err := MoveDataDir(fs, oldPath, newPath, locker.NewFLock())
+		require.NoError(t, err)
+		assert.DirExists(t, oldPath)
+		assert.FileExists(t, filepath.Join(newPath, nodesDirName, instanceId, ".lock"))
+		instance, err := dataDir.Instance(instanceId)
+		require.NoError(t, err)
+		assert.Equal(t, "option-returner", instance.Profile)
+	})
+	t.Run("instance in use", func(t *testing.T) {
+		oldPath, instanceId := newPopulatedDataDir(t)
+		l := locker.NewFLock().New(filepath.Join(oldPath, nodesDirName, instanceId, ".lock"))
+		require.NoError(t, l.Lock())
+		_, err := MoveDataDir(fs, oldPath, filepath.Join(t.TempDir(), "new"), locker.NewFLock())
+		assert.ErrorIs(t, err, ErrInstanceBusy)
+		require.NoError(t, l.Unlock())
+		assert.DirExists(t, oldPath)
+	})
+	t.Run("invalid paths", func(t *testing.T) {
+		oldPath, _ := newPopulatedDataDir(t)
+		_, err := MoveDataDir(fs, oldPath, filepath.Join(oldPath, "nested"), locker.NewFLock())
+		assert.ErrorIs(t, err, ErrInvalidDataDirPath)
+		_, err = MoveDataDir(fs, filepath.Join(t.TempDir(), "missing"), filepath.Join(t.TempDir(), "new"), locker.NewFLock())
+		assert.ErrorIs(t, err, ErrNotDataDir)
+	})
+}
+
+func TestDataDir_SpaceCheck(t *testing.T) {
+	dataDir, err := NewDataDir(t.TempDir(), afero.NewOsFs(), locker.NewFLock(), WithSpaceCheck())
+	require.NoError(t, err)
+	instance := &Instance{
+		Name:    "mock-avs",
+		Tag:     "default",
+		URL:     common.MockAvsPkg.Repo(),
+		Version: common.MockAvsPkg.Version(),
+		Profile: "option-returner",
+	}
+	instanceId, err := dataDir.InitInstance(instance)
+	require.NoError(t, err)
+	require.NoError(t, afero.WriteFile(dataDir.fs, filepath.Join(instance.path, "data"), make([]byte, 1024), 0o644))
+	profilePath := filepath.Join(t.TempDir(), "profile")
+	require.NoError(t, dataDir.fs.MkdirAll(profilePath, 0o755))
+	require.NoError(t, afero.WriteFile(dataDir.fs, filepath.Join(profilePath, "docker-compose.yml"), make([]byte, 1024), 0o644))
+
+	dataDir.freeSpace = func() (uint64, error) { return 512, nil }
+	backup := &Backup{InstanceId: instanceId, Timestamp: time.Now()}
+	err = dataDir.InitBackup(backup)
+	assert.ErrorIs(t, err, ErrInsufficientSpace)
+	exists, err := dataDir.HasBackup(backup.Id())
+	require.NoError(t, err)
+	assert.False(t, exists, "rejected backup must not be created")
+	assert.ErrorIs(t, dataDir.CheckInstallSpace(profilePath), ErrInsufficientSpace)
+
+	// Unknown free space skips the check
+	dataDir.freeSpace = func() (uint64, error) { return 0, ErrFreeSpaceUnknown }
+	assert.NoError(t, dataDir.CheckInstallSpace(profilePath))
+
+	dataDir.freeSpace = func() (uint64, error) { return 1 << 20, nil }
+	assert.NoError(t, dataDir.CheckInstallSpace(profilePath))
+	assert.NoError(t, dataDir.InitBackup(backup))
+
+	// Without WithSpaceCheck, nothing is checked
+	dataDir.spaceCheck = false
+	dataDir.freeSpace = func() (uint64, error) { return 0, nil }
+	assert.NoError(t, dataDir.CheckInstallSpace(profilePath))
+
+	free, err := (&DataDir{path: t.TempDir(), fs: afero.NewOsFs()}).FreeSpace()
+	require.NoError(t, err)
+	assert.Positive(t, free)
+	_, err = (&DataDir{path: "/data", fs: afero.NewMemMapFs()}).FreeSpace()
+	assert.ErrorIs(t, err, ErrFreeSpaceUnknown)
+}
+
+func TestDataDir_InstanceLifecycle(t *testing.T) {
+	fs := afero.NewOsFs()
+	clock := &fakeClock{now: time.Unix(1696420902, 0).UTC()}
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock(), WithClock(clock))
+	require.NoError(t, err)
+	newInstance := func(version string) *Instance {
+		return &Instance{
+			Name:    "mock-avs",
+			Tag:     "default",
+			URL:     common.MockAvsPkg.Repo(),
+			Version: version,
+			Profile: "option-returner",
+		}
+	}
+	_, err = dataDir.InstanceLifecycle("mock-avs-default")
+	assert.ErrorIs(t, err, ErrInstanceNotFound)
+
+	created := clock.now
+	_, err = dataDir.InitInstance(newInstance(common.MockAvsPkg.Version()))
+	require.NoError(t, err)
+	info, err := dataDir.InstanceLifecycle("mock-avs-default")
+	require.NoError(t, err)
+	assert.Equal(t, LifecycleInfo{CreatedAt: created, UpdatedAt: created}, info)
+
+	// An upsert changing the configuration keeps the creation time
+	clock.now = clock.now.Add(time.Hour)
+	changed, err := dataDir.UpsertInstance(newInstance("v5.5.0"))
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	state := []byte(`{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","profile":"option-returner","tag":"default"}`)
+	otherState := []byte(`{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","profile":"option-returner","tag":"other"}`)
+	addBackup := func(instanceId string, state []byte, timestamp time.Time) {
+		backup := Backup{InstanceId: instanceId, Timestamp: timestamp}
+		require.NoError(t, dataDir.InitBackup(&backup))
+		backupTarFile, err := fs.OpenFile(dataDir.BackupWritePath(backup.Id()), os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		tarWriter := tar.NewWriter(backupTarFile)
+		tarAddStateJson(t, tarWriter, state)
+		tarAddTimestamp(t, tarWriter, backup.Timestamp)
+		require.NoError(t, tarWriter.Close())
+		require.NoError(t, backupTarFile.Close())
+		require.NoError(t, dataDir.CommitBackup(&backup))
+	}
+	addBackup("mock-avs-default", state, clock.now.Add(time.Hour))
+	addBackup("mock-avs-default", state, clock.now.Add(3*time.Hour))
+	addBackup("mock-avs-default", state, clock.now.Add(2*time.Hour))
+	// A newer backup of another instance is ignored
+	addBackup("mock-avs-other", otherState, clock.now.Add(4*time.Hour))
+
+	info, err = dataDir.InstanceLifecycle("mock-avs-default")
+	require.NoError(t, err)
+	assert.Equal(t, created, info.CreatedAt)
+	assert.Equal(t, clock.now, info.UpdatedAt)
+	assert.True(t, clock.now.Add(3*time.Hour).Equal(info.LastBackupAt), "last backup at %v", info.LastBackupAt)
+
+	// Instances installed before the times were recorded have zero times
+	statePath := filepath.Join(dataDir.NodesPath(), "mock-avs-default", "state.json")
+	require.NoError(t, afero.WriteFile(fs, statePath, state, 0o644))
+	info, err = dataDir.InstanceLifecycle("mock-avs-default")
+	require.NoError(t, err)
+	assert.True(t, info.CreatedAt.IsZero())
+	assert.True(t, info.UpdatedAt.IsZero())
+	assert.False(t, info.LastBackupAt.IsZero())
+}
+
+// exdevRenameFs fails the given number of renames with EXDEV, like overlay file
+// systems renaming files across layers.
+type exdevRenameFs struct {
+	afero.Fs
+	failures int
+}
+
+func (fs *exdevRenameFs) Rename(oldname, newname string) error {
+	if fs.failures > 0 {
+		fs.failures--
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+	return fs.Fs.Rename(oldname, newname)
+}
+
+func TestWriteFileAtomic_CrossDevice(t *testing.T) {
+	noTempFiles := func(t *testing.T, fs afero.Fs, dir string) {
+		entries, err := afero.ReadDir(fs, dir)
+		require.NoError(t, err)
+		for _, entry := range entries {
+			assert.NotContains(t, entry.Name(), ".tmp")
+		}
+	}
+
+	t.Run("copy fallback", func(t *testing.T) {
+		fs := &exdevRenameFs{Fs: afero.NewOsFs(), failures: 1}
+		dir := t.TempDir()
+		path := filepath.Join(dir, "state.json")
+		require.NoError(t, afero.WriteFile(fs, path, []byte("old"), 0o600))
+
+		err := writeFileAtomic(fs, path, []byte("new"), 0o644, true)
+		require.NoError(t, err)
+		assert.Zero(t, fs.failures)
+		content, err := afero.ReadFile(fs, path)
+		require.NoError(t, err)
+		assert.Equal(t, "new", string(content))
+		info, err := fs.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+		noTempFiles(t, fs, dir)
+	})
+	t.Run("instance state", func(t *testing.T) {
+		fs := &exdevRenameFs{Fs: afero.NewOsFs()}
+		dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock(), WithSync())
+		require.NoError(t, err)
+		_, err = dataDir.InitInstance(&Instance{
+			Name:    "mock-avs",
+			Tag:     "default",
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Profile: "option-returner",
+		})
+		require.NoError(t, err)
+		instance, err := dataDir.Instance("mock-avs-default")
+		require.NoError(t, err)
+
+		fs.failures = 1
+		require.NoError(t, instance.SetMaintenance(true))
+		stored, err := dataDir.Instance("mock-avs-default")
+		require.NoError(t, err)
+		assert.True(t, stored.Maintenance)
+		noTempFiles(t, fs, instance.path)
+	})
+	t.Run("persistent failure", func(t *testing.T) {
+		fs := &exdevRenameFs{Fs: afero.NewOsFs(), failures: 2}
+		dir := t.TempDir()
+		path := filepath.Join(dir, "state.json")
+		require.NoError(t, afero.WriteFile(fs, path, []byte("old"), 0o644))
+
+		err := writeFileAtomic(fs, path, []byte("new"), 0o644, false)
+		assert.ErrorIs(t, err, syscall.EXDEV)
+		content, err := afero.ReadFile(fs, path)
+		require.NoError(t, err)
+		assert.Equal(t, "old", string(content))
+		noTempFiles(t, fs, dir)
+	})
+}
+
+func TestCheckReport_JSON(t *testing.T) {
+	healthy, err := (&CheckReport{}).JSON()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"healthy": true,
+		"problems": {
+			"invalid-instance": [],
+			"pending-removal": [],
+			"orphaned-plugin-context": [],
+			"stale-temp": [],
+			"corrupt-backup": [],
+			"missing-monitoring-config": [],
+			"mismatched-instance-id": [],
+			"stale-label-index": [],
+			"nonconforming-instance": []
+		}
+	}`, string(healthy))
+
+	report := CheckReport{Problems: []CheckProblem{
+		{Kind: CheckCorruptBackup, Path: "/data/backup/mock-avs-default-1696420902.tar", Detail: "checksum mismatch"},
+		{Kind: CheckOrphanedPluginContext, Path: "/data/plugin/mock-avs-removed.tar"},
+	}}
+	out, err := report.JSON()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"healthy": false,
+		"problems": {
+			"invalid-instance": [],
+			"pending-removal": [],
+			"orphaned-plugin-context": [{"path": "/data/plugin/mock-avs-removed.tar"}],
+			"stale-temp": [],
+			"corrupt-backup": [{"path": "/data/backup/mock-avs-default-1696420902.tar", "detail": "checksum mismatch"}],
+			"missing-monitoring-config": [],
+			"mismatched-instance-id": [],
+			"stale-label-index": [],
+			"nonconforming-instance": []
+		}
+	}`, string(out))
+}
+
+func TestDataDir_ConcurrentBackups(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDirPath := t.TempDir()
+	clock := fakeClock{now: time.Unix(1696420902, 0)}
+	// Each data dir stands for a process backing up the same instance
+	first, err := NewDataDir(dataDirPath, fs, locker.NewFLock(), WithClock(clock))
+	require.NoError(t, err)
+	second, err := NewDataDir(dataDirPath, fs, locker.NewFLock(), WithClock(clock))
+	require.NoError(t, err)
+	instanceId, err := first.InitInstance(&Instance{
+		Name:    "mock-avs",
+		Tag:     "default",
+		URL:     common.MockAvsPkg.Repo(),
+		Version: common.MockAvsPkg.Version(),
+		Profile: "option-returner",
+	})
+	require.NoError(t, err)
+	state, err := first.RawInstanceState(instanceId)
+	require.NoError(t, err)
+	writeBackup := func(dataDir *DataDir, b *Backup) {
+		backupTarFile, err := fs.OpenFile(dataDir.BackupWritePath(b.Id()), os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		tarWriter := tar.NewWriter(backupTarFile)
+		tarAddStateJson(t, tarWriter, state)
+		tarAddTimestamp(t, tarWriter, b.Timestamp)
+		require.NoError(t, tarWriter.Close())
+		require.NoError(t, backupTarFile.Close())
+	}
+	stagingFiles := func() []string {
+		entries, err := afero.ReadDir(fs, first.BackupDirPath())
+		require.NoError(t, err)
+		var names []string
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".tmp") {
+				names = append(names, entry.Name())
+			}
+		}
+		return names
+	}
+
+	t.Run("same second", func(t *testing.T) {
+		// Both backups start before either is complete
+		firstBackup, err := first.InitTimestampedBackup(instanceId)
+		require.NoError(t, err)
+		secondBackup, err := second.InitTimestampedBackup(instanceId)
+		require.NoError(t, err)
+		require.Equal(t, firstBackup.Id(), secondBackup.Id())
+		assert.NotEqual(t, first.BackupWritePath(firstBackup.Id()), second.BackupWritePath(secondBackup.Id()))
+		exists, err := first.HasBackup(firstBackup.Id())
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Len(t, stagingFiles(), 2)
+
+		writeBackup(first, firstBackup)
+		writeBackup(second, secondBackup)
+		require.NoError(t, first.WriteBackupManifest(firstBackup))
+		err = second.WriteBackupManifest(secondBackup)
+		require.ErrorIs(t, err, ErrBackupAlreadyExists)
+		// Cleaning up the failed backup keeps the committed one
+		require.NoError(t, second.RemoveBackup(secondBackup.Id()))
+
+		assert.Empty(t, stagingFiles())
+		valid, err := first.verifyBackup(firstBackup.Id())
+		require.NoError(t, err)
+		assert.True(t, valid)
+		_, err = second.InitTimestampedBackup(instanceId)
+		assert.ErrorIs(t, err, ErrBackupAlreadyExists)
+		require.NoError(t, first.RemoveBackup(firstBackup.Id()))
+	})
+	t.Run("parallel", func(t *testing.T) {
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for i, dataDir := range []*DataDir{first, second} {
+			wg.Add(1)
+			go func(i int, dataDir *DataDir) {
+				defer wg.Done()
+				b, err := dataDir.InitTimestampedBackup(instanceId)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				writeBackup(dataDir, b)
+				if errs[i] = dataDir.WriteBackupManifest(b); errs[i] != nil {
+					assert.NoError(t, dataDir.RemoveBackup(b.Id()))
+				}
+			}(i, dataDir)
+		}
+		wg.Wait()
+
+		var committed int
+		for _, err := range errs {
+			if err == nil {
+				committed++
+			} else {
+				assert.ErrorIs(t, err, ErrBackupAlreadyExists)
+			}
+		}
+		assert.Equal(t, 1, committed)
+		assert.Empty(t, stagingFiles())
+		backups, err := first.BackupList()
+		require.NoError(t, err)
+		require.Len(t, backups, 1)
+		valid, err := first.verifyBackup(backups[0].Id())
+		require.NoError(t, err)
+		assert.True(t, valid)
+	})
+}
+
+func newSummaryTestDataDir(t testing.TB, instances int) *DataDir {
+	fs := afero.NewOsFs()
+	path := t.TempDir()
+	for i := 0; i < instances; i++ {
+		tag := fmt.Sprintf("tag%d", i)
+		instancePath := filepath.Join(path, nodesDirName, "mock-avs-"+tag)
+		require.NoError(t, fs.MkdirAll(instancePath, 0o755))
+		targets := make([]string, 0, 20)
+		labels := make([]string, 0, 20)
+		for j := 0; j < 20; j++ {
+			targets = append(targets, fmt.Sprintf(`{"service":"service%d","port":"%d","path":"/metrics"}`, j, 9000+j))
+			labels = append(labels, fmt.Sprintf(`"label%d":"value%d"`, j, j))
+		}
+		state := `{"name":"mock-avs","url":"` + common.MockAvsPkg.Repo() + `","version":"` + common.MockAvsPkg.Version() +
+			`","commit":"` + common.MockAvsPkg.CommitHash() + `","profile":"option-returner","tag":"` + tag +
+			`","maintenance":` + fmt.Sprint(i%2 == 0) +
+			`,"monitoring":{"targets":[` + strings.Join(targets, ",") + `]},"labels":{` + strings.Join(labels, ",") + `}}`
+		require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "state.json"), []byte(state), 0o644))
+	}
+	dataDir, err := NewDataDir(path, fs, locker.NewFLock())
+	require.NoError(t, err)
+	return dataDir
+}
+
+func TestDataDir_ListInstanceSummaries(t *testing.T) {
+	dataDir := newSummaryTestDataDir(t, 10)
+	// Entries that are not instances are skipped
+	require.NoError(t, os.MkdirAll(filepath.Join(dataDir.NodesPath(), "mock-avs-gone"+deletingSuffix), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir.NodesPath(), "file"), nil, 0o644))
+
+	instances, err := dataDir.ListInstances()
+	require.NoError(t, err)
+	summaries, err := dataDir.ListInstanceSummaries()
+	require.NoError(t, err)
+	require.Len(t, summaries, len(instances))
+	for i, instance := range instances {
+		assert.Equal(t, InstanceSummary{
+			ID:          instance.ID(),
+			Name:        instance.Name,
+			Tag:         instance.Tag,
+			URL:         instance.URL,
+			Version:     instance.Version,
+			Commit:      instance.Commit,
+			Profile:     instance.Profile,
+			Maintenance: instance.Maintenance,
+		}, summaries[i])
+	}
+
+	// Missing nodes dir
+	emptyDataDir, err := NewDataDir(t.TempDir(), afero.NewOsFs(), locker.NewFLock())
+	require.NoError(t, err)
+	summaries, err = emptyDataDir.ListInstanceSummaries()
+	require.NoError(t, err)
+	assert.Empty(t, summaries)
+
+	// Instance without state
+	require.NoError(t, os.MkdirAll(filepath.Join(dataDir.NodesPath(), "mock-avs-broken"), 0o755))
+	_, err = dataDir.ListInstanceSummaries()
+	assert.ErrorIs(t, err, ErrInvalidInstanceDir)
+}
+
+func BenchmarkDataDir_ListInstanceSummaries(b *testing.B) {
+	dataDir := newSummaryTestDataDir(b, 100)
+	b.Run("ListInstances", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := dataDir.ListInstances(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ListInstanceSummaries", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := dataDir.ListInstanceSummaries(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestDataDir_FindInstancesByLabel(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	newInstance := func(tag string, labels map[string]string) *Instance {
+		return &Instance{
+			Name:    "mock-avs",
+			Tag:     tag,
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Profile: "option-returner",
+			Labels:  labels,
+		}
+	}
+	find := func(name, value string) []string {
+		ids, err := dataDir.FindInstancesByLabel(name, value)
+		require.NoError(t, err)
+		return ids
+	}
+
+	// Without an index
+	assert.Equal(t, []string{}, find("env", "prod"))
+	_, err = dataDir.InitInstance(newInstance("plain", nil))
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(dataDir.NodesPath(), labelIndexFileName))
+
+	// Installed and labeled instances
+	_, err = dataDir.InitInstance(newInstance("b", map[string]string{"env": "prod"}))
+	require.NoError(t, err)
+	_, err = dataDir.InitInstance(newInstance("a", map[string]string{"env": "prod", "owner": "team-a"}))
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dataDir.NodesPath(), labelIndexFileName))
+	instance, err := dataDir.Instance("mock-avs-plain")
+	require.NoError(t, err)
+	require.NoError(t, instance.SetLabel("env", "staging"))
+	assert.Equal(t, []string{"mock-avs-a", "mock-avs-b"}, find("env", "prod"))
+	assert.Equal(t, []string{"mock-avs-plain"}, find("env", "staging"))
+	assert.Equal(t, []string{"mock-avs-a"}, find("owner", "team-a"))
+	assert.Equal(t, []string{}, find("owner", "team-b"))
+
+	// Label changes and removals
+	instance, err = dataDir.Instance("mock-avs-a")
+	require.NoError(t, err)
+	require.NoError(t, instance.SetLabel("env", "staging"))
+	require.NoError(t, instance.RemoveLabel("owner"))
+	assert.Equal(t, []string{"mock-avs-b"}, find("env", "prod"))
+	assert.Equal(t, []string{"mock-avs-a", "mock-avs-plain"}, find("env", "staging"))
+	assert.Equal(t, []string{}, find("owner", "team-a"))
+	require.NoError(t, dataDir.RemoveInstance("mock-avs-plain", false))
+	assert.Equal(t, []string{"mock-avs-a"}, find("env", "staging"))
+
+	report, err := dataDir.Check()
+	require.NoError(t, err)
+	assert.Empty(t, report.ByKind(CheckStaleLabelIndex))
+
+	// A stale index is reported by Check and rebuilt by GC
+	indexPath := filepath.Join(dataDir.NodesPath(), labelIndexFileName)
+	require.NoError(t, os.WriteFile(indexPath, []byte(`{"env":{"prod":["mock-avs-gone"]}}`), 0o644))
+	assert.Equal(t, []string{"mock-avs-gone"}, find("env", "prod"))
+	report, err = dataDir.Check()
+	require.NoError(t, err)
+	assert.Equal(t, []CheckProblem{{Kind: CheckStaleLabelIndex, Path: indexPath, Detail: "label index doesn't match the instance labels"}}, report.ByKind(CheckStaleLabelIndex))
+	require.NoError(t, dataDir.GC())
+	assert.Equal(t, []string{"mock-avs-b"}, find("env", "prod"))
+	assert.Equal(t, []string{"mock-avs-a"}, find("env", "staging"))
+
+	// A missing index is rebuilt too
+	require.NoError(t, os.Remove(indexPath))
+	report, err = dataDir.Check()
+	require.NoError(t, err)
+	assert.Len(t, report.ByKind(CheckStaleLabelIndex), 1)
+	assert.Equal(t, []string{"mock-avs-b"}, find("env", "prod"))
+	require.NoError(t, dataDir.GC())
+	assert.FileExists(t, indexPath)
+	report, err = dataDir.Check()
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+}
+
+func TestDataDir_BackupRoot(t *testing.T) {
+	fs := afero.NewOsFs()
+	backupRoot := filepath.Join(t.TempDir(), "backups")
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock(), WithClock(fakeClock{now: time.Unix(1696420902, 0)}), WithBackupRoot(backupRoot))
+	require.NoError(t, err)
+	assert.Equal(t, backupRoot, dataDir.BackupDirPath())
+	assert.DirExists(t, backupRoot)
+	instanceId, err := dataDir.InitInstance(&Instance{
+		Name:    "mock-avs",
+		Tag:     "default",
+		URL:     common.MockAvsPkg.Repo(),
+		Version: common.MockAvsPkg.Version(),
+		Profile: "option-returner",
+	})
+	require.NoError(t, err)
+	state, err := dataDir.RawInstanceState(instanceId)
+	require.NoError(t, err)
+
+	b, err := dataDir.InitTimestampedBackup(instanceId)
+	require.NoError(t, err)
+	backupTarFile, err := fs.OpenFile(dataDir.BackupWritePath(b.Id()), os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	tarWriter := tar.NewWriter(backupTarFile)
+	tarAddStateJson(t, tarWriter, state)
+	tarAddTimestamp(t, tarWriter, b.Timestamp)
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, backupTarFile.Close())
+	require.NoError(t, dataDir.WriteBackupManifest(b))
+
+	assert.FileExists(t, filepath.Join(backupRoot, b.Id()+".tar"))
+	assert.FileExists(t, filepath.Join(backupRoot, b.Id()+".json"))
+	assert.NoDirExists(t, filepath.Join(dataDir.Path(), backupDir))
+	backups, err := dataDir.BackupList()
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	assert.Equal(t, b.Id(), backups[0].Id())
+	exists, err := dataDir.HasBackup(b.Id())
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// Another data dir without the backup root doesn't see the backups
+	defaultDataDir, err := NewDataDir(dataDir.Path(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	backups, err = defaultDataDir.BackupList()
+	require.NoError(t, err)
+	assert.Empty(t, backups)
+
+	// Later on, with the same backup root
+	laterDataDir, err := NewDataDir(dataDir.Path(), fs, locker.NewFLock(), WithClock(fakeClock{now: time.Unix(1696420902, 0).Add(time.Hour)}), WithBackupRoot(backupRoot))
+	require.NoError(t, err)
+	pruned, err := laterDataDir.PruneBackups(time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, []string{b.Id()}, pruned)
+	assert.NoFileExists(t, filepath.Join(backupRoot, b.Id()+".tar"))
+
+	// The backup root must be writable
+	notDir := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(notDir, nil, 0o644))
+	_, err = NewDataDir(t.TempDir(), fs, locker.NewFLock(), WithBackupRoot(notDir))
+	assert.ErrorIs(t, err, ErrInvalidBackupRoot)
+}
+
+func TestDataDir_AuditEntries(t *testing.T) {
+	fs := afero.NewOsFs()
+	path := t.TempDir()
+	start := time.Unix(1696420902, 0).UTC()
+	later := start.Add(time.Hour)
+	dataDir, err := NewDataDir(path, fs, locker.NewFLock(), WithClock(fakeClock{now: start}), WithAuditUser("operator"))
+	require.NoError(t, err)
+	laterDataDir, err := NewDataDir(path, fs, locker.NewFLock(), WithClock(fakeClock{now: later}))
+	require.NoError(t, err)
+
+	entries, err := dataDir.AuditEntries(time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	instance := &Instance{
+		Name:    "mock-avs",
+		Tag:     "default",
+		URL:     common.MockAvsPkg.Repo(),
+		Version: common.MockAvsPkg.Version(),
+		Profile: "option-returner",
+	}
+	instanceId, err := dataDir.InitInstance(instance)
+	require.NoError(t, err)
+	updated := *instance
+	updated.Commit = common.MockAvsPkg.CommitHash()
+	changed, err := dataDir.UpsertInstance(&updated)
+	require.NoError(t, err)
+	require.True(t, changed)
+	_, err = dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	state, err := dataDir.RawInstanceState(instanceId)
+	require.NoError(t, err)
+	b, err := dataDir.InitTimestampedBackup(instanceId)
+	require.NoError(t, err)
+	backupTarFile, err := fs.OpenFile(dataDir.BackupWritePath(b.Id()), os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	tarWriter := tar.NewWriter(backupTarFile)
+	tarAddStateJson(t, tarWriter, state)
+	tarAddTimestamp(t, tarWriter, b.Timestamp)
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, backupTarFile.Close())
+	require.NoError(t, dataDir.WriteBackupManifest(b))
+
+	_, err = laterDataDir.PruneBackups(time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, laterDataDir.RemoveInstance(instanceId, false))
+	require.NoError(t, laterDataDir.RemoveMonitoringStack())
+
+	entries, err = dataDir.AuditEntries(time.Time{})
+	require.NoError(t, err)
+	pid := os.Getpid()
+	assert.Equal(t, []AuditEntry{
+		{Time: start, Action: AuditInstanceInstalled, Target: instanceId, PID: pid, User: "operator"},
+		{Time: start, Action: AuditInstanceUpdated, Target: instanceId, PID: pid, User: "operator"},
+		{Time: start, Action: AuditMonitoringStackCreated, Target: "", PID: pid, User: "operator"},
+		{Time: start, Action: AuditBackupCreated, Target: b.Id(), PID: pid, User: "operator"},
+		{Time: later, Action: AuditBackupPruned, Target: b.Id(), PID: pid},
+		{Time: later, Action: AuditInstanceRemoved, Target: instanceId, PID: pid},
+		{Time: later, Action: AuditMonitoringStackRemoved, Target: "", PID: pid},
+	}, entries)
+
+	// Filtered by time
+	entries, err = dataDir.AuditEntries(later)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, AuditBackupPruned, entries[0].Action)
+	entries, err = dataDir.AuditEntries(later.Add(time.Second))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Invalid lines are skipped
+	f, err := os.OpenFile(dataDir.AuditLogPath(), os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"time":"2023-10-04T13:01`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	entries, err = dataDir.AuditEntries(later)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	// Audit failures don't break the operations
+	require.NoError(t, os.Remove(dataDir.AuditLogPath()))
+	require.NoError(t, os.Mkdir(dataDir.AuditLogPath(), 0o755))
+	_, err = dataDir.InitInstance(instance)
+	require.NoError(t, err)
+}
+
+func TestDataDir_ListInstancesWithLockState(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	for _, tag := range []string{"a", "b", "c"} {
+		_, err := dataDir.InitInstance(&Instance{
+			Name:    "mock-avs",
+			Tag:     tag,
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Profile: "option-returner",
+		})
+		require.NoError(t, err)
+	}
+	lockPath := filepath.Join(dataDir.NodesPath(), "mock-avs-b", ".lock")
+	// A shared holder, like a backup, doesn't lock the instance for writing
+	reader := locker.NewFLock().New(filepath.Join(dataDir.NodesPath(), "mock-avs-c", ".lock"))
+	ok, err := reader.TryRLockContext(context.Background(), 10*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	holder := locker.NewFLock().New(lockPath)
+	require.NoError(t, holder.Lock())
+	states, err := dataDir.ListInstancesWithLockState()
+	require.NoError(t, err)
+	require.Len(t, states, 3)
+	locked := make(map[string]bool)
+	for _, state := range states {
+		locked[state.Instance.ID()] = state.Locked
+	}
+	assert.Equal(t, map[string]bool{"mock-avs-a": false, "mock-avs-b": true, "mock-avs-c": false}, locked)
+	assert.True(t, holder.Locked(), "the lock holder was disturbed")
+	require.NoError(t, holder.Unlock())
+
+	// The probe locks are released
+	for _, id := range []string{"mock-avs-a", "mock-avs-b", "mock-avs-c"} {
+		if id == "mock-avs-c" {
+			require.NoError(t, reader.Unlock())
+		}
+		l := locker.NewFLock().New(filepath.Join(dataDir.NodesPath(), id, ".lock"))
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		ok, err := l.TryLockContext(ctx, 10*time.Millisecond)
+		cancel()
+		require.NoError(t, err)
+		require.True(t, ok, "instance %s still locked", id)
+		require.NoError(t, l.Unlock())
+	}
+	states, err = dataDir.ListInstancesWithLockState()
+	require.NoError(t, err)
+	for _, state := range states {
+		assert.False(t, state.Locked)
+	}
+}
+
+func TestDataDir_PluginContextInfo(t *testing.T) {
+	fs := afero.NewOsFs()
+	savedAt := time.Unix(1696420902, 0).UTC()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock(), WithClock(fakeClock{now: savedAt}))
+	require.NoError(t, err)
+
+	_, err = dataDir.PluginContextInfo("plugin")
+	assert.ErrorIs(t, err, ErrPluginContextNotFound)
+	contexts, err := dataDir.ListPluginContexts()
+	require.NoError(t, err)
+	assert.Empty(t, contexts)
+
+	content := "plugin context"
+	checksum := sha256.Sum256([]byte(content))
+	require.NoError(t, dataDir.SavePluginImageContextFrom("plugin", "nethermind/mock-avs-plugin:v0.1.0", io.NopCloser(strings.NewReader(content))))
+	meta, err := dataDir.PluginContextInfo("plugin")
+	require.NoError(t, err)
+	assert.Equal(t, PluginMeta{
+		ID:       "plugin",
+		Image:    "nethermind/mock-avs-plugin:v0.1.0",
+		Size:     int64(len(content)),
+		Checksum: hex.EncodeToString(checksum[:]),
+		SavedAt:  savedAt,
+	}, meta)
+
+	// Legacy contexts have no metadata file
+	legacyPath := filepath.Join(dataDir.PluginDirPath(), "legacy.tar")
+	require.NoError(t, os.WriteFile(legacyPath, []byte(content), 0o644))
+	require.NoError(t, os.Chtimes(legacyPath, savedAt, savedAt))
+	legacy, err := dataDir.PluginContextInfo("legacy")
+	require.NoError(t, err)
+	assert.Equal(t, PluginMeta{
+		ID:       "legacy",
+		Size:     int64(len(content)),
+		Checksum: hex.EncodeToString(checksum[:]),
+		SavedAt:  savedAt,
+		Legacy:   true,
+	}, legacy)
+
+	contexts, err = dataDir.ListPluginContexts()
+	require.NoError(t, err)
+	assert.Equal(t, []PluginMeta{legacy, meta}, contexts)
+
+	require.NoError(t, dataDir.RemovePluginContext("plugin"))
+	assert.NoFileExists(t, filepath.Join(dataDir.PluginDirPath(), "plugin.json"))
+	_, err = dataDir.PluginContextInfo("plugin")
+	assert.ErrorIs(t, err, ErrPluginContextNotFound)
+}
+
+// memStore is an in-memory Store.
+type memStore struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{files: make(map[string][]byte)}
+}
+
+func (s *memStore) Open(name string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", os.ErrNotExist, name)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memStore) Create(name string) (io.WriteCloser, error) {
+	return &memStoreWriter{store: s, name: name}, nil
+}
+
+func (s *memStore) Stat(name string) (os.FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", os.ErrNotExist, name)
+	}
+	return memFileInfo{name: name, size: int64(len(data))}, nil
+}
+
+func (s *memStore) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[name]; !ok {
+		return fmt.Errorf("%w: %s", os.ErrNotExist, name)
+	}
+	delete(s.files, name)
+	return nil
+}
+
+func (s *memStore) List() ([]os.FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var infos []os.FileInfo
+	for name, data := range s.files {
+		infos = append(infos, memFileInfo{name: name, size: int64(len(data))})
+	}
+	return infos, nil
+}
+
+// memStoreWriter writes a file of a memStore, stored once closed.
+type memStoreWriter struct {
+	bytes.Buffer
+	store *memStore
+	name  string
+}
+
+func (w *memStoreWriter) Close() error {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+	w.store.files[w.name] = w.Bytes()
+	return nil
+}
+
+type memFileInfo struct {
+	name string
+	size int64
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) Mode() os.FileMode  { return 0o644 }
+func (i memFileInfo) ModTime() time.Time { return time.Time{} }
+func (i memFileInfo) IsDir() bool        { return false }
+func (i memFileInfo) Sys() any           { return nil }
+
+func TestDataDir_BackupStore(t *testing.T) {
+	fs := afero.NewOsFs()
+	store := newMemStore()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock(), WithBackupStore(store))
+	require.NoError(t, err)
+	_, err = dataDir.InitInstance(&Instance{
+		Name:    "mock-avs",
+		Tag:     "default",
+		URL:     common.MockAvsPkg.Repo(),
+		Version: common.MockAvsPkg.Version(),
+		Profile: "option-returner",
+	})
+	require.NoError(t, err)
+	timestamp := time.Unix(1696420902, 0)
+	timestampPath := filepath.Join(t.TempDir(), "timestamp")
+	require.NoError(t, afero.WriteFile(fs, timestampPath, []byte(strconv.FormatInt(timestamp.Unix(), 10)), 0o644))
+
+	// Create a backup, committed to the store
+	backup := Backup{InstanceId: "mock-avs-default", Timestamp: timestamp}
+	require.NoError(t, dataDir.InitBackup(&backup))
+	require.NoError(t, dataDir.AddBackupDir(backup.Id(), filepath.Join(dataDir.NodesPath(), "mock-avs-default"), "data"))
+	require.NoError(t, dataDir.AddBackupFile(backup.Id(), timestampPath, "timestamp"))
+	require.NoError(t, dataDir.WriteBackupManifest(&backup))
+	assert.Contains(t, store.files, backup.Id()+".tar")
+	assert.Contains(t, store.files, backup.Id()+".json")
+	backupDirEntries, err := afero.ReadDir(fs, dataDir.BackupDirPath())
+	require.NoError(t, err)
+	assert.Empty(t, backupDirEntries, "the staging archive must be moved to the store")
+
+	// List the backups of the store
+	backups, err := dataDir.BackupList()
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	assert.Equal(t, backup.Id(), backups[0].Id())
+	assert.Equal(t, "mock-avs-default", backups[0].InstanceId)
+	assert.Equal(t, common.MockAvsPkg.Version(), backups[0].Version)
+	assert.True(t, timestamp.Equal(backups[0].Timestamp))
+	assert.Equal(t, backup.Checksum, backups[0].Checksum)
+	exists, err := dataDir.HasBackup(backup.Id())
+	require.NoError(t, err)
+	assert.True(t, exists)
+	size, err := dataDir.BackupSize(backup.Id())
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(store.files[backup.Id()+".tar"])), size)
+
+	// Verify the backup against its manifest
+	verified, err := dataDir.verifyBackup(backup.Id())
+	require.NoError(t, err)
+	assert.True(t, verified)
+	report, err := dataDir.Check()
+	require.NoError(t, err)
+	assert.Empty(t, report.ByKind(CheckCorruptBackup))
+	archive := store.files[backup.Id()+".tar"]
+	store.files[backup.Id()+".tar"] = append(bytes.Clone(archive), make([]byte, 512)...)
+	verified, err = dataDir.verifyBackup(backup.Id())
+	require.NoError(t, err)
+	assert.False(t, verified)
+	store.files[backup.Id()+".tar"] = archive
+
+	// Backups of the store are not overwritten
+	err = dataDir.InitBackup(&backup)
+	assert.ErrorIs(t, err, ErrBackupAlreadyExists)
+
+	// Restore the instance from a local copy of the backup of the store
+	instancePath := filepath.Join(dataDir.NodesPath(), "mock-avs-default")
+	state, err := afero.ReadFile(fs, filepath.Join(instancePath, "state.json"))
+	require.NoError(t, err)
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "state.json"), []byte(`{}`), 0o644))
+	backupPath, release, err := dataDir.LocalBackupPath(backup.Id())
+	require.NoError(t, err)
+	require.NoError(t, dataDir.ReplaceInstanceDirFromTar("mock-avs-default", backupPath, "data"))
+	require.NoError(t, release())
+	assert.NoFileExists(t, backupPath)
+	restored, err := afero.ReadFile(fs, filepath.Join(instancePath, "state.json"))
+	require.NoError(t, err)
+	assert.Equal(t, state, restored)
+	_, _, err = dataDir.LocalBackupPath("mock-avs-missing")
+	assert.ErrorIs(t, err, ErrBackupNotFound)
+
+	// Remove the backup from the store
+	require.NoError(t, dataDir.RemoveBackup(backup.Id()))
+	assert.Empty(t, store.files)
+	backups, err = dataDir.BackupList()
+	require.NoError(t, err)
+	assert.Empty(t, backups)
+}
@@ -0,0 +1,159 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v3"
+)
+
+// redactedValue replaces the secrets in the files returned by DumpConfigs.
+const redactedValue = "<redacted>"
+
+// dumpedConfigFiles are the config files of the monitoring stack returned by
+// DumpConfigs, besides the Grafana datasources.
+var dumpedConfigFiles = []string{
+	".env",
+	"docker-compose.yml",
+	"prometheus/prometheus.yml",
+	"prometheus/file_sd/targets.json",
+}
+
+// grafanaDatasourcesDir is the directory of the Grafana datasources
+// provisioning files in the monitoring stack.
+const grafanaDatasourcesDir = "grafana/provisioning/datasources"
+
+// secretKeyParts are the parts of the names of the settings and variables
+// holding secrets. Settings ending in _file hold the path of a secret file,
+// which is not a secret itself.
+var secretKeyParts = []string{"password", "secret", "token", "credentials", "securejsondata"}
+
+// DumpConfigs returns the contents of the config files of the monitoring
+// stack, keyed by their path in the stack, with the secrets redacted, so they
+// are safe to share in a bug report. Passwords, tokens and other credentials in
+// YAML files and in the .env file are replaced, keeping the structure of the
+// files. YAML files that can't be parsed are replaced by a note, as their
+// secrets can't be found. Missing files are left out, and scrape secret files
+// are never returned.
+func (m *MonitoringStack) DumpConfigs() (configs map[string][]byte, err error) {
+	err = m.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		unlockErr := m.unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+
+	paths := append([]string{}, dumpedConfigFiles...)
+	datasources, err := afero.ReadDir(m.fs, filepath.Join(m.path, grafanaDatasourcesDir))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, datasource := range datasources {
+		if ext := filepath.Ext(datasource.Name()); !datasource.IsDir() && (ext == ".yml" || ext == ".yaml") {
+			paths = append(paths, filepath.ToSlash(filepath.Join(grafanaDatasourcesDir, datasource.Name())))
+		}
+	}
+
+	configs = make(map[string][]byte, len(paths))
+	for _, path := range paths {
+		content, err := afero.ReadFile(m.fs, filepath.Join(m.path, path))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("%w: %w", ErrReadingFile, err)
+		}
+		switch ext := filepath.Ext(path); {
+		case path == ".env":
+			configs[path] = redactDotEnv(content)
+		case ext == ".yml" || ext == ".yaml":
+			redacted, err := redactYAML(content)
+			if err != nil {
+				redacted = []byte(fmt.Sprintf("# Left out, it can't be parsed to redact its secrets: %s\n", err))
+			}
+			configs[path] = redacted
+		default:
+			configs[path] = content
+		}
+	}
+	return configs, nil
+}
+
+// isSecretKey returns true if the setting or variable with the given name
+// holds a secret.
+func isSecretKey(name string) bool {
+	name = strings.ToLower(name)
+	if strings.HasSuffix(name, "_file") {
+		return false
+	}
+	for _, part := range secretKeyParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactDotEnv replaces the values of the secret variables of the env file.
+func redactDotEnv(content []byte) []byte {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		name, _, ok := strings.Cut(line, "=")
+		if ok && !strings.HasPrefix(strings.TrimSpace(line), "#") && isSecretKey(strings.TrimSpace(name)) {
+			line = name + "=" + redactedValue
+		}
+		out.WriteString(line + "\n")
+	}
+	return out.Bytes()
+}
+
+// redactYAML replaces the values of the secret settings of the YAML document,
+// with every value nested in them.
+func redactYAML(content []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	redactYAMLNode(&doc, false)
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func redactYAMLNode(node *yaml.Node, secret bool) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if secret {
+			node.Value = redactedValue
+			node.Tag = "!!str"
+			node.Style = 0
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			redactYAMLNode(node.Content[i+1], secret || isSecretKey(node.Content[i].Value))
+		}
+	default:
+		for _, child := range node.Content {
+			redactYAMLNode(child, secret)
+		}
+	}
+}
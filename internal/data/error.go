@@ -1,15 +1,27 @@
 package data
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+)
 
 var (
 	ErrInstanceAlreadyExists       = errors.New("instance already exists")
 	ErrInstanceNotFound            = errors.New("instance not found")
+	ErrInstanceLockTimeout         = errors.New("timeout waiting for instance lock")
+	ErrInstancePendingRemoval      = errors.New("instance marked for removal but not removed")
+	ErrInstanceInMaintenance       = errors.New("instance is in maintenance mode")
+	ErrInstanceHasDependents       = errors.New("instance has dependent instances")
 	ErrInvalidInstance             = errors.New("invalid instance")
 	ErrInvalidInstanceDir          = errors.New("invalid instance directory")
 	ErrTempDirDoesNotExist         = errors.New("temp directory does not exist")
 	ErrTempIsNotDir                = errors.New("temp is not a directory")
+	ErrTempQuotaExceeded           = errors.New("temp directory quota exceeded")
 	ErrMonitoringStackNotFound     = errors.New("monitoring stack not found")
+	ErrMonitoringStackLocked       = errors.New("monitoring stack is locked by another process")
+	ErrInvalidMonitoringStackName  = errors.New("invalid monitoring stack name")
 	ErrInitializingMonitoringStack = errors.New("failed monitoring stack initialization")
 	ErrReadingFile                 = errors.New("failed reading file")
 	ErrWritingFile                 = errors.New("failed writing file")
@@ -18,4 +30,114 @@ var (
 	ErrCreatingBackup              = errors.New("failed creating backup")
 	ErrInvalidBackupName           = errors.New("invalid backup name")
 	ErrBackupNotFound              = errors.New("backup not found")
+	ErrBackupManifestNotFound      = errors.New("backup manifest not found")
+	ErrInvalidBackupManifest       = errors.New("invalid backup manifest")
+	ErrInvalidDataDirArchive       = errors.New("invalid data directory archive")
+	ErrInvalidDataDirPath          = errors.New("invalid data directory path")
+	ErrNotDataDir                  = errors.New("not a data directory")
+	ErrInstanceBusy                = errors.New("instance is locked by another process")
+	ErrDiskFull                    = errors.New("no space left on device")
+	ErrDataDirClosed               = errors.New("data directory is closed")
+	ErrInvalidVersion              = errors.New("invalid version")
+	ErrInvalidBackupArchive        = errors.New("invalid backup archive")
+	ErrReadOnly                    = errors.New("data directory is read-only")
+	ErrInvalidCompressionLevel     = errors.New("invalid compression level")
+	ErrWatchUnsupported            = errors.New("watching is not supported on this file system")
+	ErrNoVerifiedBackup            = errors.New("no verified backup")
+	ErrInstanceManifestNotFound    = errors.New("instance manifest not found")
+	ErrInvalidInstanceManifest     = errors.New("invalid instance manifest")
+	ErrInstanceCorrupted           = errors.New("instance files don't match its manifest")
+	ErrNoDataDirRoot               = errors.New("no data directory root available")
+	ErrInstallRolledBack           = errors.New("install rolled back")
+	ErrDataDirLockTimeout          = errors.New("timeout waiting for data directory lock")
+	ErrDataDirCopyMismatch         = errors.New("data directory copy doesn't match the original")
+	ErrInsufficientSpace           = errors.New("insufficient free disk space")
+	ErrFreeSpaceUnknown            = errors.New("free disk space can't be measured")
+	ErrNoMetricsPort               = errors.New("instance exposes no metrics port")
+	ErrInvalidBackupRoot           = errors.New("invalid backup root")
+	ErrBackupTooLarge              = errors.New("backup too large")
+	ErrPluginContextNotFound       = errors.New("plugin context not found")
 )
+
+// WrapDiskFull wraps err with ErrDiskFull if it was caused by a full disk, so
+// callers can tell it apart from other write errors. Other errors are returned
+// unchanged.
+func WrapDiskFull(err error) error {
+	if err != nil && errors.Is(err, syscall.ENOSPC) && !errors.Is(err, ErrDiskFull) {
+		return fmt.Errorf("%w: %w", ErrDiskFull, err)
+	}
+	return err
+}
+
+// ErrStopWalk is returned by a WalkInstances callback to stop the walk without
+// error.
+var ErrStopWalk = errors.New("stop walk")
+
+// InstanceNotFoundError is returned when the instance with the given id does
+// not exist. It matches ErrInstanceNotFound with errors.Is.
+type InstanceNotFoundError struct {
+	Id string
+}
+
+func (e *InstanceNotFoundError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrInstanceNotFound, e.Id)
+}
+
+func (e *InstanceNotFoundError) Unwrap() error {
+	return ErrInstanceNotFound
+}
+
+// BackupNotFoundError is returned when the backup with the given id does not
+// exist. It matches ErrBackupNotFound with errors.Is.
+type BackupNotFoundError struct {
+	Id string
+}
+
+func (e *BackupNotFoundError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrBackupNotFound, e.Id)
+}
+
+func (e *BackupNotFoundError) Unwrap() error {
+	return ErrBackupNotFound
+}
+
+// InstanceIntegrityError is returned by VerifyInstance when files of the
+// instance don't match its manifest. It matches ErrInstanceCorrupted with
+// errors.Is.
+type InstanceIntegrityError struct {
+	Id string
+	// Missing and Altered are the paths, relative to the instance directory,
+	// of the files listed in the manifest that are missing or whose checksum
+	// differs.
+	Missing []string
+	Altered []string
+}
+
+func (e *InstanceIntegrityError) Error() string {
+	var details []string
+	if len(e.Missing) > 0 {
+		details = append(details, "missing "+strings.Join(e.Missing, ", "))
+	}
+	if len(e.Altered) > 0 {
+		details = append(details, "altered "+strings.Join(e.Altered, ", "))
+	}
+	return fmt.Sprintf("%s: %s: %s", ErrInstanceCorrupted, e.Id, strings.Join(details, "; "))
+}
+
+func (e *InstanceIntegrityError) Unwrap() error {
+	return ErrInstanceCorrupted
+}
+
+// TempNotFoundError is returned when the temporary directory with the given id
+// does not exist. It matches ErrTempDirDoesNotExist with errors.Is.
+type TempNotFoundError struct {
+	Id string
+}
+
+func (e *TempNotFoundError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrTempDirDoesNotExist, e.Id)
+}
+
+func (e *TempNotFoundError) Unwrap() error {
+	return ErrTempDirDoesNotExist
+}
@@ -0,0 +1,45 @@
+package data
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotFoundErrors(t *testing.T) {
+	dataDir, err := NewDataDir(t.TempDir(), afero.NewOsFs(), nil)
+	require.NoError(t, err)
+
+	t.Run("instance path", func(t *testing.T) {
+		_, err := dataDir.InstancePath("mock-avs-default")
+		require.ErrorIs(t, err, ErrInstanceNotFound)
+		var notFound *InstanceNotFoundError
+		require.True(t, errors.As(err, &notFound))
+		assert.Equal(t, "mock-avs-default", notFound.Id)
+	})
+	t.Run("remove instance", func(t *testing.T) {
+		err := dataDir.RemoveInstance("mock-avs-default", false)
+		require.ErrorIs(t, err, ErrInstanceNotFound)
+		var notFound *InstanceNotFoundError
+		require.True(t, errors.As(err, &notFound))
+		assert.Equal(t, "mock-avs-default", notFound.Id)
+		assert.EqualError(t, err, "instance not found: mock-avs-default")
+	})
+	t.Run("backup", func(t *testing.T) {
+		_, err := dataDir.Backup("a6b8f3a9")
+		require.ErrorIs(t, err, ErrBackupNotFound)
+		var notFound *BackupNotFoundError
+		require.True(t, errors.As(err, &notFound))
+		assert.Equal(t, "a6b8f3a9", notFound.Id)
+	})
+	t.Run("temp", func(t *testing.T) {
+		_, err := dataDir.TempPath("temp-dir-id")
+		require.ErrorIs(t, err, ErrTempDirDoesNotExist)
+		var notFound *TempNotFoundError
+		require.True(t, errors.As(err, &notFound))
+		assert.Equal(t, "temp-dir-id", notFound.Id)
+	})
+}
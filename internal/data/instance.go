@@ -1,14 +1,25 @@
 package data
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"maps"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/NethermindEth/eigenlayer/internal/env"
 	"github.com/NethermindEth/eigenlayer/internal/locker"
@@ -16,12 +27,59 @@ import (
 	"github.com/compose-spec/compose-go/cli"
 	"github.com/compose-spec/compose-go/types"
 	"github.com/spf13/afero"
+	"golang.org/x/mod/semver"
 	"gopkg.in/yaml.v3"
 )
 
-// InstanceId returns the instance ID for the given name and tag
+// instanceIdPartRegex is the allowlist of instance names and tags. As both are
+// part of the instance directory name, path separators are not allowed.
+var (
+	instanceIdPartRegex        = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+	instanceIdInvalidCharRegex = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+)
+
+// reservedIdParts are the names of the data dir infrastructure directories,
+// which can't be used as instance names or tags.
+var reservedIdParts = []string{nodesDirName, tempDir, pluginsDir, backupDir, monitoringStackDirName}
+
+// instanceIdSeparator joins the name and the tag in an instance ID.
+const instanceIdSeparator = "-"
+
+// InstanceId returns the instance ID for the given name and tag, without
+// needing an Instance. The ID is the name and the tag joined by a '-', after
+// replacing with '_' the characters not allowed in names and tags, and each
+// ".." with "__", so it is always safe to use as a directory name. Names and
+// tags passing validation are not changed.
+//
+// The ID is the name of the instance directory, so this rule is stable and
+// must never change. The separator is not escaped, as it is allowed in names
+// and tags: the name and tag can't be parsed back from the ID, and are read
+// from the instance instead. Name and tag pairs splitting the same string at
+// different separators, like "mock-avs" and "default" or "mock" and
+// "avs-default", have the same ID, so only one of them can be installed.
 func InstanceId(name, tag string) string {
-	return fmt.Sprintf("%s-%s", name, tag)
+	return sanitizeIdPart(name) + instanceIdSeparator + sanitizeIdPart(tag)
+}
+
+// sanitizeIdPart replaces the characters not allowed in instance names and
+// tags with underscores.
+func sanitizeIdPart(s string) string {
+	s = instanceIdInvalidCharRegex.ReplaceAllString(s, "_")
+	return strings.ReplaceAll(s, "..", "__")
+}
+
+// validateIdPart checks that the given instance name or tag is safe to use as
+// part of the instance directory name.
+func validateIdPart(field, value string) error {
+	for _, reserved := range reservedIdParts {
+		if strings.EqualFold(value, reserved) {
+			return fmt.Errorf("%w: %s %q is reserved", ErrInvalidInstance, field, value)
+		}
+	}
+	if !instanceIdPartRegex.MatchString(value) || strings.Contains(value, "..") {
+		return fmt.Errorf("%w: invalid %s %q: only letters, digits, '.', '_' and '-' are allowed, and it can't contain '..'", ErrInvalidInstance, field, value)
+	}
+	return nil
 }
 
 // Instance represents the data stored about a node software instance
@@ -31,20 +89,145 @@ type Instance struct {
 	Version           string            `json:"version"`
 	SpecVersion       string            `json:"spec_version"`
 	Commit            string            `json:"commit,omitempty"`
+	Digest            string            `json:"digest,omitempty"`
 	Profile           string            `json:"profile"`
 	Tag               string            `json:"tag"`
 	MonitoringTargets MonitoringTargets `json:"monitoring"`
 	APITarget         *APITarget        `json:"api,omitempty"`
 	Plugin            *Plugin           `json:"plugin,omitempty"`
-	path              string
-	fs                afero.Fs
-	locker            locker.Locker
+	Maintenance       bool              `json:"maintenance,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	// AllowArbitraryVersion disables the semver check of Version, for
+	// instances whose version is not a release, like local installs. The check
+	// only applies to new and updated instances, so the instances installed
+	// before it load without the flag.
+	AllowArbitraryVersion bool `json:"allow_arbitrary_version,omitempty"`
+	// DependsOn are the ids of the instances this instance depends on, like
+	// the primary of a sidecar. Instances with dependents are not removed
+	// unless forced.
+	DependsOn []string `json:"depends_on,omitempty"`
+	// Ports are the ports exposed by the instance on the host, by name, like
+	// "metrics", "rpc" or "p2p".
+	Ports map[string]int `json:"ports,omitempty"`
+	// CreatedAt is when the instance was installed, and UpdatedAt when its
+	// configuration was last written, by the install or an upsert. They are
+	// nil for instances installed before they were recorded.
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	path      string
+	fs        afero.Fs
+	locker    locker.Locker
+	// compressState makes the state be stored gzip-compressed, in
+	// state.json.gz instead of state.json.
+	compressState bool
+	// syncState makes state writes be flushed to disk.
+	syncState bool
+	// compactState makes the state be written in compact JSON instead of
+	// indented.
+	compactState bool
 }
 
 func (i *Instance) ID() string {
 	return InstanceId(i.Name, i.Tag)
 }
 
+// MetricsPortName is the name of the port of Instance.Ports serving the
+// metrics of the instance.
+const MetricsPortName = "metrics"
+
+// MetricsEndpoint returns the host:port endpoint of the metrics of the
+// instance on the host, from its MetricsPortName port, or ErrNoMetricsPort if
+// the instance doesn't expose one. Monitoring services not running on the host
+// network have to rewrite the host.
+func (i *Instance) MetricsEndpoint() (string, error) {
+	port, ok := i.Ports[MetricsPortName]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNoMetricsPort, i.ID())
+	}
+	return net.JoinHostPort("localhost", strconv.Itoa(port)), nil
+}
+
+// volatileStateFields are the state.json fields that don't describe the
+// instance configuration, so they are not part of the fingerprint.
+var volatileStateFields = []string{"maintenance", "labels", "created_at", "updated_at"}
+
+// Fingerprint returns a SHA-256 hash of the instance state. The state is
+// encoded as JSON with sorted keys, so two instances with the same
+// configuration always have the same fingerprint, regardless of where they
+// are stored. Volatile fields are not part of the fingerprint.
+func (i *Instance) Fingerprint() (string, error) {
+	// Round trip through a map to get the keys sorted
+	state, err := i.configState()
+	if err != nil {
+		return "", err
+	}
+	canonical, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(canonical)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// configState returns the state.json fields of the instance, decoded as
+// generic JSON values, without the volatile fields.
+func (i *Instance) configState() (map[string]interface{}, error) {
+	stateData, err := json.Marshal(i)
+	if err != nil {
+		return nil, err
+	}
+	var state map[string]interface{}
+	if err := json.Unmarshal(stateData, &state); err != nil {
+		return nil, err
+	}
+	for _, field := range volatileStateFields {
+		delete(state, field)
+	}
+	return state, nil
+}
+
+// FieldDiff is a state.json field that differs between two instances. Old or
+// New is nil if the field is not set in that instance.
+type FieldDiff struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+// DiffInstances returns the state.json fields that differ between the old and
+// new instances, sorted by field name, like the fields that change in an
+// upgrade. Values are the JSON values of the fields, and volatile fields are
+// ignored. A nil instance has no fields set.
+func DiffInstances(old, new *Instance) []FieldDiff {
+	oldState, newState := diffState(old), diffState(new)
+	fields := make(map[string]struct{}, len(oldState)+len(newState))
+	for field := range oldState {
+		fields[field] = struct{}{}
+	}
+	for field := range newState {
+		fields[field] = struct{}{}
+	}
+	var diffs []FieldDiff
+	for field := range fields {
+		if !reflect.DeepEqual(oldState[field], newState[field]) {
+			diffs = append(diffs, FieldDiff{Field: field, Old: oldState[field], New: newState[field]})
+		}
+	}
+	sort.Slice(diffs, func(a, b int) bool {
+		return diffs[a].Field < diffs[b].Field
+	})
+	return diffs
+}
+
+func diffState(i *Instance) map[string]interface{} {
+	if i == nil {
+		return nil
+	}
+	// An Instance always encodes to a JSON object
+	state, _ := i.configState()
+	return state
+}
+
 type MonitoringTargets struct {
 	Targets []MonitoringTarget `json:"targets"`
 }
@@ -78,29 +261,19 @@ func newInstance(path string, fs afero.Fs, locker locker.Locker) (*Instance, err
 		path: path,
 		fs:   fs,
 	}
-	stateFile, err := i.fs.Open(filepath.Join(i.path, "state.json"))
+	stateData, compressed, err := readStateFile(fs, path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return nil, fmt.Errorf("%w %s: state.json not found", ErrInvalidInstanceDir, path)
 		}
 		return nil, err
 	}
-	defer func() {
-		closeErr := stateFile.Close()
-		if err == nil {
-			err = closeErr
-		}
-	}()
-
-	stateData, err := io.ReadAll(stateFile)
-	if err != nil {
-		return nil, err
-	}
+	i.compressState = compressed
 	err = json.Unmarshal(stateData, &i)
 	if err != nil {
 		return nil, fmt.Errorf("%w %s: invalid state.json file: %s", ErrInvalidInstance, path, err)
 	}
-	err = i.validate()
+	err = i.validateStored()
 	if err != nil {
 		return nil, err
 	}
@@ -108,9 +281,14 @@ func newInstance(path string, fs afero.Fs, locker locker.Locker) (*Instance, err
 	return &i, nil
 }
 
-// init initializes a new instance with the given path as root. It creates the
-// .lock and state.json files. If the instance is invalid, an error is returned.
-func (i *Instance) init(instancePath string, fs afero.Fs, locker locker.Locker) error {
+// init initializes a new instance with the given path as root, which must be
+// a directory right inside nodesPath, the nodes directory of the data dir. It
+// creates the .lock and state.json files. If the instance is invalid, an error
+// is returned.
+func (i *Instance) init(nodesPath, instancePath string, fs afero.Fs, locker locker.Locker) error {
+	if err := checkInstancePath(nodesPath, instancePath); err != nil {
+		return err
+	}
 	i.fs = fs
 	i.locker = locker
 	i.path = instancePath
@@ -123,32 +301,31 @@ func (i *Instance) init(instancePath string, fs afero.Fs, locker locker.Locker)
 		return err
 	}
 
-	// Create the lock file
-	_, err = i.fs.Create(filepath.Join(i.path, ".lock"))
-	if err != nil {
-		return err
+	// Create the lock file, unless locks are disabled
+	if !isLockless(i.locker) {
+		if _, err = i.fs.Create(filepath.Join(i.path, ".lock")); err != nil {
+			return err
+		}
 	}
 	// Set lock
 	i.locker = i.locker.New(filepath.Join(i.path, ".lock"))
 
 	// Create state file
-	stateFile, err := i.fs.Create(filepath.Join(i.path, "state.json"))
-	if err != nil {
+	if err := i.saveState(); err != nil {
 		return err
 	}
-	defer func() {
-		closeErr := stateFile.Close()
-		if err == nil {
-			err = closeErr
-		}
-	}()
+	return i.updateLabelIndex()
+}
 
-	stateData, err := json.Marshal(i)
-	if err != nil {
-		return err
+// checkInstancePath checks that the instance path is a directory right inside
+// the nodes directory, so the instance files can't be written anywhere else.
+func checkInstancePath(nodesPath, instancePath string) error {
+	cleanPath := filepath.Clean(instancePath)
+	base := filepath.Base(cleanPath)
+	if filepath.Dir(cleanPath) != filepath.Clean(nodesPath) || base == "." || base == ".." {
+		return fmt.Errorf("%w: %s is not in %s", ErrInvalidInstanceDir, instancePath, nodesPath)
 	}
-	_, err = stateFile.Write(stateData)
-	return err
+	return nil
 }
 
 // Setup creates the instance directory and copies the profile files into it from
@@ -236,6 +413,22 @@ func (i *Instance) ComposeProject() (*types.Project, error) {
 	if err != nil {
 		return nil, err
 	}
+	return i.composeProject(instanceEnv)
+}
+
+// ComposeProjectUnlocked is like ComposeProject, but reads the .env file of
+// the instance without taking the instance lock, so it doesn't wait for
+// another process writing to the instance. Meant for forced backups, it may
+// read a partially written environment.
+func (i *Instance) ComposeProjectUnlocked() (*types.Project, error) {
+	instanceEnv, err := env.LoadEnv(i.fs, filepath.Join(i.path, ".env"))
+	if err != nil {
+		return nil, err
+	}
+	return i.composeProject(instanceEnv)
+}
+
+func (i *Instance) composeProject(instanceEnv map[string]string) (*types.Project, error) {
 	// Build project options with the instance environment
 	projectOptions, err := cli.NewProjectOptions([]string{i.ComposePath()})
 	if err != nil {
@@ -288,6 +481,195 @@ func (i *Instance) Env() (map[string]string, error) {
 	return env.LoadEnv(i.fs, envPath)
 }
 
+// EnvOverrides returns the environment variables of the instance, from its
+// .env file, whose values differ from the given defaults, like the ones of its
+// package. Variables missing from the defaults are overrides too, while the
+// defaults missing from the .env file are not.
+func (i *Instance) EnvOverrides(defaults map[string]string) (map[string]string, error) {
+	instanceEnv, err := i.Env()
+	if err != nil {
+		return nil, err
+	}
+	overrides := make(map[string]string)
+	for name, value := range instanceEnv {
+		if defaultValue, ok := defaults[name]; !ok || defaultValue != value {
+			overrides[name] = value
+		}
+	}
+	return overrides, nil
+}
+
+// ToDotEnv returns the environment of the instance, from its .env file, along
+// with its core fields as EGN_INSTANCE_* variables, in the env file format of
+// docker compose, so the install can be recreated elsewhere. Variables are
+// sorted by name, and values are quoted and escaped when needed. A variable of
+// the environment hides the core field of the same name.
+func (i *Instance) ToDotEnv() ([]byte, error) {
+	instanceEnv, err := i.Env()
+	if err != nil {
+		return nil, err
+	}
+	coreFields := map[string]string{
+		"EGN_INSTANCE_NAME":         i.Name,
+		"EGN_INSTANCE_TAG":          i.Tag,
+		"EGN_INSTANCE_URL":          i.URL,
+		"EGN_INSTANCE_VERSION":      i.Version,
+		"EGN_INSTANCE_SPEC_VERSION": i.SpecVersion,
+		"EGN_INSTANCE_COMMIT":       i.Commit,
+		"EGN_INSTANCE_PROFILE":      i.Profile,
+	}
+	vars := make(map[string]string, len(instanceEnv)+len(coreFields))
+	for name, value := range coreFields {
+		if value != "" {
+			vars[name] = value
+		}
+	}
+	maps.Copy(vars, instanceEnv)
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		if name == "" || strings.ContainsAny(name, "=# \t\r\n\"'") {
+			return nil, fmt.Errorf("%w: invalid environment variable name %q", ErrInvalidInstance, name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Instance %s\n", i.ID())
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s=%s\n", name, dotEnvValue(vars[name]))
+	}
+	return buf.Bytes(), nil
+}
+
+// dotEnvValue returns the value as written in an env file. Values with
+// whitespace, quotes, comments, escapes or variable references are double
+// quoted, with the characters special inside double quotes escaped, so they are
+// read back as they are.
+func dotEnvValue(value string) string {
+	if !strings.ContainsAny(value, " \t\r\n\"'#$\\") {
+		return value
+	}
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		`$`, `\$`,
+		"\n", `\n`,
+		"\r", `\r`,
+		"\t", `\t`,
+	)
+	return `"` + r.Replace(value) + `"`
+}
+
+// SetMaintenance sets the maintenance mode of the instance and persists it in
+// the state.json file. While in maintenance mode, the instance can't be
+// mutated unless forced.
+func (i *Instance) SetMaintenance(maintenance bool) (err error) {
+	err = i.lock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		unlockErr := i.unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+	i.Maintenance = maintenance
+	return i.saveState()
+}
+
+// SetLabel sets the label with the given name of the instance to value and
+// persists it in the state.json file, replacing any previous value. Labels are
+// free-form metadata for the operator's bookkeeping, they are not part of the
+// instance id nor validated.
+func (i *Instance) SetLabel(name, value string) (err error) {
+	if name == "" {
+		return fmt.Errorf("%w: label name is empty", ErrInvalidInstance)
+	}
+	err = i.lock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		unlockErr := i.unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+	if i.Labels == nil {
+		i.Labels = make(map[string]string)
+	}
+	i.Labels[name] = value
+	if err := i.saveState(); err != nil {
+		return err
+	}
+	return i.updateLabelIndex()
+}
+
+// RemoveLabel removes the label with the given name of the instance and
+// persists the change in the state.json file. Removing a missing label does
+// nothing.
+func (i *Instance) RemoveLabel(name string) (err error) {
+	if _, ok := i.Labels[name]; !ok {
+		return nil
+	}
+	err = i.lock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		unlockErr := i.unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+	delete(i.Labels, name)
+	if len(i.Labels) == 0 {
+		i.Labels = nil
+	}
+	if err := i.saveState(); err != nil {
+		return err
+	}
+	return i.updateLabelIndex()
+}
+
+// updateLabelIndex sets the labels of the instance in the label index of its
+// nodes directory.
+func (i *Instance) updateLabelIndex() error {
+	return updateLabelIndex(i.fs, filepath.Dir(i.path), i.ID(), i.Labels, false, i.syncState)
+}
+
+// CompareVersion compares the instance version with the given one, as semantic
+// versions. The result is 0 if they are equal, -1 if the instance version is
+// lower, and +1 if it is greater. Both versions must be valid semvers.
+func (i *Instance) CompareVersion(other string) (int, error) {
+	if !semver.IsValid(i.Version) {
+		return 0, fmt.Errorf("%w: version %q is not a valid semver", ErrInvalidVersion, i.Version)
+	}
+	if !semver.IsValid(other) {
+		return 0, fmt.Errorf("%w: version %q is not a valid semver", ErrInvalidVersion, other)
+	}
+	return semver.Compare(i.Version, other), nil
+}
+
+// saveState writes the instance state to the state.json file, or to the
+// state.json.gz file if the state is compressed.
+func (i *Instance) saveState() error {
+	stateData, err := marshalState(i, i.compactState)
+	if err != nil {
+		return err
+	}
+	return writeStateFile(i.fs, i.path, stateData, i.compressState, i.syncState)
+}
+
+// isLockless returns true if the given locker doesn't lock, so no lock files
+// are needed.
+func isLockless(l locker.Locker) bool {
+	_, ok := l.(*locker.NoLock)
+	return ok
+}
+
 // lock locks the .lock file of the instance.
 func (i *Instance) lock() error {
 	return i.locker.Lock()
@@ -301,27 +683,84 @@ func (i *Instance) unlock() error {
 	return i.locker.Unlock()
 }
 
+// validate checks the state of a new or updated instance. It reports every
+// problem found at once, joined in a single error matching ErrInvalidInstance.
 func (i *Instance) validate() error {
-	if i.Name == "" {
-		return fmt.Errorf("%w: name is empty", ErrInvalidInstance)
+	return i.validateState(true)
+}
+
+// validateStored checks the state of an installed instance when it is loaded.
+// It only enforces the rules every installed instance follows, so instances
+// installed before a stricter rule was added can still be loaded, see
+// validate.
+func (i *Instance) validateStored() error {
+	return i.validateState(false)
+}
+
+// validateState checks the instance state, with the rules of new and updated
+// instances if strict is true.
+func (i *Instance) validateState(strict bool) error {
+	var errs []error
+	if strings.TrimSpace(i.Name) == "" {
+		errs = append(errs, fmt.Errorf("%w: name is empty", ErrInvalidInstance))
+	} else if err := validateIdPart("name", i.Name); strict && err != nil {
+		errs = append(errs, err)
 	}
 	if i.URL == "" {
-		return fmt.Errorf("%w: url is empty", ErrInvalidInstance)
+		errs = append(errs, fmt.Errorf("%w: url is empty", ErrInvalidInstance))
+	} else if err := validateURL(i.URL); strict && err != nil {
+		errs = append(errs, err)
 	}
 	if i.Version == "" && i.Commit == "" {
-		return fmt.Errorf("%w: version and commit are empty", ErrInvalidInstance)
+		errs = append(errs, fmt.Errorf("%w: version and commit are empty", ErrInvalidInstance))
+	} else if strict && i.Version != "" && !i.AllowArbitraryVersion && !semver.IsValid(i.Version) {
+		errs = append(errs, fmt.Errorf("%w: version %q is not a valid semver", ErrInvalidInstance, i.Version))
 	}
 	if i.Profile == "" {
-		return fmt.Errorf("%w: profile is empty", ErrInvalidInstance)
+		errs = append(errs, fmt.Errorf("%w: profile is empty", ErrInvalidInstance))
 	}
-	if i.Tag == "" {
-		return fmt.Errorf("%w: tag is empty", ErrInvalidInstance)
+	if strings.TrimSpace(i.Tag) == "" {
+		errs = append(errs, fmt.Errorf("%w: tag is empty", ErrInvalidInstance))
+	} else if err := validateIdPart("tag", i.Tag); strict && err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, dependency := range i.DependsOn {
+		if dependency == i.ID() {
+			errs = append(errs, fmt.Errorf("%w: instance depends on itself", ErrInvalidInstance))
+		} else if !instanceIdPartRegex.MatchString(dependency) || strings.Contains(dependency, "..") {
+			errs = append(errs, fmt.Errorf("%w: invalid dependency %q", ErrInvalidInstance, dependency))
+		}
+	}
+
+	for name, port := range i.Ports {
+		if name == "" {
+			errs = append(errs, fmt.Errorf("%w: port name is empty", ErrInvalidInstance))
+		}
+		if port < 1 || port > 65535 {
+			errs = append(errs, fmt.Errorf("%w: invalid %s port %d", ErrInvalidInstance, name, port))
+		}
 	}
 
 	if i.Plugin != nil {
 		if err := i.Plugin.validate(); err != nil {
-			return err
+			errs = append(errs, err)
 		}
 	}
+	return errors.Join(errs...)
+}
+
+// validateURL checks that the given URL is an absolute URL with scheme and host.
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: invalid url %q: %s", ErrInvalidInstance, rawURL, err)
+	}
+	if u.Scheme == "" {
+		return fmt.Errorf("%w: invalid url %q: missing scheme", ErrInvalidInstance, rawURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%w: invalid url %q: missing host", ErrInvalidInstance, rawURL)
+	}
 	return nil
 }
@@ -1,15 +1,20 @@
 package data
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"maps"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/NethermindEth/eigenlayer/internal/common"
 	"github.com/NethermindEth/eigenlayer/internal/data/testdata"
+	"github.com/NethermindEth/eigenlayer/internal/locker"
 	"github.com/NethermindEth/eigenlayer/internal/locker/mocks"
+	"github.com/compose-spec/compose-go/dotenv"
 	"github.com/golang/mock/gomock"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
@@ -213,6 +218,31 @@ func TestNewInstance(t *testing.T) {
 	}
 }
 
+func TestNewInstance_AltStateFile(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	instancePath := filepath.Join(dataDir.NodesPath(), "mock-avs-default")
+	require.NoError(t, fs.MkdirAll(instancePath, 0o755))
+	state := `{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","profile":"option-returner","tag":"default"}`
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, "instance.json"), []byte(state), 0o644))
+
+	instance, err := dataDir.Instance("mock-avs-default")
+	require.NoError(t, err)
+	assert.Equal(t, "mock-avs", instance.Name)
+	assert.Equal(t, "v5.5.1", instance.Version)
+
+	// Writes go to state.json, which is read from then on
+	require.NoError(t, instance.SetLabel("env", "prod"))
+	assert.FileExists(t, filepath.Join(instancePath, "state.json"))
+	altState, err := afero.ReadFile(fs, filepath.Join(instancePath, "instance.json"))
+	require.NoError(t, err)
+	assert.Equal(t, state, string(altState))
+	instance, err = dataDir.Instance("mock-avs-default")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod"}, instance.Labels)
+}
+
 func TestInstance_Init(t *testing.T) {
 	// TODO: Use always the latest version of mock-avs
 	ts := []struct {
@@ -262,13 +292,14 @@ func TestInstance_Init(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			locker := mocks.NewMockLocker(ctrl)
 
-			path := t.TempDir()
+			nodesPath := t.TempDir()
+			path := filepath.Join(nodesPath, "test_name-test_tag")
 
 			if tc.mocker != nil {
 				tc.mocker(path, locker)
 			}
 
-			err := tc.instance.init(path, fs, locker)
+			err := tc.instance.init(nodesPath, path, fs, locker)
 			if tc.err != nil {
 				assert.ErrorIs(t, err, tc.err)
 			} else {
@@ -277,12 +308,47 @@ func TestInstance_Init(t *testing.T) {
 				assert.NoError(t, err)
 				stateData, err := io.ReadAll(stateFile)
 				assert.NoError(t, err)
-				assert.Equal(t, tc.stateJSON, stateData)
+				// Written indented, with a trailing newline
+				var want bytes.Buffer
+				require.NoError(t, json.Indent(&want, tc.stateJSON, "", "  "))
+				want.WriteByte('\n')
+				assert.Equal(t, want.String(), string(stateData))
 			}
 		})
 	}
 }
 
+func TestInstance_InitOutsideNodes(t *testing.T) {
+	paths := []string{
+		"/data/nodes/../../etc",
+		"/data/nodes/../mock-avs-default",
+		"/data/nodes/a/../../mock-avs-default",
+		"/data/nodes/mock-avs-default/nested",
+		"/data/nodes",
+		"/data/nodes/..",
+		"/tmp/mock-avs-default",
+		"mock-avs-default",
+	}
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			i := Instance{
+				Name:    "mock-avs",
+				URL:     common.MockAvsPkg.Repo(),
+				Version: common.MockAvsPkg.Version(),
+				Profile: "option-returner",
+				Tag:     "default",
+			}
+			// The locker is never used
+			err := i.init("/data/nodes", path, fs, nil)
+			assert.ErrorIs(t, err, ErrInvalidInstanceDir)
+			entries, err := afero.ReadDir(fs, "/")
+			require.NoError(t, err)
+			assert.Empty(t, entries, "files written outside the nodes directory")
+		})
+	}
+}
+
 func TestInstance_Setup(t *testing.T) {
 	fs := afero.NewMemMapFs()
 	instancePath, err := afero.TempDir(fs, "", "instance")
@@ -306,7 +372,7 @@ func TestInstance_Setup(t *testing.T) {
 		Profile: "option-returner",
 		Tag:     "test-tag",
 	}
-	err = i.init(instancePath, fs, locker)
+	err = i.init(filepath.Dir(instancePath), instancePath, fs, locker)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -439,3 +505,745 @@ func TestInstance_ComposeProject(t *testing.T) {
 	// Check main-service container name
 	require.Equal(t, "main-service", mainService.ContainerName)
 }
+
+func TestInstance_Fingerprint(t *testing.T) {
+	newTestInstance := func(path string) *Instance {
+		return &Instance{
+			Name:    "test_name",
+			Tag:     "test_tag",
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Commit:  common.MockAvsPkg.CommitHash(),
+			Profile: "mainnet",
+			MonitoringTargets: MonitoringTargets{
+				Targets: []MonitoringTarget{
+					{Service: "main-service", Port: "9090", Path: "/metrics"},
+				},
+			},
+			Plugin: &Plugin{Image: "mock-avs-plugin:latest"},
+			path:   path,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Instance)
+		equal  bool
+	}{
+		{
+			name:   "same config, different path",
+			modify: func(i *Instance) { i.path = "/other/path" },
+			equal:  true,
+		},
+		{
+			name:   "different version",
+			modify: func(i *Instance) { i.Version = "v99.0.0" },
+			equal:  false,
+		},
+		{
+			name:   "different monitoring target",
+			modify: func(i *Instance) { i.MonitoringTargets.Targets[0].Port = "9091" },
+			equal:  false,
+		},
+		{
+			name:   "no plugin",
+			modify: func(i *Instance) { i.Plugin = nil },
+			equal:  false,
+		},
+		{
+			name:   "maintenance mode",
+			modify: func(i *Instance) { i.Maintenance = true },
+			equal:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := newTestInstance("/path").Fingerprint()
+			require.NoError(t, err)
+
+			i := newTestInstance("/path")
+			tt.modify(i)
+			got, err := i.Fingerprint()
+			require.NoError(t, err)
+
+			if tt.equal {
+				assert.Equal(t, want, got)
+			} else {
+				assert.NotEqual(t, want, got)
+			}
+		})
+	}
+}
+
+func TestInstance_ValidateURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{
+			name: "github repository",
+			url:  common.MockAvsPkg.Repo(),
+		},
+		{
+			name: "http with port and path",
+			url:  "http://localhost:8080/mock-avs-pkg",
+		},
+		{
+			name:    "missing scheme",
+			url:     "github.com/NethermindEth/mock-avs-pkg",
+			wantErr: true,
+		},
+		{
+			name:    "missing host",
+			url:     "https:///NethermindEth/mock-avs-pkg",
+			wantErr: true,
+		},
+		{
+			name:    "garbage",
+			url:     "::not a url::",
+			wantErr: true,
+		},
+		{
+			name:    "control characters",
+			url:     "https://github.com/\x7f",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := Instance{
+				Name:    "test_name",
+				Tag:     "test_tag",
+				URL:     tt.url,
+				Version: common.MockAvsPkg.Version(),
+				Profile: "mainnet",
+			}
+			err := i.validate()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidInstance)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestInstance_ValidateNameAndTag(t *testing.T) {
+	tests := []struct {
+		name    string
+		tag     string
+		iName   string
+		wantErr bool
+	}{
+		{name: "valid", iName: "mock-avs", tag: "default"},
+		{name: "valid with dots and underscores", iName: "mock_avs.v2", tag: "test_tag-1"},
+		{name: "tag with path separator", iName: "mock-avs", tag: "a/b", wantErr: true},
+		{name: "tag escaping nodes dir", iName: "mock-avs", tag: "../../etc", wantErr: true},
+		{name: "tag with dot dot", iName: "mock-avs", tag: "a..b", wantErr: true},
+		{name: "tag with backslash", iName: "mock-avs", tag: `a\b`, wantErr: true},
+		{name: "tag starting with dot", iName: "mock-avs", tag: ".hidden", wantErr: true},
+		{name: "tag with spaces", iName: "mock-avs", tag: "my tag", wantErr: true},
+		{name: "name with path separator", iName: "../mock-avs", tag: "default", wantErr: true},
+		{name: "absolute name", iName: "/mock-avs", tag: "default", wantErr: true},
+		{name: "whitespace-only name", iName: " \t ", tag: "default", wantErr: true},
+		{name: "whitespace-only tag", iName: "mock-avs", tag: "  ", wantErr: true},
+		{name: "name with surrounding whitespace", iName: " mock-avs ", tag: "default", wantErr: true},
+		{name: "reserved name", iName: "monitoring", tag: "default", wantErr: true},
+		{name: "reserved tag", iName: "mock-avs", tag: "temp", wantErr: true},
+		{name: "reserved tag in another case", iName: "mock-avs", tag: "Backup", wantErr: true},
+		{name: "reserved word as part of the tag", iName: "mock-avs", tag: "plugin-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := Instance{
+				Name:    tt.iName,
+				Tag:     tt.tag,
+				URL:     common.MockAvsPkg.Repo(),
+				Version: common.MockAvsPkg.Version(),
+				Profile: "mainnet",
+			}
+			err := i.validate()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidInstance)
+			} else {
+				assert.NoError(t, err)
+			}
+			// The instance ID is always a single safe path element
+			id := InstanceId(tt.iName, tt.tag)
+			assert.Equal(t, id, filepath.Base(id))
+			assert.NotContains(t, id, "..")
+		})
+	}
+}
+
+func TestInstanceId(t *testing.T) {
+	tests := []struct {
+		iName string
+		tag   string
+		want  string
+	}{
+		{iName: "mock-avs", tag: "default", want: "mock-avs-default"},
+		{iName: "mock", tag: "avs-default", want: "mock-avs-default"},
+		{iName: "mock-avs-", tag: "-default", want: "mock-avs---default"},
+		{iName: "mock_avs.v2", tag: "test_tag-1", want: "mock_avs.v2-test_tag-1"},
+		{iName: "-", tag: "-", want: "---"},
+		{iName: "", tag: "", want: "-"},
+		{iName: "mock avs", tag: "a/b", want: "mock_avs-a_b"},
+		{iName: "../mock-avs", tag: "a..b", want: "___mock-avs-a__b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			id := InstanceId(tt.iName, tt.tag)
+			assert.Equal(t, tt.want, id)
+			// Stable, and the same for already sanitized names and tags
+			assert.Equal(t, id, InstanceId(tt.iName, tt.tag))
+			assert.Equal(t, id, InstanceId(sanitizeIdPart(tt.iName), sanitizeIdPart(tt.tag)))
+			// The same as the ID of the instance
+			i := Instance{Name: tt.iName, Tag: tt.tag}
+			assert.Equal(t, id, i.ID())
+		})
+	}
+}
+
+func TestInstance_ValidateVersion(t *testing.T) {
+	tests := []struct {
+		name           string
+		version        string
+		allowArbitrary bool
+		wantErr        bool
+	}{
+		{name: "release", version: "v1.2.3"},
+		{name: "pre-release", version: "v1.2.3-rc.1"},
+		{name: "build metadata", version: "v1.2.3+build.5"},
+		{name: "short", version: "v1.2"},
+		{name: "missing v prefix", version: "1.2.3", wantErr: true},
+		{name: "not a version", version: "latest", wantErr: true},
+		{name: "leading zeros", version: "v01.2.3", wantErr: true},
+		{name: "arbitrary allowed", version: "local", allowArbitrary: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := Instance{
+				Name:                  "mock-avs",
+				Tag:                   "default",
+				URL:                   common.MockAvsPkg.Repo(),
+				Version:               tt.version,
+				Profile:               "mainnet",
+				AllowArbitraryVersion: tt.allowArbitrary,
+			}
+			err := i.validate()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidInstance)
+				assert.ErrorContains(t, err, "not a valid semver")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDataDir_LoadLegacyInstance(t *testing.T) {
+	tests := []struct {
+		name       string
+		instanceId string
+		state      string
+	}{
+		{
+			name:       "url without scheme",
+			instanceId: "mock-avs-default",
+			state:      `{"name":"mock-avs","url":"github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","profile":"option-returner","tag":"default"}`,
+		},
+		{
+			name:       "local path url",
+			instanceId: "mock-avs-local",
+			state:      `{"name":"mock-avs","url":"/home/user/mock-avs-pkg","version":"v5.5.1","profile":"option-returner","tag":"local"}`,
+		},
+		{
+			name:       "local install version",
+			instanceId: "mock-avs-default",
+			state:      `{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"local","profile":"option-returner","tag":"default"}`,
+		},
+		{
+			name:       "git tag without v prefix",
+			instanceId: "mock-avs-default",
+			state:      `{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"1.2.3","profile":"option-returner","tag":"default"}`,
+		},
+		{
+			name:       "tag outside the allowlist",
+			instanceId: "mock-avs-my_tag",
+			state:      `{"name":"mock-avs","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","profile":"option-returner","tag":"my tag"}`,
+		},
+		{
+			name:       "reserved name",
+			instanceId: "backup-default",
+			state:      `{"name":"backup","url":"https://github.com/NethermindEth/mock-avs-pkg","version":"v5.5.1","profile":"option-returner","tag":"default"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewOsFs()
+			dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+			require.NoError(t, err)
+			instancePath := filepath.Join(dataDir.NodesPath(), tt.instanceId)
+			require.NoError(t, fs.MkdirAll(instancePath, 0o755))
+			require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, ".lock"), nil, 0o644))
+			require.NoError(t, afero.WriteFile(fs, filepath.Join(instancePath, stateFileName), []byte(tt.state), 0o644))
+
+			// Instances installed before the stricter rules still load
+			instance, err := dataDir.Instance(tt.instanceId)
+			require.NoError(t, err)
+			assert.Equal(t, tt.instanceId, instance.ID())
+			instances, err := dataDir.ListInstances()
+			require.NoError(t, err)
+			assert.Len(t, instances, 1)
+
+			// and are reported by Check
+			report, err := dataDir.Check()
+			require.NoError(t, err)
+			assert.Empty(t, report.ByKind(CheckInvalidInstance))
+			problems := report.ByKind(CheckNonconformingInstance)
+			require.Len(t, problems, 1)
+			assert.Equal(t, instancePath, problems[0].Path)
+		})
+	}
+}
+
+func TestInstance_CompareVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		other   string
+		want    int
+		wantErr bool
+	}{
+		{name: "equal", version: "v1.2.3", other: "v1.2.3", want: 0},
+		{name: "lower", version: "v1.2.3", other: "v1.10.0", want: -1},
+		{name: "greater", version: "v2.0.0", other: "v1.99.99", want: 1},
+		{name: "pre-release is lower", version: "v1.0.0-rc.1", other: "v1.0.0", want: -1},
+		{name: "build metadata ignored", version: "v1.0.0+a", other: "v1.0.0+b", want: 0},
+		{name: "invalid instance version", version: "local", other: "v1.0.0", wantErr: true},
+		{name: "invalid other version", version: "v1.0.0", other: "1.0.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := Instance{Version: tt.version}
+			got, err := i.CompareVersion(tt.other)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidVersion)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestInstance_ValidateReportsAllErrors(t *testing.T) {
+	i := Instance{
+		Name: "mock-avs",
+		Tag:  "default",
+		URL:  "not-a-url",
+	}
+	err := i.validate()
+	require.ErrorIs(t, err, ErrInvalidInstance)
+	assert.ErrorContains(t, err, `invalid url "not-a-url"`)
+	assert.ErrorContains(t, err, "version and commit are empty")
+	assert.ErrorContains(t, err, "profile is empty")
+	var joined interface{ Unwrap() []error }
+	require.ErrorAs(t, err, &joined)
+	assert.Len(t, joined.Unwrap(), 3)
+}
+
+func TestInstance_CommitAndDigest(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+
+	// Commit and digest are persisted
+	digest := "sha256:0cc3d1e4276253cf62cce80bc282f68b793238cc159fcb6b7d15e9b4708d33ec"
+	_, err = dataDir.InitInstance(&Instance{
+		Name:    "mock-avs",
+		Tag:     "pinned",
+		URL:     common.MockAvsPkg.Repo(),
+		Version: common.MockAvsPkg.Version(),
+		Commit:  common.MockAvsPkg.CommitHash(),
+		Digest:  digest,
+		Profile: "option-returner",
+	})
+	require.NoError(t, err)
+	instance, err := dataDir.Instance("mock-avs-pinned")
+	require.NoError(t, err)
+	assert.Equal(t, common.MockAvsPkg.CommitHash(), instance.Commit)
+	assert.Equal(t, digest, instance.Digest)
+
+	// Legacy state files without commit and digest are still valid
+	legacyPath := filepath.Join(dataDir.NodesPath(), "mock-avs-legacy")
+	require.NoError(t, fs.MkdirAll(legacyPath, 0o755))
+	state := `{"name":"mock-avs","url":"` + common.MockAvsPkg.Repo() + `","version":"` + common.MockAvsPkg.Version() + `","profile":"option-returner","tag":"legacy"}`
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(legacyPath, "state.json"), []byte(state), 0o644))
+	instance, err = dataDir.Instance("mock-avs-legacy")
+	require.NoError(t, err)
+	assert.Empty(t, instance.Commit)
+	assert.Empty(t, instance.Digest)
+	stateData, err := json.Marshal(instance)
+	require.NoError(t, err)
+	assert.NotContains(t, string(stateData), "digest")
+}
+
+func TestInstance_Labels(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	instanceId, err := dataDir.InitInstance(&Instance{
+		Name:    "mock-avs",
+		Tag:     "default",
+		URL:     common.MockAvsPkg.Repo(),
+		Version: common.MockAvsPkg.Version(),
+		Profile: "option-returner",
+	})
+	require.NoError(t, err)
+	instance, err := dataDir.Instance(instanceId)
+	require.NoError(t, err)
+	fingerprint, err := instance.Fingerprint()
+	require.NoError(t, err)
+
+	require.NoError(t, instance.SetLabel("owner", "team-a"))
+	require.NoError(t, instance.SetLabel("env", "staging"))
+	require.NoError(t, instance.SetLabel("owner", "team-b"))
+	assert.ErrorIs(t, instance.SetLabel("", "value"), ErrInvalidInstance)
+
+	loaded, err := dataDir.Instance(instanceId)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"owner": "team-b", "env": "staging"}, loaded.Labels)
+	assert.Equal(t, instanceId, loaded.ID())
+	loadedFingerprint, err := loaded.Fingerprint()
+	require.NoError(t, err)
+	assert.Equal(t, fingerprint, loadedFingerprint, "labels changed the fingerprint")
+
+	require.NoError(t, loaded.RemoveLabel("owner"))
+	require.NoError(t, loaded.RemoveLabel("missing"))
+	loaded, err = dataDir.Instance(instanceId)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "staging"}, loaded.Labels)
+
+	require.NoError(t, loaded.RemoveLabel("env"))
+	stateData, err := dataDir.RawInstanceState(instanceId)
+	require.NoError(t, err)
+	assert.NotContains(t, string(stateData), "labels")
+}
+
+func TestInstance_Ports(t *testing.T) {
+	fs := afero.NewOsFs()
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	newInstance := func(tag string, ports map[string]int) *Instance {
+		return &Instance{
+			Name:    "mock-avs",
+			Tag:     tag,
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Profile: "option-returner",
+			Ports:   ports,
+		}
+	}
+
+	instanceId, err := dataDir.InitInstance(newInstance("metrics", map[string]int{"metrics": 9090, "rpc": 8545}))
+	require.NoError(t, err)
+	loaded, err := dataDir.Instance(instanceId)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"metrics": 9090, "rpc": 8545}, loaded.Ports)
+	endpoint, err := loaded.MetricsEndpoint()
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:9090", endpoint)
+
+	instanceId, err = dataDir.InitInstance(newInstance("no-metrics", map[string]int{"p2p": 30303}))
+	require.NoError(t, err)
+	loaded, err = dataDir.Instance(instanceId)
+	require.NoError(t, err)
+	_, err = loaded.MetricsEndpoint()
+	assert.ErrorIs(t, err, ErrNoMetricsPort)
+
+	// Instances without ports don't store them
+	instanceId, err = dataDir.InitInstance(newInstance("no-ports", nil))
+	require.NoError(t, err)
+	stateData, err := dataDir.RawInstanceState(instanceId)
+	require.NoError(t, err)
+	assert.NotContains(t, string(stateData), `"ports":`)
+	loaded, err = dataDir.Instance(instanceId)
+	require.NoError(t, err)
+	_, err = loaded.MetricsEndpoint()
+	assert.ErrorIs(t, err, ErrNoMetricsPort)
+
+	_, err = dataDir.InitInstance(newInstance("invalid", map[string]int{"metrics": 70000}))
+	assert.ErrorIs(t, err, ErrInvalidInstance)
+	_, err = dataDir.InitInstance(newInstance("unnamed", map[string]int{"": 9090}))
+	assert.ErrorIs(t, err, ErrInvalidInstance)
+}
+
+func TestInstance_ToDotEnv(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	env := map[string]string{
+		"MAIN_PORT":    "8080",
+		"NODE_NAME":    "my node",
+		"GREETING":     `say "hi"`,
+		"APOSTROPHE":   "it's",
+		"HOME_PATH":    "$HOME/.eigen",
+		"WINDOWS_PATH": `C:\eigen\data`,
+		"HASHTAG":      "a #tag",
+		"EMPTY":        "",
+	}
+	var envData bytes.Buffer
+	for name, value := range env {
+		fmt.Fprintf(&envData, "%s=%s\n", name, value)
+	}
+	require.NoError(t, afero.WriteFile(fs, "/instance/.env", envData.Bytes(), 0o644))
+
+	ctrl := gomock.NewController(t)
+	l := mocks.NewMockLocker(ctrl)
+	gomock.InOrder(
+		l.EXPECT().Lock().Return(nil),
+		l.EXPECT().Locked().Return(true),
+		l.EXPECT().Unlock().Return(nil),
+	)
+	i := Instance{
+		Name:    "mock-avs",
+		Tag:     "default",
+		URL:     common.MockAvsPkg.Repo(),
+		Version: common.MockAvsPkg.Version(),
+		Profile: "mainnet",
+		path:    "/instance",
+		fs:      fs,
+		locker:  l,
+	}
+
+	dotEnv, err := i.ToDotEnv()
+	require.NoError(t, err)
+	assert.Contains(t, string(dotEnv), "NODE_NAME=\"my node\"\n")
+	assert.Contains(t, string(dotEnv), "MAIN_PORT=8080\n")
+
+	// The env file is read back by docker compose as it was
+	got, err := dotenv.Parse(bytes.NewReader(dotEnv))
+	require.NoError(t, err)
+	want := map[string]string{
+		"EGN_INSTANCE_NAME":    "mock-avs",
+		"EGN_INSTANCE_TAG":     "default",
+		"EGN_INSTANCE_URL":     common.MockAvsPkg.Repo(),
+		"EGN_INSTANCE_VERSION": common.MockAvsPkg.Version(),
+		"EGN_INSTANCE_PROFILE": "mainnet",
+	}
+	maps.Copy(want, env)
+	assert.Equal(t, want, got)
+}
+
+func TestDotEnvValue(t *testing.T) {
+	values := []string{"plain", "", "two words", "tab\there", "new\nline", `back\slash`, `"quoted"`, "'single'", "$VAR ${VAR}", "#comment", `\"$`}
+	for _, value := range values {
+		got, err := dotenv.Parse(strings.NewReader("KEY=" + dotEnvValue(value)))
+		require.NoError(t, err, value)
+		assert.Equal(t, value, got["KEY"], value)
+	}
+}
+
+func TestDiffInstances(t *testing.T) {
+	base := Instance{
+		Name:        "mock-avs",
+		URL:         "https://github.com/NethermindEth/mock-avs",
+		Version:     "v1.0.0",
+		SpecVersion: "v0.1.0",
+		Profile:     "option-returner",
+		Tag:         "default",
+		MonitoringTargets: MonitoringTargets{
+			Targets: []MonitoringTarget{{Service: "main-service", Port: "8080", Path: "/metrics"}},
+		},
+	}
+	tests := []struct {
+		name   string
+		old    *Instance
+		modify func(i *Instance)
+		want   []FieldDiff
+	}{
+		{
+			name:   "unchanged",
+			old:    &base,
+			modify: func(i *Instance) {},
+		},
+		{
+			name: "changed",
+			old:  &base,
+			modify: func(i *Instance) {
+				i.Version = "v1.1.0"
+				i.MonitoringTargets.Targets = []MonitoringTarget{{Service: "main-service", Port: "9090", Path: "/metrics"}}
+			},
+			want: []FieldDiff{
+				{
+					Field: "monitoring",
+					Old:   map[string]interface{}{"targets": []interface{}{map[string]interface{}{"service": "main-service", "port": "8080", "path": "/metrics"}}},
+					New:   map[string]interface{}{"targets": []interface{}{map[string]interface{}{"service": "main-service", "port": "9090", "path": "/metrics"}}},
+				},
+				{Field: "version", Old: "v1.0.0", New: "v1.1.0"},
+			},
+		},
+		{
+			name: "added fields",
+			old:  &base,
+			modify: func(i *Instance) {
+				i.Commit = "a3406616b848164358fdd24465b8eecda5f5ae34"
+				i.Plugin = &Plugin{Image: "mock-avs-plugin:latest"}
+			},
+			want: []FieldDiff{
+				{Field: "commit", New: "a3406616b848164358fdd24465b8eecda5f5ae34"},
+				{Field: "plugin", New: map[string]interface{}{"image": "mock-avs-plugin:latest"}},
+			},
+		},
+		{
+			name: "removed field",
+			old:  &Instance{Name: "mock-avs", Tag: "default", Digest: "sha256:abc"},
+			modify: func(i *Instance) {
+				i.Digest = ""
+			},
+			want: []FieldDiff{{Field: "digest", Old: "sha256:abc"}},
+		},
+		{
+			name: "volatile fields ignored",
+			old:  &base,
+			modify: func(i *Instance) {
+				i.Maintenance = true
+				i.Labels = map[string]string{"env": "prod"}
+			},
+		},
+		{
+			name:   "nil old instance",
+			modify: func(i *Instance) {},
+			want: []FieldDiff{
+				{Field: "monitoring", New: map[string]interface{}{"targets": nil}},
+				{Field: "name", New: ""},
+				{Field: "profile", New: ""},
+				{Field: "spec_version", New: ""},
+				{Field: "tag", New: ""},
+				{Field: "url", New: ""},
+				{Field: "version", New: ""},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var new Instance
+			if tt.old != nil {
+				new = *tt.old
+			}
+			tt.modify(&new)
+			assert.Equal(t, tt.want, DiffInstances(tt.old, &new))
+		})
+	}
+}
+
+func TestInstance_IndentedState(t *testing.T) {
+	fs := afero.NewOsFs()
+	newTestInstance := func() *Instance {
+		return &Instance{
+			Name:    "mock-avs",
+			Tag:     "default",
+			URL:     common.MockAvsPkg.Repo(),
+			Version: common.MockAvsPkg.Version(),
+			Profile: "option-returner",
+			MonitoringTargets: MonitoringTargets{
+				Targets: []MonitoringTarget{{Service: "main-service", Port: "8080", Path: "/metrics"}},
+			},
+			Labels: map[string]string{"env": "prod"},
+			Ports:  map[string]int{MetricsPortName: 9090},
+		}
+	}
+
+	dataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	instanceId, err := dataDir.InitInstance(newTestInstance())
+	require.NoError(t, err)
+	stateData, err := afero.ReadFile(fs, filepath.Join(dataDir.NodesPath(), instanceId, "state.json"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(stateData), "{\n  \"name\": \"mock-avs\",\n"))
+	assert.True(t, strings.HasSuffix(string(stateData), "}\n"))
+	// Round trip
+	loaded, err := dataDir.Instance(instanceId)
+	require.NoError(t, err)
+	loadedData, err := json.Marshal(loaded)
+	require.NoError(t, err)
+	var compactData bytes.Buffer
+	require.NoError(t, json.Compact(&compactData, stateData))
+	assert.Equal(t, compactData.String(), string(loadedData))
+	require.NoError(t, loaded.SetLabel("owner", "team-a"))
+	stateData, err = afero.ReadFile(fs, filepath.Join(dataDir.NodesPath(), instanceId, "state.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(stateData), "\n    \"owner\": \"team-a\"\n")
+
+	// Compact states
+	compactDataDir, err := NewDataDir(t.TempDir(), fs, locker.NewFLock(), WithCompactState())
+	require.NoError(t, err)
+	instanceId, err = compactDataDir.InitInstance(newTestInstance())
+	require.NoError(t, err)
+	stateData, err = afero.ReadFile(fs, filepath.Join(compactDataDir.NodesPath(), instanceId, "state.json"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(stateData), "\n")
+	loaded, err = compactDataDir.Instance(instanceId)
+	require.NoError(t, err)
+	loadedData, err = json.Marshal(loaded)
+	require.NoError(t, err)
+	assert.Equal(t, string(stateData), string(loadedData))
+}
+
+func TestInstance_EnvOverrides(t *testing.T) {
+	defaults := map[string]string{
+		"MAIN_PORT":    "8080",
+		"NETWORK_NAME": "eigenlayer",
+		"LOG_LEVEL":    "info",
+	}
+	tests := []struct {
+		name string
+		env  string
+		want map[string]string
+	}{
+		{
+			name: "unchanged",
+			env:  "MAIN_PORT=8080\nNETWORK_NAME=eigenlayer\nLOG_LEVEL=info\n",
+			want: map[string]string{},
+		},
+		{
+			name: "changed",
+			env:  "MAIN_PORT=9090\nNETWORK_NAME=eigenlayer\nLOG_LEVEL=\n",
+			want: map[string]string{"MAIN_PORT": "9090", "LOG_LEVEL": ""},
+		},
+		{
+			name: "added",
+			env:  "MAIN_PORT=8080\nNETWORK_NAME=eigenlayer\nLOG_LEVEL=info\nEXTRA=1\n",
+			want: map[string]string{"EXTRA": "1"},
+		},
+		{
+			name: "missing defaults",
+			env:  "MAIN_PORT=8080\n",
+			want: map[string]string{},
+		},
+		{
+			name: "empty env",
+			env:  "",
+			want: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			require.NoError(t, afero.WriteFile(fs, "/instance/.env", []byte(tt.env), 0o644))
+			ctrl := gomock.NewController(t)
+			l := mocks.NewMockLocker(ctrl)
+			gomock.InOrder(
+				l.EXPECT().Lock().Return(nil),
+				l.EXPECT().Locked().Return(true),
+				l.EXPECT().Unlock().Return(nil),
+			)
+			i := Instance{path: "/instance", fs: fs, locker: l}
+
+			overrides, err := i.EnvOverrides(defaults)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, overrides)
+		})
+	}
+}
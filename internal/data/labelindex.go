@@ -0,0 +1,230 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+const labelIndexFileName = ".index.json"
+
+// labelIndexMu serializes the updates of the label index of the process.
+// Updates racing from different processes can leave the index stale, which
+// Check reports and GC fixes.
+var labelIndexMu sync.Mutex
+
+// labelIndex maps label names to label values to the sorted ids of the
+// instances with that label.
+type labelIndex map[string]map[string][]string
+
+// add adds the instance with the given id and labels to the index.
+func (idx labelIndex) add(instanceId string, labels map[string]string) {
+	for name, value := range labels {
+		if idx[name] == nil {
+			idx[name] = make(map[string][]string)
+		}
+		ids := idx[name][value]
+		if i, found := slices.BinarySearch(ids, instanceId); !found {
+			idx[name][value] = slices.Insert(ids, i, instanceId)
+		}
+	}
+}
+
+// remove removes the instance with the given id from the index.
+func (idx labelIndex) remove(instanceId string) {
+	for name, values := range idx {
+		for value, ids := range values {
+			if i, found := slices.BinarySearch(ids, instanceId); found {
+				ids = slices.Delete(ids, i, i+1)
+				if len(ids) == 0 {
+					delete(values, value)
+				} else {
+					values[value] = ids
+				}
+			}
+		}
+		if len(values) == 0 {
+			delete(idx, name)
+		}
+	}
+}
+
+// labelIndexPath returns the path of the label index of the nodes directory.
+func labelIndexPath(nodesPath string) string {
+	return filepath.Join(nodesPath, labelIndexFileName)
+}
+
+// readLabelIndex reads the label index of the nodes directory. If the index
+// doesn't exist, the returned error matches os.ErrNotExist.
+func readLabelIndex(fs afero.Fs, nodesPath string) (labelIndex, error) {
+	data, err := afero.ReadFile(fs, labelIndexPath(nodesPath))
+	if err != nil {
+		return nil, err
+	}
+	idx := make(labelIndex)
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// writeLabelIndex writes the label index of the nodes directory.
+func writeLabelIndex(fs afero.Fs, nodesPath string, idx labelIndex, sync bool) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs, labelIndexPath(nodesPath), data, 0o644, sync)
+}
+
+// buildLabelIndex builds the label index from the states of the instances in
+// the nodes directory. Like Dependents, instances without a readable state
+// are skipped.
+func buildLabelIndex(fs afero.Fs, nodesPath string) (labelIndex, error) {
+	idx := make(labelIndex)
+	dirEntries, err := readDirIfExists(fs, nodesPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() || strings.HasSuffix(dirEntry.Name(), deletingSuffix) {
+			continue
+		}
+		stateData, _, err := readStateFile(fs, filepath.Join(nodesPath, dirEntry.Name()))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		var state struct {
+			Labels map[string]string `json:"labels"`
+		}
+		if err := json.Unmarshal(stateData, &state); err != nil {
+			continue
+		}
+		idx.add(dirEntry.Name(), state.Labels)
+	}
+	return idx, nil
+}
+
+// updateLabelIndex sets the labels of the instance with the given id in the
+// label index of the nodes directory, or removes the instance from the index
+// if labels is nil and remove is true. A missing or unreadable index is
+// rebuilt from the states instead, which must already be written. The index
+// is only written if it changed.
+func updateLabelIndex(fs afero.Fs, nodesPath, instanceId string, labels map[string]string, remove, sync bool) error {
+	labelIndexMu.Lock()
+	defer labelIndexMu.Unlock()
+	idx, err := readLabelIndex(fs, nodesPath)
+	if err != nil {
+		if os.IsNotExist(err) && len(labels) == 0 {
+			// Nothing to index, a missing index is an empty one
+			return nil
+		}
+		if idx, err = buildLabelIndex(fs, nodesPath); err != nil {
+			return err
+		}
+		return writeLabelIndex(fs, nodesPath, idx, sync)
+	}
+	before, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	idx.remove(instanceId)
+	if !remove {
+		idx.add(instanceId, labels)
+	}
+	after, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(before, after) {
+		return nil
+	}
+	return writeFileAtomic(fs, labelIndexPath(nodesPath), after, 0o644, sync)
+}
+
+// FindInstancesByLabel returns the sorted ids of the instances with the label
+// with the given name set to value, looked up in the label index of the data
+// dir instead of loading every instance. If the index doesn't exist yet, it is
+// built in memory from the states.
+func (d *DataDir) FindInstancesByLabel(name, value string) ([]string, error) {
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+	idx, err := readLabelIndex(d.fs, d.NodesPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		if idx, err = buildLabelIndex(d.fs, d.NodesPath()); err != nil {
+			return nil, err
+		}
+	}
+	ids := slices.Clone(idx[name][value])
+	if ids == nil {
+		ids = []string{}
+	}
+	return ids, nil
+}
+
+// checkLabelIndex reports a label index that doesn't match the labels of the
+// instances.
+func (d *DataDir) checkLabelIndex(r *CheckReport) error {
+	stale, err := d.labelIndexStale()
+	if err != nil {
+		return err
+	}
+	if stale != "" {
+		r.add(CheckStaleLabelIndex, labelIndexPath(d.NodesPath()), errors.New(stale))
+	}
+	return nil
+}
+
+// labelIndexStale returns why the label index is stale, or an empty string if
+// it is up to date. A missing index is up to date if no instance has labels.
+func (d *DataDir) labelIndexStale() (string, error) {
+	want, err := buildLabelIndex(d.fs, d.NodesPath())
+	if err != nil {
+		return "", err
+	}
+	stored, err := readLabelIndex(d.fs, d.NodesPath())
+	switch {
+	case os.IsNotExist(err):
+		if len(want) == 0 {
+			return "", nil
+		}
+		return "label index is missing", nil
+	case err != nil:
+		return fmt.Sprintf("label index is unreadable: %v", err), nil
+	case !reflect.DeepEqual(stored, want):
+		return "label index doesn't match the instance labels", nil
+	}
+	return "", nil
+}
+
+// rebuildLabelIndex rewrites the label index from the states of the
+// instances, if it is stale.
+func (d *DataDir) rebuildLabelIndex() error {
+	stale, err := d.labelIndexStale()
+	if err != nil || stale == "" {
+		return err
+	}
+	labelIndexMu.Lock()
+	defer labelIndexMu.Unlock()
+	idx, err := buildLabelIndex(d.fs, d.NodesPath())
+	if err != nil {
+		return err
+	}
+	return writeLabelIndex(d.fs, d.NodesPath(), idx, d.syncWrites)
+}
@@ -0,0 +1,144 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// MigrateLayout moves the instances stored by early versions directly in the
+// data dir root into the nodes directory, where ListInstances finds them. It
+// returns the ids of the moved instances. Only directories with a valid state
+// file are moved and the data dir directories are skipped, so running it again
+// moves nothing. Instances whose id is already taken in the nodes directory
+// are left in place and reported with ErrInstanceAlreadyExists. Once every
+// instance of a LayoutVersionLegacy data dir was moved, its marker records the
+// LayoutVersionNodes layout.
+func (d *DataDir) MigrateLayout() ([]string, error) {
+	if err := d.checkWritable(); err != nil {
+		return nil, err
+	}
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+	dirEntries, err := afero.ReadDir(d.fs, d.path)
+	if err != nil {
+		return nil, err
+	}
+	migrated := make([]string, 0)
+	var errs []error
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() || isDataDirEntry(dirEntry.Name()) {
+			continue
+		}
+		instanceId := dirEntry.Name()
+		legacyPath := filepath.Join(d.path, instanceId)
+		if !isLegacyInstanceDir(d.fs, legacyPath) {
+			continue
+		}
+		if err := d.fs.MkdirAll(d.NodesPath(), 0o755); err != nil {
+			return migrated, err
+		}
+		if d.HasInstance(instanceId) {
+			errs = append(errs, fmt.Errorf("%w: %s is also in %s", ErrInstanceAlreadyExists, instanceId, d.NodesPath()))
+			continue
+		}
+		if err := d.fs.Rename(legacyPath, filepath.Join(d.NodesPath(), instanceId)); err != nil {
+			return migrated, err
+		}
+		migrated = append(migrated, instanceId)
+	}
+	if len(errs) > 0 {
+		return migrated, errors.Join(errs...)
+	}
+	if d.layoutVersion < LayoutVersionNodes {
+		if err := d.upgradeMarkerLayout(); err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
+
+// upgradeMarkerLayout records the LayoutVersionNodes layout in the data dir
+// marker, keeping its creation time.
+func (d *DataDir) upgradeMarkerLayout() error {
+	marker, err := readMarker(d.fs, d.path)
+	if err != nil {
+		return err
+	}
+	if marker == nil {
+		marker = &dataDirMarker{SchemaVersion: dataDirSchemaVersion, CreatedAt: d.now().UTC()}
+	}
+	marker.LayoutVersion = LayoutVersionNodes
+	if err := d.writeMarker(*marker); err != nil {
+		return err
+	}
+	d.layoutVersion = LayoutVersionNodes
+	return nil
+}
+
+// isLegacyInstanceDir returns true if the directory at path has a valid
+// instance state file, like the instance directories of early versions stored
+// in the data dir root.
+func isLegacyInstanceDir(fs afero.Fs, path string) bool {
+	stateData, _, err := readStateFile(fs, path)
+	if err != nil {
+		return false
+	}
+	var instance Instance
+	if err := json.Unmarshal(stateData, &instance); err != nil {
+		return false
+	}
+	return instance.validateStored() == nil
+}
+
+// RepairInstanceIds renames the instance directories whose name is not the id
+// of their state, like the directories renamed by hand, to that id, together
+// with their plugin context. It returns the ids of the renamed instances.
+// Directories whose id is already taken by another directory are left in place
+// and reported with ErrInstanceAlreadyExists.
+func (d *DataDir) RepairInstanceIds() ([]string, error) {
+	if err := d.checkWritable(); err != nil {
+		return nil, err
+	}
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+	dirEntries, err := readDirIfExists(d.fs, d.NodesPath())
+	if err != nil {
+		return nil, err
+	}
+	renamed := make([]string, 0)
+	var errs []error
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() || strings.HasSuffix(dirEntry.Name(), deletingSuffix) {
+			continue
+		}
+		instance, err := d.Instance(dirEntry.Name())
+		if err != nil || instance.ID() == dirEntry.Name() {
+			continue
+		}
+		instanceId := instance.ID()
+		if d.HasInstance(instanceId) {
+			errs = append(errs, fmt.Errorf("%w: %s holds instance %s", ErrInstanceAlreadyExists, dirEntry.Name(), instanceId))
+			continue
+		}
+		if err := d.fs.Rename(filepath.Join(d.NodesPath(), dirEntry.Name()), filepath.Join(d.NodesPath(), instanceId)); err != nil {
+			return renamed, err
+		}
+		pluginContextPath := filepath.Join(d.PluginDirPath(), dirEntry.Name()+".tar")
+		if ok, err := afero.Exists(d.fs, pluginContextPath); err != nil {
+			return renamed, err
+		} else if ok {
+			if err := d.fs.Rename(pluginContextPath, filepath.Join(d.PluginDirPath(), instanceId+".tar")); err != nil {
+				return renamed, err
+			}
+		}
+		renamed = append(renamed, instanceId)
+	}
+	return renamed, errors.Join(errs...)
+}
@@ -0,0 +1,66 @@
+package data
+
+import (
+	"context"
+)
+
+// Close releases the resources of the data dir: the running operations, such
+// as DiskUsage walks and archive backups or restores, are canceled and waited
+// for. The data dir is unusable after Close, its operations return
+// ErrDataDirClosed. Closing a closed data dir does nothing.
+func (d *DataDir) Close() error {
+	d.lifecycleMu.Lock()
+	if d.closed {
+		d.lifecycleMu.Unlock()
+		return nil
+	}
+	d.closed = true
+	if d.done == nil {
+		d.done = make(chan struct{})
+	}
+	close(d.done)
+	d.lifecycleMu.Unlock()
+
+	d.operations.Wait()
+	return nil
+}
+
+// checkOpen returns ErrDataDirClosed if the data dir is closed.
+func (d *DataDir) checkOpen() error {
+	d.lifecycleMu.Lock()
+	defer d.lifecycleMu.Unlock()
+	if d.closed {
+		return ErrDataDirClosed
+	}
+	return nil
+}
+
+// startOperation registers a long running operation, which Close waits for.
+// The returned context is derived from ctx and also canceled by Close, and the
+// returned function must be called when the operation is done.
+func (d *DataDir) startOperation(ctx context.Context) (context.Context, func(), error) {
+	d.lifecycleMu.Lock()
+	if d.closed {
+		d.lifecycleMu.Unlock()
+		return nil, nil, ErrDataDirClosed
+	}
+	if d.done == nil {
+		d.done = make(chan struct{})
+	}
+	done := d.done
+	d.operations.Add(1)
+	d.lifecycleMu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		cancel()
+		d.operations.Done()
+	}, nil
+}
@@ -0,0 +1,74 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// InstanceLockState is an instance listed by ListInstancesWithLockState.
+type InstanceLockState struct {
+	Instance *Instance
+	// Locked is true if the instance was locked for writing by another
+	// process when listed.
+	Locked bool
+}
+
+// ListInstancesWithLockState is like ListInstances, but also reports which
+// instances are locked for writing, to find the commands waiting on them. The
+// lock of each instance is probed without waiting, like by InstanceBusy: the
+// instances that aren't locked are read while holding the shared lock taken
+// by the probe, which is always released, while the locked instances are read
+// without lock, as their state is written atomically, instead of being
+// skipped. The lock holders are never disturbed.
+func (d *DataDir) ListInstancesWithLockState() ([]InstanceLockState, error) {
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+	states := make([]InstanceLockState, 0)
+	dirEntries, err := afero.ReadDir(d.fs, d.NodesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return states, nil
+		}
+		return nil, err
+	}
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() || strings.HasSuffix(dirEntry.Name(), deletingSuffix) {
+			continue
+		}
+		state, err := d.instanceLockState(dirEntry.Name())
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// instanceLockState probes the lock of the instance with the given id, and
+// reads the instance under the probe lock if it isn't locked.
+func (d *DataDir) instanceLockState(instanceId string) (state InstanceLockState, err error) {
+	l := d.locker.New(filepath.Join(d.path, nodesDirName, instanceId, ".lock"))
+	ctx, cancel := context.WithTimeout(context.Background(), instanceBusyProbeTimeout)
+	defer cancel()
+	locked, err := l.TryRLockContext(ctx, instanceBusyProbeTimeout)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return state, err
+	}
+	if locked {
+		defer func() {
+			unlockErr := l.Unlock()
+			if err == nil {
+				err = unlockErr
+			}
+		}()
+	}
+	state.Locked = !locked
+	state.Instance, err = d.Instance(instanceId)
+	return state, err
+}
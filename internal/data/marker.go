@@ -0,0 +1,147 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	// dataDirMarkerName is the name of the file identifying a data dir.
+	dataDirMarkerName = ".eigen-datadir"
+	// dataDirSchemaVersion is the version of the data dir layout.
+	dataDirSchemaVersion = 1
+)
+
+const (
+	// LayoutVersionLegacy is the layout of the data dirs created by early
+	// versions, which may have instances in their root.
+	LayoutVersionLegacy = 1
+	// LayoutVersionNodes is the current layout, with all the instances in the
+	// nodes directory.
+	LayoutVersionNodes = 2
+)
+
+// dataDirMarker is the content of the data dir marker file.
+type dataDirMarker struct {
+	SchemaVersion int       `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	// LayoutVersion is zero in the markers written before it was recorded,
+	// which are read as LayoutVersionLegacy.
+	LayoutVersion int `json:"layout_version,omitempty"`
+}
+
+// WithForceInit allows initializing a data dir in a directory containing files
+// that are not part of a data dir.
+func WithForceInit() DataDirOption {
+	return func(d *DataDir) {
+		d.forceInit = true
+	}
+}
+
+// IsDataDir returns true if the directory at path has a data dir marker file.
+func IsDataDir(fs afero.Fs, path string) (bool, error) {
+	marker, err := readMarker(fs, path)
+	return marker != nil, err
+}
+
+// readMarker returns the marker of the data dir at path, and nil if it has
+// none.
+func readMarker(fs afero.Fs, path string) (*dataDirMarker, error) {
+	markerData, err := afero.ReadFile(fs, filepath.Join(path, dataDirMarkerName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var marker dataDirMarker
+	if err := json.Unmarshal(markerData, &marker); err != nil {
+		return nil, fmt.Errorf("%w: %s: invalid %s file: %s", ErrNotDataDir, path, dataDirMarkerName, err)
+	}
+	if marker.LayoutVersion == 0 {
+		marker.LayoutVersion = LayoutVersionLegacy
+	}
+	return &marker, nil
+}
+
+// LayoutVersion returns the layout version of the data dir, recorded in its
+// marker. Data dirs with the LayoutVersionLegacy layout may have instances in
+// their root, moved by MigrateLayout.
+func (d *DataDir) LayoutVersion() int {
+	return d.layoutVersion
+}
+
+// initMarker writes the marker file of the data dir, creating the data dir if
+// needed. Directories created before the marker was introduced are adopted,
+// including the ones with instances in their root, left by early versions
+// until MigrateLayout moves them. Directories containing files that are not
+// part of a data dir are refused, unless the data dir is forced. Read-only data
+// dirs are left as they are. The layout version of the data dir is loaded
+// from its marker, and is LayoutVersionLegacy for the adopted directories with
+// instances in their root.
+func (d *DataDir) initMarker() error {
+	marker, err := readMarker(d.fs, d.path)
+	if err != nil {
+		return err
+	}
+	if marker != nil {
+		d.layoutVersion = marker.LayoutVersion
+		return nil
+	}
+	d.layoutVersion = LayoutVersionNodes
+	if d.readOnly {
+		return nil
+	}
+	if err = d.fs.MkdirAll(d.path, 0o755); err != nil {
+		return err
+	}
+	dirEntries, err := afero.ReadDir(d.fs, d.path)
+	if err != nil {
+		return err
+	}
+	for _, dirEntry := range dirEntries {
+		if isDataDirEntry(dirEntry.Name()) {
+			continue
+		}
+		if dirEntry.IsDir() && isLegacyInstanceDir(d.fs, filepath.Join(d.path, dirEntry.Name())) {
+			d.layoutVersion = LayoutVersionLegacy
+		} else if !d.forceInit {
+			return fmt.Errorf("%w: %s contains %s, which is not part of a data dir", ErrNotDataDir, d.path, dirEntry.Name())
+		}
+	}
+	return d.writeMarker(dataDirMarker{
+		SchemaVersion: dataDirSchemaVersion,
+		CreatedAt:     d.now().UTC(),
+		LayoutVersion: d.layoutVersion,
+	})
+}
+
+// writeMarker replaces the marker file of the data dir.
+func (d *DataDir) writeMarker(marker dataDirMarker) error {
+	markerData, err := json.Marshal(marker)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(d.fs, filepath.Join(d.path, dataDirMarkerName), markerData, 0o644, d.syncWrites)
+}
+
+// isDataDirEntry returns true if name is an entry of the data dir root.
+func isDataDirEntry(name string) bool {
+	switch name {
+	case nodesDirName, tempDir, pluginsDir, backupDir, monitoringStackDirName, dataDirMarkerName, dataDirLockName, auditLogName:
+		return true
+	}
+	return isMonitoringStackDir(name)
+}
+
+// isMonitoringStackDir returns true if name is the name of the directory of a
+// monitoring stack in the data dir root.
+func isMonitoringStackDir(name string) bool {
+	return name == monitoringStackDirName || strings.HasPrefix(name, monitoringStackDirName+"-")
+}
@@ -0,0 +1,80 @@
+package data
+
+import (
+	"bytes"
+
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// MetricsText returns a summary of the data dir in the Prometheus text
+// exposition format, for the node exporter textfile collector:
+//
+//   - eigen_instances_total: the number of instances, by profile.
+//   - eigen_backups_total: the number of backups, by instance id.
+//   - eigen_backups_bytes: the total size of the backups.
+//   - eigen_datadir_bytes: the total size of the instance directories, see
+//     DiskUsage.
+func (d *DataDir) MetricsText() ([]byte, error) {
+	instances := promclient.NewGaugeVec(promclient.GaugeOpts{
+		Namespace: "eigen",
+		Name:      "instances_total",
+		Help:      "Number of instances in the data dir.",
+	}, []string{"profile"})
+	backups := promclient.NewGaugeVec(promclient.GaugeOpts{
+		Namespace: "eigen",
+		Name:      "backups_total",
+		Help:      "Number of backups in the data dir.",
+	}, []string{"instance_id"})
+	backupsBytes := promclient.NewGauge(promclient.GaugeOpts{
+		Namespace: "eigen",
+		Name:      "backups_bytes",
+		Help:      "Total size in bytes of the backups.",
+	})
+	dataDirBytes := promclient.NewGauge(promclient.GaugeOpts{
+		Namespace: "eigen",
+		Name:      "datadir_bytes",
+		Help:      "Total size in bytes of the instance directories.",
+	})
+
+	err := d.WalkInstances(func(instance *Instance) error {
+		instances.WithLabelValues(instance.Profile).Inc()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	backupsByInstance, err := d.BackupsByInstance()
+	if err != nil {
+		return nil, err
+	}
+	for instanceId, instanceBackups := range backupsByInstance {
+		backups.WithLabelValues(instanceId).Set(float64(len(instanceBackups)))
+		for _, backup := range instanceBackups {
+			size, err := d.BackupSize(backup.Id())
+			if err != nil {
+				return nil, err
+			}
+			backupsBytes.Add(float64(size))
+		}
+	}
+	usage, err := d.DiskUsage()
+	if err != nil {
+		return nil, err
+	}
+	dataDirBytes.Set(float64(usage))
+
+	registry := promclient.NewPedanticRegistry()
+	registry.MustRegister(instances, backups, backupsBytes, dataDirBytes)
+	families, err := registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(&out, family); err != nil {
+			return nil, err
+		}
+	}
+	return out.Bytes(), nil
+}
@@ -7,16 +7,28 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
 
 	"github.com/NethermindEth/eigenlayer/internal/locker"
 	"github.com/spf13/afero"
 )
 
+// monitoringStackFiles are the files of an installed monitoring stack.
+var monitoringStackFiles = []string{
+	".env",
+	"docker-compose.yml",
+}
+
 // MonitoringStack represents the data stored about the monitoring stack
 type MonitoringStack struct {
 	path string
 	fs   afero.Fs
 	l    locker.Locker
+	// mu serializes the access to the stack inside the same process, the
+	// file lock alone is not enough because the locker is shared.
+	mu sync.Mutex
 }
 
 // newMonitoringStack creates a new monitoring stack with the given path as root.
@@ -38,20 +50,83 @@ func (m *MonitoringStack) Init() error {
 
 // Lock locks the monitoring stack
 func (m *MonitoringStack) lock() error {
+	m.mu.Lock()
 	if m.l == nil {
+		m.mu.Unlock()
 		return ErrStackNotInitialized
 	}
-	return m.l.Lock()
+	if err := m.l.Lock(); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	return nil
 }
 
 // Unlock unlocks the monitoring stack
 func (m *MonitoringStack) unlock() error {
+	defer m.mu.Unlock()
 	if m.l == nil || !m.l.Locked() {
 		return errors.New("monitoring stack is not locked")
 	}
 	return m.l.Unlock()
 }
 
+// LockedMonitoringStack gives access to the monitoring stack files while the
+// stack lock is held. It is only valid inside a WithLock callback.
+type LockedMonitoringStack struct {
+	m *MonitoringStack
+}
+
+// ReadFile reads the file at the given path in the monitoring stack.
+func (l *LockedMonitoringStack) ReadFile(path string) ([]byte, error) {
+	return l.m.readFile(path)
+}
+
+// WriteFile writes the given data to the file at the given path in the
+// monitoring stack. It creates the file if it doesn't exist.
+func (l *LockedMonitoringStack) WriteFile(path string, data []byte) error {
+	return l.m.writeFile(path, data)
+}
+
+// WriteSecretFile is like WriteFile, but the file is only readable by its
+// owner and is replaced atomically, so a rotated secret is never read half
+// written. The missing parent directories are created, only accessible by
+// their owner.
+func (l *LockedMonitoringStack) WriteSecretFile(path string, data []byte) error {
+	fullPath := filepath.Join(l.m.path, path)
+	if err := l.m.fs.MkdirAll(filepath.Dir(fullPath), 0o700); err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingFile, err)
+	}
+	if err := writeFileAtomic(l.m.fs, fullPath, data, 0o600, false); err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingFile, err)
+	}
+	return nil
+}
+
+// RemoveAll removes the file or directory at the given path in the monitoring
+// stack, with its content. A missing path is not an error.
+func (l *LockedMonitoringStack) RemoveAll(path string) error {
+	return l.m.fs.RemoveAll(filepath.Join(l.m.path, path))
+}
+
+// WithLock runs fn while holding the monitoring stack lock, so a
+// read-modify-write sequence done through the given LockedMonitoringStack is
+// atomic.
+func (m *MonitoringStack) WithLock(fn func(s *LockedMonitoringStack) error) (err error) {
+	err = m.lock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		unlockErr := m.unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+
+	return fn(&LockedMonitoringStack{m: m})
+}
+
 // Setup sets up the monitoring stack with the given environment variables and
 // docker-compose.yml file.
 func (m *MonitoringStack) Setup(env map[string]string, monitoringFs fs.FS) (err error) {
@@ -141,11 +216,15 @@ func (m *MonitoringStack) ReadFile(path string) (data []byte, err error) {
 		}
 	}()
 
-	data, err = afero.ReadFile(m.fs, filepath.Join(m.path, path))
+	return m.readFile(path)
+}
+
+func (m *MonitoringStack) readFile(path string) ([]byte, error) {
+	data, err := afero.ReadFile(m.fs, filepath.Join(m.path, path))
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrReadingFile, err)
 	}
-	return
+	return data, nil
 }
 
 // WriteFile writes the given data to the file at the given path in the monitoring stack.
@@ -163,13 +242,131 @@ func (m *MonitoringStack) WriteFile(path string, data []byte) (err error) {
 		}
 	}()
 
-	err = afero.WriteFile(m.fs, filepath.Join(m.path, path), data, 0o644)
+	return m.writeFile(path, data)
+}
+
+func (m *MonitoringStack) writeFile(path string, data []byte) error {
+	err := afero.WriteFile(m.fs, filepath.Join(m.path, path), data, 0o644)
 	if err != nil {
 		return fmt.Errorf("%w: %w", ErrWritingFile, err)
 	}
 	return nil
 }
 
+// WriteFiles writes the given files, keyed by their path in the monitoring
+// stack, all or nothing. The files are written to a staging directory first
+// and then moved into place, so if any write fails the stack is left as it
+// was, with the replaced files restored and the created directories removed.
+func (m *MonitoringStack) WriteFiles(files map[string][]byte) (err error) {
+	err = m.lock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		unlockErr := m.unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+
+	return m.writeFiles(files)
+}
+
+// movedFile is a staged file moved into place by writeFiles, with what is
+// needed to undo the move.
+type movedFile struct {
+	target string
+	// replaced is the path the replaced file was moved to, empty if the
+	// target didn't exist.
+	replaced string
+	// createdDir is the topmost directory created for the target, empty if
+	// none was created.
+	createdDir string
+}
+
+func (m *MonitoringStack) writeFiles(files map[string][]byte) (err error) {
+	stagingPath, err := afero.TempDir(m.fs, m.path, ".staging-")
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingFile, err)
+	}
+	defer m.fs.RemoveAll(stagingPath)
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for i, path := range paths {
+		if err = afero.WriteFile(m.fs, filepath.Join(stagingPath, strconv.Itoa(i)), files[path], 0o644); err != nil {
+			return fmt.Errorf("%w: %w", ErrWritingFile, err)
+		}
+	}
+
+	var moved []movedFile
+	defer func() {
+		if err != nil {
+			m.undoMoves(moved)
+		}
+	}()
+	for i, path := range paths {
+		staged := filepath.Join(stagingPath, strconv.Itoa(i))
+		move := movedFile{target: filepath.Join(m.path, path)}
+		if move.createdDir, err = m.createParentDirs(move.target); err != nil {
+			return fmt.Errorf("%w: %w", ErrWritingFile, err)
+		}
+		if _, statErr := m.fs.Stat(move.target); statErr == nil {
+			move.replaced = staged + ".old"
+			if err = m.fs.Rename(move.target, move.replaced); err != nil {
+				return fmt.Errorf("%w: %w", ErrWritingFile, err)
+			}
+		}
+		if err = m.fs.Rename(staged, move.target); err != nil {
+			// Undo the partial move of this file with the others
+			moved = append(moved, move)
+			return fmt.Errorf("%w: %w", ErrWritingFile, err)
+		}
+		moved = append(moved, move)
+	}
+	return nil
+}
+
+// createParentDirs creates the missing parent directories of the given path,
+// and returns the topmost one it created, or an empty string if all existed.
+func (m *MonitoringStack) createParentDirs(path string) (string, error) {
+	var created string
+	for dir := filepath.Dir(path); dir != m.path && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if _, err := m.fs.Stat(dir); err == nil {
+			break
+		}
+		created = dir
+	}
+	if created == "" {
+		return "", nil
+	}
+	if err := m.fs.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		m.fs.RemoveAll(created)
+		return "", err
+	}
+	return created, nil
+}
+
+// undoMoves restores the state of the stack before the given moves, in
+// reverse order. It is best effort, as it runs after a failure.
+func (m *MonitoringStack) undoMoves(moved []movedFile) {
+	for i := len(moved) - 1; i >= 0; i-- {
+		move := moved[i]
+		if move.replaced != "" {
+			m.fs.Remove(move.target)
+			m.fs.Rename(move.replaced, move.target)
+		} else if _, err := m.fs.Stat(move.target); err == nil {
+			m.fs.Remove(move.target)
+		}
+		if move.createdDir != "" {
+			m.fs.RemoveAll(move.createdDir)
+		}
+	}
+}
+
 // Installed checks if the monitoring stack is installed.
 func (m *MonitoringStack) Installed() (installed bool, err error) {
 	err = m.lock()
@@ -186,11 +383,7 @@ func (m *MonitoringStack) Installed() (installed bool, err error) {
 		}
 	}()
 
-	toCheck := []string{
-		".env",
-		"docker-compose.yml",
-	}
-	for _, path := range toCheck {
+	for _, path := range monitoringStackFiles {
 		_, err = m.fs.Stat(filepath.Join(m.path, path))
 		if err != nil {
 			if errors.Is(err, os.ErrNotExist) {
@@ -219,6 +412,7 @@ func (m *MonitoringStack) Cleanup(force bool) (err error) {
 		defer func() {
 			// Reset locker
 			m.l = nil
+			m.mu.Unlock()
 		}()
 	}
 	return m.fs.RemoveAll(m.path)
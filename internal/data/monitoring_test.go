@@ -15,6 +15,7 @@ import (
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func TestInit(t *testing.T) {
@@ -910,3 +911,168 @@ func TestCleanup(t *testing.T) {
 		})
 	}
 }
+
+// failingRenameFs fails renaming files to the paths ending with failSuffix.
+type failingRenameFs struct {
+	afero.Fs
+	failSuffix string
+}
+
+func (fs *failingRenameFs) Rename(oldname, newname string) error {
+	if strings.HasSuffix(newname, fs.failSuffix) {
+		return errors.New("rename failed")
+	}
+	return fs.Fs.Rename(oldname, newname)
+}
+
+func TestWriteFiles(t *testing.T) {
+	t.Parallel()
+
+	newStack := func(t *testing.T, fs afero.Fs) *MonitoringStack {
+		ctrl := gomock.NewController(t)
+		locker := mocks.NewMockLocker(ctrl)
+		locker.EXPECT().Lock().Return(nil)
+		locker.EXPECT().Locked().Return(true)
+		locker.EXPECT().Unlock().Return(nil)
+		return &MonitoringStack{path: "/monitoring", l: locker, fs: fs}
+	}
+	files := map[string][]byte{
+		"a/config.yml":      []byte("new config"),
+		"b/c/targets.json":  []byte("[]"),
+		"prometheus.yml":    []byte("new prometheus"),
+		"z/extra/file.yaml": []byte("extra"),
+	}
+
+	t.Run("all written", func(t *testing.T) {
+		afs := afero.NewMemMapFs()
+		require.NoError(t, afero.WriteFile(afs, "/monitoring/prometheus.yml", []byte("old prometheus"), 0o644))
+
+		err := newStack(t, afs).WriteFiles(files)
+		require.NoError(t, err)
+		for path, want := range files {
+			got, err := afero.ReadFile(afs, filepath.Join("/monitoring", path))
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		}
+		entries, err := afero.ReadDir(afs, "/monitoring")
+		require.NoError(t, err)
+		assert.Len(t, entries, 4, "staging directory left behind")
+	})
+
+	t.Run("failure restores the prior state", func(t *testing.T) {
+		memFs := afero.NewMemMapFs()
+		require.NoError(t, afero.WriteFile(memFs, "/monitoring/prometheus.yml", []byte("old prometheus"), 0o644))
+		require.NoError(t, afero.WriteFile(memFs, "/monitoring/a/config.yml", []byte("old config"), 0o644))
+		// The last file fails to be moved into place, after the others were
+		afs := &failingRenameFs{Fs: memFs, failSuffix: "file.yaml"}
+
+		err := newStack(t, afs).WriteFiles(files)
+		require.ErrorIs(t, err, ErrWritingFile)
+
+		got, err := afero.ReadFile(memFs, "/monitoring/prometheus.yml")
+		require.NoError(t, err)
+		assert.Equal(t, "old prometheus", string(got))
+		got, err = afero.ReadFile(memFs, "/monitoring/a/config.yml")
+		require.NoError(t, err)
+		assert.Equal(t, "old config", string(got))
+		for _, path := range []string{"/monitoring/b", "/monitoring/z"} {
+			exists, err := afero.Exists(memFs, path)
+			require.NoError(t, err)
+			assert.False(t, exists, path)
+		}
+		entries, err := afero.ReadDir(memFs, "/monitoring")
+		require.NoError(t, err)
+		assert.Len(t, entries, 2, "staging directory left behind")
+	})
+}
+
+func TestDumpConfigs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().Lock().Return(nil)
+	locker.EXPECT().Locked().Return(true)
+	locker.EXPECT().Unlock().Return(nil)
+	afs := afero.NewMemMapFs()
+	stack := &MonitoringStack{path: "/monitoring", l: locker, fs: afs}
+
+	files := map[string]string{
+		".env": "# Grafana\nGRAFANA_PORT=3000\nGRAFANA_ADMIN_PASSWORD=admin-s3cret\nPROM_PORT=9090\n",
+		"prometheus/prometheus.yml": `global:
+  scrape_interval: 15s
+scrape_configs:
+  - job_name: basic
+    basic_auth:
+      username: user
+      password: basic-s3cret
+    static_configs:
+      - targets: ["localhost:8000"]
+  - job_name: bearer
+    bearer_token: t0ken
+  - job_name: file
+    basic_auth:
+      username: user
+      password_file: /etc/prometheus/secrets/file/password
+`,
+		"grafana/provisioning/datasources/datasource.yml": `datasources:
+  - name: Prometheus
+    url: http://prometheus:9090
+    secureJsonData:
+      httpHeaderValue1: Bearer header-s3cret
+`,
+		"prometheus/file_sd/targets.json":                `[{"targets":["localhost:8000"]}]`,
+		"prometheus/secrets/file/password":               "file-s3cret",
+		"grafana/provisioning/dashboards/dashboard.json": "{}",
+	}
+	for path, content := range files {
+		require.NoError(t, afero.WriteFile(afs, filepath.Join("/monitoring", path), []byte(content), 0o644))
+	}
+
+	configs, err := stack.DumpConfigs()
+	require.NoError(t, err)
+	paths := make([]string, 0, len(configs))
+	for path := range configs {
+		paths = append(paths, path)
+	}
+	assert.ElementsMatch(t, []string{
+		".env",
+		"prometheus/prometheus.yml",
+		"prometheus/file_sd/targets.json",
+		"grafana/provisioning/datasources/datasource.yml",
+	}, paths)
+	for path, content := range configs {
+		for _, secret := range []string{"admin-s3cret", "basic-s3cret", "t0ken", "header-s3cret", "file-s3cret"} {
+			assert.NotContains(t, string(content), secret, path)
+		}
+	}
+
+	assert.Equal(t, "# Grafana\nGRAFANA_PORT=3000\nGRAFANA_ADMIN_PASSWORD=<redacted>\nPROM_PORT=9090\n", string(configs[".env"]))
+	assert.Equal(t, files["prometheus/file_sd/targets.json"], string(configs["prometheus/file_sd/targets.json"]))
+
+	var prom map[string]any
+	require.NoError(t, yaml.Unmarshal(configs["prometheus/prometheus.yml"], &prom))
+	assert.Equal(t, map[string]any{
+		"global": map[string]any{"scrape_interval": "15s"},
+		"scrape_configs": []any{
+			map[string]any{
+				"job_name":       "basic",
+				"basic_auth":     map[string]any{"username": "user", "password": "<redacted>"},
+				"static_configs": []any{map[string]any{"targets": []any{"localhost:8000"}}},
+			},
+			map[string]any{"job_name": "bearer", "bearer_token": "<redacted>"},
+			map[string]any{
+				"job_name":   "file",
+				"basic_auth": map[string]any{"username": "user", "password_file": "/etc/prometheus/secrets/file/password"},
+			},
+		},
+	}, prom)
+
+	var datasources map[string]any
+	require.NoError(t, yaml.Unmarshal(configs["grafana/provisioning/datasources/datasource.yml"], &datasources))
+	assert.Equal(t, map[string]any{
+		"datasources": []any{map[string]any{
+			"name":           "Prometheus",
+			"url":            "http://prometheus:9090",
+			"secureJsonData": map[string]any{"httpHeaderValue1": "<redacted>"},
+		}},
+	}, datasources)
+}
@@ -0,0 +1,298 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/NethermindEth/eigenlayer/internal/locker"
+	"github.com/spf13/afero"
+)
+
+// MoveDataDir moves the data dir at oldPath to newPath, like to a bigger
+// disk, and returns the data dir at its new path. The tree is renamed, or
+// copied and removed when the paths are on different file systems. If newPath
+// already exists, it must be a copy of the data dir made beforehand, with the
+// same files, and the data dir at oldPath is left in place. The absolute paths
+// of the old data dir in the instance and monitoring stack files, like their
+// .env and compose files, are rewritten, and the missing lock files are
+// created.
+//
+// The instances are locked during the move, so it returns ErrInstanceBusy if
+// any instance is in use by another process, and installs and removals wait
+// for the move to finish.
+func MoveDataDir(fs afero.Fs, oldPath, newPath string, locker locker.Locker) (*DataDir, error) {
+	oldDir, err := NewDataDir(oldPath, fs, locker, WithReadOnly())
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := IsDataDir(fs, oldDir.Path()); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotDataDir, oldDir.Path())
+	}
+	newDir, err := NewDataDir(newPath, fs, locker, WithReadOnly())
+	if err != nil {
+		return nil, err
+	}
+	src, dst := oldDir.Path(), newDir.Path()
+	if dst == src || strings.HasPrefix(dst, src+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%w: can't move %s to %s", ErrInvalidDataDirPath, src, dst)
+	}
+
+	unlock, err := oldDir.lockForMove()
+	if err != nil {
+		return nil, err
+	}
+	err = moveTree(fs, src, dst)
+	unlockErr := unlock()
+	if err != nil {
+		return nil, err
+	}
+	if unlockErr != nil {
+		return nil, unlockErr
+	}
+	if err := rewriteDataDirPaths(fs, dst, src); err != nil {
+		return nil, err
+	}
+	if err := createLockFiles(fs, dst); err != nil {
+		return nil, err
+	}
+	return NewDataDir(dst, fs, locker)
+}
+
+// lockForMove takes the data dir lock and the lock of every instance
+// exclusively, failing with ErrInstanceBusy if an instance is in use. It
+// returns the function releasing the locks.
+func (d *DataDir) lockForMove() (func() error, error) {
+	var locks []locker.Locker
+	unlock := func() error {
+		var errs []error
+		for _, l := range locks {
+			errs = append(errs, l.Unlock())
+		}
+		return errors.Join(errs...)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dataDirLockTimeout)
+	defer cancel()
+	l := d.locker.New(filepath.Join(d.path, dataDirLockName))
+	locked, err := l.TryLockContext(ctx, dataDirLockRetryDelay)
+	if err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, fmt.Errorf("%w: %s", ErrDataDirLockTimeout, d.path)
+	}
+	locks = append(locks, l)
+
+	dirEntries, err := readDirIfExists(d.fs, d.NodesPath())
+	if err != nil {
+		return nil, errors.Join(err, unlock())
+	}
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() || strings.HasSuffix(dirEntry.Name(), deletingSuffix) {
+			continue
+		}
+		l := d.locker.New(filepath.Join(d.NodesPath(), dirEntry.Name(), ".lock"))
+		ctx, cancel := context.WithTimeout(context.Background(), instanceBusyProbeTimeout)
+		locked, err := l.TryLockContext(ctx, instanceBusyProbeTimeout)
+		cancel()
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			return nil, errors.Join(err, unlock())
+		}
+		if !locked {
+			return nil, errors.Join(fmt.Errorf("%w: %s", ErrInstanceBusy, dirEntry.Name()), unlock())
+		}
+		locks = append(locks, l)
+	}
+	return unlock, nil
+}
+
+// moveTree moves the tree at src to dst. If dst exists, it checks it has the
+// same files as src instead, and leaves src in place.
+func moveTree(fs afero.Fs, src, dst string) error {
+	exists, err := afero.Exists(fs, dst)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return checkTreeCopy(fs, src, dst)
+	}
+	if err := fs.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	err = fs.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	// Different file systems, copy instead
+	if err := copyTree(fs, src, dst); err != nil {
+		return errors.Join(err, fs.RemoveAll(dst))
+	}
+	return fs.RemoveAll(src)
+}
+
+// copyTree copies the tree at src to dst, leaving out the lock files.
+func copyTree(fs afero.Fs, src, dst string) error {
+	return afero.Walk(fs, src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, relPath)
+		switch {
+		case info.IsDir():
+			return fs.MkdirAll(target, info.Mode().Perm())
+		case !info.Mode().IsRegular() || isLockFile(info.Name()):
+			return nil
+		}
+		return copyFile(fs, path, target, info.Mode().Perm())
+	})
+}
+
+// checkTreeCopy returns ErrDataDirCopyMismatch if the trees at src and dst
+// don't have the same regular files with the same sizes, lock files aside.
+func checkTreeCopy(fs afero.Fs, src, dst string) error {
+	srcFiles, err := treeFiles(fs, src)
+	if err != nil {
+		return err
+	}
+	dstFiles, err := treeFiles(fs, dst)
+	if err != nil {
+		return err
+	}
+	for relPath, size := range srcFiles {
+		dstSize, ok := dstFiles[relPath]
+		if !ok {
+			return fmt.Errorf("%w: %s is missing in %s", ErrDataDirCopyMismatch, relPath, dst)
+		}
+		if dstSize != size {
+			return fmt.Errorf("%w: %s differs in %s", ErrDataDirCopyMismatch, relPath, dst)
+		}
+	}
+	for relPath := range dstFiles {
+		if _, ok := srcFiles[relPath]; !ok {
+			return fmt.Errorf("%w: %s is not in %s", ErrDataDirCopyMismatch, relPath, src)
+		}
+	}
+	return nil
+}
+
+// treeFiles returns the sizes of the regular files of the tree at root, by
+// path relative to root, lock files aside.
+func treeFiles(fs afero.Fs, root string) (map[string]int64, error) {
+	files := make(map[string]int64)
+	err := afero.Walk(fs, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() || isLockFile(info.Name()) {
+			return err
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[relPath] = info.Size()
+		return nil
+	})
+	return files, err
+}
+
+// rewriteDataDirPaths replaces the absolute paths under oldPath by the same
+// paths under the data dir at path, in the text files of its instances and
+// monitoring stacks.
+func rewriteDataDirPaths(afs afero.Fs, path, oldPath string) error {
+	oldPathRegexp := regexp.MustCompile(regexp.QuoteMeta(oldPath) + `(/|\\|$|[^\w.-])`)
+	dirEntries, err := afero.ReadDir(afs, path)
+	if err != nil {
+		return err
+	}
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() || (dirEntry.Name() != nodesDirName && !isMonitoringStackDir(dirEntry.Name())) {
+			continue
+		}
+		err := afero.Walk(afs, filepath.Join(path, dirEntry.Name()), func(filePath string, info fs.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() || !isRewrittenFile(info.Name()) {
+				return err
+			}
+			content, err := afero.ReadFile(afs, filePath)
+			if err != nil {
+				return err
+			}
+			rewritten := oldPathRegexp.ReplaceAll(content, []byte(strings.ReplaceAll(path, "$", "$$")+"${1}"))
+			if string(rewritten) == string(content) {
+				return nil
+			}
+			return writeFileAtomic(afs, filePath, rewritten, info.Mode().Perm(), false)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isRewrittenFile returns true if the file with the given name may hold
+// absolute paths of the data dir rewritten by MoveDataDir.
+func isRewrittenFile(name string) bool {
+	switch filepath.Ext(name) {
+	case ".env", ".yml", ".yaml", ".json":
+		return true
+	}
+	return false
+}
+
+// isLockFile returns true if the file with the given name is a lock file of
+// the data dir.
+func isLockFile(name string) bool {
+	return name == ".lock" || name == dataDirLockName
+}
+
+// createLockFiles creates the missing lock files of the instances and
+// monitoring stacks of the data dir at path.
+func createLockFiles(fs afero.Fs, path string) error {
+	dirs := make([]string, 0)
+	nodesEntries, err := readDirIfExists(fs, filepath.Join(path, nodesDirName))
+	if err != nil {
+		return err
+	}
+	for _, dirEntry := range nodesEntries {
+		if dirEntry.IsDir() && !strings.HasSuffix(dirEntry.Name(), deletingSuffix) {
+			dirs = append(dirs, filepath.Join(path, nodesDirName, dirEntry.Name()))
+		}
+	}
+	rootEntries, err := afero.ReadDir(fs, path)
+	if err != nil {
+		return err
+	}
+	for _, dirEntry := range rootEntries {
+		if dirEntry.IsDir() && isMonitoringStackDir(dirEntry.Name()) {
+			dirs = append(dirs, filepath.Join(path, dirEntry.Name()))
+		}
+	}
+	for _, dir := range dirs {
+		lockPath := filepath.Join(dir, ".lock")
+		ok, err := afero.Exists(fs, lockPath)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		f, err := fs.Create(lockPath)
+		if err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// PlacementPolicy chooses the root of a MultiDataDir where a new instance is
+// installed, among the writable roots, which are never empty.
+type PlacementPolicy func(roots []*DataDir) (*DataDir, error)
+
+// MostFreeSpace is the PlacementPolicy choosing the root with the most free
+// disk space, the first one on ties. Roots whose free space can't be measured,
+// like the ones not on the OS file system, are only chosen if none can be.
+func MostFreeSpace(roots []*DataDir) (*DataDir, error) {
+	best, bestFree := roots[0], uint64(0)
+	for _, root := range roots {
+		free, err := root.FreeSpace()
+		if err != nil {
+			continue
+		}
+		if free > bestFree {
+			best, bestFree = root, free
+		}
+	}
+	return best, nil
+}
+
+// MultiDataDirOption is an optional setting of a MultiDataDir.
+type MultiDataDirOption func(*MultiDataDir)
+
+// WithPlacementPolicy sets the policy choosing the root of the new instances.
+// The default is MostFreeSpace.
+func WithPlacementPolicy(policy PlacementPolicy) MultiDataDirOption {
+	return func(m *MultiDataDir) {
+		m.placement = policy
+	}
+}
+
+// MultiDataDir presents several data dirs, for instance on different disks, as
+// a single one. New instances are installed on the root chosen by its
+// placement policy, and the other instance methods find the root holding the
+// instance. Instance ids are unique across the roots.
+type MultiDataDir struct {
+	roots     []*DataDir
+	placement PlacementPolicy
+}
+
+// NewMultiDataDir creates a MultiDataDir over the given roots. It returns
+// ErrInstanceAlreadyExists if an instance is installed in more than one root.
+func NewMultiDataDir(roots []*DataDir, opts ...MultiDataDirOption) (*MultiDataDir, error) {
+	if len(roots) == 0 {
+		return nil, ErrNoDataDirRoot
+	}
+	m := &MultiDataDir{roots: roots, placement: MostFreeSpace}
+	for _, opt := range opts {
+		opt(m)
+	}
+	owners := make(map[string]string)
+	for _, root := range roots {
+		err := root.WalkInstances(func(instance *Instance) error {
+			if owner, ok := owners[instance.ID()]; ok {
+				return fmt.Errorf("%w: %s is in %s and %s", ErrInstanceAlreadyExists, instance.ID(), owner, root.Path())
+			}
+			owners[instance.ID()] = root.Path()
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Roots returns the data dirs of the MultiDataDir.
+func (m *MultiDataDir) Roots() []*DataDir {
+	return m.roots
+}
+
+// Root returns the data dir holding the instance with the given id.
+func (m *MultiDataDir) Root(instanceId string) (*DataDir, error) {
+	for _, root := range m.roots {
+		if root.HasInstance(instanceId) {
+			return root, nil
+		}
+	}
+	return nil, &InstanceNotFoundError{Id: instanceId}
+}
+
+// InitInstance initializes a new instance on the root chosen by the placement
+// policy, among the writable roots, and returns its id. If an instance with
+// the same id already exists in any root, an error is returned.
+func (m *MultiDataDir) InitInstance(instance *Instance) (string, error) {
+	instanceId := InstanceId(instance.Name, instance.Tag)
+	if root, err := m.Root(instanceId); err == nil {
+		return "", fmt.Errorf("%w: %s in %s", ErrInstanceAlreadyExists, instanceId, root.Path())
+	}
+	writable := make([]*DataDir, 0, len(m.roots))
+	for _, root := range m.roots {
+		if !root.ReadOnly() {
+			writable = append(writable, root)
+		}
+	}
+	if len(writable) == 0 {
+		return "", fmt.Errorf("%w: all the roots are read-only", ErrNoDataDirRoot)
+	}
+	root, err := m.placement(writable)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrNoDataDirRoot, err)
+	}
+	return root.InitInstance(instance)
+}
+
+// Instance returns the instance with the given id, from the root holding it.
+func (m *MultiDataDir) Instance(instanceId string) (*Instance, error) {
+	root, err := m.Root(instanceId)
+	if err != nil {
+		return nil, err
+	}
+	return root.Instance(instanceId)
+}
+
+// HasInstance returns true if any root has the instance with the given id.
+func (m *MultiDataDir) HasInstance(instanceId string) bool {
+	_, err := m.Root(instanceId)
+	return err == nil
+}
+
+// ListInstances returns the instances of all the roots, sorted by id.
+func (m *MultiDataDir) ListInstances() ([]*Instance, error) {
+	instances := make([]*Instance, 0)
+	for _, root := range m.roots {
+		rootInstances, err := root.ListInstances()
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, rootInstances...)
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID() < instances[j].ID()
+	})
+	return instances, nil
+}
+
+// RemoveInstance removes the instance with the given id from the root holding
+// it, like DataDir.RemoveInstance.
+func (m *MultiDataDir) RemoveInstance(instanceId string, force bool) error {
+	root, err := m.Root(instanceId)
+	if err != nil {
+		return err
+	}
+	return root.RemoveInstance(instanceId, force)
+}
+
+// Close closes all the roots, returning their errors together.
+func (m *MultiDataDir) Close() error {
+	var errs []error
+	for _, root := range m.roots {
+		errs = append(errs, root.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,121 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/NethermindEth/eigenlayer/internal/common"
+	"github.com/NethermindEth/eigenlayer/internal/locker"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMultiTestInstance(tag string) *Instance {
+	return &Instance{
+		Name:    "mock-avs",
+		Tag:     tag,
+		URL:     common.MockAvsPkg.Repo(),
+		Version: common.MockAvsPkg.Version(),
+		Profile: "option-returner",
+	}
+}
+
+func TestMultiDataDir(t *testing.T) {
+	fs := afero.NewOsFs()
+	configRoot, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	dataRoot, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+
+	// Place the instances alternately on each root
+	next := 0
+	alternate := func(roots []*DataDir) (*DataDir, error) {
+		root := roots[next%len(roots)]
+		next++
+		return root, nil
+	}
+	multi, err := NewMultiDataDir([]*DataDir{configRoot, dataRoot}, WithPlacementPolicy(alternate))
+	require.NoError(t, err)
+
+	for _, tag := range []string{"first", "second", "third"} {
+		_, err := multi.InitInstance(newMultiTestInstance(tag))
+		require.NoError(t, err)
+	}
+	assert.True(t, configRoot.HasInstance("mock-avs-first"))
+	assert.True(t, dataRoot.HasInstance("mock-avs-second"))
+	assert.True(t, configRoot.HasInstance("mock-avs-third"))
+
+	// Ids are unique across the roots
+	_, err = multi.InitInstance(newMultiTestInstance("second"))
+	assert.ErrorIs(t, err, ErrInstanceAlreadyExists)
+	assert.False(t, configRoot.HasInstance("mock-avs-second"))
+
+	instances, err := multi.ListInstances()
+	require.NoError(t, err)
+	ids := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		ids = append(ids, instance.ID())
+	}
+	assert.Equal(t, []string{"mock-avs-first", "mock-avs-second", "mock-avs-third"}, ids)
+
+	instance, err := multi.Instance("mock-avs-second")
+	require.NoError(t, err)
+	assert.Equal(t, "second", instance.Tag)
+	root, err := multi.Root("mock-avs-second")
+	require.NoError(t, err)
+	assert.Same(t, dataRoot, root)
+
+	require.NoError(t, multi.RemoveInstance("mock-avs-second", false))
+	assert.False(t, multi.HasInstance("mock-avs-second"))
+	assert.False(t, dataRoot.HasInstance("mock-avs-second"))
+	err = multi.RemoveInstance("mock-avs-second", false)
+	assert.ErrorIs(t, err, ErrInstanceNotFound)
+	instances, err = multi.ListInstances()
+	require.NoError(t, err)
+	assert.Len(t, instances, 2)
+}
+
+func TestMultiDataDir_DuplicateInstance(t *testing.T) {
+	fs := afero.NewOsFs()
+	first, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	second, err := NewDataDir(t.TempDir(), fs, locker.NewFLock())
+	require.NoError(t, err)
+	_, err = first.InitInstance(newMultiTestInstance("default"))
+	require.NoError(t, err)
+	_, err = second.InitInstance(newMultiTestInstance("default"))
+	require.NoError(t, err)
+
+	_, err = NewMultiDataDir([]*DataDir{first, second})
+	assert.ErrorIs(t, err, ErrInstanceAlreadyExists)
+	_, err = NewMultiDataDir(nil)
+	assert.ErrorIs(t, err, ErrNoDataDirRoot)
+}
+
+func TestMultiDataDir_MostFreeSpace(t *testing.T) {
+	memRoot, err := NewDataDir("/data", afero.NewMemMapFs(), locker.NewNoLock())
+	require.NoError(t, err)
+	osRoot, err := NewDataDir(t.TempDir(), afero.NewOsFs(), locker.NewFLock())
+	require.NoError(t, err)
+	readOnlyRoot, err := NewDataDir(t.TempDir(), afero.NewOsFs(), locker.NewFLock(), WithReadOnly())
+	require.NoError(t, err)
+
+	// The memory root has no measurable free space
+	root, err := MostFreeSpace([]*DataDir{memRoot, osRoot})
+	require.NoError(t, err)
+	assert.Same(t, osRoot, root)
+	root, err = MostFreeSpace([]*DataDir{memRoot})
+	require.NoError(t, err)
+	assert.Same(t, memRoot, root)
+
+	// Read-only roots are never chosen
+	multi, err := NewMultiDataDir([]*DataDir{readOnlyRoot, memRoot})
+	require.NoError(t, err)
+	_, err = multi.InitInstance(newMultiTestInstance("default"))
+	require.NoError(t, err)
+	assert.True(t, memRoot.HasInstance("mock-avs-default"))
+	multi, err = NewMultiDataDir([]*DataDir{readOnlyRoot})
+	require.NoError(t, err)
+	_, err = multi.InitInstance(newMultiTestInstance("other"))
+	assert.ErrorIs(t, err, ErrNoDataDirRoot)
+}
@@ -0,0 +1,140 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// PluginMeta describes a saved plugin image context, from the <id>.json file
+// written next to its <id>.tar file.
+type PluginMeta struct {
+	ID string `json:"id"`
+	// Image is the reference of the image the context was saved from, if
+	// known.
+	Image string `json:"image,omitempty"`
+	// Size is the size in bytes of the context tar file.
+	Size int64 `json:"size"`
+	// Checksum is the hex encoded SHA-256 of the context tar file.
+	Checksum string    `json:"checksum"`
+	SavedAt  time.Time `json:"saved_at"`
+	// Legacy is true for the contexts saved without metadata, whose metadata
+	// is read from the tar file instead, without image.
+	Legacy bool `json:"-"`
+}
+
+// pluginContextPath returns the path of the tar file of the plugin context
+// with the given id.
+func (d *DataDir) pluginContextPath(id string) string {
+	return filepath.Join(d.PluginDirPath(), id+".tar")
+}
+
+// pluginMetaPath returns the path of the metadata file of the plugin context
+// with the given id.
+func (d *DataDir) pluginMetaPath(id string) string {
+	return filepath.Join(d.PluginDirPath(), id+".json")
+}
+
+// SavePluginImageContextFrom is like SavePluginImageContext, but records the
+// reference of the image the context is saved from in its metadata, see
+// PluginContextInfo.
+func (d *DataDir) SavePluginImageContextFrom(id, image string, ctx io.ReadCloser) (err error) {
+	defer ctx.Close()
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if err := d.checkOpen(); err != nil {
+		return err
+	}
+	if err = d.fs.MkdirAll(d.PluginDirPath(), 0o755); err != nil {
+		return err
+	}
+	ctxF, err := d.fs.Create(d.pluginContextPath(id))
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(ctxF, h), ctx)
+	if closeErr := ctxF.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	metaData, err := json.Marshal(PluginMeta{
+		ID:       id,
+		Image:    image,
+		Size:     size,
+		Checksum: hex.EncodeToString(h.Sum(nil)),
+		SavedAt:  d.now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(d.fs, d.pluginMetaPath(id), metaData, 0o644, d.syncWrites)
+}
+
+// PluginContextInfo returns the metadata of the plugin context with the given
+// id. The metadata of legacy contexts, saved without it, is read from their
+// tar file: its size, checksum and modification time. A missing context
+// returns ErrPluginContextNotFound.
+func (d *DataDir) PluginContextInfo(id string) (PluginMeta, error) {
+	info, err := d.fs.Stat(d.pluginContextPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return PluginMeta{}, fmt.Errorf("%w: %s", ErrPluginContextNotFound, id)
+		}
+		return PluginMeta{}, err
+	}
+	metaData, err := afero.ReadFile(d.fs, d.pluginMetaPath(id))
+	if err == nil {
+		var meta PluginMeta
+		if err := json.Unmarshal(metaData, &meta); err != nil {
+			return PluginMeta{}, fmt.Errorf("invalid metadata of plugin context %s: %w", id, err)
+		}
+		return meta, nil
+	}
+	if !os.IsNotExist(err) {
+		return PluginMeta{}, err
+	}
+	checksum, err := fileChecksum(d.fs, d.pluginContextPath(id))
+	if err != nil {
+		return PluginMeta{}, err
+	}
+	return PluginMeta{
+		ID:       id,
+		Size:     info.Size(),
+		Checksum: checksum,
+		SavedAt:  info.ModTime().UTC(),
+		Legacy:   true,
+	}, nil
+}
+
+// ListPluginContexts returns the metadata of the saved plugin contexts,
+// sorted by id, legacy ones included.
+func (d *DataDir) ListPluginContexts() ([]PluginMeta, error) {
+	dirEntries, err := readDirIfExists(d.fs, d.PluginDirPath())
+	if err != nil {
+		return nil, err
+	}
+	contexts := make([]PluginMeta, 0)
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || filepath.Ext(dirEntry.Name()) != ".tar" {
+			continue
+		}
+		meta, err := d.PluginContextInfo(strings.TrimSuffix(dirEntry.Name(), ".tar"))
+		if err != nil {
+			return nil, err
+		}
+		contexts = append(contexts, meta)
+	}
+	return contexts, nil
+}
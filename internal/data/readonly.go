@@ -0,0 +1,25 @@
+package data
+
+// WithReadOnly makes the data dir read-only, for status and inspection tools.
+// The data dir is not created nor marked, and the methods changing it return
+// ErrReadOnly, while the reads keep working. Instances and monitoring stacks
+// returned by a read-only data dir are not read-only themselves. For data dirs
+// on read-only media, use a locker.NoLock, so no lock files are created.
+func WithReadOnly() DataDirOption {
+	return func(d *DataDir) {
+		d.readOnly = true
+	}
+}
+
+// ReadOnly returns true if the data dir was created with WithReadOnly.
+func (d *DataDir) ReadOnly() bool {
+	return d.readOnly
+}
+
+// checkWritable returns ErrReadOnly if the data dir is read-only.
+func (d *DataDir) checkWritable() error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/NethermindEth/eigenlayer/internal/locker"
+)
+
+const (
+	// dataDirLockName is the name of the lock file of the whole data dir.
+	dataDirLockName = ".eigen-datadir.lock"
+	// dataDirLockTimeout is the maximum time InitInstance and RemoveInstance
+	// wait for a consistent list to finish.
+	dataDirLockTimeout = 30 * time.Second
+	// dataDirLockRetryDelay is the delay between data dir lock attempts.
+	dataDirLockRetryDelay = 50 * time.Millisecond
+)
+
+// ListInstancesConsistent is like ListInstances, but returns a point-in-time
+// view of the instances: it holds the data dir lock exclusively during the
+// scan, so no instance is added or removed by InitInstance or RemoveInstance,
+// in this or another process, until it returns. The price is latency: the
+// scan waits for the running installs and removals to finish, and blocks the
+// new ones until it is done, while ListInstances doesn't wait for anything.
+// If ctx is done before the lock is taken, it returns ErrDataDirLockTimeout.
+// Lockless data dirs, meant for read-only media, are listed like
+// ListInstances, without guarantee.
+func (d *DataDir) ListInstancesConsistent(ctx context.Context) (instances []*Instance, err error) {
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+	l := d.locker.New(filepath.Join(d.path, dataDirLockName))
+	locked, err := l.TryLockContext(ctx, dataDirLockRetryDelay)
+	if errors.Is(err, locker.ErrLockless) {
+		return d.ListInstances()
+	}
+	if err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, fmt.Errorf("%w: %s", ErrDataDirLockTimeout, d.path)
+	}
+	defer func() {
+		unlockErr := l.Unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+	return d.ListInstances()
+}
+
+// rlockDataDir takes the data dir lock shared, for the changes to the set of
+// instances, so they run concurrently with each other but not with
+// ListInstancesConsistent. The caller must unlock the returned locker.
+func (d *DataDir) rlockDataDir() (locker.Locker, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dataDirLockTimeout)
+	defer cancel()
+	l := d.locker.New(filepath.Join(d.path, dataDirLockName))
+	locked, err := l.TryRLockContext(ctx, dataDirLockRetryDelay)
+	if err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, fmt.Errorf("%w: %s", ErrDataDirLockTimeout, d.path)
+	}
+	return l, nil
+}
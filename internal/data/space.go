@@ -0,0 +1,69 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+)
+
+// WithSpaceCheck makes backups and installs check there is enough free disk
+// space before writing anything, failing early with ErrInsufficientSpace
+// instead of filling the disk. Backups require the size of the instance, and
+// installs the size of the package profile.
+func WithSpaceCheck() DataDirOption {
+	return func(d *DataDir) {
+		d.spaceCheck = true
+	}
+}
+
+// FreeSpace returns the bytes of the file system of the data dir available to
+// unprivileged users. It returns ErrFreeSpaceUnknown if the data dir is not on
+// the OS file system, such as an in-memory one.
+func (d *DataDir) FreeSpace() (uint64, error) {
+	if d.freeSpace != nil {
+		return d.freeSpace()
+	}
+	if _, ok := d.fs.(*afero.OsFs); !ok {
+		return 0, fmt.Errorf("%w: %s is not on the OS file system", ErrFreeSpaceUnknown, d.path)
+	}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(d.path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// CheckInstallSpace checks there is enough free disk space to install the
+// package profile at profilePath. It does nothing unless the data dir was
+// opened WithSpaceCheck.
+func (d *DataDir) CheckInstallSpace(profilePath string) error {
+	if !d.spaceCheck {
+		return nil
+	}
+	required, err := diskUsage(context.Background(), d.fs, profilePath)
+	if err != nil {
+		return err
+	}
+	return d.checkSpace(required)
+}
+
+// checkSpace returns ErrInsufficientSpace if the free disk space is below
+// required bytes. The check is skipped if the free space can't be measured.
+func (d *DataDir) checkSpace(required int64) error {
+	free, err := d.FreeSpace()
+	if errors.Is(err, ErrFreeSpaceUnknown) {
+		logrus.Debugf("Skipping free space check: %v", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if required > 0 && uint64(required) > free {
+		return fmt.Errorf("%w: %d bytes required, %d bytes available", ErrInsufficientSpace, required, free)
+	}
+	return nil
+}
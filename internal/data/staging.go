@@ -0,0 +1,154 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/NethermindEth/eigenlayer/internal/utils"
+	"github.com/spf13/afero"
+)
+
+// stageBackup creates the staging archive of the backup with the given id: a
+// hidden file with a random suffix in the backup directory, so concurrent
+// backups with the same id never write to the same file. It returns the path
+// of the staging archive.
+func (d *DataDir) stageBackup(backupId string) (string, error) {
+	f, err := afero.TempFile(d.fs, d.BackupDirPath(), "."+backupId+"-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	err = f.Close()
+	if err == nil {
+		err = d.fs.Chmod(f.Name(), 0o644)
+	}
+	if err != nil {
+		d.fs.Remove(f.Name())
+		return "", err
+	}
+	d.stagingMu.Lock()
+	defer d.stagingMu.Unlock()
+	if d.stagedBackups == nil {
+		d.stagedBackups = make(map[string]string)
+	}
+	d.stagedBackups[backupId] = f.Name()
+	return f.Name(), nil
+}
+
+// stagedBackup returns the path of the staging archive of the backup with the
+// given id, if it is being written by this data dir.
+func (d *DataDir) stagedBackup(backupId string) (string, bool) {
+	d.stagingMu.Lock()
+	defer d.stagingMu.Unlock()
+	path, ok := d.stagedBackups[backupId]
+	return path, ok
+}
+
+func (d *DataDir) unstageBackup(backupId string) {
+	d.stagingMu.Lock()
+	defer d.stagingMu.Unlock()
+	delete(d.stagedBackups, backupId)
+}
+
+// BackupWritePath returns the path of the archive of the backup with the given
+// id being written: its staging archive, from InitBackup until the backup is
+// committed, and else BackupPath.
+func (d *DataDir) BackupWritePath(backupId string) string {
+	if path, ok := d.stagedBackup(backupId); ok {
+		return path
+	}
+	return d.BackupPath(backupId)
+}
+
+// CommitBackup moves the staging archive of the backup, complete, to
+// BackupPath, which WriteBackupManifest does before writing the manifest. The
+// move never replaces an existing archive: if another backup with the same id
+// was committed meanwhile, the staging archive is removed and
+// ErrBackupAlreadyExists is returned. A backup larger than the maximum backup
+// size, see WithMaxBackupBytes, is removed instead and ErrBackupTooLarge is
+// returned. Committing a committed backup does nothing.
+func (d *DataDir) CommitBackup(b *Backup) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if err := d.checkOpen(); err != nil {
+		return err
+	}
+	staging, ok := d.stagedBackup(b.Id())
+	if !ok {
+		return nil
+	}
+	// Archives not written by AddBackupFile and AddBackupDir, like the volume
+	// snapshots, are only checked here
+	if d.maxBackupBytes > 0 {
+		info, err := d.fs.Stat(staging)
+		if err != nil {
+			return err
+		}
+		if info.Size() > d.maxBackupBytes {
+			return d.abortBackup(b.Id(), utils.ErrTarTooLarge)
+		}
+	}
+	if d.syncWrites {
+		if err := syncPath(d.fs, staging); err != nil {
+			return err
+		}
+	}
+	if err := d.storeBackupArchive(staging, b.Id()); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			// The backup stays staged, so removing it after the failure
+			// doesn't remove the other backup
+			if removeErr := d.fs.Remove(staging); removeErr != nil && !os.IsNotExist(removeErr) {
+				err = errors.Join(err, removeErr)
+			}
+			return fmt.Errorf("%w: %s", ErrBackupAlreadyExists, b.Id())
+		}
+		return err
+	}
+	d.unstageBackup(b.Id())
+	d.audit(AuditBackupCreated, b.Id())
+	if d.syncWrites {
+		return syncPath(d.fs, d.BackupDirPath())
+	}
+	return nil
+}
+
+// moveNoReplace moves the file at oldPath to newPath, failing with an error
+// matching os.ErrExist if newPath exists. On the OS file system the file is
+// hard linked to newPath and then unlinked from oldPath, which is race-free
+// across processes. Elsewhere, or where hard links are not supported, the
+// existence check and the rename are only atomic within the data dir.
+func (d *DataDir) moveNoReplace(oldPath, newPath string) error {
+	if _, ok := d.fs.(*afero.OsFs); ok {
+		err := os.Link(oldPath, newPath)
+		if err == nil {
+			return os.Remove(oldPath)
+		}
+		if !errors.Is(err, syscall.EPERM) && !errors.Is(err, syscall.ENOTSUP) && !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+	}
+	d.stagingMu.Lock()
+	defer d.stagingMu.Unlock()
+	if _, err := d.fs.Stat(newPath); err == nil {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: os.ErrExist}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return d.fs.Rename(oldPath, newPath)
+}
+
+// removeStagedBackup removes the staging archive of the backup with the given
+// id, if it is being written by this data dir, and returns whether it was.
+func (d *DataDir) removeStagedBackup(backupId string) (bool, error) {
+	staging, ok := d.stagedBackup(backupId)
+	if !ok {
+		return false, nil
+	}
+	d.unstageBackup(backupId)
+	if err := d.fs.Remove(staging); err != nil && !os.IsNotExist(err) {
+		return true, err
+	}
+	return true, nil
+}
@@ -0,0 +1,105 @@
+package data
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	stateFileName           = "state.json"
+	compressedStateFileName = "state.json.gz"
+)
+
+// altStateFileNames are the names of the state file written by related tools,
+// read when the instance has no state file of its own. The state is always
+// written to the canonical names.
+var altStateFileNames = []string{"instance.json"}
+
+// readStateFile reads the state of the instance at instancePath. It reads the
+// compressed state.json.gz file if present, the state.json file otherwise, and
+// then the alternate state files, and reports whether the state was
+// compressed. If none exists, the returned error is os.ErrNotExist.
+func readStateFile(fs afero.Fs, instancePath string) (stateData []byte, compressed bool, err error) {
+	gzFile, err := fs.Open(filepath.Join(instancePath, compressedStateFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		for _, fileName := range append([]string{stateFileName}, altStateFileNames...) {
+			stateData, err = afero.ReadFile(fs, filepath.Join(instancePath, fileName))
+			if !errors.Is(err, os.ErrNotExist) {
+				break
+			}
+		}
+		return stateData, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer gzFile.Close()
+	gr, err := gzip.NewReader(gzFile)
+	if err != nil {
+		return nil, true, err
+	}
+	defer gr.Close()
+	stateData, err = io.ReadAll(gr)
+	return stateData, true, err
+}
+
+// marshalState encodes the state of an instance, indented with two spaces and
+// ending with a newline so operators can read and edit it, or in compact form
+// if compact is true.
+func marshalState(state any, compact bool) ([]byte, error) {
+	if compact {
+		return json.Marshal(state)
+	}
+	stateData, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(stateData, '\n'), nil
+}
+
+// writeStateFile atomically writes the state of the instance at instancePath,
+// compressed into state.json.gz or uncompressed into state.json. The file of
+// the other form is removed, so only one form exists per instance. If sync is
+// true, the write is flushed to disk.
+func writeStateFile(fs afero.Fs, instancePath string, stateData []byte, compressed, sync bool) error {
+	fileName, otherFileName := stateFileName, compressedStateFileName
+	if compressed {
+		fileName, otherFileName = compressedStateFileName, stateFileName
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(stateData); err != nil {
+			return err
+		}
+		if err := gw.Close(); err != nil {
+			return err
+		}
+		stateData = buf.Bytes()
+	}
+	if err := writeFileAtomic(fs, filepath.Join(instancePath, fileName), stateData, 0o644, sync); err != nil {
+		return err
+	}
+	err := fs.Remove(filepath.Join(instancePath, otherFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// hasStateFile returns true if the instance at instancePath has a state file,
+// in any form or under an alternate name.
+func hasStateFile(fs afero.Fs, instancePath string) (bool, error) {
+	for _, fileName := range append([]string{compressedStateFileName, stateFileName}, altStateFileNames...) {
+		ok, err := afero.Exists(fs, filepath.Join(instancePath, fileName))
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
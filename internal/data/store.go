@@ -0,0 +1,221 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// Store is where the committed backups of a data dir are kept: the backup
+// archives, <backup_id>.tar, and their manifests, <backup_id>.json, by file
+// name. Backups are written to a staging archive on the data dir file system,
+// see BackupWritePath, and only moved to the store once committed. The default
+// store is the backup directory, see BackupDirPath, and WithBackupStore sets
+// another one, such as a remote object storage.
+type Store interface {
+	// Open opens the file with the given name for reading. A missing file
+	// returns an error matching os.ErrNotExist.
+	Open(name string) (io.ReadCloser, error)
+	// Create creates the file with the given name for writing, replacing the
+	// existing one. The file is complete once closed.
+	Create(name string) (io.WriteCloser, error)
+	// Stat returns the info of the file with the given name. A missing file
+	// returns an error matching os.ErrNotExist.
+	Stat(name string) (fs.FileInfo, error)
+	// Remove removes the file with the given name. A missing file returns an
+	// error matching os.ErrNotExist.
+	Remove(name string) error
+	// List returns the info of the files in the store.
+	List() ([]fs.FileInfo, error)
+}
+
+// AferoStore is a Store keeping the files in a directory of an afero.Fs. It is
+// the default store of the backups, in the backup directory of the data dir.
+type AferoStore struct {
+	fs  afero.Fs
+	dir string
+}
+
+// NewAferoStore returns a Store keeping the files in the directory dir of fs.
+// The directory is created by the first file.
+func NewAferoStore(fs afero.Fs, dir string) *AferoStore {
+	return &AferoStore{fs: fs, dir: dir}
+}
+
+// Open implements Store.
+func (s *AferoStore) Open(name string) (io.ReadCloser, error) {
+	return s.fs.Open(filepath.Join(s.dir, name))
+}
+
+// Create implements Store.
+func (s *AferoStore) Create(name string) (io.WriteCloser, error) {
+	if err := s.fs.MkdirAll(s.dir, 0o755); err != nil {
+		return nil, err
+	}
+	return s.fs.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+}
+
+// Stat implements Store.
+func (s *AferoStore) Stat(name string) (fs.FileInfo, error) {
+	return s.fs.Stat(filepath.Join(s.dir, name))
+}
+
+// Remove implements Store.
+func (s *AferoStore) Remove(name string) error {
+	return s.fs.Remove(filepath.Join(s.dir, name))
+}
+
+// List implements Store. A missing directory has no files.
+func (s *AferoStore) List() ([]fs.FileInfo, error) {
+	return readDirIfExists(s.fs, s.dir)
+}
+
+// WithBackupStore sets the store of the committed backups, instead of the
+// backup directory on the data dir file system. The backups being written are
+// still staged in the backup directory.
+func WithBackupStore(store Store) DataDirOption {
+	return func(d *DataDir) {
+		d.backupStore = store
+	}
+}
+
+// backupStorage returns the store of the committed backups.
+func (d *DataDir) backupStorage() Store {
+	if d.backupStore != nil {
+		return d.backupStore
+	}
+	return NewAferoStore(d.fs, d.BackupDirPath())
+}
+
+// backupArchiveName returns the name of the archive of the backup with the
+// given id in the backup store.
+func backupArchiveName(backupId string) string {
+	return backupId + ".tar"
+}
+
+// backupManifestName returns the name of the manifest of the backup with the
+// given id in the backup store.
+func backupManifestName(backupId string) string {
+	return backupId + ".json"
+}
+
+// storedBackup loads the information of the backup archive with the given
+// name in the backup store.
+func (d *DataDir) storedBackup(name string) (*Backup, error) {
+	r, err := d.backupStorage().Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readBackupArchive(r)
+}
+
+// storeBackupArchive moves the complete staging archive of the backup with
+// the given id to the backup store, failing with an error matching
+// os.ErrExist if the store already has the backup.
+func (d *DataDir) storeBackupArchive(staging, backupId string) error {
+	if d.backupStore == nil {
+		return d.moveNoReplace(staging, d.BackupPath(backupId))
+	}
+	name := backupArchiveName(backupId)
+	if _, err := d.backupStore.Stat(name); err == nil {
+		return fmt.Errorf("%w: %s", os.ErrExist, name)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	src, err := d.fs.Open(staging)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := d.writeStoreFile(name, src); err != nil {
+		return err
+	}
+	return d.fs.Remove(staging)
+}
+
+// LocalBackupPath returns the path of the archive of the backup with the given
+// id on the data dir file system, to extract it or mount it. The archives of
+// the default store are used in place, while the archives of another store are
+// copied to a temp directory first. The returned release function removes the
+// copy, and must be called once the archive is no longer used. A missing
+// backup returns ErrBackupNotFound.
+func (d *DataDir) LocalBackupPath(backupId string) (path string, release func() error, err error) {
+	if d.backupStore == nil {
+		path = d.BackupPath(backupId)
+		if _, err := d.fs.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				return "", nil, fmt.Errorf("%w: %s", ErrBackupNotFound, backupId)
+			}
+			return "", nil, err
+		}
+		return path, func() error { return nil }, nil
+	}
+	r, err := d.backupStore.Open(backupArchiveName(backupId))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil, fmt.Errorf("%w: %s", ErrBackupNotFound, backupId)
+		}
+		return "", nil, err
+	}
+	defer r.Close()
+	tempId := "restore-" + backupId
+	tempPath, err := d.InitTemp(tempId)
+	if err != nil {
+		return "", nil, err
+	}
+	release = func() error {
+		return d.RemoveTemp(tempId)
+	}
+	path = filepath.Join(tempPath, backupArchiveName(backupId))
+	if err := extractFile(d.fs, r, path, 0o644); err != nil {
+		return "", nil, errors.Join(WrapDiskFull(err), release())
+	}
+	return path, release, nil
+}
+
+// readBackupManifestFile reads the manifest data of the backup with the given
+// id from the backup store.
+func (d *DataDir) readBackupManifestFile(backupId string) ([]byte, error) {
+	r, err := d.backupStorage().Open(backupManifestName(backupId))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// writeBackupManifestFile writes the manifest data of the backup with the
+// given id to the backup store. The default store writes it atomically.
+func (d *DataDir) writeBackupManifestFile(backupId string, data []byte) error {
+	if d.backupStore == nil {
+		return writeFileAtomic(d.fs, d.BackupManifestPath(backupId), data, 0o644, d.syncWrites)
+	}
+	return d.writeStoreFile(backupManifestName(backupId), bytes.NewReader(data))
+}
+
+// writeStoreFile writes the content read from r to the file with the given
+// name in the backup store. A partial file is removed.
+func (d *DataDir) writeStoreFile(name string, r io.Reader) error {
+	w, err := d.backupStore.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if removeErr := d.backupStore.Remove(name); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			err = errors.Join(err, removeErr)
+		}
+		return err
+	}
+	return nil
+}
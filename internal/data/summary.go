@@ -0,0 +1,63 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// InstanceSummary holds the core fields of an instance, enough to list it.
+type InstanceSummary struct {
+	ID          string `json:"-"`
+	Name        string `json:"name"`
+	Tag         string `json:"tag"`
+	URL         string `json:"url"`
+	Version     string `json:"version"`
+	Commit      string `json:"commit,omitempty"`
+	Profile     string `json:"profile"`
+	Maintenance bool   `json:"maintenance,omitempty"`
+}
+
+// ListInstanceSummaries is a faster ListInstances for listings: it only
+// decodes the core fields of the state of the instances, skipping the heavier
+// ones like the monitoring targets, the plugin and the labels. The instances
+// are neither locked nor validated, which is safe as states are written
+// atomically. The summaries are in the order of ListInstances.
+func (d *DataDir) ListInstanceSummaries() ([]InstanceSummary, error) {
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+	dirEntries, err := afero.ReadDir(d.fs, d.NodesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []InstanceSummary{}, nil
+		}
+		return nil, err
+	}
+	summaries := make([]InstanceSummary, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() || strings.HasSuffix(dirEntry.Name(), deletingSuffix) {
+			continue
+		}
+		instancePath := filepath.Join(d.NodesPath(), dirEntry.Name())
+		stateData, _, err := readStateFile(d.fs, instancePath)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("%w %s: state.json not found", ErrInvalidInstanceDir, instancePath)
+			}
+			return nil, err
+		}
+		var summary InstanceSummary
+		if err := json.Unmarshal(stateData, &summary); err != nil {
+			return nil, fmt.Errorf("%w %s: %w", ErrInvalidInstanceDir, instancePath, err)
+		}
+		summary.ID = InstanceId(summary.Name, summary.Tag)
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
@@ -0,0 +1,131 @@
+package data
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+)
+
+// writeFileAtomic writes data to the file at path through a temporary file
+// renamed over it, so readers never see a partially written file. If sync is
+// true, the file is flushed to disk before the rename, and its parent
+// directory after it, so the rename itself survives a crash.
+func writeFileAtomic(fs afero.Fs, path string, data []byte, perm os.FileMode, sync bool) (err error) {
+	dir, base := filepath.Split(path)
+	tmp, err := afero.TempFile(fs, dir, "."+base+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			fs.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if sync {
+		if err = syncFile(tmp); err != nil {
+			return err
+		}
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = fs.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err = renameFile(fs, tmp.Name(), path); err != nil {
+		return err
+	}
+	if sync {
+		return syncPath(fs, dir)
+	}
+	return nil
+}
+
+// renameFile renames the file at oldpath over the file at newpath, in the same
+// directory. On overlay file systems the rename can still fail with EXDEV, when
+// the files are on different layers. Then newpath is copied up to the upper
+// layer by opening it for writing, and oldpath is copied to a new temporary
+// file next to newpath, flushed to disk, and renamed over newpath, so the
+// replacement stays atomic.
+func renameFile(fs afero.Fs, oldpath, newpath string) error {
+	err := fs.Rename(oldpath, newpath)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	logrus.Debugf("Renaming %s across devices, copying it: %v", newpath, err)
+	if f, err := fs.OpenFile(newpath, os.O_WRONLY, 0); err == nil {
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	dir, base := filepath.Split(newpath)
+	tmp, err := afero.TempFile(fs, dir, "."+base+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	err = copyToFile(fs, oldpath, tmp)
+	if err == nil {
+		err = fs.Rename(tmp.Name(), newpath)
+	}
+	if err != nil {
+		fs.Remove(tmp.Name())
+		return err
+	}
+	return fs.Remove(oldpath)
+}
+
+// copyToFile copies the content and mode of the file at path to dst, which is
+// flushed to disk and closed.
+func copyToFile(fs afero.Fs, path string, dst afero.File) error {
+	src, err := fs.Open(path)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+	defer src.Close()
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := syncFile(dst); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	return fs.Chmod(dst.Name(), info.Mode().Perm())
+}
+
+// syncPath flushes the file or directory at path to disk.
+func syncPath(fs afero.Fs, path string) error {
+	f, err := fs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return syncFile(f)
+}
+
+// syncFile flushes f to disk. It is a no-op where syncing is not supported,
+// such as directories on some platforms.
+func syncFile(f afero.File) error {
+	err := f.Sync()
+	if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOTSUP) {
+		return nil
+	}
+	return err
+}
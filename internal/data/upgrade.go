@@ -0,0 +1,95 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// UpgradeInstance upgrades the instance with the given id without risking a
+// half-upgraded instance: build is called with a working copy of the instance
+// directory, in a temp directory, to build or migrate the instance there. The
+// state of the working copy is then validated, and must still be of the
+// instance, before the working copy is swapped in place of the instance
+// directory. If build fails, or the upgraded state is invalid, the instance is
+// left unchanged. The instance is locked for writing during the whole upgrade,
+// and the data dir is locked while the instance directory is swapped.
+func (d *DataDir) UpgradeInstance(instanceId string, build func(workDir string) error) (err error) {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if err := d.checkOpen(); err != nil {
+		return err
+	}
+	if !d.HasInstance(instanceId) {
+		return &InstanceNotFoundError{Id: instanceId}
+	}
+	instance, err := d.Instance(instanceId)
+	if err != nil {
+		return err
+	}
+	if err = instance.lock(); err != nil {
+		return err
+	}
+	defer func() {
+		unlockErr := instance.unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+
+	workId := "upgrade-" + instanceId
+	workDir, err := d.InitTemp(workId)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		removeErr := d.RemoveTemp(workId)
+		if err == nil {
+			err = removeErr
+		}
+	}()
+	if err = copyTree(d.fs, instance.path, workDir); err != nil {
+		return WrapDiskFull(err)
+	}
+	if err = build(workDir); err != nil {
+		return err
+	}
+
+	upgraded, err := newInstance(workDir, d.fs, d.locker)
+	if err != nil {
+		return err
+	}
+	if err = upgraded.validate(); err != nil {
+		return err
+	}
+	if upgraded.ID() != instanceId {
+		return fmt.Errorf("%w: the upgraded state is of instance %s, not %s", ErrInvalidInstance, upgraded.ID(), instanceId)
+	}
+	if !isLockless(d.locker) {
+		f, err := d.fs.Create(filepath.Join(workDir, ".lock"))
+		if err != nil {
+			return err
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+	}
+	// Like RemoveInstance, hold the data dir lock while the instance
+	// directory is swapped, so ListInstancesConsistent doesn't see it missing.
+	l, err := d.rlockDataDir()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		unlockErr := l.Unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+	if err = d.replaceInstanceDir(instanceId, workDir, true); err != nil && !errors.Is(err, ErrInstancePendingRemoval) {
+		return err
+	}
+	d.audit(AuditInstanceUpdated, instanceId)
+	return errors.Join(err, updateLabelIndex(d.fs, d.NodesPath(), instanceId, upgraded.Labels, false, d.syncWrites))
+}
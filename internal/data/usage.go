@@ -0,0 +1,125 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/spf13/afero"
+)
+
+// WithDiskUsageConcurrency sets the maximum number of instance directories
+// walked in parallel by DiskUsage. A concurrency of 0 means the number of
+// CPUs, which is the default.
+func WithDiskUsageConcurrency(concurrency int) DataDirOption {
+	return func(d *DataDir) {
+		d.diskUsageConcurrency = concurrency
+	}
+}
+
+// DiskUsage returns the total size in bytes of the files of the instance.
+func (i *Instance) DiskUsage() (int64, error) {
+	return diskUsage(context.Background(), i.fs, i.path)
+}
+
+// DiskUsage returns the total size in bytes of the files of all the instance
+// directories, including the ones pending removal. The instance directories
+// are walked in parallel.
+func (d *DataDir) DiskUsage() (int64, error) {
+	return d.DiskUsageContext(context.Background())
+}
+
+// DiskUsageContext is like DiskUsage, but stops walking when ctx is done. The
+// first walk error cancels the other walks, and the errors of all the walks
+// are returned together.
+func (d *DataDir) DiskUsageContext(ctx context.Context) (int64, error) {
+	ctx, done, err := d.startOperation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	dirEntries, err := afero.ReadDir(d.fs, d.NodesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	concurrency := d.diskUsageConcurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	walkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		total     atomic.Int64
+		errsMutex sync.Mutex
+		errs      []error
+		waitGroup sync.WaitGroup
+	)
+	paths := make(chan string)
+	for w := 0; w < concurrency; w++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for path := range paths {
+				size, err := diskUsage(walkCtx, d.fs, path)
+				if err != nil {
+					if walkCtx.Err() == nil || !errors.Is(err, context.Canceled) {
+						errsMutex.Lock()
+						errs = append(errs, err)
+						errsMutex.Unlock()
+					}
+					cancel()
+					continue
+				}
+				total.Add(size)
+			}
+		}()
+	}
+feed:
+	for _, dirEntry := range dirEntries {
+		select {
+		case paths <- filepath.Join(d.NodesPath(), dirEntry.Name()):
+		case <-walkCtx.Done():
+			break feed
+		}
+	}
+	close(paths)
+	waitGroup.Wait()
+
+	if len(errs) > 0 {
+		return 0, errors.Join(errs...)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return total.Load(), nil
+}
+
+// diskUsage returns the total size in bytes of the regular files under path.
+// Files removed during the walk are ignored.
+func diskUsage(ctx context.Context, fs afero.Fs, path string) (int64, error) {
+	var size int64
+	err := afero.Walk(fs, path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
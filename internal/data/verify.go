@@ -0,0 +1,106 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/afero"
+)
+
+// instanceManifestName is the name of the manifest file in the directory of
+// the instances exported with one.
+const instanceManifestName = "manifest.json"
+
+// InstanceManifest lists the files of an exported instance, to verify they
+// arrived intact once imported.
+type InstanceManifest struct {
+	// Files maps the slash-separated paths of the files, relative to the
+	// instance directory, to their hex encoded SHA-256.
+	Files map[string]string `json:"files"`
+}
+
+// VerifyInstance checks the files of the instance with the given id against
+// its manifest, recomputing their checksums. It returns an
+// *InstanceIntegrityError listing the missing and altered files, if any.
+// Instances without manifest can't be verified, and return
+// ErrInstanceManifestNotFound.
+func (d *DataDir) VerifyInstance(instanceId string) (err error) {
+	instancePath, err := d.InstancePath(instanceId)
+	if err != nil {
+		return err
+	}
+	l, err := d.rlockInstance(instanceId)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		unlockErr := l.Unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+
+	manifestData, err := afero.ReadFile(d.fs, filepath.Join(instancePath, instanceManifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s", ErrInstanceManifestNotFound, instanceId)
+		}
+		return err
+	}
+	var manifest InstanceManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidInstanceManifest, instanceId, err)
+	}
+
+	paths := make([]string, 0, len(manifest.Files))
+	for path := range manifest.Files {
+		if !filepath.IsLocal(filepath.FromSlash(path)) {
+			return fmt.Errorf("%w: %s: %s is outside the instance directory", ErrInvalidInstanceManifest, instanceId, path)
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	integrityErr := &InstanceIntegrityError{Id: instanceId}
+	for _, path := range paths {
+		checksum, err := fileChecksum(d.fs, filepath.Join(instancePath, filepath.FromSlash(path)))
+		if os.IsNotExist(err) {
+			integrityErr.Missing = append(integrityErr.Missing, path)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if checksum != manifest.Files[path] {
+			integrityErr.Altered = append(integrityErr.Altered, path)
+		}
+	}
+	if len(integrityErr.Missing) > 0 || len(integrityErr.Altered) > 0 {
+		return integrityErr
+	}
+	return nil
+}
+
+// fileChecksum returns the hex encoded SHA-256 of the file at path.
+func fileChecksum(fs afero.Fs, path string) (string, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return readerChecksum(f)
+}
+
+// readerChecksum returns the hex encoded SHA-256 of the content read from r.
+func readerChecksum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,221 @@
+package data
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+)
+
+// watchDebounce is the time without changes an instance waits before its
+// event is sent by Watch, so the many changes of a single install, removal or
+// update give a single event.
+const watchDebounce = 100 * time.Millisecond
+
+// InstanceEventType is the kind of change of an InstanceEvent.
+type InstanceEventType string
+
+const (
+	InstanceAdded    InstanceEventType = "added"
+	InstanceRemoved  InstanceEventType = "removed"
+	InstanceModified InstanceEventType = "modified"
+)
+
+// InstanceEvent is a change of an instance on disk, sent by Watch.
+type InstanceEvent struct {
+	Type       InstanceEventType
+	InstanceId string
+}
+
+// Watch sends an event on the returned channel each time an instance is
+// added, removed or modified on disk, by this or another process. The changes
+// of an instance are debounced, so a burst of changes, like the files written
+// by an install, gives a single event, sent once the instance settled. An
+// instance is modified when the files in its directory change, like its
+// state, but not the files in its subdirectories nor its lock file. The
+// channel is closed when ctx is done or the data dir is closed.
+//
+// Watch relies on the file system notifications of the OS, so it returns
+// ErrWatchUnsupported for data dirs on other file systems.
+func (d *DataDir) Watch(ctx context.Context) (<-chan InstanceEvent, error) {
+	if _, ok := d.fs.(*afero.OsFs); !ok {
+		return nil, ErrWatchUnsupported
+	}
+	nodesPath := d.NodesPath()
+	if !d.readOnly {
+		if err := d.fs.MkdirAll(nodesPath, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	known, err := d.watchedInstanceDirs()
+	if err != nil {
+		return nil, err
+	}
+	return d.watchFrom(ctx, known)
+}
+
+// watchFrom is like Watch, but known are the instances that existed before
+// the watch started. The instances added or removed since then, before the
+// nodes directory is watched or while it is listed again, give an event like
+// the later changes.
+func (d *DataDir) watchFrom(ctx context.Context, known map[string]bool) (<-chan InstanceEvent, error) {
+	nodesPath := d.NodesPath()
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// Watch the nodes directory before listing it again, so no instance
+	// added in between is missed
+	if err = watcher.Add(nodesPath); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	current, err := d.watchedInstanceDirs()
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	pending := make(map[string]bool)
+	for instanceId := range current {
+		if err = watcher.Add(filepath.Join(nodesPath, instanceId)); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+		if !known[instanceId] {
+			pending[instanceId] = true
+		}
+	}
+	for instanceId := range known {
+		if !current[instanceId] {
+			pending[instanceId] = true
+		}
+	}
+
+	ctx, done, err := d.startOperation(ctx)
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	events := make(chan InstanceEvent)
+	go func() {
+		defer done()
+		defer close(events)
+		defer watcher.Close()
+		d.watchInstances(ctx, watcher, known, pending, events)
+	}()
+	return events, nil
+}
+
+// watchedInstanceDirs returns the ids of the instance directories, skipping
+// the ones being removed.
+func (d *DataDir) watchedInstanceDirs() (map[string]bool, error) {
+	dirEntries, err := readDirIfExists(d.fs, d.NodesPath())
+	if err != nil {
+		return nil, err
+	}
+	instanceIds := make(map[string]bool, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() && !strings.HasSuffix(dirEntry.Name(), deletingSuffix) {
+			instanceIds[dirEntry.Name()] = true
+		}
+	}
+	return instanceIds, nil
+}
+
+// watchInstances turns the notifications of the watcher into instance events,
+// until ctx is done. known are the instances that existed, and pending the
+// instances already changed since, whose events are sent first.
+func (d *DataDir) watchInstances(ctx context.Context, watcher *fsnotify.Watcher, known, pending map[string]bool, events chan<- InstanceEvent) {
+	nodesPath := d.NodesPath()
+	timer := time.NewTimer(watchDebounce)
+	if len(pending) == 0 {
+		timer.Stop()
+	}
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logrus.Warnf("Error watching the instances: %v", err)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			instanceId, ok := watchedInstanceId(nodesPath, event)
+			if !ok {
+				continue
+			}
+			if event.Has(fsnotify.Create) && !known[instanceId] {
+				// Watch the new instance directory, for its modifications
+				if err := watcher.Add(filepath.Join(nodesPath, instanceId)); err != nil {
+					logrus.Debugf("Not watching instance %s: %v", instanceId, err)
+				}
+			}
+			pending[instanceId] = true
+			timer.Reset(watchDebounce)
+		case <-timer.C:
+			for _, event := range d.settleInstances(pending, known) {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			pending = make(map[string]bool)
+		}
+	}
+}
+
+// watchedInstanceId returns the id of the instance changed by the event, and
+// false if the event is not an instance change.
+func watchedInstanceId(nodesPath string, event fsnotify.Event) (string, bool) {
+	rel, err := filepath.Rel(nodesPath, event.Name)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	instanceId, rest, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	if strings.HasSuffix(instanceId, deletingSuffix) || rest == ".lock" {
+		return "", false
+	}
+	return instanceId, true
+}
+
+// settleInstances returns the events of the changed instances, sorted by
+// instance id, comparing whether they exist now with whether they existed
+// before, and updates known.
+func (d *DataDir) settleInstances(changed, known map[string]bool) []InstanceEvent {
+	var events []InstanceEvent
+	for instanceId := range changed {
+		exists, err := afero.DirExists(d.fs, filepath.Join(d.NodesPath(), instanceId))
+		if err != nil {
+			logrus.Warnf("Error watching instance %s: %v", instanceId, err)
+			continue
+		}
+		switch {
+		case exists && known[instanceId]:
+			events = append(events, InstanceEvent{Type: InstanceModified, InstanceId: instanceId})
+		case exists:
+			events = append(events, InstanceEvent{Type: InstanceAdded, InstanceId: instanceId})
+		case known[instanceId]:
+			events = append(events, InstanceEvent{Type: InstanceRemoved, InstanceId: instanceId})
+		}
+		if exists {
+			known[instanceId] = true
+		} else {
+			delete(known, instanceId)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].InstanceId < events[j].InstanceId
+	})
+	return events
+}
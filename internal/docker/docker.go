@@ -320,6 +320,13 @@ func (d *DockerManager) ContainerNetworks(container string) ([]string, error) {
 	return networkNames, nil
 }
 
+// ContainerKill sends the given signal, such as SIGHUP, to the main process of
+// the container
+func (d *DockerManager) ContainerKill(container, signal string) error {
+	log.Debugf("Sending %s to container %s", signal, container)
+	return d.dockerClient.ContainerKill(context.Background(), container, signal)
+}
+
 // NetworkConnect connects a container to a network
 func (d *DockerManager) NetworkConnect(container, network string) error {
 	log.Debugf("Connecting container %s to network %s", container, network)
@@ -1061,6 +1061,54 @@ func TestContainerNetworks(t *testing.T) {
 	}
 }
 
+func TestContainerKill(t *testing.T) {
+	tests := []struct {
+		name      string
+		mocker    func(t *testing.T, container string) *mocks.MockAPIClient
+		container string
+		wantErr   bool
+	}{
+		{
+			name: "ok",
+			mocker: func(t *testing.T, container string) *mocks.MockAPIClient {
+				ctrl := gomock.NewController(t)
+				dockerClient := mocks.NewMockAPIClient(ctrl)
+				dockerClient.EXPECT().
+					ContainerKill(context.Background(), container, "SIGHUP").
+					Return(nil)
+				return dockerClient
+			},
+			container: "prometheus",
+		},
+		{
+			name: "error signaling container",
+			mocker: func(t *testing.T, container string) *mocks.MockAPIClient {
+				ctrl := gomock.NewController(t)
+				dockerClient := mocks.NewMockAPIClient(ctrl)
+				dockerClient.EXPECT().
+					ContainerKill(context.Background(), container, "SIGHUP").
+					Return(errors.New("container is not running"))
+				return dockerClient
+			},
+			container: "prometheus",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dockerClient := tt.mocker(t, tt.container)
+			dockerManager := NewDockerManager(dockerClient)
+			err := dockerManager.ContainerKill(tt.container, "SIGHUP")
+			if tt.wantErr {
+				assert.Error(t, err, "Expected error not returned")
+			} else {
+				assert.NoError(t, err, "Unexpected error returned")
+			}
+		})
+	}
+}
+
 func TestNetworkConnect(t *testing.T) {
 	tests := []struct {
 		name      string
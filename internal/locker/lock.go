@@ -1,10 +1,17 @@
 package locker
 
-import "github.com/gofrs/flock"
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/flock"
+)
 
 type Locker interface {
 	New(path string) Locker
 	Lock() error
+	TryLockContext(ctx context.Context, retryDelay time.Duration) (bool, error)
+	TryRLockContext(ctx context.Context, retryDelay time.Duration) (bool, error)
 	Unlock() error
 	Locked() bool
 }
@@ -17,15 +24,28 @@ func NewFLock() Locker {
 	return &FLock{}
 }
 
+// New returns a new FLock for the given path. The receiver is not modified,
+// so each returned locker holds its own file handle.
 func (l *FLock) New(path string) Locker {
-	l.locker = flock.New(path)
-	return l
+	return &FLock{locker: flock.New(path)}
 }
 
 func (l *FLock) Lock() error {
 	return l.locker.Lock()
 }
 
+// TryLockContext repeatedly tries to take an exclusive lock until it succeeds,
+// or the context is done. It returns true if the lock was taken.
+func (l *FLock) TryLockContext(ctx context.Context, retryDelay time.Duration) (bool, error) {
+	return l.locker.TryLockContext(ctx, retryDelay)
+}
+
+// TryRLockContext repeatedly tries to take a shared lock until it succeeds, or
+// the context is done. It returns true if the lock was taken.
+func (l *FLock) TryRLockContext(ctx context.Context, retryDelay time.Duration) (bool, error) {
+	return l.locker.TryRLockContext(ctx, retryDelay)
+}
+
 func (l *FLock) Unlock() error {
 	return l.locker.Unlock()
 }
@@ -0,0 +1,51 @@
+package locker
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrLockless is returned when taking an exclusive lock with a NoLock.
+var ErrLockless = errors.New("exclusive locks are not supported in lockless mode")
+
+// NoLock is a Locker that doesn't lock anything, for data on read-only media
+// where lock files can't be created. Shared locks always succeed without
+// locking, as nothing can change the data, while exclusive locks, only needed
+// to change the data, return ErrLockless.
+type NoLock struct{}
+
+// NewNoLock returns a new NoLock.
+func NewNoLock() Locker {
+	return &NoLock{}
+}
+
+// New returns the receiver, as there is no lock file to open.
+func (l *NoLock) New(path string) Locker {
+	return l
+}
+
+// Lock returns ErrLockless.
+func (l *NoLock) Lock() error {
+	return ErrLockless
+}
+
+// TryLockContext returns ErrLockless.
+func (l *NoLock) TryLockContext(ctx context.Context, retryDelay time.Duration) (bool, error) {
+	return false, ErrLockless
+}
+
+// TryRLockContext succeeds without locking.
+func (l *NoLock) TryRLockContext(ctx context.Context, retryDelay time.Duration) (bool, error) {
+	return true, nil
+}
+
+// Unlock does nothing.
+func (l *NoLock) Unlock() error {
+	return nil
+}
+
+// Locked returns false, as nothing is ever locked.
+func (l *NoLock) Locked() bool {
+	return false
+}
@@ -1,11 +1,13 @@
 package package_handler
 
 import (
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"maps"
 	"path/filepath"
+	"sort"
 
 	"github.com/NethermindEth/eigenlayer/internal/env"
 	"github.com/NethermindEth/eigenlayer/internal/profile"
@@ -565,6 +567,27 @@ func (p *PackageHandler) checkSum() error {
 	return nil
 }
 
+// Digest returns the digest of the package content, in the form
+// sha256:<hex>. It is the SHA-256 of the package checksums listed in the
+// checksum.txt format, sorted by file path, so it identifies the installed
+// files regardless of the git history.
+func (p *PackageHandler) Digest() (string, error) {
+	hashes, err := packageHashes(p.path, p.afs)
+	if err != nil {
+		return "", err
+	}
+	files := make([]string, 0, len(hashes))
+	for file := range hashes {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	h := sha256.New()
+	for _, file := range files {
+		fmt.Fprintf(h, "%s  %s\n", hashes[file], filepath.ToSlash(file))
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
 func (p *PackageHandler) SpecVersion() (string, error) {
 	manifest, err := p.parseManifest()
 	if err != nil {
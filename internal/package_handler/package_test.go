@@ -1,6 +1,7 @@
 package package_handler
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"os"
 	"os/exec"
@@ -963,3 +964,30 @@ func TestCurrentVersion(t *testing.T) {
 func intP(i int) *int {
 	return &i
 }
+
+func TestDigest(t *testing.T) {
+	afs := afero.NewOsFs()
+	testDir := t.TempDir()
+	testdata.SetupDir(t, "mock-avs", testDir, afs)
+	pkgPath := filepath.Join(testDir, "mock-avs")
+	pkgHandler := NewPackageHandler(pkgPath)
+
+	digest, err := pkgHandler.Digest()
+	require.NoError(t, err)
+	// The digest is the SHA-256 of the checksums sorted by path, in the
+	// checksum.txt format.
+	var checksums string
+	for _, file := range []string{"pkg/manifest.yml", "pkg/sepolia/.env", "pkg/sepolia/docker-compose.yml", "pkg/sepolia/profile.yml"} {
+		data, err := afero.ReadFile(afs, filepath.Join(pkgPath, file))
+		require.NoError(t, err)
+		checksums += fmt.Sprintf("%x  %s\n", sha256.Sum256(data), file)
+	}
+	assert.Equal(t, fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(checksums))), digest)
+
+	// Any change in the package changes the digest
+	err = afero.WriteFile(afs, filepath.Join(pkgPath, "pkg", "sepolia", ".env"), []byte("CHANGED=true"), 0o644)
+	require.NoError(t, err)
+	newDigest, err := pkgHandler.Digest()
+	require.NoError(t, err)
+	assert.NotEqual(t, digest, newDigest)
+}
@@ -2,16 +2,52 @@ package utils
 
 import (
 	"archive/tar"
+	"bufio"
 	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
 )
 
+// tarBlockSize is the size of the blocks of a tar archive.
+const tarBlockSize = 512
+
+// DefaultTarBufferSize is the default size of the buffers used to read and
+// write tar archives.
+const DefaultTarBufferSize = 64 * 1024
+
+// ErrTarTooLarge is returned when appending to a tar archive would make it
+// larger than the given maximum size.
+var ErrTarTooLarge = errors.New("tar archive too large")
+
+// tarBufferSize is the size of the buffers used by TarInit, TarAddFile and
+// TarAddDir.
+var tarBufferSize atomic.Int64
+
+// SetTarBufferSize sets the size of the buffers used to read the archived
+// files and write the tar archives. Archives with many small files are written
+// with far fewer writes, as entries are no longer written block by block. A
+// size of zero or less restores DefaultTarBufferSize.
+func SetTarBufferSize(size int) {
+	tarBufferSize.Store(int64(size))
+}
+
+// TarBufferSize returns the size of the buffers used to read the archived
+// files and write the tar archives.
+func TarBufferSize() int {
+	if size := tarBufferSize.Load(); size > 0 {
+		return int(size)
+	}
+	return DefaultTarBufferSize
+}
+
 func CompressToTarGz(srcDir string, tarFile io.Writer) error {
 	gw := gzip.NewWriter(tarFile)
 	defer gw.Close()
@@ -112,3 +148,237 @@ func DecompressTarGz(tarFile io.Reader, destDir string) error {
 		}
 	}
 }
+
+// TarInit creates an empty tar archive at tarPath, replacing any existing file.
+func TarInit(fs afero.Fs, tarPath string) error {
+	f, err := fs.Create(tarPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	bw := bufio.NewWriterSize(f, TarBufferSize())
+	if err := tar.NewWriter(bw).Close(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// TarAddFile appends the file at srcPath to the existing tar archive at
+// tarPath, as archivePath. Archives are append-only: entries can't be replaced
+// or removed, and adding an existing archivePath again adds a duplicate entry.
+func TarAddFile(fs afero.Fs, tarPath, srcPath, archivePath string) error {
+	return TarAddFileLimited(fs, tarPath, srcPath, archivePath, 0)
+}
+
+// TarAddFileLimited is like TarAddFile, but fails with ErrTarTooLarge as soon
+// as the archive grows larger than maxSize bytes while being written, leaving
+// a partial archive behind. A maxSize of zero or less means no limit.
+func TarAddFileLimited(fs afero.Fs, tarPath, srcPath, archivePath string, maxSize int64) error {
+	info, err := fs.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", srcPath)
+	}
+	return tarAppend(fs, tarPath, maxSize, func(tw *tar.Writer, br *bufio.Reader) error {
+		return tarWriteEntry(fs, tw, br, srcPath, filepath.ToSlash(archivePath), info)
+	})
+}
+
+// TarAddDir appends the directory tree at srcDir to the existing tar archive at
+// tarPath, under archiveDir. Like TarAddFile, it is append-only. The paths
+// matching any of the exclude patterns, as defined by ExcludedPath, are left
+// out.
+func TarAddDir(fs afero.Fs, tarPath, srcDir, archiveDir string, exclude ...string) error {
+	return TarAddDirLimited(fs, tarPath, srcDir, archiveDir, 0, exclude...)
+}
+
+// TarAddDirLimited is like TarAddDir, but limits the size of the archive like
+// TarAddFileLimited.
+func TarAddDirLimited(fs afero.Fs, tarPath, srcDir, archiveDir string, maxSize int64, exclude ...string) error {
+	return TarAddDirLimitedContext(context.Background(), fs, tarPath, srcDir, archiveDir, maxSize, exclude...)
+}
+
+// TarAddDirLimitedContext is like TarAddDirLimited, but checks ctx before each
+// entry and stops with the context error once ctx is done, leaving a partial
+// archive behind.
+func TarAddDirLimitedContext(ctx context.Context, fs afero.Fs, tarPath, srcDir, archiveDir string, maxSize int64, exclude ...string) error {
+	if err := ValidateExcludePatterns(exclude); err != nil {
+		return err
+	}
+	return tarAppend(fs, tarPath, maxSize, func(tw *tar.Writer, br *bufio.Reader) error {
+		return afero.Walk(fs, srcDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			relPath, err := filepath.Rel(srcDir, path)
+			if err != nil {
+				return err
+			}
+			if ExcludedPath(exclude, relPath) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			return tarWriteEntry(fs, tw, br, path, filepath.ToSlash(filepath.Join(archiveDir, relPath)), info)
+		})
+	})
+}
+
+// ExcludedPath returns true if the path, relative to the root of a copied
+// tree, matches any of the patterns. Patterns use filepath.Match syntax and
+// match the whole relative path, so * doesn't match across
+// directories: "logs/*" matches the entries of the logs directory, but not the
+// directory itself. Excluding a directory excludes its whole tree. The root
+// itself is never excluded.
+func ExcludedPath(patterns []string, relPath string) bool {
+	if relPath == "." {
+		return false
+	}
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, relPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateExcludePatterns returns an error wrapping filepath.ErrBadPattern if
+// any of the patterns is malformed.
+func ValidateExcludePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// tarAppend opens the tar archive at tarPath and calls fn with a writer
+// positioned over the end-of-archive marker, so the written entries follow the
+// existing ones. The end-of-archive marker is written again on close. fn also
+// gets a reader buffer to read the archived files with, reused across them.
+// If maxSize is positive, the writes that would make the archive larger fail
+// with ErrTarTooLarge.
+func tarAppend(fs afero.Fs, tarPath string, maxSize int64, fn func(tw *tar.Writer, br *bufio.Reader) error) (err error) {
+	f, err := fs.OpenFile(tarPath, os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+
+	bufferSize := TarBufferSize()
+	end, err := tarEnd(bufio.NewReaderSize(f, bufferSize))
+	if err != nil {
+		return err
+	}
+	if err = f.Truncate(end); err != nil {
+		return err
+	}
+	if _, err = f.Seek(end, io.SeekStart); err != nil {
+		return err
+	}
+	var w io.Writer = f
+	if maxSize > 0 {
+		w = &limitedWriter{w: f, remaining: maxSize - end}
+	}
+	bw := bufio.NewWriterSize(w, bufferSize)
+	tw := tar.NewWriter(bw)
+	if err = fn(tw, bufio.NewReaderSize(nil, bufferSize)); err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	// Flush before the file is closed, or the end of the archive is lost
+	return bw.Flush()
+}
+
+// limitedWriter is a writer failing with ErrTarTooLarge once more than
+// remaining bytes would be written to w.
+type limitedWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining {
+		return 0, ErrTarTooLarge
+	}
+	n, err := l.w.Write(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// tarEnd returns the offset of the end of the last entry of the tar archive,
+// which is where the end-of-archive zero blocks start.
+func tarEnd(r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+	tr := tar.NewReader(cr)
+	var end int64
+	for {
+		_, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return end, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return 0, err
+		}
+		// Entry data is padded to a whole block
+		end = (cr.n + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+	}
+}
+
+func tarWriteEntry(fs afero.Fs, tw *tar.Writer, br *bufio.Reader, path, name string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := fs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br.Reset(f)
+	_, err = io.Copy(tw, br)
+	return err
+}
+
+// countingReader counts the bytes read from r. It hides any Seek method of r,
+// so the tar reader reads every byte.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
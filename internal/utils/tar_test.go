@@ -1,12 +1,17 @@
 package utils
 
 import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"testing"
 
 	"github.com/NethermindEth/eigenlayer/internal/common"
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -85,3 +90,207 @@ func assertEqualFiles(t *testing.T, f1, f2 string) {
 	require.NoError(t, err, "failed to read file %s", f2)
 	assert.Equal(t, file1, file2)
 }
+
+func TestTarAppend(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/src/a.txt", []byte("a"), 0o644))
+	require.NoError(t, afero.WriteFile(fs, "/src/dir/b.txt", []byte("b"), 0o644))
+	require.NoError(t, afero.WriteFile(fs, "/src/dir/sub/c.txt", make([]byte, 1000), 0o600))
+	require.NoError(t, afero.WriteFile(fs, "/src/d.txt", []byte("d"), 0o644))
+
+	// Build the archive incrementally
+	require.NoError(t, TarInit(fs, "/backup.tar"))
+	require.NoError(t, TarAddFile(fs, "/backup.tar", "/src/a.txt", "files/a.txt"))
+	require.NoError(t, TarAddDir(fs, "/backup.tar", "/src/dir", "data"))
+	require.NoError(t, TarAddFile(fs, "/backup.tar", "/src/d.txt", "files/d.txt"))
+
+	f, err := fs.Open("/backup.tar")
+	require.NoError(t, err)
+	defer f.Close()
+	tr := tar.NewReader(f)
+	entries := make(map[string]string)
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		names = append(names, header.Name)
+		entries[header.Name] = string(content)
+	}
+	assert.Equal(t, []string{"files/a.txt", "data/", "data/b.txt", "data/sub/", "data/sub/c.txt", "files/d.txt"}, names)
+	assert.Equal(t, "a", entries["files/a.txt"])
+	assert.Equal(t, "b", entries["data/b.txt"])
+	assert.Equal(t, string(make([]byte, 1000)), entries["data/sub/c.txt"])
+	assert.Equal(t, "d", entries["files/d.txt"])
+
+	// Only one end-of-archive marker is kept
+	info, err := fs.Stat("/backup.tar")
+	require.NoError(t, err)
+	// 6 headers, 5 data blocks and 2 end-of-archive blocks
+	assert.EqualValues(t, (6+5+2)*tarBlockSize, info.Size())
+}
+
+func TestTarAddDirExclude(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/src/.env", []byte("a"), 0o644))
+	require.NoError(t, afero.WriteFile(fs, "/src/logs/node.log", []byte("b"), 0o644))
+	require.NoError(t, afero.WriteFile(fs, "/src/data/chaindata/000001.ldb", []byte("c"), 0o644))
+	require.NoError(t, afero.WriteFile(fs, "/src/data/key.json", []byte("d"), 0o644))
+
+	require.NoError(t, TarInit(fs, "/backup.tar"))
+	err := TarAddDir(fs, "/backup.tar", "/src", "data", "[bad")
+	require.ErrorIs(t, err, filepath.ErrBadPattern)
+	require.NoError(t, TarAddDir(fs, "/backup.tar", "/src", "data", "logs/*", "data/chaindata"))
+
+	f, err := fs.Open("/backup.tar")
+	require.NoError(t, err)
+	defer f.Close()
+	tr := tar.NewReader(f)
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+	}
+	assert.Equal(t, []string{"data/", "data/.env", "data/data/", "data/data/key.json", "data/logs/"}, names)
+}
+
+func TestTarAddFileErrors(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/src/a.txt", []byte("a"), 0o644))
+
+	// Missing archive
+	err := TarAddFile(fs, "/missing.tar", "/src/a.txt", "a.txt")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Directories must be added with TarAddDir
+	require.NoError(t, TarInit(fs, "/backup.tar"))
+	err = TarAddFile(fs, "/backup.tar", "/src", "src")
+	assert.Error(t, err)
+}
+
+func TestTarAddLimited(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/src/small.txt", []byte("small"), 0o644))
+	require.NoError(t, afero.WriteFile(fs, "/src/big.bin", make([]byte, 1<<20), 0o644))
+	const maxSize = 16 << 10
+
+	require.NoError(t, TarInit(fs, "/backup.tar"))
+	require.NoError(t, TarAddFileLimited(fs, "/backup.tar", "/src/small.txt", "small.txt", maxSize))
+	err := TarAddFileLimited(fs, "/backup.tar", "/src/big.bin", "big.bin", maxSize)
+	assert.ErrorIs(t, err, ErrTarTooLarge)
+	// The archive never grows past the limit
+	info, err := fs.Stat("/backup.tar")
+	require.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), int64(maxSize))
+
+	require.NoError(t, TarInit(fs, "/dir.tar"))
+	err = TarAddDirLimited(fs, "/dir.tar", "/src", "data", maxSize)
+	assert.ErrorIs(t, err, ErrTarTooLarge)
+	require.NoError(t, TarInit(fs, "/excluded.tar"))
+	require.NoError(t, TarAddDirLimited(fs, "/excluded.tar", "/src", "data", maxSize, "big.bin"))
+}
+
+// newTarBufferTestFs returns a filesystem with a tree of many small files under
+// /src.
+func newTarBufferTestFs(t testing.TB, files int) afero.Fs {
+	fs := afero.NewMemMapFs()
+	for i := 0; i < files; i++ {
+		path := fmt.Sprintf("/src/dir%d/file%d.txt", i%10, i)
+		require.NoError(t, afero.WriteFile(fs, path, []byte(fmt.Sprintf("content of file %d", i)), 0o644))
+	}
+	require.NoError(t, afero.WriteFile(fs, "/src/big.bin", make([]byte, 3*DefaultTarBufferSize+7), 0o644))
+	return fs
+}
+
+func TestTarBufferSize(t *testing.T) {
+	t.Cleanup(func() { SetTarBufferSize(0) })
+	assert.Equal(t, DefaultTarBufferSize, TarBufferSize())
+
+	fs := newTarBufferTestFs(t, 100)
+	var archives [][]byte
+	for _, size := range []int{16, 0, 1 << 20} {
+		SetTarBufferSize(size)
+		tarPath := fmt.Sprintf("/backup-%d.tar", size)
+		require.NoError(t, TarInit(fs, tarPath))
+		require.NoError(t, TarAddFile(fs, tarPath, "/src/big.bin", "files/big.bin"))
+		require.NoError(t, TarAddDir(fs, tarPath, "/src", "data"))
+		archive, err := afero.ReadFile(fs, tarPath)
+		require.NoError(t, err)
+		archives = append(archives, archive)
+	}
+	// The buffer size doesn't change the archive
+	assert.Equal(t, archives[0], archives[1])
+	assert.Equal(t, archives[0], archives[2])
+
+	// Every entry is written, along with the end-of-archive marker
+	tr := tar.NewReader(bytes.NewReader(archives[1]))
+	var entries int
+	for {
+		_, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		entries++
+	}
+	// 100 files, 10 directories, the root, and big.bin twice
+	assert.Equal(t, 113, entries)
+}
+
+// writeCountingFs counts the writes to the files it opens.
+type writeCountingFs struct {
+	afero.Fs
+	writes *int64
+}
+
+func (fs writeCountingFs) Create(name string) (afero.File, error) {
+	f, err := fs.Fs.Create(name)
+	return writeCountingFile{File: f, writes: fs.writes}, err
+}
+
+func (fs writeCountingFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	f, err := fs.Fs.OpenFile(name, flag, perm)
+	return writeCountingFile{File: f, writes: fs.writes}, err
+}
+
+type writeCountingFile struct {
+	afero.File
+	writes *int64
+}
+
+func (f writeCountingFile) Write(p []byte) (int, error) {
+	*f.writes++
+	return f.File.Write(p)
+}
+
+func BenchmarkTarAddDir(b *testing.B) {
+	memFs := newTarBufferTestFs(b, 1000)
+	b.Cleanup(func() { SetTarBufferSize(0) })
+	// A buffer of one tar block writes the archive block by block, as with no
+	// buffering
+	for _, size := range []int{tarBlockSize, DefaultTarBufferSize} {
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			SetTarBufferSize(size)
+			var writes int64
+			fs := writeCountingFs{Fs: memFs, writes: &writes}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := TarInit(fs, "/backup.tar"); err != nil {
+					b.Fatal(err)
+				}
+				if err := TarAddDir(fs, "/backup.tar", "/src", "data"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(writes)/float64(b.N), "writes/op")
+		})
+	}
+}
@@ -4,4 +4,10 @@ type BackupManager interface {
 	// BackupInstance creates a backup of the instance with the given ID.
 	BackupInstance(instanceId string) (string, error)
 	RestoreInstance(backupId string) error
+	// SetForce sets whether instances locked by another process are backed up
+	// anyway.
+	SetForce(force bool)
+	// SetExclude sets the patterns of the instance data paths left out of the
+	// backups.
+	SetExclude(patterns []string) error
 }
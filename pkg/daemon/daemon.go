@@ -81,6 +81,9 @@ type Daemon interface {
 	// will be returned.
 	Backup(instanceId string) (backupId string, err error)
 
+	// BackupWithOptions is like Backup, but with the given options.
+	BackupWithOptions(instanceId string, options BackupOptions) (backupId string, err error)
+
 	// Restore restores the backup with the given ID. If the AVS instance id of
 	// the backup exists, then the command will uninstall it before restoring
 	// the backup. If the AVS instance does not exist, then the command will
@@ -108,6 +111,7 @@ type ListInstanceItem struct {
 	ID      string
 	Version string
 	Commit  string
+	Digest  string
 	Health  NodeHealth
 	Running bool
 	Comment string
@@ -266,6 +270,17 @@ func (h HardwareRequirements) String() string {
 	return fmt.Sprintf("CPU: %d Cores, RAM: %d Mb, Disk Space: %d Mb", h.MinCPUCores, h.MinRAM, h.MinFreeSpace)
 }
 
+// BackupOptions is a set of options for the backup of an instance.
+type BackupOptions struct {
+	// Force backs up the instance even if it is locked by another process,
+	// in which case the backup may be inconsistent.
+	Force bool
+	// Exclude are the patterns of the instance data paths left out of the
+	// backup, relative to the instance directory. Volumes are always backed
+	// up whole.
+	Exclude []string
+}
+
 type BackupInfo struct {
 	Id        string
 	Instance  string
@@ -274,4 +289,5 @@ type BackupInfo struct {
 	Version   string
 	Commit    string
 	Url       string
+	Checksum  string
 }
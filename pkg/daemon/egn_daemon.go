@@ -12,6 +12,7 @@ import (
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -31,6 +32,10 @@ import (
 	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
 )
 
+// localVersion is the version and commit of the instances installed from a
+// local package.
+const localVersion = "local"
+
 // Checks that EgnDaemon implements Daemon.
 var _ = Daemon(&EgnDaemon{})
 
@@ -153,6 +158,7 @@ func (d *EgnDaemon) ListInstances() ([]ListInstanceItem, error) {
 				Comment: fmt.Sprintf("Failed to get instance status: %v", err),
 				Version: instance.Version,
 				Commit:  instance.Commit,
+				Digest:  instance.Digest,
 			})
 			continue
 		}
@@ -164,6 +170,7 @@ func (d *EgnDaemon) ListInstances() ([]ListInstanceItem, error) {
 		item.Running = running
 		item.Version = instance.Version
 		item.Commit = instance.Commit
+		item.Digest = instance.Digest
 		result = append(result, item)
 	}
 	return result, nil
@@ -539,9 +546,9 @@ func (d *EgnDaemon) LocalPullUpdate(instanceID string, pkgTar io.Reader) (PullUp
 		Profile:       instance.Profile,
 		HasPlugin:     instance.Plugin != nil,
 		OldVersion:    instance.Version,
-		NewVersion:    "local",
+		NewVersion:    localVersion,
 		OldCommit:     instance.Commit,
-		NewCommit:     "local",
+		NewCommit:     localVersion,
 		OldOptions:    optionsOld,
 		NewOptions:    optionsNew,
 		MergedOptions: mergedOptions,
@@ -733,9 +740,9 @@ func (d *EgnDaemon) localInstall(pkgTar io.Reader, options LocalInstallOptions)
 		Profile:     options.Profile,
 		Tag:         options.Tag,
 		URL:         "http://localhost",
-		Version:     "local",
+		Version:     localVersion,
 		SpecVersion: specVersion,
-		Commit:      "local",
+		Commit:      localVersion,
 	}
 	return d.install(options.Name, instanceID, tID, pkgHandler, selectedProfile, env, installOptions)
 }
@@ -848,6 +855,11 @@ func (d *EgnDaemon) install(
 		}
 	}
 
+	digest, err := pkgHandler.Digest()
+	if err != nil {
+		return instanceID, tID, err
+	}
+
 	// Init instance
 	instance := data.Instance{
 		Name:              instanceName,
@@ -855,13 +867,19 @@ func (d *EgnDaemon) install(
 		Version:           options.Version,
 		SpecVersion:       options.SpecVersion,
 		Commit:            options.Commit,
+		Digest:            digest,
 		URL:               options.URL,
 		Tag:               options.Tag,
 		MonitoringTargets: data.MonitoringTargets{Targets: monitoringTargets},
 		APITarget:         apiTarget,
 		Plugin:            plugin,
+		// Local installs are not releases, so their version is not a semver
+		AllowArbitraryVersion: options.Version == localVersion,
+	}
+	if err = d.dataDir.CheckInstallSpace(pkgHandler.ProfilePath(instance.Profile)); err != nil {
+		return instanceID, tID, err
 	}
-	if err = d.dataDir.InitInstance(&instance); err != nil {
+	if _, err = d.dataDir.InitInstance(&instance); err != nil {
 		return instanceID, tID, err
 	}
 
@@ -951,6 +969,15 @@ func (d *EgnDaemon) Stop(instanceID string) error {
 
 // Uninstall implements Daemon.Uninstall.
 func (d *EgnDaemon) Uninstall(instanceID string) error {
+	// Check the dependents before anything is torn down, as the instance
+	// directory can't be removed while other instances depend on it.
+	dependents, err := d.dataDir.Dependents(instanceID)
+	if err != nil {
+		return err
+	}
+	if len(dependents) > 0 {
+		return fmt.Errorf("%w: %s is required by %s", data.ErrInstanceHasDependents, instanceID, strings.Join(dependents, ", "))
+	}
 	return d.uninstall(instanceID, true)
 }
 
@@ -988,7 +1015,7 @@ func (d *EgnDaemon) uninstall(instanceID string, down bool) error {
 	}
 
 	// remove instance directory
-	return d.dataDir.RemoveInstance(instanceID)
+	return d.dataDir.RemoveInstance(instanceID, false)
 }
 
 // CheckHardwareRequirements implements Daemon.CheckHardwareRequirements
@@ -1121,9 +1148,17 @@ func (d *EgnDaemon) NodeLogs(ctx context.Context, w io.Writer, instanceID string
 }
 
 func (d *EgnDaemon) Backup(instanceId string) (string, error) {
+	return d.BackupWithOptions(instanceId, BackupOptions{})
+}
+
+func (d *EgnDaemon) BackupWithOptions(instanceId string, options BackupOptions) (string, error) {
 	if !d.HasInstance(instanceId) {
 		return "", fmt.Errorf("%w: %s", ErrInstanceNotFound, instanceId)
 	}
+	if err := d.backupManager.SetExclude(options.Exclude); err != nil {
+		return "", err
+	}
+	d.backupManager.SetForce(options.Force)
 	log.Infof("Stopping instance %s", instanceId)
 	err := d.Stop(instanceId)
 	if err != nil {
@@ -1188,6 +1223,7 @@ func (d *EgnDaemon) BackupList() ([]BackupInfo, error) {
 			Version:   b.Version,
 			Commit:    b.Commit,
 			Url:       b.Url,
+			Checksum:  b.Checksum,
 		}
 	}
 	return out, nil
@@ -1746,7 +1746,9 @@ func TestListInstances(t *testing.T) {
 					}
 				}`)
 
-				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(3)
+				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(4)
+				d.locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+				d.locker.EXPECT().Unlock().Return(nil)
 				// Mocks
 				gomock.InOrder(
 					d.composeManager.EXPECT().PS(compose.DockerComposePsOptions{
@@ -1859,7 +1861,9 @@ func TestListInstances(t *testing.T) {
 				var mockCalls []*gomock.Call
 				for _, instance := range instances {
 					initInstanceDir(t, d.fs, d.dataDirPath, instance.id, instance.stateJSON)
-					d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", instance.id, ".lock")).Return(d.locker).Times(3)
+					d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", instance.id, ".lock")).Return(d.locker).Times(4)
+					d.locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+					d.locker.EXPECT().Unlock().Return(nil)
 					mockCalls = append(mockCalls,
 						d.composeManager.EXPECT().PS(compose.DockerComposePsOptions{
 							Path:          filepath.Join(d.dataDirPath, "nodes", instance.id, "docker-compose.yml"),
@@ -1995,8 +1999,12 @@ func TestListInstances(t *testing.T) {
 					}(),
 				}
 
-				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-0", ".lock")).Return(d.locker).Times(3)
-				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-1", ".lock")).Return(d.locker).Times(2)
+				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-0", ".lock")).Return(d.locker).Times(4)
+				d.locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+				d.locker.EXPECT().Unlock().Return(nil)
+				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-1", ".lock")).Return(d.locker).Times(3)
+				d.locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+				d.locker.EXPECT().Unlock().Return(nil)
 
 				var mockCalls []*gomock.Call
 				for _, instance := range instances {
@@ -2102,8 +2110,12 @@ func TestListInstances(t *testing.T) {
 					},
 				}
 
-				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-0", ".lock")).Return(d.locker).Times(3)
-				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-1", ".lock")).Return(d.locker).Times(3)
+				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-0", ".lock")).Return(d.locker).Times(4)
+				d.locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+				d.locker.EXPECT().Unlock().Return(nil)
+				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-1", ".lock")).Return(d.locker).Times(4)
+				d.locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+				d.locker.EXPECT().Unlock().Return(nil)
 
 				var mockCalls []*gomock.Call
 				for _, instance := range instances {
@@ -2172,8 +2184,12 @@ func TestListInstances(t *testing.T) {
 					},
 				}
 
-				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-0", ".lock")).Return(d.locker).Times(2)
-				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-1", ".lock")).Return(d.locker).Times(2)
+				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-0", ".lock")).Return(d.locker).Times(3)
+				d.locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+				d.locker.EXPECT().Unlock().Return(nil)
+				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-1", ".lock")).Return(d.locker).Times(3)
+				d.locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+				d.locker.EXPECT().Unlock().Return(nil)
 
 				var mockCalls []*gomock.Call
 				for _, instance := range instances {
@@ -2224,7 +2240,9 @@ func TestListInstances(t *testing.T) {
 				}
 			}`)
 
-				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(3)
+				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(4)
+				d.locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+				d.locker.EXPECT().Unlock().Return(nil)
 				// Mocks
 				gomock.InOrder(
 					d.composeManager.EXPECT().PS(compose.DockerComposePsOptions{
@@ -2281,7 +2299,9 @@ func TestListInstances(t *testing.T) {
 						}
 					}`)
 
-					d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(3)
+					d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(4)
+					d.locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+					d.locker.EXPECT().Unlock().Return(nil)
 
 					// Mocks
 					gomock.InOrder(
@@ -2345,7 +2365,9 @@ func TestListInstances(t *testing.T) {
 				}
 			}`)
 
-				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(2)
+				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(3)
+				d.locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+				d.locker.EXPECT().Unlock().Return(nil)
 
 				// Mocks
 				gomock.InOrder(
@@ -2386,7 +2408,9 @@ func TestListInstances(t *testing.T) {
 					}
 				}`)
 
-				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(3)
+				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(4)
+				d.locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+				d.locker.EXPECT().Unlock().Return(nil)
 
 				// Mocks
 				gomock.InOrder(
@@ -2444,7 +2468,9 @@ func TestListInstances(t *testing.T) {
 					}
 				}`)
 
-				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(3)
+				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(4)
+				d.locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+				d.locker.EXPECT().Unlock().Return(nil)
 
 				// Mocks
 				gomock.InOrder(
@@ -2502,7 +2528,9 @@ func TestListInstances(t *testing.T) {
 					}
 				}`)
 
-				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(3)
+				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(4)
+				d.locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+				d.locker.EXPECT().Unlock().Return(nil)
 
 				// Mocks
 				gomock.InOrder(
@@ -2560,7 +2588,9 @@ func TestListInstances(t *testing.T) {
 					}
 				}`)
 
-				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(2)
+				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(3)
+				d.locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+				d.locker.EXPECT().Unlock().Return(nil)
 
 				// Mocks
 				gomock.InOrder(
@@ -2601,7 +2631,9 @@ func TestListInstances(t *testing.T) {
 					}
 				}`)
 
-				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(3)
+				d.locker.EXPECT().New(filepath.Join(d.dataDirPath, "nodes", "mock-avs-default", ".lock")).Return(d.locker).Times(4)
+				d.locker.EXPECT().TryRLockContext(gomock.Any(), gomock.Any()).Return(true, nil)
+				d.locker.EXPECT().Unlock().Return(nil)
 
 				// Mocks
 				gomock.InOrder(
@@ -10,6 +10,10 @@ type DockerManager interface {
 	// ContainerIP returns the IP address of the container.
 	ContainerIP(container string) (string, error)
 
+	// ContainerKill sends a signal, such as SIGHUP, to the main process of a
+	// container.
+	ContainerKill(container, signal string) error
+
 	// ContainerNetworks returns the networks of a container.
 	ContainerNetworks(container string) ([]string, error)
 
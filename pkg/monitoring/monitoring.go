@@ -49,6 +49,12 @@ func NewMonitoringManager(
 		log.Fatal(err)
 	}
 
+	for _, service := range services {
+		if s, ok := service.(reloadSignalerSetter); ok {
+			s.SetReloadSignaler(&containerReloadSignaler{dockerManager: dockerMgr, container: service.ContainerName()})
+		}
+	}
+
 	return &MonitoringManager{
 		services:       services,
 		composeManager: cmpMgr,
@@ -57,6 +63,22 @@ func NewMonitoringManager(
 	}
 }
 
+// reloadSignalerSetter is implemented by the services able to reload their
+// configuration on SIGHUP, such as Prometheus with the sighup reload strategy.
+type reloadSignalerSetter interface {
+	SetReloadSignaler(signaler types.ReloadSignaler)
+}
+
+// containerReloadSignaler sends SIGHUP to the container of a service.
+type containerReloadSignaler struct {
+	dockerManager DockerManager
+	container     string
+}
+
+func (s *containerReloadSignaler) SignalReload() error {
+	return s.dockerManager.ContainerKill(s.container, "SIGHUP")
+}
+
 // Init initializes the monitoring stack. Assumes that the stack is already installed.
 func (m *MonitoringManager) Init() error {
 	// Read installed .env
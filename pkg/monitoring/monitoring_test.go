@@ -1081,6 +1081,48 @@ func TestAddAndRemoveTarget(t *testing.T) {
 	}
 }
 
+// signaledService is a service reloading its configuration on SIGHUP.
+type signaledService struct {
+	*mocks.MockServiceAPI
+	signaler types.ReloadSignaler
+}
+
+func (s *signaledService) SetReloadSignaler(signaler types.ReloadSignaler) {
+	s.signaler = signaler
+}
+
+func TestReloadSignaler(t *testing.T) {
+	userDataHome := os.Getenv("XDG_DATA_HOME")
+	if userDataHome == "" {
+		userHome, err := os.UserHomeDir()
+		require.NoError(t, err)
+		userDataHome = filepath.Join(userHome, ".local", "share")
+	}
+	ctrl := gomock.NewController(t)
+	locker := mock_locker.NewMockLocker(ctrl)
+	locker.EXPECT().New(filepath.Join(userDataHome, ".eigen", "monitoring", ".lock")).Return(locker)
+	prometheusService := &signaledService{MockServiceAPI: mocks.NewMockServiceAPI(ctrl)}
+	prometheusService.EXPECT().ContainerName().Return(PrometheusContainerName)
+	// Services without SIGHUP reloads get no signaler
+	otherService := mocks.NewMockServiceAPI(ctrl)
+	dockerManager := mocks.NewMockDockerManager(ctrl)
+
+	NewMonitoringManager(
+		[]ServiceAPI{prometheusService, otherService},
+		mocks.NewMockComposeManager(ctrl),
+		dockerManager,
+		afero.NewMemMapFs(),
+		locker,
+	)
+	require.NotNil(t, prometheusService.signaler)
+
+	// The signaler sends SIGHUP to the container of the service
+	dockerManager.EXPECT().ContainerKill(PrometheusContainerName, "SIGHUP").Return(nil)
+	require.NoError(t, prometheusService.signaler.SignalReload())
+	dockerManager.EXPECT().ContainerKill(PrometheusContainerName, "SIGHUP").Return(errors.New("container is not running"))
+	assert.Error(t, prometheusService.signaler.SignalReload())
+}
+
 func TestRun(t *testing.T) {
 	// Silence logger
 	log.SetOutput(io.Discard)
@@ -0,0 +1,105 @@
+package prometheus
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+	"gopkg.in/yaml.v3"
+)
+
+// TargetAdd is a target added by Apply, with the arguments of AddTarget.
+type TargetAdd struct {
+	Target  types.MonitoringTarget
+	Labels  map[string]string
+	JobName string
+}
+
+// TargetChangeSet lists the changes made together by Apply: the targets to add
+// and the ids of the instances whose targets are removed.
+type TargetChangeSet struct {
+	Add    []TargetAdd
+	Remove []string
+}
+
+// Apply makes the changes of the change set with a single write of the
+// Prometheus config and a single reload. The jobs of the removed instances are
+// removed first, like by RemoveTargetsByInstance, and then the targets are
+// added like by AddTarget, skipping the jobs that already exist. If any change
+// is invalid, such as the removal of an instance without targets, or the
+// resulting config doesn't validate, the config is left untouched. Nothing is
+// written nor reloaded if the config doesn't change. File service discovery is
+// not supported.
+func (p *PrometheusService) Apply(changes TargetChangeSet) error {
+	if p.discovery == FileDiscovery {
+		return fmt.Errorf("%w: batch target changes", ErrFileSDUnsupported)
+	}
+	adds := make([]ScrapeConfig, 0, len(changes.Add))
+	for _, add := range changes.Add {
+		endpoint, labels, jobName, err := p.prepareTarget(add.Target, add.Labels, add.JobName)
+		if err != nil {
+			return err
+		}
+		adds = append(adds, targetJob(add.Target, endpoint, labels, jobName))
+	}
+
+	var (
+		changed        bool
+		added, removed int
+	)
+	err := p.stack.WithLock(func(s *data.LockedMonitoringStack) error {
+		var err error
+		changed, err = p.updateConfig(s, func(config *Config) error {
+			added, removed = 0, 0
+			for _, instanceID := range changes.Remove {
+				jobs := make([]ScrapeConfig, 0, len(config.ScrapeConfigs))
+				for _, job := range config.ScrapeConfigs {
+					if !isInstanceScrapeConfig(job, instanceID) {
+						jobs = append(jobs, job)
+					}
+				}
+				if len(jobs) == len(config.ScrapeConfigs) {
+					return fmt.Errorf("%w: %s", monitoring.ErrNonexistingTarget, instanceID)
+				}
+				removed += len(config.ScrapeConfigs) - len(jobs)
+				config.ScrapeConfigs = jobs
+			}
+			for _, add := range adds {
+				exists := slices.ContainsFunc(config.ScrapeConfigs, func(job ScrapeConfig) bool {
+					return job.JobName == add.JobName
+				})
+				if !exists {
+					config.ScrapeConfigs = append(config.ScrapeConfigs, add)
+					added++
+				}
+			}
+			newConfig, err := yaml.Marshal(config)
+			if err != nil {
+				return err
+			}
+			if err := validateConfig(newConfig); err != nil {
+				return fmt.Errorf("%w: %s", ErrInvalidConfig, err)
+			}
+			return nil
+		})
+		if err != nil || !changed {
+			return err
+		}
+		p.targetsAdded(added)
+		p.targetsRemoved(removed)
+		// Remove the scrape secrets of the removed instances
+		for _, instanceID := range changes.Remove {
+			if err := s.RemoveAll(filepath.Join(secretsDir, instanceID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || !changed {
+		return err
+	}
+	return p.reloadConfig()
+}
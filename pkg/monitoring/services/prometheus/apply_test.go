@@ -0,0 +1,120 @@
+package prometheus
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/internal/locker/mocks"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestApply(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	options := map[string]string{
+		"PROM_PORT":          "9999",
+		"NODE_EXPORTER_PORT": "9100",
+	}
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: options,
+	})
+	require.NoError(t, err)
+	err = prometheus.Setup(options)
+	require.NoError(t, err)
+
+	// Setup mock http server counting the reloads
+	var reloads int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reloads++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.URL[len("http://"):])
+	require.NoError(t, err)
+	prometheus.containerIP = net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	prometheus.port = uint16(p)
+
+	readConfig := func() Config {
+		promYml, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+		require.NoError(t, err)
+		var prom Config
+		require.NoError(t, yaml.Unmarshal(promYml, &prom))
+		return prom
+	}
+	jobNames := func() []string {
+		var names []string
+		for _, job := range readConfig().ScrapeConfigs {
+			names = append(names, job.JobName)
+		}
+		return names
+	}
+
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8000}, nil, "old-avs--main++holesky")
+	require.NoError(t, err)
+	require.Equal(t, 1, reloads)
+
+	err = prometheus.Apply(TargetChangeSet{
+		Add: []TargetAdd{
+			{Target: types.MonitoringTarget{Host: "localhost", Port: 8001}, JobName: "first-avs--main++holesky"},
+			{Target: types.MonitoringTarget{Host: "localhost", Port: 8002, Path: "/stats"}, JobName: "second-avs--main++holesky"},
+		},
+		Remove: []string{"old-avs"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, reloads, "the change set must be reloaded once")
+	prom := readConfig()
+	require.Len(t, prom.ScrapeConfigs, 3)
+	assert.Equal(t, []string{"egn_node_exporter:9100", "first-avs--main++holesky", "second-avs--main++holesky"}, jobNames())
+	assert.Equal(t, []string{"localhost:8001"}, prom.ScrapeConfigs[1].StaticConfigs[0].Targets)
+	assert.Equal(t, "/stats", prom.ScrapeConfigs[2].MetricsPath)
+
+	// An invalid change leaves the config untouched
+	before, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+	require.NoError(t, err)
+	err = prometheus.Apply(TargetChangeSet{
+		Add:    []TargetAdd{{Target: types.MonitoringTarget{Host: "localhost", Port: 8003}, JobName: "third-avs--main++holesky"}},
+		Remove: []string{"missing-avs"},
+	})
+	assert.ErrorIs(t, err, monitoring.ErrNonexistingTarget)
+	err = prometheus.Apply(TargetChangeSet{
+		Add: []TargetAdd{{Target: types.MonitoringTarget{Host: "localhost", Port: 8003}, Labels: map[string]string{"bad-name": "x"}, JobName: "third-avs--main++holesky"}},
+	})
+	assert.ErrorIs(t, err, ErrInvalidLabel)
+	after, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+	require.NoError(t, err)
+	assert.Equal(t, string(before), string(after))
+	assert.Equal(t, 2, reloads)
+
+	// Nothing is reloaded without changes
+	err = prometheus.Apply(TargetChangeSet{
+		Add: []TargetAdd{{Target: types.MonitoringTarget{Host: "localhost", Port: 8001}, JobName: "first-avs--main++holesky"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, reloads)
+}
@@ -0,0 +1,86 @@
+package prometheus
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring"
+)
+
+const (
+	// secretsDir is the directory of the scrape secrets in the monitoring
+	// stack. The PROM_SECRETS_DIR volume mounts it at secretsContainerDir.
+	secretsDir          = "prometheus/secrets"
+	secretsContainerDir = "/etc/prometheus/secrets"
+	passwordFileName    = "password"
+	bearerTokenFileName = "bearer_token"
+)
+
+// BasicAuth represents the basic authentication of a scrape job. The password
+// is read by Prometheus from PasswordFile, so it is not in prometheus.yml.
+type BasicAuth struct {
+	Username     string `yaml:"username"`
+	PasswordFile string `yaml:"password_file,omitempty"`
+}
+
+// SetBasicAuth makes the jobs of the instance scrape their targets with basic
+// authentication. The password is stored in a file of the monitoring stack
+// only readable by its owner, which the jobs reference, so it never appears in
+// prometheus.yml. Calling it again rotates the password, and the configuration
+// is only reloaded if it changed. Jobs of the instance added later have no
+// authentication.
+func (p *PrometheusService) SetBasicAuth(instanceID, username, password string) error {
+	return p.setScrapeSecret(instanceID, passwordFileName, password, func(job *ScrapeConfig, secretFile string) {
+		job.BasicAuth = &BasicAuth{Username: username, PasswordFile: secretFile}
+		job.BearerTokenFile = ""
+	})
+}
+
+// SetBearerToken is like SetBasicAuth, but the jobs of the instance send the
+// given bearer token, read from the bearer_token_file.
+func (p *PrometheusService) SetBearerToken(instanceID, token string) error {
+	return p.setScrapeSecret(instanceID, bearerTokenFileName, token, func(job *ScrapeConfig, secretFile string) {
+		job.BearerTokenFile = secretFile
+		job.BasicAuth = nil
+	})
+}
+
+// setScrapeSecret writes the secret of the instance to the named file, and
+// calls setAuth on every job of the instance with the path of the file in the
+// Prometheus container. Authentication is set per job, so it isn't supported
+// with file service discovery.
+func (p *PrometheusService) setScrapeSecret(instanceID, name, secret string, setAuth func(job *ScrapeConfig, secretFile string)) error {
+	if p.discovery == FileDiscovery {
+		return fmt.Errorf("%w: scrape authentication", ErrFileSDUnsupported)
+	}
+	secretFile := path.Join(secretsContainerDir, instanceID, name)
+	_, err := p.editConfig(func(s *data.LockedMonitoringStack, config *Config) error {
+		var found bool
+		for i := range config.ScrapeConfigs {
+			if isInstanceScrapeConfig(config.ScrapeConfigs[i], instanceID) {
+				setAuth(&config.ScrapeConfigs[i], secretFile)
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: %s", monitoring.ErrNonexistingTarget, instanceID)
+		}
+
+		// Write the secret first, so the config never references a missing file
+		if err := s.WriteSecretFile(filepath.Join(secretsDir, instanceID, name), []byte(secret)); err != nil {
+			return err
+		}
+		for _, other := range []string{passwordFileName, bearerTokenFileName} {
+			if other == name {
+				continue
+			}
+			if err := s.RemoveAll(filepath.Join(secretsDir, instanceID, other)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,124 @@
+package prometheus
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/internal/locker/mocks"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestScrapeSecrets(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	options := map[string]string{
+		"PROM_PORT":          "9999",
+		"NODE_EXPORTER_PORT": "9100",
+	}
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: options,
+	})
+	require.NoError(t, err)
+	err = prometheus.Setup(options)
+	require.NoError(t, err)
+	exists, err := afero.DirExists(afs, "/monitoring/prometheus/secrets")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// Setup mock http server, counting the reloads
+	var reloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reloads.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	split := strings.Split(server.URL, ":")
+	host, port := split[1][2:], split[2]
+	prometheus.containerIP = net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	prometheus.port = uint16(p)
+
+	readJob := func() (ScrapeConfig, string) {
+		promYml, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+		require.NoError(t, err)
+		var prom Config
+		require.NoError(t, yaml.Unmarshal(promYml, &prom))
+		require.Len(t, prom.ScrapeConfigs, 2)
+		return prom.ScrapeConfigs[1], string(promYml)
+	}
+
+	err = prometheus.SetBasicAuth("test-avs", "user", "s3cret")
+	assert.ErrorIs(t, err, monitoring.ErrNonexistingTarget)
+
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8000}, nil, "test-avs--main++testnet")
+	require.NoError(t, err)
+	require.EqualValues(t, 1, reloads.Load())
+
+	// Basic auth references the password file
+	err = prometheus.SetBasicAuth("test-avs", "user", "s3cret")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, reloads.Load())
+	job, promYml := readJob()
+	assert.Equal(t, &BasicAuth{Username: "user", PasswordFile: "/etc/prometheus/secrets/test-avs/password"}, job.BasicAuth)
+	assert.NotContains(t, promYml, "s3cret")
+	secret, err := afero.ReadFile(afs, "/monitoring/prometheus/secrets/test-avs/password")
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", string(secret))
+	info, err := afs.Stat("/monitoring/prometheus/secrets/test-avs/password")
+	require.NoError(t, err)
+	assert.Equal(t, 0o600, int(info.Mode().Perm()))
+
+	// Rotating the password doesn't change the config
+	err = prometheus.SetBasicAuth("test-avs", "user", "n3w-s3cret")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, reloads.Load(), "config reloaded without changes")
+	secret, err = afero.ReadFile(afs, "/monitoring/prometheus/secrets/test-avs/password")
+	require.NoError(t, err)
+	assert.Equal(t, "n3w-s3cret", string(secret))
+
+	// A bearer token replaces the basic auth
+	err = prometheus.SetBearerToken("test-avs", "t0ken")
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, reloads.Load())
+	job, promYml = readJob()
+	assert.Nil(t, job.BasicAuth)
+	assert.Equal(t, "/etc/prometheus/secrets/test-avs/bearer_token", job.BearerTokenFile)
+	assert.NotContains(t, promYml, "t0ken")
+	exists, err = afero.Exists(afs, "/monitoring/prometheus/secrets/test-avs/password")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// Removing the target removes its secrets
+	_, err = prometheus.RemoveTarget("test-avs")
+	require.NoError(t, err)
+	exists, err = afero.DirExists(afs, "/monitoring/prometheus/secrets/test-avs")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
@@ -0,0 +1,118 @@
+package prometheus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigChecker checks a Prometheus configuration with the Prometheus own
+// parser.
+type ConfigChecker interface {
+	// Check returns an error describing why the given prometheus.yml content
+	// is rejected, or ErrCheckerUnavailable if the check can't be done.
+	Check(config []byte) error
+}
+
+// PromtoolChecker checks the configuration with `promtool check config`.
+type PromtoolChecker struct {
+	// Path is the promtool binary. If empty, promtool is looked up in PATH.
+	Path string
+}
+
+// Check implements the ConfigChecker interface.
+func (c *PromtoolChecker) Check(config []byte) error {
+	path := c.Path
+	if path == "" {
+		var err error
+		if path, err = exec.LookPath("promtool"); err != nil {
+			return fmt.Errorf("%w: %s", ErrCheckerUnavailable, err)
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "egn-prometheus-check-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "prometheus.yml")
+	if err = os.WriteFile(configPath, config, 0o644); err != nil {
+		return err
+	}
+
+	out, err := exec.Command(path, "check", "config", "--syntax-only", configPath).CombinedOutput()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return errors.New(strings.TrimSpace(string(out)))
+		}
+		return fmt.Errorf("%w: %s", ErrCheckerUnavailable, err)
+	}
+	return nil
+}
+
+// CheckConfig checks the current Prometheus configuration. It uses the
+// configured ConfigChecker, or promtool by default, and falls back to a basic
+// validation when the checker is unavailable. Rejected configurations return
+// ErrInvalidConfig.
+func (p *PrometheusService) CheckConfig() error {
+	rawConfig, err := p.stack.ReadFile(filepath.Join("prometheus", "prometheus.yml"))
+	if err != nil {
+		return err
+	}
+
+	var checker ConfigChecker = &PromtoolChecker{}
+	if p.checker != nil {
+		checker = p.checker
+	}
+	err = checker.Check(rawConfig)
+	if errors.Is(err, ErrCheckerUnavailable) {
+		err = validateConfig(rawConfig)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidConfig, err)
+	}
+	return nil
+}
+
+// validateConfig does a basic validation of a Prometheus configuration,
+// catching the errors Prometheus would report on the fields known by Config.
+func validateConfig(rawConfig []byte) error {
+	var config Config
+	decoder := yaml.NewDecoder(bytes.NewReader(rawConfig))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		return err
+	}
+
+	if config.Global.ScrapeInterval != "" {
+		if _, err := model.ParseDuration(config.Global.ScrapeInterval); err != nil {
+			return fmt.Errorf("invalid scrape_interval: %w", err)
+		}
+	}
+	jobs := make(map[string]bool, len(config.ScrapeConfigs))
+	for _, job := range config.ScrapeConfigs {
+		if job.JobName == "" {
+			return errors.New("job_name is empty")
+		}
+		if jobs[job.JobName] {
+			return fmt.Errorf("found multiple scrape configs with job name %q", job.JobName)
+		}
+		jobs[job.JobName] = true
+		for _, static := range job.StaticConfigs {
+			for name := range static.Labels {
+				if !model.LabelName(name).IsValid() {
+					return fmt.Errorf("%q is not a valid label name in job %q", name, job.JobName)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package prometheus
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/internal/locker/mocks"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubChecker struct {
+	err error
+}
+
+func (c *stubChecker) Check([]byte) error {
+	return c.err
+}
+
+func TestCheckConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		checker ConfigChecker
+		config  string
+		wantErr string
+	}{
+		{
+			name:    "checker accepts",
+			checker: &stubChecker{},
+		},
+		{
+			name:    "checker rejects",
+			checker: &stubChecker{err: errors.New(`parsing YAML file prometheus.yml: "5x" is not a valid duration string`)},
+			wantErr: `"5x" is not a valid duration string`,
+		},
+		{
+			name:    "fallback accepts",
+			checker: &stubChecker{err: ErrCheckerUnavailable},
+		},
+		{
+			name:    "fallback invalid duration",
+			checker: &stubChecker{err: ErrCheckerUnavailable},
+			config:  "global:\n  scrape_interval: 5x\n",
+			wantErr: "invalid scrape_interval",
+		},
+		{
+			name:    "fallback duplicate job",
+			checker: &stubChecker{err: ErrCheckerUnavailable},
+			config:  "scrape_configs:\n  - job_name: job\n  - job_name: job\n",
+			wantErr: `multiple scrape configs with job name "job"`,
+		},
+		{
+			name:    "fallback invalid label",
+			checker: &stubChecker{err: ErrCheckerUnavailable},
+			config:  "scrape_configs:\n  - job_name: job\n    static_configs:\n      - targets: [localhost:8080]\n        labels:\n          bad-label: value\n",
+			wantErr: `"bad-label" is not a valid label name`,
+		},
+		{
+			name:    "fallback unknown field",
+			checker: &stubChecker{err: ErrCheckerUnavailable},
+			config:  "scrape_configs:\n  - job_name: job\n    scrape_intervall: 5s\n",
+			wantErr: "scrape_intervall",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock locker
+			ctrl := gomock.NewController(t)
+			locker := mocks.NewMockLocker(ctrl)
+			locker.EXPECT().New("/monitoring/.lock").Return(locker)
+			locker.EXPECT().Lock().Return(nil).AnyTimes()
+			locker.EXPECT().Locked().Return(true).AnyTimes()
+			locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+			afs := afero.NewMemMapFs()
+			dataDir, err := data.NewDataDir("/", afs, locker)
+			require.NoError(t, err)
+			stack, err := dataDir.MonitoringStack()
+			require.NoError(t, err)
+
+			options := map[string]string{
+				"PROM_PORT":          "9999",
+				"NODE_EXPORTER_PORT": "9100",
+			}
+			prometheus := NewPrometheus()
+			err = prometheus.Init(types.ServiceOptions{
+				Stack:  stack,
+				Dotenv: options,
+			})
+			require.NoError(t, err)
+			err = prometheus.Setup(options)
+			require.NoError(t, err)
+			if tt.config != "" {
+				require.NoError(t, afero.WriteFile(afs, "/monitoring/prometheus/prometheus.yml", []byte(tt.config), 0o644))
+			}
+			prometheus.SetConfigChecker(tt.checker)
+
+			err = prometheus.CheckConfig()
+			if tt.wantErr != "" {
+				require.ErrorIs(t, err, ErrInvalidConfig)
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+// configTemplateData is the data the embedded config/prometheus.yml template
+// is rendered with, from the dotenv values.
+type configTemplateData struct {
+	// ScrapeInterval is the global scrape interval, from PROM_SCRAPE_INTERVAL.
+	ScrapeInterval string
+	// PromPort is the port of Prometheus, from PROM_PORT.
+	PromPort string
+	// NodeExporterPort is the port of the node exporter, from
+	// NODE_EXPORTER_PORT.
+	NodeExporterPort string
+}
+
+// newConfigTemplateData returns the template data for the given dotenv
+// values. Optional values missing from options take their default value.
+func newConfigTemplateData(options map[string]string) (configTemplateData, error) {
+	data := configTemplateData{
+		ScrapeInterval:   options["PROM_SCRAPE_INTERVAL"],
+		PromPort:         options["PROM_PORT"],
+		NodeExporterPort: options["NODE_EXPORTER_PORT"],
+	}
+	if data.ScrapeInterval == "" {
+		data.ScrapeInterval = dotEnv["PROM_SCRAPE_INTERVAL"]
+	}
+	interval, err := model.ParseDuration(data.ScrapeInterval)
+	if err != nil || interval < model.Duration(minScrapeInterval) || interval > model.Duration(maxScrapeInterval) {
+		return data, fmt.Errorf("%w: PROM_SCRAPE_INTERVAL must be a duration between %s and %s", ErrInvalidOptions, minScrapeInterval, maxScrapeInterval)
+	}
+	data.ScrapeInterval = interval.String()
+	if data.PromPort == "" {
+		data.PromPort = dotEnv["PROM_PORT"]
+	}
+	return data, nil
+}
+
+// renderConfig renders the Prometheus config template with the given data.
+// Referencing data that doesn't exist is an error, and so is a rendered config
+// that isn't a valid Prometheus config.
+func renderConfig(rawTemplate []byte, data configTemplateData) (Config, error) {
+	var config Config
+	tmpl, err := template.New("prometheus.yml").Option("missingkey=error").Parse(string(rawTemplate))
+	if err != nil {
+		return config, fmt.Errorf("%w: prometheus.yml template: %w", ErrInvalidConfig, err)
+	}
+	var rendered bytes.Buffer
+	if err = tmpl.Execute(&rendered, data); err != nil {
+		return config, fmt.Errorf("%w: prometheus.yml template: %w", ErrInvalidConfig, err)
+	}
+	if err = yaml.Unmarshal(rendered.Bytes(), &config); err != nil {
+		return config, fmt.Errorf("%w: rendered prometheus.yml: %w", ErrInvalidConfig, err)
+	}
+	return config, nil
+}
@@ -0,0 +1,153 @@
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/internal/locker/mocks"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRenderConfig(t *testing.T) {
+	tmpl := []byte(`global:
+  scrape_interval: {{ .ScrapeInterval }}
+scrape_configs:
+  - job_name: prometheus
+    static_configs:
+      - targets: ["localhost:{{ .PromPort }}", "node-exporter:{{ .NodeExporterPort }}"]
+`)
+	tests := []struct {
+		name     string
+		template []byte
+		options  map[string]string
+		want     Config
+		wantErr  error
+	}{
+		{
+			name:     "custom values",
+			template: tmpl,
+			options:  map[string]string{"PROM_PORT": "9999", "NODE_EXPORTER_PORT": "9101", "PROM_SCRAPE_INTERVAL": "90s"},
+			want: Config{
+				Global: GlobalConfig{ScrapeInterval: "1m30s"},
+				ScrapeConfigs: []ScrapeConfig{{
+					JobName:       "prometheus",
+					StaticConfigs: []StaticConfig{{Targets: []string{"localhost:9999", "node-exporter:9101"}}},
+				}},
+			},
+		},
+		{
+			name:     "defaults",
+			template: tmpl,
+			options:  map[string]string{"NODE_EXPORTER_PORT": "9100"},
+			want: Config{
+				Global: GlobalConfig{ScrapeInterval: "15s"},
+				ScrapeConfigs: []ScrapeConfig{{
+					JobName:       "prometheus",
+					StaticConfigs: []StaticConfig{{Targets: []string{"localhost:9090", "node-exporter:9100"}}},
+				}},
+			},
+		},
+		{
+			name:     "embedded template",
+			template: mustReadEmbedded(t, "config/prometheus.yml"),
+			options:  map[string]string{"PROM_SCRAPE_INTERVAL": "30s"},
+			want:     Config{Global: GlobalConfig{ScrapeInterval: "30s"}},
+		},
+		{
+			name:     "missing variable",
+			template: []byte("global:\n  scrape_interval: {{ .EvaluationInterval }}\n"),
+			options:  map[string]string{},
+			wantErr:  ErrInvalidConfig,
+		},
+		{
+			name:     "invalid template",
+			template: []byte("global:\n  scrape_interval: {{ .ScrapeInterval\n"),
+			options:  map[string]string{},
+			wantErr:  ErrInvalidConfig,
+		},
+		{
+			name:     "invalid YAML",
+			template: []byte("global: [{{ .ScrapeInterval }}\n"),
+			options:  map[string]string{},
+			wantErr:  ErrInvalidConfig,
+		},
+		{
+			name:     "invalid scrape interval",
+			template: tmpl,
+			options:  map[string]string{"PROM_SCRAPE_INTERVAL": "15 seconds"},
+			wantErr:  ErrInvalidOptions,
+		},
+		{
+			name:     "scrape interval out of range",
+			template: tmpl,
+			options:  map[string]string{"PROM_SCRAPE_INTERVAL": "2h"},
+			wantErr:  ErrInvalidOptions,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := newConfigTemplateData(tt.options)
+			if err == nil {
+				var config Config
+				config, err = renderConfig(tt.template, data)
+				if tt.wantErr == nil {
+					require.NoError(t, err)
+					assert.Equal(t, tt.want, config)
+					return
+				}
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestSetupScrapeInterval(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	options := map[string]string{
+		"PROM_PORT":            "9999",
+		"NODE_EXPORTER_PORT":   "9100",
+		"PROM_SCRAPE_INTERVAL": "1m",
+	}
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: options,
+	})
+	require.NoError(t, err)
+	require.NoError(t, prometheus.Setup(options))
+
+	promYml, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+	require.NoError(t, err)
+	var config Config
+	require.NoError(t, yaml.Unmarshal(promYml, &config))
+	assert.Equal(t, "1m", config.Global.ScrapeInterval)
+	// The node exporter job is still added
+	require.Len(t, config.ScrapeConfigs, 1)
+	assert.Equal(t, []StaticConfig{{Targets: []string{monitoring.NodeExporterContainerName + ":9100"}}}, config.ScrapeConfigs[0].StaticConfigs)
+}
+
+func mustReadEmbedded(t *testing.T, path string) []byte {
+	t.Helper()
+	content, err := config.ReadFile(path)
+	require.NoError(t, err)
+	return content
+}
@@ -4,4 +4,15 @@ var dotEnv map[string]string = map[string]string{
 	"PROM_IMAGE": "prom/prometheus:v2.37.0",
 	"PROM_PORT":  "9090",
 	"PROM_CONF":  "./prometheus/prometheus.yml",
+	// PROM_SCRAPE_INTERVAL is the global scrape interval, between 1s and 1h
+	"PROM_SCRAPE_INTERVAL": "15s",
+	// PROM_RELOAD_STRATEGY is one of http, sighup or config-only
+	"PROM_RELOAD_STRATEGY": "http",
+	// PROM_SERVICE_DISCOVERY is static or file
+	"PROM_SERVICE_DISCOVERY": "static",
+	"PROM_FILE_SD_DIR":       "./prometheus/file_sd",
+	"PROM_SECRETS_DIR":       "./prometheus/secrets",
+	// PROM_JOB_NAME_TEMPLATE is a text/template over JobNameData naming the
+	// scrape jobs
+	"PROM_JOB_NAME_TEMPLATE": defaultJobNameTemplate,
 }
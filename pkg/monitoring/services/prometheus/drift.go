@@ -0,0 +1,158 @@
+package prometheus
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+// DriftKind is the kind of a difference between the Prometheus config and the
+// config the service generates.
+type DriftKind string
+
+const (
+	// DriftUnexpectedJob is a scrape job the service doesn't generate, like a
+	// hand-added job or a job with hand-added settings.
+	DriftUnexpectedJob DriftKind = "unexpected-job"
+	// DriftMissingNodeExporter is a config without the node exporter job added
+	// by Setup.
+	DriftMissingNodeExporter DriftKind = "missing-node-exporter"
+	// DriftGlobalSettings is a global setting the service doesn't generate, or
+	// a scrape interval it wouldn't set.
+	DriftGlobalSettings DriftKind = "altered-global-settings"
+	// DriftUnknownSetting is a top-level setting the service doesn't generate.
+	DriftUnknownSetting DriftKind = "unknown-setting"
+)
+
+// DriftItem is a difference between the Prometheus config and the config the
+// service generates.
+type DriftItem struct {
+	Kind DriftKind
+	// Job is the name of the scrape job, for the drift of a job.
+	Job    string
+	Detail string
+}
+
+// DetectDrift compares the structure of the Prometheus config with the config
+// the service generates for its targets, and returns the differences, such as
+// the result of editing prometheus.yml by hand. The service only generates the
+// global scrape interval, the node exporter job, the file service discovery
+// job with file service discovery, and one job per target with a single static
+// config and a metrics path. Nothing is changed, and a config without drift
+// returns no items.
+func (p *PrometheusService) DetectDrift() ([]DriftItem, error) {
+	var items []DriftItem
+	err := p.stack.WithLock(func(s *data.LockedMonitoringStack) error {
+		config, err := readConfig(s, prometheusConfigPath)
+		if err != nil {
+			return err
+		}
+		rawConfig, err := s.ReadFile(prometheusConfigPath)
+		if err != nil {
+			return err
+		}
+		var raw struct {
+			Global        map[string]any   `yaml:"global"`
+			ScrapeConfigs []map[string]any `yaml:"scrape_configs"`
+			Other         map[string]any   `yaml:",inline"`
+		}
+		if err := yaml.Unmarshal(rawConfig, &raw); err != nil {
+			return err
+		}
+		items = p.detectDrift(config, raw.Global, raw.ScrapeConfigs, raw.Other)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// detectDrift returns the drift of the config, given with the raw settings of
+// its global section, of its jobs and the other top-level settings.
+func (p *PrometheusService) detectDrift(config Config, rawGlobal map[string]any, rawJobs []map[string]any, rawOther map[string]any) []DriftItem {
+	var items []DriftItem
+	for _, key := range sortedKeys(rawOther) {
+		items = append(items, DriftItem{Kind: DriftUnknownSetting, Detail: fmt.Sprintf("unknown setting %q", key)})
+	}
+
+	for _, key := range sortedKeys(rawGlobal) {
+		if key != "scrape_interval" {
+			items = append(items, DriftItem{Kind: DriftGlobalSettings, Detail: fmt.Sprintf("unknown global setting %q", key)})
+		}
+	}
+	if interval, err := model.ParseDuration(config.Global.ScrapeInterval); err != nil || interval < model.Duration(minScrapeInterval) || interval > model.Duration(maxScrapeInterval) {
+		items = append(items, DriftItem{Kind: DriftGlobalSettings, Detail: fmt.Sprintf("scrape interval %q is not between %s and %s", config.Global.ScrapeInterval, minScrapeInterval, maxScrapeInterval)})
+	}
+
+	jobKeys := yamlKeys(reflect.TypeOf(ScrapeConfig{}))
+	seen := make(map[string]bool, len(config.ScrapeConfigs))
+	hasNodeExporter := false
+	for i, job := range config.ScrapeConfigs {
+		if seen[job.JobName] {
+			items = append(items, DriftItem{Kind: DriftUnexpectedJob, Job: job.JobName, Detail: "duplicate job"})
+			continue
+		}
+		seen[job.JobName] = true
+		var unknown []string
+		if i < len(rawJobs) {
+			for _, key := range sortedKeys(rawJobs[i]) {
+				if !jobKeys[key] {
+					unknown = append(unknown, key)
+				}
+			}
+		}
+		if len(unknown) > 0 {
+			items = append(items, DriftItem{Kind: DriftUnexpectedJob, Job: job.JobName, Detail: "unknown settings " + strings.Join(unknown, ", ")})
+			continue
+		}
+		switch {
+		case isNodeExporterJob(job.JobName):
+			if len(job.StaticConfigs) == 1 && slices.Equal(job.StaticConfigs[0].Targets, []string{job.JobName}) {
+				hasNodeExporter = true
+				continue
+			}
+		case job.JobName == fileSDJobName && p.discovery == FileDiscovery:
+			if reflect.DeepEqual(job, fileSDScrapeConfig()) {
+				continue
+			}
+		default:
+			if len(job.FileSDConfigs) == 0 && len(job.StaticConfigs) == 1 && len(job.StaticConfigs[0].Targets) > 0 && job.MetricsPath != "" {
+				continue
+			}
+		}
+		items = append(items, DriftItem{Kind: DriftUnexpectedJob, Job: job.JobName, Detail: "job not generated by the service"})
+	}
+	if !hasNodeExporter {
+		items = append(items, DriftItem{Kind: DriftMissingNodeExporter, Detail: "no node exporter job"})
+	}
+	return items
+}
+
+// yamlKeys returns the YAML keys of the fields of the given struct type.
+func yamlKeys(t reflect.Type) map[string]bool {
+	keys := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name != "" && name != "-" {
+			keys[name] = true
+		}
+	}
+	return keys
+}
+
+// sortedKeys returns the keys of m, sorted.
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
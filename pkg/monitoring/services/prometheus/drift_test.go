@@ -0,0 +1,131 @@
+package prometheus
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/internal/locker/mocks"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectDrift(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	options := map[string]string{
+		"PROM_PORT":          "9999",
+		"NODE_EXPORTER_PORT": "9100",
+	}
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: options,
+	})
+	require.NoError(t, err)
+	err = prometheus.Setup(options)
+	require.NoError(t, err)
+
+	// Setup mock http server for the reloads
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.URL[len("http://"):])
+	require.NoError(t, err)
+	prometheus.containerIP = net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	prometheus.port = uint16(p)
+
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8000}, map[string]string{"team": "a"}, "mock-avs--main++holesky")
+	require.NoError(t, err)
+	err = prometheus.AddInstanceTargets("mock-avs-default", []string{"localhost:8001"}, nil)
+	require.NoError(t, err)
+	require.NoError(t, prometheus.SetBearerToken("mock-avs-default", "token"))
+
+	// The generated config has no drift
+	items, err := prometheus.DetectDrift()
+	require.NoError(t, err)
+	assert.Empty(t, items)
+
+	configPath := "/monitoring/prometheus/prometheus.yml"
+	generated, err := afero.ReadFile(afs, configPath)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		edit func(config string) string
+		want []DriftItem
+	}{
+		{
+			name: "hand-added job",
+			edit: func(config string) string {
+				return config + "    - job_name: hand-added\n      static_configs:\n        - targets: [\"localhost:9000\"]\n"
+			},
+			want: []DriftItem{{Kind: DriftUnexpectedJob, Job: "hand-added", Detail: "job not generated by the service"}},
+		},
+		{
+			name: "hand-added job setting",
+			edit: func(config string) string {
+				return strings.Replace(config, "    - job_name: mock-avs--main++holesky\n", "    - job_name: mock-avs--main++holesky\n      scrape_timeout: 5s\n", 1)
+			},
+			want: []DriftItem{{Kind: DriftUnexpectedJob, Job: "mock-avs--main++holesky", Detail: "unknown settings scrape_timeout"}},
+		},
+		{
+			name: "missing node exporter",
+			edit: func(config string) string {
+				return strings.Replace(config, "job_name: egn_node_exporter:9100", "job_name: node", 1)
+			},
+			want: []DriftItem{
+				{Kind: DriftUnexpectedJob, Job: "node", Detail: "job not generated by the service"},
+				{Kind: DriftMissingNodeExporter, Detail: "no node exporter job"},
+			},
+		},
+		{
+			name: "altered global settings",
+			edit: func(config string) string {
+				return strings.Replace(config, "global:\n", "rule_files: [rules.yml]\nglobal:\n    evaluation_interval: 1m\n", 1)
+			},
+			want: []DriftItem{
+				{Kind: DriftUnknownSetting, Detail: `unknown setting "rule_files"`},
+				{Kind: DriftGlobalSettings, Detail: `unknown global setting "evaluation_interval"`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edited := tt.edit(string(generated))
+			require.NotEqual(t, string(generated), edited, "the edit must change the config")
+			require.NoError(t, afero.WriteFile(afs, configPath, []byte(edited), 0o644))
+
+			items, err := prometheus.DetectDrift()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, items)
+
+			// Detecting the drift changes nothing
+			content, err := afero.ReadFile(afs, configPath)
+			require.NoError(t, err)
+			assert.Equal(t, edited, string(content))
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package prometheus
+
+import (
+	"bytes"
+	"path/filepath"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"gopkg.in/yaml.v3"
+)
+
+// prometheusConfigPath is the path of the Prometheus config in the monitoring stack.
+var prometheusConfigPath = filepath.Join("prometheus", "prometheus.yml")
+
+// editConfig calls edit with the Prometheus config under the lock of the
+// monitoring stack, writes the config and reloads Prometheus, only if the edit
+// changed it. It returns whether the config changed. The edit can also change
+// other files of the locked stack, before the config is written.
+func (p *PrometheusService) editConfig(edit func(s *data.LockedMonitoringStack, config *Config) error) (bool, error) {
+	var changed bool
+	err := p.stack.WithLock(func(s *data.LockedMonitoringStack) error {
+		var err error
+		changed, err = p.updateConfig(s, func(config *Config) error {
+			return edit(s, config)
+		})
+		return err
+	})
+	if err != nil || !changed {
+		return changed, err
+	}
+	return true, p.reloadConfig()
+}
+
+// updateConfig calls edit with the Prometheus config of the locked stack, and
+// writes the config if the edit changed its YAML encoding. It returns whether
+// the config changed. Unlike editConfig, Prometheus is not reloaded, so the
+// caller can change more files under the lock after the config is written.
+func (p *PrometheusService) updateConfig(s *data.LockedMonitoringStack, edit func(config *Config) error) (bool, error) {
+	config, err := readConfig(s, prometheusConfigPath)
+	if err != nil {
+		return false, err
+	}
+	oldConfig, err := yaml.Marshal(&config)
+	if err != nil {
+		return false, err
+	}
+	if err = edit(&config); err != nil {
+		return false, err
+	}
+	newConfig, err := yaml.Marshal(&config)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(oldConfig, newConfig) {
+		return false, nil
+	}
+	if err = s.WriteFile(prometheusConfigPath, newConfig); err != nil {
+		return false, err
+	}
+	p.setTargets(len(config.ScrapeConfigs))
+	return true, nil
+}
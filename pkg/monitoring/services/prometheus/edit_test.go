@@ -0,0 +1,113 @@
+package prometheus
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/internal/locker/mocks"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEditConfig(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	options := map[string]string{
+		"PROM_PORT":          "9999",
+		"NODE_EXPORTER_PORT": "9100",
+	}
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: options,
+	})
+	require.NoError(t, err)
+	err = prometheus.Setup(options)
+	require.NoError(t, err)
+
+	// Setup mock http server counting the reloads
+	var reloads int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reloads++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.URL[len("http://"):])
+	require.NoError(t, err)
+	prometheus.containerIP = net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	prometheus.port = uint16(p)
+
+	// A no-op edit neither writes nor reloads
+	before, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+	require.NoError(t, err)
+	changed, err := prometheus.editConfig(func(_ *data.LockedMonitoringStack, config *Config) error {
+		config.ScrapeConfigs = append([]ScrapeConfig(nil), config.ScrapeConfigs...)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Zero(t, reloads)
+	after, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+	require.NoError(t, err)
+	assert.Equal(t, string(before), string(after))
+
+	// Each edit reloads once, repeating it doesn't reload
+	edits := []struct {
+		name string
+		edit func() error
+	}{
+		{"add target", func() error {
+			return prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8000}, nil, "mock-avs--main++holesky")
+		}},
+		{"add instance targets", func() error {
+			return prometheus.AddInstanceTargets("other-avs", []string{"localhost:8001"}, nil)
+		}},
+		{"scrape interval", func() error { return prometheus.SetScrapeInterval(45 * time.Second) }},
+		{"pause", func() error { return prometheus.PauseInstance("mock-avs") }},
+		{"resume", func() error { return prometheus.ResumeInstance("mock-avs") }},
+		{"basic auth", func() error { return prometheus.SetBasicAuth("other-avs", "user", "secret") }},
+	}
+	for _, edit := range edits {
+		want := reloads + 1
+		require.NoError(t, edit.edit(), edit.name)
+		assert.Equal(t, want, reloads, edit.name)
+		require.NoError(t, edit.edit(), edit.name)
+		assert.Equal(t, want, reloads, "%s again", edit.name)
+	}
+
+	// Removing a missing target doesn't reload
+	reloadsBefore := reloads
+	_, removed, err := prometheus.RemoveTargetIfExists("missing-avs")
+	require.NoError(t, err)
+	assert.False(t, removed)
+	err = prometheus.Apply(TargetChangeSet{})
+	require.NoError(t, err)
+	assert.Equal(t, reloadsBefore, reloads)
+
+	_, removed, err = prometheus.RemoveTargetIfExists("mock-avs")
+	require.NoError(t, err)
+	assert.True(t, removed)
+	assert.Equal(t, reloadsBefore+1, reloads)
+}
@@ -0,0 +1,46 @@
+package prometheus
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+)
+
+// EndpointRewriter maps the host:port endpoint of a target of the instance, as
+// known by the caller, to the endpoint Prometheus scrapes, like a host port to
+// the DNS name of the container in the Docker network of Prometheus.
+type EndpointRewriter func(endpoint, instanceID string) string
+
+// SetEndpointRewriter sets the rewriter applied to the endpoints of the targets
+// added by AddTarget and AddInstanceTargets before they are written. Job names
+// are still built from the endpoints as given. A nil rewriter keeps the
+// endpoints, which is the default.
+func (p *PrometheusService) SetEndpointRewriter(rewriter EndpointRewriter) {
+	p.endpointRewriter = rewriter
+}
+
+// rewriteEndpoint returns the endpoint Prometheus scrapes for the endpoint of
+// the instance.
+func (p *PrometheusService) rewriteEndpoint(endpoint, instanceID string) (string, error) {
+	if p.endpointRewriter == nil {
+		return endpoint, nil
+	}
+	rewritten := p.endpointRewriter(endpoint, instanceID)
+	if rewritten == "" {
+		return "", fmt.Errorf("%w: endpoint %s rewritten to an empty endpoint", types.ErrInvalidMonitoringTarget, endpoint)
+	}
+	return rewritten, nil
+}
+
+// targetInstanceID returns the id of the instance of a target added by
+// AddTarget: its monitoring.InstanceIDLabel label, or else the beginning of
+// its job name, <instance_id>--<container>++<network>.
+func targetInstanceID(labels map[string]string, jobName string) string {
+	if instanceID := labels[monitoring.InstanceIDLabel]; instanceID != "" {
+		return instanceID
+	}
+	instanceID, _, _ := strings.Cut(jobName, "--")
+	return instanceID
+}
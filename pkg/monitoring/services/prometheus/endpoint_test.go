@@ -0,0 +1,97 @@
+package prometheus
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/internal/locker/mocks"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestEndpointRewriter(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	options := map[string]string{
+		"PROM_PORT":          "9999",
+		"NODE_EXPORTER_PORT": "9100",
+	}
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: options,
+	})
+	require.NoError(t, err)
+	err = prometheus.Setup(options)
+	require.NoError(t, err)
+
+	// Setup mock http server for the reloads
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	split := strings.Split(server.URL, ":")
+	host, port := split[1][2:], split[2]
+	prometheus.containerIP = net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	prometheus.port = uint16(p)
+
+	var instanceIDs []string
+	prometheus.SetEndpointRewriter(func(endpoint, instanceID string) string {
+		instanceIDs = append(instanceIDs, instanceID)
+		return strings.Replace(endpoint, "localhost:", "node:", 1)
+	})
+	readConfig := func() Config {
+		promYml, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+		require.NoError(t, err)
+		var prom Config
+		require.NoError(t, yaml.Unmarshal(promYml, &prom))
+		return prom
+	}
+
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 9100}, nil, "test-avs--main++holesky")
+	require.NoError(t, err)
+	err = prometheus.AddInstanceTargets("other-avs", []string{"localhost:8000", "sidecar:9000"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test-avs", "other-avs", "other-avs"}, instanceIDs)
+	prom := readConfig()
+	require.Len(t, prom.ScrapeConfigs, 3)
+	assert.Equal(t, "test-avs--main++holesky", prom.ScrapeConfigs[1].JobName)
+	assert.Equal(t, []string{"node:9100"}, prom.ScrapeConfigs[1].StaticConfigs[0].Targets)
+	assert.Equal(t, []string{"node:8000", "sidecar:9000"}, prom.ScrapeConfigs[2].StaticConfigs[0].Targets)
+
+	// Empty endpoints are refused
+	prometheus.SetEndpointRewriter(func(endpoint, instanceID string) string { return "" })
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 9101}, nil, "third-avs--main++holesky")
+	assert.ErrorIs(t, err, types.ErrInvalidMonitoringTarget)
+	assert.Len(t, readConfig().ScrapeConfigs, 3)
+}
+
+func TestTargetInstanceID(t *testing.T) {
+	assert.Equal(t, "mock-avs-default", targetInstanceID(map[string]string{monitoring.InstanceIDLabel: "mock-avs-default"}, "other--main++holesky"))
+	assert.Equal(t, "mock-avs-default", targetInstanceID(nil, "mock-avs-default--main++holesky"))
+	assert.Equal(t, "job", targetInstanceID(nil, "job"))
+}
@@ -3,6 +3,15 @@ package prometheus
 import "errors"
 
 var (
-	ErrReloadFailed   = errors.New("failed to reload Prometheus config")
-	ErrInvalidOptions = errors.New("invalid options for grafana setup")
+	ErrReloadFailed            = errors.New("failed to reload Prometheus config")
+	ErrInvalidOptions          = errors.New("invalid options for grafana setup")
+	ErrNodeExporterUnreachable = errors.New("node exporter endpoint is unreachable")
+	ErrInvalidConfig           = errors.New("invalid Prometheus config")
+	ErrCheckerUnavailable      = errors.New("no Prometheus config checker available")
+	ErrUnsupportedScheme       = errors.New("unsupported scrape target scheme")
+	ErrInvalidLabel            = errors.New("invalid target label")
+	ErrConfigMissing           = errors.New("missing Prometheus config")
+	ErrFileSDUnsupported       = errors.New("not supported with file service discovery")
+	ErrInvalidScrapeInterval   = errors.New("invalid scrape interval")
+	ErrNotReady                = errors.New("Prometheus is not ready")
 )
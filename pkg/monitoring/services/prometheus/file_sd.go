@@ -0,0 +1,184 @@
+package prometheus
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+	"github.com/thoas/go-funk"
+)
+
+// ServiceDiscovery is how the targets are given to Prometheus.
+type ServiceDiscovery string
+
+const (
+	// StaticDiscovery writes the targets as static configs in prometheus.yml,
+	// reloading Prometheus on every change. It is the default.
+	StaticDiscovery ServiceDiscovery = "static"
+	// FileDiscovery writes the targets to a file referenced by a file_sd_config
+	// in prometheus.yml, which Prometheus watches without reloads.
+	FileDiscovery ServiceDiscovery = "file"
+)
+
+const (
+	// fileSDJobName is the name of the scrape job of the file service
+	// discovery targets.
+	fileSDJobName = "file_sd"
+	// fileSDTargetsPath is the path of the targets file in the monitoring
+	// stack. The PROM_FILE_SD_DIR volume mounts its directory at
+	// fileSDContainerDir.
+	fileSDTargetsPath  = "prometheus/file_sd/targets.json"
+	fileSDContainerDir = "/etc/prometheus/file_sd"
+)
+
+// FileSDConfig represents the configuration of a Prometheus file service
+// discovery.
+type FileSDConfig struct {
+	Files []string `yaml:"files"`
+}
+
+// TargetGroup represents a group of targets in a file service discovery file.
+// The job label keeps the job name of the target, and the __metrics_path__
+// and __scheme__ labels its endpoint settings.
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// parseServiceDiscovery parses the PROM_SERVICE_DISCOVERY option. An empty
+// value means StaticDiscovery.
+func parseServiceDiscovery(value string) (ServiceDiscovery, error) {
+	switch discovery := ServiceDiscovery(value); discovery {
+	case "":
+		return StaticDiscovery, nil
+	case StaticDiscovery, FileDiscovery:
+		return discovery, nil
+	}
+	return "", fmt.Errorf("%w: PROM_SERVICE_DISCOVERY must be %s or %s", ErrInvalidOptions, StaticDiscovery, FileDiscovery)
+}
+
+// fileSDScrapeConfig returns the scrape job reading the targets file.
+func fileSDScrapeConfig() ScrapeConfig {
+	return ScrapeConfig{
+		JobName: fileSDJobName,
+		FileSDConfigs: []FileSDConfig{
+			{Files: []string{fileSDContainerDir + "/" + filepath.Base(fileSDTargetsPath)}},
+		},
+	}
+}
+
+// addFileSDTarget adds the target, scraped at endpoint, to the targets file.
+// Prometheus picks the change up by itself, so there is no reload.
+func (p *PrometheusService) addFileSDTarget(target types.MonitoringTarget, endpoint string, labels map[string]string, jobName string) error {
+	if len(target.RelabelConfigs) > 0 || len(target.MetricRelabelConfigs) > 0 {
+		return fmt.Errorf("%w: relabel configs", ErrFileSDUnsupported)
+	}
+	return p.stack.WithLock(func(s *data.LockedMonitoringStack) error {
+		groups, err := readTargetGroups(s)
+		if err != nil {
+			return err
+		}
+		for _, group := range groups {
+			if group.Labels["job"] == jobName {
+				// There is no need to add the job if it already exists
+				return nil
+			}
+		}
+
+		groupLabels := make(map[string]string, len(labels)+3)
+		for name, value := range labels {
+			groupLabels[name] = value
+		}
+		groupLabels["job"] = jobName
+		if target.Path != "" {
+			groupLabels["__metrics_path__"] = target.Path
+		}
+		if target.Scheme != "" {
+			groupLabels["__scheme__"] = target.Scheme
+		}
+		groups = append(groups, TargetGroup{
+			Targets: []string{endpoint},
+			Labels:  groupLabels,
+		})
+		if err = writeTargetGroups(s, groups); err != nil {
+			return err
+		}
+		p.setTargets(len(groups))
+		p.targetsAdded(1)
+		return nil
+	})
+}
+
+// removeFileSDTarget removes the targets of the instance from the targets
+// file, like removeTarget.
+func (p *PrometheusService) removeFileSDTarget(instanceID string, ifExists bool) (string, bool, error) {
+	var (
+		network string
+		removed bool
+	)
+	err := p.stack.WithLock(func(s *data.LockedMonitoringStack) error {
+		groups, err := readTargetGroups(s)
+		if err != nil {
+			return err
+		}
+		count := len(groups)
+		groups = funk.Filter(groups, func(group TargetGroup) bool {
+			if isInstanceTargetGroup(group, instanceID) {
+				network, removed = jobNetwork(group.Labels["job"], instanceID), true
+				return false
+			}
+			return true
+		}).([]TargetGroup)
+
+		if !removed {
+			if ifExists {
+				return nil
+			}
+			return fmt.Errorf("%w: %s", monitoring.ErrNonexistingTarget, instanceID)
+		}
+		if err = writeTargetGroups(s, groups); err != nil {
+			return err
+		}
+		p.setTargets(len(groups))
+		p.targetsRemoved(count - len(groups))
+		return nil
+	})
+	return network, err == nil && removed, err
+}
+
+// readTargetGroups reads the targets file. A missing or empty file returns
+// ErrConfigMissing, as the stack needs to be set up again.
+func readTargetGroups(s *data.LockedMonitoringStack) ([]TargetGroup, error) {
+	rawGroups, err := s.ReadFile(fileSDTargetsPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s not found, set up the monitoring stack again", ErrConfigMissing, fileSDTargetsPath)
+		}
+		return nil, err
+	}
+	if len(bytes.TrimSpace(rawGroups)) == 0 {
+		return nil, fmt.Errorf("%w: %s is empty, set up the monitoring stack again", ErrConfigMissing, fileSDTargetsPath)
+	}
+	var groups []TargetGroup
+	if err = json.Unmarshal(rawGroups, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func writeTargetGroups(s *data.LockedMonitoringStack, groups []TargetGroup) error {
+	if groups == nil {
+		groups = []TargetGroup{}
+	}
+	rawGroups, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.WriteFile(fileSDTargetsPath, rawGroups)
+}
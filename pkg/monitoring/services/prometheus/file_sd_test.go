@@ -0,0 +1,130 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/internal/locker/mocks"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestFileServiceDiscovery(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	options := map[string]string{
+		"PROM_PORT":              "9999",
+		"NODE_EXPORTER_PORT":     "9100",
+		"PROM_SERVICE_DISCOVERY": "file",
+	}
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: options,
+	})
+	require.NoError(t, err)
+	err = prometheus.Setup(options)
+	require.NoError(t, err)
+
+	// Setup mock http server, counting the reload requests
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	split := strings.Split(server.URL, ":")
+	host, port := split[1][2:], split[2]
+	prometheus.containerIP = net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	prometheus.port = uint16(p)
+
+	// The main config references the targets file
+	promYml, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+	require.NoError(t, err)
+	var prom Config
+	require.NoError(t, yaml.Unmarshal(promYml, &prom))
+	require.Len(t, prom.ScrapeConfigs, 2)
+	assert.Equal(t, "file_sd", prom.ScrapeConfigs[1].JobName)
+	assert.Equal(t, []FileSDConfig{{Files: []string{"/etc/prometheus/file_sd/targets.json"}}}, prom.ScrapeConfigs[1].FileSDConfigs)
+
+	readGroups := func() []TargetGroup {
+		rawGroups, err := afero.ReadFile(afs, "/monitoring/prometheus/file_sd/targets.json")
+		require.NoError(t, err)
+		var groups []TargetGroup
+		require.NoError(t, json.Unmarshal(rawGroups, &groups))
+		return groups
+	}
+	assert.Empty(t, readGroups())
+
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8000}, map[string]string{monitoring.InstanceIDLabel: "avs1-default"}, "avs1-default++testnet1")
+	require.NoError(t, err)
+	err = prometheus.AddTarget(types.MonitoringTarget{Scheme: "https", Host: "168.0.0.66", Port: 8001, Path: "/custom"}, nil, "avs2-default++testnet2")
+	require.NoError(t, err)
+	// Adding the same job again is a no-op
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8000}, nil, "avs1-default++testnet1")
+	require.NoError(t, err)
+
+	assert.Equal(t, []TargetGroup{
+		{
+			Targets: []string{"localhost:8000"},
+			Labels:  map[string]string{monitoring.InstanceIDLabel: "avs1-default", "job": "avs1-default++testnet1"},
+		},
+		{
+			Targets: []string{"168.0.0.66:8001"},
+			Labels:  map[string]string{"job": "avs2-default++testnet2", "__metrics_path__": "/custom", "__scheme__": "https"},
+		},
+	}, readGroups())
+	promYmlAfter, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+	require.NoError(t, err)
+	assert.Equal(t, promYml, promYmlAfter, "main config changed")
+
+	// avs1-default is a prefix of avs1-default-2, whose target is kept
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8003}, nil, "avs1-default-2++testnet1")
+	require.NoError(t, err)
+	network, err := prometheus.RemoveTarget("avs1-default")
+	require.NoError(t, err)
+	assert.Equal(t, "testnet1", network)
+	groups := readGroups()
+	require.Len(t, groups, 2)
+	assert.Equal(t, "avs2-default++testnet2", groups[0].Labels["job"])
+	assert.Equal(t, "avs1-default-2++testnet1", groups[1].Labels["job"])
+	_, err = prometheus.RemoveTarget("avs1-default")
+	assert.ErrorIs(t, err, monitoring.ErrNonexistingTarget)
+
+	// Relabel configs can't be written to the targets file
+	err = prometheus.AddTarget(types.MonitoringTarget{
+		Host:           "localhost",
+		Port:           8002,
+		RelabelConfigs: []RelabelConfig{{TargetLabel: "instance", Replacement: "avs3"}},
+	}, nil, "avs3-default++testnet3")
+	assert.ErrorIs(t, err, ErrFileSDUnsupported)
+
+	// Target changes never reload Prometheus
+	assert.Zero(t, requests.Load())
+}
@@ -0,0 +1,83 @@
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+)
+
+// defaultJobNameTemplate keeps the job name given to AddTarget.
+const defaultJobNameTemplate = "{{.JobName}}"
+
+// JobNameData is the data the PROM_JOB_NAME_TEMPLATE template is executed
+// with to name the job of a target added by AddTarget.
+type JobNameData struct {
+	// InstanceID is the id of the instance of the target, from its
+	// monitoring.InstanceIDLabel label.
+	InstanceID string
+	// Endpoint is the host:port of the target.
+	Endpoint string
+	// Labels are the labels of the target.
+	Labels map[string]string
+	// JobName is the job name given to AddTarget, which is
+	// <instance_id>--<container>++<network> for instance containers.
+	JobName string
+}
+
+// parseJobNameTemplate parses the PROM_JOB_NAME_TEMPLATE option. An empty
+// value means defaultJobNameTemplate. As targets are removed by instance id,
+// the template is executed with sample data to check the job names contain the
+// instance id.
+func parseJobNameTemplate(value string) (*template.Template, error) {
+	if value == "" {
+		value = defaultJobNameTemplate
+	}
+	tmpl, err := template.New("job_name").Option("missingkey=error").Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: PROM_JOB_NAME_TEMPLATE: %w", ErrInvalidOptions, err)
+	}
+	sample := JobNameData{
+		InstanceID: "sample-avs-default",
+		Endpoint:   "main:8080",
+		Labels:     map[string]string{monitoring.InstanceIDLabel: "sample-avs-default"},
+		JobName:    "sample-avs-default--main++eigenlayer",
+	}
+	name, err := executeJobNameTemplate(tmpl, sample)
+	if err != nil {
+		return nil, fmt.Errorf("%w: PROM_JOB_NAME_TEMPLATE: %w", ErrInvalidOptions, err)
+	}
+	if !strings.Contains(name, sample.InstanceID) {
+		return nil, fmt.Errorf("%w: PROM_JOB_NAME_TEMPLATE: job names must contain the instance id", ErrInvalidOptions)
+	}
+	return tmpl, nil
+}
+
+// jobName returns the name of the job of the target, given the job name passed
+// to AddTarget.
+func (p *PrometheusService) jobName(target types.MonitoringTarget, labels map[string]string, jobName string) (string, error) {
+	if p.jobNameTemplate == nil {
+		return jobName, nil
+	}
+	return executeJobNameTemplate(p.jobNameTemplate, JobNameData{
+		InstanceID: labels[monitoring.InstanceIDLabel],
+		Endpoint:   target.Endpoint(),
+		Labels:     labels,
+		JobName:    jobName,
+	})
+}
+
+func executeJobNameTemplate(tmpl *template.Template, data JobNameData) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(sb.String())
+	if name == "" {
+		return "", errors.New("empty job name")
+	}
+	return name, nil
+}
@@ -0,0 +1,93 @@
+package prometheus
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/internal/locker/mocks"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestJobNameTemplate(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	invalid := []string{
+		`{{.InstanceID`,
+		`{{.Network}}`,
+		`static-name`,
+		`{{if false}}{{.InstanceID}}{{end}}`,
+	}
+	for _, tmpl := range invalid {
+		err = NewPrometheus().Init(types.ServiceOptions{
+			Stack:  stack,
+			Dotenv: map[string]string{"PROM_PORT": "9999", "PROM_JOB_NAME_TEMPLATE": tmpl},
+		})
+		assert.ErrorIs(t, err, ErrInvalidOptions, tmpl)
+	}
+
+	options := map[string]string{
+		"PROM_PORT":              "9999",
+		"NODE_EXPORTER_PORT":     "9100",
+		"PROM_JOB_NAME_TEMPLATE": `{{index .Labels "avs_name"}}/{{.InstanceID}}@{{.Endpoint}}`,
+	}
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: options,
+	})
+	require.NoError(t, err)
+	require.NoError(t, prometheus.Setup(options))
+
+	// Setup mock http server for the reloads
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	split := strings.Split(server.URL, ":")
+	host, port := split[1][2:], split[2]
+	prometheus.containerIP = net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	prometheus.port = uint16(p)
+
+	labels := map[string]string{
+		monitoring.InstanceIDLabel: "mock-avs-default",
+		monitoring.AVSNameLabel:    "mock-avs",
+	}
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "main", Port: 8080}, labels, "mock-avs-default--main++eigenlayer")
+	require.NoError(t, err)
+
+	promYml, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+	require.NoError(t, err)
+	var config Config
+	require.NoError(t, yaml.Unmarshal(promYml, &config))
+	require.Len(t, config.ScrapeConfigs, 2)
+	assert.Equal(t, "mock-avs/mock-avs-default@main:8080", config.ScrapeConfigs[1].JobName)
+
+	// Jobs named by the template are still removed by instance id
+	_, err = prometheus.RemoveTarget("mock-avs-default")
+	require.NoError(t, err)
+}
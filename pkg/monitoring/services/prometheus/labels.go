@@ -0,0 +1,74 @@
+package prometheus
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/prometheus/common/model"
+)
+
+// LabelNormalizer maps a label of a target to the label written to the
+// Prometheus config, or returns an error to reject it.
+type LabelNormalizer func(name, value string) (string, string, error)
+
+// SetLabelNormalizer sets the normalizer applied to the labels of the targets
+// added by AddTarget, AddTargetWithLabels and AddInstanceTargets before they
+// are validated. A nil normalizer keeps the labels, which is the default, so
+// invalid labels are rejected.
+func (p *PrometheusService) SetLabelNormalizer(normalizer LabelNormalizer) {
+	p.labelNormalizer = normalizer
+}
+
+// SanitizeLabel is a LabelNormalizer replacing the characters not allowed in
+// label names with underscores, prefixing the names starting with a digit with
+// one, and replacing the invalid UTF-8 sequences of the values with U+FFFD.
+func SanitizeLabel(name, value string) (string, string, error) {
+	if name == "" {
+		return "", "", fmt.Errorf("%w: empty label name", ErrInvalidLabel)
+	}
+	var b strings.Builder
+	if name[0] >= '0' && name[0] <= '9' {
+		b.WriteByte('_')
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String(), strings.ToValidUTF8(value, "\uFFFD"), nil
+}
+
+// normalizeLabels returns the labels normalized by the label normalizer, and
+// ErrInvalidLabel if a name is not a valid Prometheus label name, a value is
+// not valid UTF-8, or two labels are normalized to the same name.
+func (p *PrometheusService) normalizeLabels(labels map[string]string) (map[string]string, error) {
+	if len(labels) == 0 {
+		return labels, nil
+	}
+	normalized := make(map[string]string, len(labels))
+	for name, value := range labels {
+		newName, newValue := name, value
+		if p.labelNormalizer != nil {
+			var err error
+			newName, newValue, err = p.labelNormalizer(name, value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !model.LabelName(newName).IsValid() {
+			return nil, fmt.Errorf("%w: %q is not a valid label name", ErrInvalidLabel, newName)
+		}
+		if !utf8.ValidString(newValue) {
+			return nil, fmt.Errorf("%w: value of %q is not valid UTF-8", ErrInvalidLabel, newName)
+		}
+		if _, ok := normalized[newName]; ok {
+			return nil, fmt.Errorf("%w: %q is set more than once", ErrInvalidLabel, newName)
+		}
+		normalized[newName] = newValue
+	}
+	return normalized, nil
+}
@@ -0,0 +1,122 @@
+package prometheus
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/internal/locker/mocks"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestLabelNormalization(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	options := map[string]string{
+		"PROM_PORT":          "9999",
+		"NODE_EXPORTER_PORT": "9100",
+	}
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: options,
+	})
+	require.NoError(t, err)
+	err = prometheus.Setup(options)
+	require.NoError(t, err)
+
+	// Setup mock http server for the reloads
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.URL[len("http://"):])
+	require.NoError(t, err)
+	prometheus.containerIP = net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	prometheus.port = uint16(p)
+
+	readConfig := func() Config {
+		promYml, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+		require.NoError(t, err)
+		var prom Config
+		require.NoError(t, yaml.Unmarshal(promYml, &prom))
+		return prom
+	}
+
+	// Invalid labels are rejected by default
+	badID := "mock-avs-\xff"
+	err = prometheus.AddTargetWithLabels(types.MonitoringTarget{Host: "localhost", Port: 9100}, badID, nil, "mock-avs--main++holesky")
+	assert.ErrorIs(t, err, ErrInvalidLabel)
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 9100}, map[string]string{"bad-name": "x"}, "mock-avs--main++holesky")
+	assert.ErrorIs(t, err, ErrInvalidLabel)
+	err = prometheus.AddInstanceTargets(badID, []string{"localhost:8000"}, nil)
+	assert.ErrorIs(t, err, ErrInvalidLabel)
+	assert.Len(t, readConfig().ScrapeConfigs, 1)
+
+	// The sanitizer normalizes them
+	prometheus.SetLabelNormalizer(SanitizeLabel)
+	err = prometheus.AddTargetWithLabels(types.MonitoringTarget{Host: "localhost", Port: 9100}, badID, map[string]string{"bad-name": "x", "1st": "y"}, "mock-avs--main++holesky")
+	require.NoError(t, err)
+	prom := readConfig()
+	require.Len(t, prom.ScrapeConfigs, 2)
+	assert.Equal(t, map[string]string{
+		monitoring.InstanceIDLabel: "mock-avs-�",
+		"bad_name":                 "x",
+		"_1st":                     "y",
+	}, prom.ScrapeConfigs[1].StaticConfigs[0].Labels)
+
+	// Labels normalized to the same name are rejected
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 9101}, map[string]string{"a-b": "x", "a_b": "y"}, "other--main++holesky")
+	assert.ErrorIs(t, err, ErrInvalidLabel)
+	assert.Len(t, readConfig().ScrapeConfigs, 2)
+}
+
+func TestSanitizeLabel(t *testing.T) {
+	tests := []struct {
+		name, value         string
+		wantName, wantValue string
+		wantErr             bool
+	}{
+		{name: "valid_name", value: "value", wantName: "valid_name", wantValue: "value"},
+		{name: "my.label-name", value: "v", wantName: "my_label_name", wantValue: "v"},
+		{name: "9lives", value: "v", wantName: "_9lives", wantValue: "v"},
+		{name: "ünicode", value: "ok ü", wantName: "__nicode", wantValue: "ok ü"},
+		{name: "name", value: "bad\xffvalue", wantName: "name", wantValue: "bad�value"},
+		{name: "", value: "v", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, value, err := SanitizeLabel(tt.name, tt.value)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidLabel)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantName, name)
+			assert.Equal(t, tt.wantValue, value)
+		})
+	}
+}
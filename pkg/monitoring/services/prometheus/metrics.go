@@ -0,0 +1,102 @@
+package prometheus
+
+import (
+	promclient "github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics records the operations of the Prometheus service. A nil Metrics
+// records nothing.
+type Metrics interface {
+	// TargetAdded is called for every target added to the config or the
+	// targets file. Invalid targets and targets of existing jobs are not
+	// counted.
+	TargetAdded()
+	// TargetRemoved is called for every target removed from the config or the
+	// targets file. Missing targets are not counted.
+	TargetRemoved()
+	// Reloaded is called after every config reload, with its result.
+	Reloaded(err error)
+	// SetTargets sets the current number of scrape targets.
+	SetTargets(count int)
+}
+
+// Verify that CollectorMetrics implements the Metrics and Collector interfaces.
+var (
+	_ Metrics              = &CollectorMetrics{}
+	_ promclient.Collector = &CollectorMetrics{}
+)
+
+// CollectorMetrics implements Metrics with Prometheus client metrics. It is a
+// Collector, so callers can register it in their own registry.
+type CollectorMetrics struct {
+	targetsAdded   promclient.Counter
+	targetsRemoved promclient.Counter
+	reloads        *promclient.CounterVec
+	targets        promclient.Gauge
+}
+
+// NewCollectorMetrics creates a new CollectorMetrics.
+func NewCollectorMetrics() *CollectorMetrics {
+	return &CollectorMetrics{
+		targetsAdded: promclient.NewCounter(promclient.CounterOpts{
+			Namespace: "egn",
+			Subsystem: "prometheus",
+			Name:      "targets_added_total",
+			Help:      "Number of scrape targets added.",
+		}),
+		targetsRemoved: promclient.NewCounter(promclient.CounterOpts{
+			Namespace: "egn",
+			Subsystem: "prometheus",
+			Name:      "targets_removed_total",
+			Help:      "Number of scrape targets removed.",
+		}),
+		reloads: promclient.NewCounterVec(promclient.CounterOpts{
+			Namespace: "egn",
+			Subsystem: "prometheus",
+			Name:      "reloads_total",
+			Help:      "Number of Prometheus config reloads, by result.",
+		}, []string{"result"}),
+		targets: promclient.NewGauge(promclient.GaugeOpts{
+			Namespace: "egn",
+			Subsystem: "prometheus",
+			Name:      "targets",
+			Help:      "Current number of Prometheus scrape targets.",
+		}),
+	}
+}
+
+func (m *CollectorMetrics) TargetAdded() {
+	m.targetsAdded.Inc()
+}
+
+func (m *CollectorMetrics) TargetRemoved() {
+	m.targetsRemoved.Inc()
+}
+
+func (m *CollectorMetrics) Reloaded(err error) {
+	if err != nil {
+		m.reloads.WithLabelValues("failure").Inc()
+	} else {
+		m.reloads.WithLabelValues("success").Inc()
+	}
+}
+
+func (m *CollectorMetrics) SetTargets(count int) {
+	m.targets.Set(float64(count))
+}
+
+// Describe implements the prometheus Collector interface.
+func (m *CollectorMetrics) Describe(ch chan<- *promclient.Desc) {
+	m.targetsAdded.Describe(ch)
+	m.targetsRemoved.Describe(ch)
+	m.reloads.Describe(ch)
+	m.targets.Describe(ch)
+}
+
+// Collect implements the prometheus Collector interface.
+func (m *CollectorMetrics) Collect(ch chan<- promclient.Metric) {
+	m.targetsAdded.Collect(ch)
+	m.targetsRemoved.Collect(ch)
+	m.reloads.Collect(ch)
+	m.targets.Collect(ch)
+}
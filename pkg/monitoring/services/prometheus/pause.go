@@ -0,0 +1,91 @@
+package prometheus
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring"
+)
+
+// pausedLabel is the target label marking the targets of a paused instance,
+// which the pausedRelabelConfig rule drops before scraping.
+const pausedLabel = "paused"
+
+// pausedRelabelConfig returns the relabeling rule dropping the paused targets.
+func pausedRelabelConfig() RelabelConfig {
+	return RelabelConfig{
+		SourceLabels: []string{pausedLabel},
+		Regex:        "true",
+		Action:       "drop",
+	}
+}
+
+// PauseInstance stops the scraping of the targets of the instance, keeping its
+// jobs: the targets are labeled paused=true, and a relabeling rule of each job
+// drops them. ResumeInstance restores the jobs as they were. The configuration
+// is only reloaded if it changed, so pausing a paused instance does nothing.
+// Pausing is set per job, so it isn't supported with file service discovery.
+func (p *PrometheusService) PauseInstance(instanceID string) error {
+	return p.setPaused(instanceID, true)
+}
+
+// ResumeInstance resumes the scraping of the targets of an instance paused by
+// PauseInstance.
+func (p *PrometheusService) ResumeInstance(instanceID string) error {
+	return p.setPaused(instanceID, false)
+}
+
+func (p *PrometheusService) setPaused(instanceID string, paused bool) error {
+	if p.discovery == FileDiscovery {
+		return fmt.Errorf("%w: pausing instances", ErrFileSDUnsupported)
+	}
+	_, err := p.editConfig(func(_ *data.LockedMonitoringStack, config *Config) error {
+		var found bool
+		for i := range config.ScrapeConfigs {
+			if isInstanceScrapeConfig(config.ScrapeConfigs[i], instanceID) {
+				setJobPaused(&config.ScrapeConfigs[i], paused)
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: %s", monitoring.ErrNonexistingTarget, instanceID)
+		}
+		return nil
+	})
+	return err
+}
+
+// setJobPaused adds the paused label and its drop rule to the job, or removes
+// them.
+func setJobPaused(job *ScrapeConfig, paused bool) {
+	for i := range job.StaticConfigs {
+		labels := job.StaticConfigs[i].Labels
+		if paused {
+			if labels == nil {
+				labels = make(map[string]string, 1)
+			}
+			labels[pausedLabel] = "true"
+		} else {
+			delete(labels, pausedLabel)
+			if len(labels) == 0 {
+				labels = nil
+			}
+		}
+		job.StaticConfigs[i].Labels = labels
+	}
+	rules := make([]RelabelConfig, 0, len(job.RelabelConfigs)+1)
+	for _, rule := range job.RelabelConfigs {
+		if !reflect.DeepEqual(rule, pausedRelabelConfig()) {
+			rules = append(rules, rule)
+		}
+	}
+	if paused {
+		// First, so no other rule changes the label before
+		rules = append([]RelabelConfig{pausedRelabelConfig()}, rules...)
+	}
+	if len(rules) == 0 {
+		rules = nil
+	}
+	job.RelabelConfigs = rules
+}
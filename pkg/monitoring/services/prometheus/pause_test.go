@@ -0,0 +1,131 @@
+package prometheus
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/internal/locker/mocks"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// scrapedTargets returns the targets of the job left by its drop rules, which
+// are the ones Prometheus scrapes.
+func scrapedTargets(t *testing.T, job ScrapeConfig) []string {
+	var targets []string
+	for _, staticConfig := range job.StaticConfigs {
+		dropped := false
+		for _, rule := range job.RelabelConfigs {
+			if rule.Action != "drop" {
+				continue
+			}
+			values := make([]string, 0, len(rule.SourceLabels))
+			for _, name := range rule.SourceLabels {
+				values = append(values, staticConfig.Labels[name])
+			}
+			regex, err := regexp.Compile("^(?:" + rule.Regex + ")$")
+			require.NoError(t, err)
+			if regex.MatchString(strings.Join(values, ";")) {
+				dropped = true
+			}
+		}
+		if !dropped {
+			targets = append(targets, staticConfig.Targets...)
+		}
+	}
+	return targets
+}
+
+func TestPauseInstance(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	options := map[string]string{
+		"PROM_PORT":          "9999",
+		"NODE_EXPORTER_PORT": "9100",
+	}
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: options,
+	})
+	require.NoError(t, err)
+	err = prometheus.Setup(options)
+	require.NoError(t, err)
+
+	// Setup mock http server, counting the reloads
+	var reloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reloads.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	split := strings.Split(server.URL, ":")
+	host, port := split[1][2:], split[2]
+	prometheus.containerIP = net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	prometheus.port = uint16(p)
+
+	readJob := func() ScrapeConfig {
+		promYml, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+		require.NoError(t, err)
+		var prom Config
+		require.NoError(t, yaml.Unmarshal(promYml, &prom))
+		require.Len(t, prom.ScrapeConfigs, 2)
+		return prom.ScrapeConfigs[1]
+	}
+
+	err = prometheus.PauseInstance("test-avs")
+	assert.ErrorIs(t, err, monitoring.ErrNonexistingTarget)
+
+	relabel := []RelabelConfig{{TargetLabel: "instance", Replacement: "avs"}}
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8000, RelabelConfigs: relabel}, map[string]string{"network": "holesky"}, "test-avs--main++holesky")
+	require.NoError(t, err)
+	require.EqualValues(t, 1, reloads.Load())
+	original := readJob()
+	assert.Equal(t, []string{"localhost:8000"}, scrapedTargets(t, original))
+
+	// Paused targets are dropped
+	require.NoError(t, prometheus.PauseInstance("test-avs"))
+	assert.EqualValues(t, 2, reloads.Load())
+	job := readJob()
+	assert.Empty(t, scrapedTargets(t, job))
+	assert.Equal(t, "true", job.StaticConfigs[0].Labels["paused"])
+	assert.Equal(t, "holesky", job.StaticConfigs[0].Labels["network"])
+
+	// Pausing again changes nothing
+	require.NoError(t, prometheus.PauseInstance("test-avs"))
+	assert.EqualValues(t, 2, reloads.Load(), "config reloaded without changes")
+
+	// Resuming restores the original job
+	require.NoError(t, prometheus.ResumeInstance("test-avs"))
+	assert.EqualValues(t, 3, reloads.Load())
+	assert.Equal(t, original, readJob())
+	assert.Equal(t, []string{"localhost:8000"}, scrapedTargets(t, readJob()))
+	require.NoError(t, prometheus.ResumeInstance("test-avs"))
+	assert.EqualValues(t, 3, reloads.Load(), "config reloaded without changes")
+}
@@ -0,0 +1,51 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// readyPollInterval is the time between the readiness checks of WaitReady.
+const readyPollInterval = 250 * time.Millisecond
+
+// WaitReady waits until Prometheus is ready to serve traffic, which it is once
+// its configuration is loaded, polling its /-/ready endpoint with the service
+// HTTP client. It returns ErrNotReady, wrapping the context error, if ctx is
+// done before.
+func (p *PrometheusService) WaitReady(ctx context.Context) error {
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+	for {
+		err := p.checkReady(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Debugf("Prometheus not ready: %v", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", ErrNotReady, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkReady returns nil if the Prometheus readiness endpoint answers OK.
+func (p *PrometheusService) checkReady(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Endpoint()+"/-/ready", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", ErrNotReady, resp.Status)
+	}
+	return nil
+}
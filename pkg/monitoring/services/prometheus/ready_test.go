@@ -0,0 +1,55 @@
+package prometheus
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitReady(t *testing.T) {
+	// Setup mock http server, ready after its third request
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/-/ready" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	split := strings.Split(server.URL, ":")
+	host, port := split[1][2:], split[2]
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	prometheus := NewPrometheus()
+	prometheus.containerIP = net.ParseIP(host)
+	prometheus.port = uint16(p)
+	prometheus.SetHTTPClient(server.Client())
+
+	// Not ready before the context expires
+	ctx, cancel := context.WithTimeout(context.Background(), readyPollInterval/2)
+	defer cancel()
+	err = prometheus.WaitReady(ctx)
+	assert.ErrorIs(t, err, ErrNotReady)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Ready once the server is
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, prometheus.WaitReady(ctx))
+	assert.EqualValues(t, 3, requests.Load())
+}
@@ -0,0 +1,108 @@
+package prometheus
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring"
+	"gopkg.in/yaml.v3"
+)
+
+// ReconcileTargets makes the scrape jobs of the Prometheus config match the
+// desired targets, given like to Apply, with a single write and a single
+// reload: the jobs of the desired targets that don't exist are added, and the
+// jobs that aren't desired are removed, along with the scrape secrets of the
+// instances left without jobs. The node exporter job is never removed. Jobs
+// that remain are kept as they are, so their custom labels and authentication
+// are preserved. It returns the names of the added and removed jobs. Nothing
+// is written nor reloaded if the config already matches, and if the resulting
+// config doesn't validate it is left untouched. File service discovery is not
+// supported.
+func (p *PrometheusService) ReconcileTargets(desired []TargetAdd) (added, removed []string, err error) {
+	if p.discovery == FileDiscovery {
+		return nil, nil, fmt.Errorf("%w: target reconciliation", ErrFileSDUnsupported)
+	}
+	desiredJobs := make([]ScrapeConfig, 0, len(desired))
+	desiredNames := make(map[string]bool, len(desired))
+	for _, target := range desired {
+		endpoint, labels, jobName, err := p.prepareTarget(target.Target, target.Labels, target.JobName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if desiredNames[jobName] {
+			continue
+		}
+		desiredNames[jobName] = true
+		desiredJobs = append(desiredJobs, targetJob(target.Target, endpoint, labels, jobName))
+	}
+
+	var changed bool
+	err = p.stack.WithLock(func(s *data.LockedMonitoringStack) error {
+		var err error
+		added, removed = nil, nil
+		changed, err = p.updateConfig(s, func(config *Config) error {
+			existing := make(map[string]bool, len(config.ScrapeConfigs))
+			kept := make([]ScrapeConfig, 0, len(config.ScrapeConfigs)+len(desiredJobs))
+			for _, job := range config.ScrapeConfigs {
+				if desiredNames[job.JobName] || isNodeExporterJob(job.JobName) {
+					existing[job.JobName] = true
+					kept = append(kept, job)
+				} else {
+					removed = append(removed, job.JobName)
+				}
+			}
+			for _, job := range desiredJobs {
+				if !existing[job.JobName] {
+					added = append(added, job.JobName)
+					kept = append(kept, job)
+				}
+			}
+			config.ScrapeConfigs = kept
+			newConfig, err := yaml.Marshal(config)
+			if err != nil {
+				return err
+			}
+			if err := validateConfig(newConfig); err != nil {
+				return fmt.Errorf("%w: %s", ErrInvalidConfig, err)
+			}
+			return nil
+		})
+		if err != nil || !changed {
+			return err
+		}
+		p.targetsAdded(len(added))
+		p.targetsRemoved(len(removed))
+		// Remove the scrape secrets of the instances without jobs left
+		for _, jobName := range removed {
+			instanceID, _, _ := strings.Cut(jobName, "--")
+			hasJobs := false
+			for name := range desiredNames {
+				if isInstanceJob(name, instanceID) {
+					hasJobs = true
+					break
+				}
+			}
+			if !hasJobs {
+				if err := s.RemoveAll(filepath.Join(secretsDir, instanceID)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if !changed {
+		return added, removed, nil
+	}
+	return added, removed, p.reloadConfig()
+}
+
+// isNodeExporterJob returns true if the job is the node exporter job added by
+// Setup, named after the node exporter endpoint.
+func isNodeExporterJob(jobName string) bool {
+	return strings.HasPrefix(jobName, monitoring.NodeExporterContainerName+":")
+}
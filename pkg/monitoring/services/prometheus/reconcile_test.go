@@ -0,0 +1,127 @@
+package prometheus
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/internal/locker/mocks"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestReconcileTargets(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	options := map[string]string{
+		"PROM_PORT":          "9999",
+		"NODE_EXPORTER_PORT": "9100",
+	}
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: options,
+	})
+	require.NoError(t, err)
+	err = prometheus.Setup(options)
+	require.NoError(t, err)
+
+	// Setup mock http server counting the reloads
+	var reloads int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reloads++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.URL[len("http://"):])
+	require.NoError(t, err)
+	prometheus.containerIP = net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	prometheus.port = uint16(p)
+
+	readConfig := func() Config {
+		promYml, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+		require.NoError(t, err)
+		var prom Config
+		require.NoError(t, yaml.Unmarshal(promYml, &prom))
+		return prom
+	}
+	jobNames := func() []string {
+		var names []string
+		for _, job := range readConfig().ScrapeConfigs {
+			names = append(names, job.JobName)
+		}
+		return names
+	}
+
+	// Existing config
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8000}, map[string]string{"team": "a"}, "kept-avs--main++holesky")
+	require.NoError(t, err)
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8001}, nil, "stale-avs--main++holesky")
+	require.NoError(t, err)
+	err = prometheus.AddInstanceTargets("stale-instance", []string{"localhost:8002"}, nil)
+	require.NoError(t, err)
+	require.NoError(t, prometheus.SetBearerToken("stale-instance", "token"))
+	reloads = 0
+
+	added, removed, err := prometheus.ReconcileTargets([]TargetAdd{
+		{Target: types.MonitoringTarget{Host: "localhost", Port: 8000}, Labels: map[string]string{"team": "b"}, JobName: "kept-avs--main++holesky"},
+		{Target: types.MonitoringTarget{Host: "localhost", Port: 8003}, JobName: "new-avs--main++holesky"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new-avs--main++holesky"}, added)
+	assert.Equal(t, []string{"stale-avs--main++holesky", "stale-instance"}, removed)
+	assert.Equal(t, 1, reloads, "the reconciliation must be reloaded once")
+	assert.Equal(t, []string{"egn_node_exporter:9100", "kept-avs--main++holesky", "new-avs--main++holesky"}, jobNames())
+	prom := readConfig()
+	assert.Equal(t, map[string]string{"team": "a"}, prom.ScrapeConfigs[1].StaticConfigs[0].Labels, "custom labels must be kept")
+	assert.Equal(t, []string{"localhost:8003"}, prom.ScrapeConfigs[2].StaticConfigs[0].Targets)
+	exists, err := afero.DirExists(afs, "/monitoring/prometheus/secrets/stale-instance")
+	require.NoError(t, err)
+	assert.False(t, exists, "the secrets of removed instances must be removed")
+
+	// Nothing is reloaded when the config already matches
+	added, removed, err = prometheus.ReconcileTargets([]TargetAdd{
+		{Target: types.MonitoringTarget{Host: "localhost", Port: 8000}, JobName: "kept-avs--main++holesky"},
+		{Target: types.MonitoringTarget{Host: "localhost", Port: 8003}, JobName: "new-avs--main++holesky"},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+	assert.Equal(t, 1, reloads)
+
+	// An invalid target leaves the config untouched
+	_, _, err = prometheus.ReconcileTargets([]TargetAdd{
+		{Target: types.MonitoringTarget{Host: "localhost", Port: 8004}, Labels: map[string]string{"bad-name": "x"}, JobName: "bad-avs--main++holesky"},
+	})
+	assert.ErrorIs(t, err, ErrInvalidLabel)
+	assert.Equal(t, []string{"egn_node_exporter:9100", "kept-avs--main++holesky", "new-avs--main++holesky"}, jobNames())
+
+	// No desired targets leave the node exporter only
+	added, removed, err = prometheus.ReconcileTargets(nil)
+	require.NoError(t, err)
+	assert.Empty(t, added)
+	assert.Equal(t, []string{"kept-avs--main++holesky", "new-avs--main++holesky"}, removed)
+	assert.Equal(t, []string{"egn_node_exporter:9100"}, jobNames())
+	assert.Equal(t, 2, reloads)
+}
@@ -0,0 +1,99 @@
+package prometheus
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+	"github.com/cenkalti/backoff/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReloadStrategy is how Prometheus is told to reload its configuration after
+// a target change.
+type ReloadStrategy string
+
+const (
+	// ReloadHTTP posts to the /-/reload endpoint, which requires Prometheus to
+	// run with --web.enable-lifecycle. It is the default.
+	ReloadHTTP ReloadStrategy = "http"
+	// ReloadSignal sends SIGHUP to the Prometheus process through the
+	// ReloadSignaler.
+	ReloadSignal ReloadStrategy = "sighup"
+	// ReloadNone only writes the configuration, relying on Prometheus picking
+	// the changes up by other means.
+	ReloadNone ReloadStrategy = "config-only"
+)
+
+// ReloadSignaler sends SIGHUP to the Prometheus process, for instance through
+// the container runtime. The monitoring manager sets one signaling the
+// Prometheus container.
+type ReloadSignaler = types.ReloadSignaler
+
+// parseReloadStrategy parses the PROM_RELOAD_STRATEGY option. An empty value
+// means ReloadHTTP.
+func parseReloadStrategy(value string) (ReloadStrategy, error) {
+	switch strategy := ReloadStrategy(value); strategy {
+	case "":
+		return ReloadHTTP, nil
+	case ReloadHTTP, ReloadSignal, ReloadNone:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("%w: PROM_RELOAD_STRATEGY must be one of %s, %s or %s", ErrInvalidOptions, ReloadHTTP, ReloadSignal, ReloadNone)
+}
+
+// SetReloadSignaler sets the signaler used by the sighup reload strategy.
+func (p *PrometheusService) SetReloadSignaler(signaler ReloadSignaler) {
+	p.signaler = signaler
+}
+
+// reloadConfig tells Prometheus to reload its configuration, following the
+// reload strategy.
+func (p *PrometheusService) reloadConfig() error {
+	var err error
+	switch p.reloadStrategy {
+	case ReloadNone:
+		return nil
+	case ReloadSignal:
+		if p.signaler == nil {
+			err = fmt.Errorf("%w: no reload signaler set", ErrReloadFailed)
+		} else if err = p.signaler.SignalReload(); err != nil {
+			err = fmt.Errorf("%w: %w", ErrReloadFailed, err)
+		}
+	default:
+		err = p.reloadHTTP()
+	}
+	if p.metrics != nil {
+		p.metrics.Reloaded(err)
+	}
+	return err
+}
+
+// reloadHTTP posts to the Prometheus reload endpoint, retrying with
+// exponential backoff.
+func (p *PrometheusService) reloadHTTP() error {
+	// Adding exponential retry
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = reloadMaxElapsedTime
+
+	return backoff.Retry(func() (err error) {
+		resp, err := p.client().Post(fmt.Sprintf("http://%s:%d/-/reload", p.containerIP, p.port), "", nil)
+		if err != nil {
+			// TODO: Use fields to log the error
+			log.Debug("Retrying request...")
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusMethodNotAllowed {
+			// Retrying won't help, the lifecycle API is disabled
+			return backoff.Permanent(fmt.Errorf("%w: %s: enable --web.enable-lifecycle or use another PROM_RELOAD_STRATEGY", ErrReloadFailed, resp.Status))
+		}
+		if resp.StatusCode != http.StatusOK {
+			// TODO: Use fields to log the error
+			log.Debug("Retrying request...")
+			return fmt.Errorf("%w: %s", ErrReloadFailed, resp.Status)
+		}
+		return nil
+	}, b)
+}
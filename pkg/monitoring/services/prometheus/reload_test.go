@@ -0,0 +1,186 @@
+package prometheus
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/internal/locker/mocks"
+	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingSignaler struct {
+	calls atomic.Int32
+}
+
+func (s *countingSignaler) SignalReload() error {
+	s.calls.Add(1)
+	return nil
+}
+
+func TestReloadStrategy(t *testing.T) {
+	tests := []struct {
+		name         string
+		strategy     string
+		status       int
+		signaler     *countingSignaler
+		wantRequests int32
+		wantSignals  int32
+		wantErr      error
+	}{
+		{
+			name:         "default",
+			status:       http.StatusOK,
+			wantRequests: 1,
+		},
+		{
+			name:         "http",
+			strategy:     "http",
+			status:       http.StatusOK,
+			wantRequests: 1,
+		},
+		{
+			name:         "http, lifecycle API disabled",
+			strategy:     "http",
+			status:       http.StatusMethodNotAllowed,
+			wantRequests: 1,
+			wantErr:      ErrReloadFailed,
+		},
+		{
+			name:        "sighup",
+			strategy:    "sighup",
+			signaler:    &countingSignaler{},
+			wantSignals: 1,
+		},
+		{
+			name:     "sighup without signaler",
+			strategy: "sighup",
+			wantErr:  ErrReloadFailed,
+		},
+		{
+			name:     "config-only",
+			strategy: "config-only",
+			status:   http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock locker
+			ctrl := gomock.NewController(t)
+			locker := mocks.NewMockLocker(ctrl)
+			locker.EXPECT().New("/monitoring/.lock").Return(locker)
+			locker.EXPECT().Lock().Return(nil).AnyTimes()
+			locker.EXPECT().Locked().Return(true).AnyTimes()
+			locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+			afs := afero.NewMemMapFs()
+			dataDir, err := data.NewDataDir("/", afs, locker)
+			require.NoError(t, err)
+			stack, err := dataDir.MonitoringStack()
+			require.NoError(t, err)
+
+			options := map[string]string{
+				"PROM_PORT":          "9999",
+				"NODE_EXPORTER_PORT": "9100",
+			}
+			if tt.strategy != "" {
+				options["PROM_RELOAD_STRATEGY"] = tt.strategy
+			}
+			prometheus := NewPrometheus()
+			err = prometheus.Init(types.ServiceOptions{
+				Stack:  stack,
+				Dotenv: options,
+			})
+			require.NoError(t, err)
+			err = prometheus.Setup(options)
+			require.NoError(t, err)
+			if tt.signaler != nil {
+				prometheus.SetReloadSignaler(tt.signaler)
+			}
+
+			// Setup mock http server, counting the reload requests
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+			split := strings.Split(server.URL, ":")
+			host, port := split[1][2:], split[2]
+			prometheus.containerIP = net.ParseIP(host)
+			p, err := strconv.Atoi(port)
+			require.NoError(t, err)
+			prometheus.port = uint16(p)
+
+			err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8000}, nil, "test-avs++testnet")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantRequests, requests.Load())
+			if tt.signaler != nil {
+				assert.Equal(t, tt.wantSignals, tt.signaler.calls.Load())
+			}
+
+			// The config is written regardless of the reload
+			promYml, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+			require.NoError(t, err)
+			assert.Contains(t, string(promYml), "localhost:8000")
+		})
+	}
+}
+
+func TestReloadStrategyInvalid(t *testing.T) {
+	prometheus := NewPrometheus()
+	err := prometheus.Init(types.ServiceOptions{
+		Dotenv: map[string]string{
+			"PROM_PORT":            "9999",
+			"PROM_RELOAD_STRATEGY": "restart",
+		},
+	})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+}
+
+func TestReloadHTTPClient(t *testing.T) {
+	defer func(d time.Duration) { reloadMaxElapsedTime = d }(reloadMaxElapsedTime)
+	reloadMaxElapsedTime = 100 * time.Millisecond
+
+	// A hung Prometheus, never answering until the request is canceled
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	split := strings.Split(server.URL, ":")
+	host, port := split[1][2:], split[2]
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	prometheus := NewPrometheus()
+	prometheus.containerIP = net.ParseIP(host)
+	prometheus.port = uint16(p)
+	assert.Same(t, defaultHTTPClient, prometheus.client())
+	assert.NotZero(t, defaultHTTPClient.Timeout)
+
+	prometheus.SetHTTPClient(&http.Client{Timeout: time.Millisecond})
+	start := time.Now()
+	err = prometheus.reloadConfig()
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second, "reload didn't fail fast")
+	assert.Positive(t, requests.Load())
+
+	prometheus.SetHTTPClient(nil)
+	assert.Same(t, defaultHTTPClient, prometheus.client())
+}
@@ -1,20 +1,24 @@
 package prometheus
 
 import (
+	"bytes"
+	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/NethermindEth/eigenlayer/internal/data"
 	"github.com/NethermindEth/eigenlayer/pkg/monitoring"
 	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
-	"github.com/cenkalti/backoff/v4"
-	log "github.com/sirupsen/logrus"
+	"github.com/prometheus/common/model"
 	"github.com/thoas/go-funk"
 	"gopkg.in/yaml.v3"
 )
@@ -22,6 +26,25 @@ import (
 //go:embed config
 var config embed.FS
 
+// reloadMaxElapsedTime is the maximum time spent retrying a config reload.
+var reloadMaxElapsedTime = time.Minute
+
+// minScrapeInterval and maxScrapeInterval bound the global scrape interval
+// set by SetScrapeInterval.
+const (
+	minScrapeInterval = time.Second
+	maxScrapeInterval = time.Hour
+)
+
+// nodeExporterProbeTimeout is the timeout of the node exporter probe done
+// during Setup, if enabled.
+const nodeExporterProbeTimeout = 5 * time.Second
+
+// defaultHTTPClient is the client of the requests to Prometheus when none is
+// set with SetHTTPClient. Its timeout keeps a hung Prometheus from blocking the
+// requests forever.
+var defaultHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
 // Config represents the Prometheus configuration.
 type Config struct {
 	Global        GlobalConfig   `yaml:"global"`
@@ -35,11 +58,20 @@ type GlobalConfig struct {
 
 // ScrapeConfig represents the configuration for a Prometheus scrape job.
 type ScrapeConfig struct {
-	JobName       string         `yaml:"job_name"`
-	StaticConfigs []StaticConfig `yaml:"static_configs"`
-	MetricsPath   string         `yaml:"metrics_path,omitempty"`
+	JobName              string          `yaml:"job_name"`
+	StaticConfigs        []StaticConfig  `yaml:"static_configs,omitempty"`
+	FileSDConfigs        []FileSDConfig  `yaml:"file_sd_configs,omitempty"`
+	MetricsPath          string          `yaml:"metrics_path,omitempty"`
+	Scheme               string          `yaml:"scheme,omitempty"`
+	BasicAuth            *BasicAuth      `yaml:"basic_auth,omitempty"`
+	BearerTokenFile      string          `yaml:"bearer_token_file,omitempty"`
+	RelabelConfigs       []RelabelConfig `yaml:"relabel_configs,omitempty"`
+	MetricRelabelConfigs []RelabelConfig `yaml:"metric_relabel_configs,omitempty"`
 }
 
+// RelabelConfig represents a Prometheus relabeling rule.
+type RelabelConfig = types.RelabelConfig
+
 // StaticConfig represents the static configuration for a Prometheus scrape job.
 type StaticConfig struct {
 	Targets []string          `yaml:"targets"`
@@ -54,6 +86,18 @@ type PrometheusService struct {
 	stack       *data.MonitoringStack
 	containerIP net.IP
 	port        uint16
+	metrics     Metrics
+	// probeNodeExporter enables checking the node exporter endpoint is
+	// reachable during Setup.
+	probeNodeExporter bool
+	checker           ConfigChecker
+	reloadStrategy    ReloadStrategy
+	signaler          ReloadSignaler
+	discovery         ServiceDiscovery
+	jobNameTemplate   *template.Template
+	httpClient        *http.Client
+	endpointRewriter  EndpointRewriter
+	labelNormalizer   LabelNormalizer
 }
 
 // NewPrometheus creates a new PrometheusService.
@@ -76,119 +120,412 @@ func (p *PrometheusService) Init(opts types.ServiceOptions) error {
 		return fmt.Errorf("%w: %s is not a valid port", ErrInvalidOptions, "PROM_PORT")
 	}
 	p.port = uint16(port)
+	if p.reloadStrategy, err = parseReloadStrategy(opts.Dotenv["PROM_RELOAD_STRATEGY"]); err != nil {
+		return err
+	}
+	if p.discovery, err = parseServiceDiscovery(opts.Dotenv["PROM_SERVICE_DISCOVERY"]); err != nil {
+		return err
+	}
+	if p.jobNameTemplate, err = parseJobNameTemplate(opts.Dotenv["PROM_JOB_NAME_TEMPLATE"]); err != nil {
+		return err
+	}
 	p.stack = opts.Stack
 	return nil
 }
 
 // AddTarget adds a new target to the Prometheus config and reloads the Prometheus configuration.
-// Assumes endpoint is in the form http://<ip/domain>:<port>
+// The target scheme must be http or https, as Prometheus can't scrape other
+// schemes, and defaults to http. With file service discovery, the target is
+// added to the targets file instead, without reload. The job is named by the
+// PROM_JOB_NAME_TEMPLATE template, which keeps the given job name by default.
+// The labels are normalized by the label normalizer, and invalid label names or
+// values that aren't valid UTF-8 return ErrInvalidLabel.
 func (p *PrometheusService) AddTarget(target types.MonitoringTarget, labels map[string]string, jobName string) error {
-	path := filepath.Join("prometheus", "prometheus.yml")
-	// Read the existing config
-	rawConfig, err := p.stack.ReadFile(path)
+	endpoint, labels, jobName, err := p.prepareTarget(target, labels, jobName)
 	if err != nil {
 		return err
 	}
-
-	// Unmarshal the YAML data into the Config struct
-	var config Config
-	if err = yaml.Unmarshal(rawConfig, &config); err != nil {
-		return err
+	if p.discovery == FileDiscovery {
+		return p.addFileSDTarget(target, endpoint, labels, jobName)
 	}
-
-	// Add a new job for the new endpoint
-	// Check if the job already exists
-	for _, job := range config.ScrapeConfigs {
-		if job.JobName == jobName {
-			// There is no need to add the job if it already exists
-			return nil
+	changed, err := p.editConfig(func(_ *data.LockedMonitoringStack, config *Config) error {
+		// Add a new job for the new endpoint
+		// Check if the job already exists
+		for _, job := range config.ScrapeConfigs {
+			if job.JobName == jobName {
+				// There is no need to add the job if it already exists
+				return nil
+			}
 		}
+		config.ScrapeConfigs = append(config.ScrapeConfigs, targetJob(target, endpoint, labels, jobName))
+		return nil
+	})
+	if changed {
+		p.targetsAdded(1)
 	}
+	return err
+}
 
+// prepareTarget checks the target and returns the endpoint Prometheus scrapes,
+// the normalized labels and the job name of the target.
+func (p *PrometheusService) prepareTarget(target types.MonitoringTarget, labels map[string]string, jobName string) (string, map[string]string, string, error) {
+	if target.Scheme != "" && target.Scheme != "http" && target.Scheme != "https" {
+		return "", nil, "", fmt.Errorf("%w: %q", ErrUnsupportedScheme, target.Scheme)
+	}
+	labels, err := p.normalizeLabels(labels)
+	if err != nil {
+		return "", nil, "", err
+	}
+	endpoint, err := p.rewriteEndpoint(target.Endpoint(), targetInstanceID(labels, jobName))
+	if err != nil {
+		return "", nil, "", err
+	}
+	jobName, err = p.jobName(target, labels, jobName)
+	if err != nil {
+		return "", nil, "", fmt.Errorf("%w: job name: %w", ErrInvalidOptions, err)
+	}
+	return endpoint, labels, jobName, nil
+}
+
+// targetJob returns the scrape config of a target added by AddTarget.
+func targetJob(target types.MonitoringTarget, endpoint string, labels map[string]string, jobName string) ScrapeConfig {
 	// Default to /metrics if no path is provided
 	metricsPath := "/metrics"
 	if target.Path != "" {
 		metricsPath = target.Path
 	}
-	job := ScrapeConfig{
+	return ScrapeConfig{
 		JobName: jobName,
 		StaticConfigs: []StaticConfig{
 			{
-				Targets: []string{target.Endpoint()},
+				Targets: []string{endpoint},
 				Labels:  labels,
 			},
 		},
-		MetricsPath: metricsPath,
+		MetricsPath:          metricsPath,
+		Scheme:               target.Scheme,
+		RelabelConfigs:       target.RelabelConfigs,
+		MetricRelabelConfigs: target.MetricRelabelConfigs,
 	}
-	config.ScrapeConfigs = append(config.ScrapeConfigs, job)
+}
 
-	// Marshal the updated config back to YAML
-	newConfig, err := yaml.Marshal(&config)
+// AddTargetWithLabels is like AddTarget, but always labels the target with the
+// given instance id, in the monitoring.InstanceIDLabel label, besides the given
+// labels. Label names reserved by Prometheus, which start with __, and invalid
+// label names return ErrInvalidLabel.
+func (p *PrometheusService) AddTargetWithLabels(target types.MonitoringTarget, instanceID string, labels map[string]string, jobName string) error {
+	merged, err := instanceLabels(instanceID, labels)
 	if err != nil {
 		return err
 	}
+	return p.AddTarget(target, merged, jobName)
+}
 
-	// Write the updated YAML data back to the file
-	if err = p.stack.WriteFile(path, newConfig); err != nil {
+// AddInstanceTargets adds the endpoints of the instance, given as host:port,
+// as the targets of a single job named after the instance, sharing the
+// metrics path /metrics and the labels. The labels are checked like in
+// AddTargetWithLabels. Like AddTarget, nothing is added if the job already
+// exists. RemoveTargetsByInstance removes the job.
+func (p *PrometheusService) AddInstanceTargets(instanceID string, endpoints []string, labels map[string]string) error {
+	if len(endpoints) == 0 {
+		return fmt.Errorf("%w: no endpoints for instance %s", types.ErrInvalidMonitoringTarget, instanceID)
+	}
+	for _, endpoint := range endpoints {
+		_, port, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			return fmt.Errorf("%w: %s", types.ErrInvalidMonitoringTarget, err)
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return fmt.Errorf("%w: invalid port %q", types.ErrInvalidMonitoringTarget, port)
+		}
+	}
+	merged, err := instanceLabels(instanceID, labels)
+	if err != nil {
 		return err
 	}
-
-	// Reload the config
-	if err = p.reloadConfig(); err != nil {
+	if merged, err = p.normalizeLabels(merged); err != nil {
 		return err
 	}
+	rewritten := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		endpoint, err := p.rewriteEndpoint(endpoint, instanceID)
+		if err != nil {
+			return err
+		}
+		rewritten = append(rewritten, endpoint)
+	}
+	endpoints = rewritten
+	if p.discovery == FileDiscovery {
+		return p.stack.WithLock(func(s *data.LockedMonitoringStack) error {
+			groups, err := readTargetGroups(s)
+			if err != nil {
+				return err
+			}
+			for _, group := range groups {
+				if group.Labels["job"] == instanceID {
+					return nil
+				}
+			}
+			merged["job"] = instanceID
+			groups = append(groups, TargetGroup{Targets: endpoints, Labels: merged})
+			if err = writeTargetGroups(s, groups); err != nil {
+				return err
+			}
+			p.setTargets(len(groups))
+			p.targetsAdded(1)
+			return nil
+		})
+	}
 
-	return nil
+	changed, err := p.editConfig(func(_ *data.LockedMonitoringStack, config *Config) error {
+		for _, job := range config.ScrapeConfigs {
+			if job.JobName == instanceID {
+				return nil
+			}
+		}
+		config.ScrapeConfigs = append(config.ScrapeConfigs, ScrapeConfig{
+			JobName:       instanceID,
+			StaticConfigs: []StaticConfig{{Targets: endpoints, Labels: merged}},
+			MetricsPath:   "/metrics",
+		})
+		return nil
+	})
+	if changed {
+		p.targetsAdded(1)
+	}
+	return err
 }
 
-// RemoveTarget removes a target from the Prometheus config and reloads the Prometheus configuration.
-func (p *PrometheusService) RemoveTarget(instanceID string) (string, error) {
-	path := filepath.Join("prometheus", "prometheus.yml")
-	// Read the existing config
-	rawConfig, err := p.stack.ReadFile(path)
-	if err != nil {
-		return "", err
+// RemoveTargetsByInstance removes every job of the instance: the job added by
+// AddInstanceTargets and the jobs added by AddTarget for its containers. It
+// returns the number of removed jobs, and monitoring.ErrNonexistingTarget if
+// there was none.
+func (p *PrometheusService) RemoveTargetsByInstance(instanceID string) (int, error) {
+	var removed int
+	if p.discovery == FileDiscovery {
+		err := p.stack.WithLock(func(s *data.LockedMonitoringStack) error {
+			groups, err := readTargetGroups(s)
+			if err != nil {
+				return err
+			}
+			kept := make([]TargetGroup, 0, len(groups))
+			for _, group := range groups {
+				if !isInstanceTargetGroup(group, instanceID) {
+					kept = append(kept, group)
+				}
+			}
+			if removed = len(groups) - len(kept); removed == 0 {
+				return fmt.Errorf("%w: %s", monitoring.ErrNonexistingTarget, instanceID)
+			}
+			if err = writeTargetGroups(s, kept); err != nil {
+				return err
+			}
+			p.setTargets(len(kept))
+			p.targetsRemoved(removed)
+			return nil
+		})
+		return removed, err
 	}
 
-	// Unmarshal the YAML data into the Config struct
-	var config Config
-	if err = yaml.Unmarshal(rawConfig, &config); err != nil {
-		return "", err
+	err := p.stack.WithLock(func(s *data.LockedMonitoringStack) error {
+		_, err := p.updateConfig(s, func(config *Config) error {
+			kept := make([]ScrapeConfig, 0, len(config.ScrapeConfigs))
+			for _, job := range config.ScrapeConfigs {
+				if !isInstanceScrapeConfig(job, instanceID) {
+					kept = append(kept, job)
+				}
+			}
+			if removed = len(config.ScrapeConfigs) - len(kept); removed == 0 {
+				return fmt.Errorf("%w: %s", monitoring.ErrNonexistingTarget, instanceID)
+			}
+			config.ScrapeConfigs = kept
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		p.targetsRemoved(removed)
+		return s.RemoveAll(filepath.Join(secretsDir, instanceID))
+	})
+	if err != nil {
+		return 0, err
 	}
+	return removed, p.reloadConfig()
+}
 
-	// Remove the target from the jobs
-	var network string
-	config.ScrapeConfigs = funk.Filter(config.ScrapeConfigs, func(job ScrapeConfig) bool {
-		if strings.Contains(job.JobName, instanceID) {
-			network = strings.Split(strings.TrimPrefix(job.JobName, instanceID), "++")[1]
-			return false
+// HasInstance returns true if any scrape job has targets labeled with the
+// given instance id, in the monitoring.InstanceIDLabel label, meaning the
+// instance is being monitored. With file service discovery, the target groups
+// are checked instead.
+func (p *PrometheusService) HasInstance(instanceID string) (bool, error) {
+	var found bool
+	err := p.stack.WithLock(func(s *data.LockedMonitoringStack) error {
+		if p.discovery == FileDiscovery {
+			groups, err := readTargetGroups(s)
+			if err != nil {
+				return err
+			}
+			for _, group := range groups {
+				if group.Labels[monitoring.InstanceIDLabel] == instanceID {
+					found = true
+					return nil
+				}
+			}
+			return nil
 		}
+		config, err := readConfig(s, filepath.Join("prometheus", "prometheus.yml"))
+		if err != nil {
+			return err
+		}
+		for _, job := range config.ScrapeConfigs {
+			for _, staticConfig := range job.StaticConfigs {
+				if staticConfig.Labels[monitoring.InstanceIDLabel] == instanceID {
+					found = true
+					return nil
+				}
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+// isInstanceJob returns true if the job is a job of the instance, added by
+// AddInstanceTargets or by AddTarget for one of its containers or networks.
+func isInstanceJob(jobName, instanceID string) bool {
+	return jobName == instanceID || strings.HasPrefix(jobName, instanceID+"--") || strings.HasPrefix(jobName, instanceID+"++")
+}
+
+// isInstanceScrapeConfig returns true if the scrape job is a job of the
+// instance, by its name or, for the jobs named by PROM_JOB_NAME_TEMPLATE, by
+// its instance id label.
+func isInstanceScrapeConfig(job ScrapeConfig, instanceID string) bool {
+	if isInstanceJob(job.JobName, instanceID) {
 		return true
-	}).([]ScrapeConfig)
+	}
+	for _, staticConfig := range job.StaticConfigs {
+		if staticConfig.Labels[monitoring.InstanceIDLabel] == instanceID {
+			return true
+		}
+	}
+	return false
+}
 
-	// Check if the target was removed
-	if network == "" {
-		// The target was not removed because it was not in the targets
-		return "", fmt.Errorf("%w: %s", monitoring.ErrNonexistingTarget, instanceID)
+// isInstanceTargetGroup is like isInstanceScrapeConfig, for a target group of
+// the targets file.
+func isInstanceTargetGroup(group TargetGroup, instanceID string) bool {
+	return isInstanceJob(group.Labels["job"], instanceID) || group.Labels[monitoring.InstanceIDLabel] == instanceID
+}
+
+// instanceLabels returns the labels with the instance id label set, checking
+// the reserved label names and that an instance id label matches instanceID.
+func instanceLabels(instanceID string, labels map[string]string) (map[string]string, error) {
+	merged := make(map[string]string, len(labels)+1)
+	for name, value := range labels {
+		if strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return nil, fmt.Errorf("%w: %q is reserved by Prometheus", ErrInvalidLabel, name)
+		}
+		if name == monitoring.InstanceIDLabel && value != instanceID {
+			return nil, fmt.Errorf("%w: %s label %q doesn't match the instance id %q", ErrInvalidLabel, name, value, instanceID)
+		}
+		merged[name] = value
 	}
+	merged[monitoring.InstanceIDLabel] = instanceID
+	return merged, nil
+}
 
-	// Marshal the updated config back to YAML
-	newConfig, err := yaml.Marshal(&config)
+// RemoveTarget removes a target from the Prometheus config and reloads the Prometheus configuration.
+func (p *PrometheusService) RemoveTarget(instanceID string) (string, error) {
+	network, _, err := p.removeTarget(instanceID, false)
+	return network, err
+}
+
+// RemoveTargetIfExists is like RemoveTarget, but a missing target is not an
+// error. The configuration is only reloaded if the target was removed, which
+// is reported by the returned bool.
+func (p *PrometheusService) RemoveTargetIfExists(instanceID string) (string, bool, error) {
+	return p.removeTarget(instanceID, true)
+}
+
+func (p *PrometheusService) removeTarget(instanceID string, ifExists bool) (string, bool, error) {
+	if p.discovery == FileDiscovery {
+		return p.removeFileSDTarget(instanceID, ifExists)
+	}
+	var (
+		network     string
+		removed     bool
+		removedJobs int
+	)
+	err := p.stack.WithLock(func(s *data.LockedMonitoringStack) error {
+		_, err := p.updateConfig(s, func(config *Config) error {
+			// Remove the target from the jobs
+			jobs := funk.Filter(config.ScrapeConfigs, func(job ScrapeConfig) bool {
+				if isInstanceScrapeConfig(job, instanceID) {
+					network, removed = jobNetwork(job.JobName, instanceID), true
+					return false
+				}
+				return true
+			}).([]ScrapeConfig)
+			removedJobs = len(config.ScrapeConfigs) - len(jobs)
+			config.ScrapeConfigs = jobs
+
+			// Check if the target was removed
+			if !removed && !ifExists {
+				// The target was not removed because it was not in the targets
+				return fmt.Errorf("%w: %s", monitoring.ErrNonexistingTarget, instanceID)
+			}
+			return nil
+		})
+		if err != nil || !removed {
+			return err
+		}
+		p.targetsRemoved(removedJobs)
+		// Remove the scrape secrets of the instance
+		return s.RemoveAll(filepath.Join(secretsDir, instanceID))
+	})
 	if err != nil {
-		return network, err
+		return network, false, err
 	}
-
-	// Write the updated YAML data back to the file
-	if err = p.stack.WriteFile(path, newConfig); err != nil {
-		return network, err
+	if !removed {
+		return "", false, nil
 	}
 
 	// Reload the config
 	if err = p.reloadConfig(); err != nil {
-		return network, err
+		return network, true, err
 	}
 
-	return network, nil
+	return network, true, nil
+}
+
+// jobNetwork returns the network in the name of a job of the instance, which
+// follows ++. Jobs added by AddInstanceTargets have no network.
+func jobNetwork(jobName, instanceID string) string {
+	_, network, _ := strings.Cut(strings.TrimPrefix(jobName, instanceID), "++")
+	return network
+}
+
+// readConfig reads the Prometheus config at path in the locked monitoring
+// stack. A missing or empty config returns ErrConfigMissing, as the stack
+// needs to be set up again.
+func readConfig(s *data.LockedMonitoringStack, path string) (Config, error) {
+	var config Config
+	rawConfig, err := s.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return config, fmt.Errorf("%w: %s not found, set up the monitoring stack again", ErrConfigMissing, path)
+		}
+		return config, err
+	}
+	if len(bytes.TrimSpace(rawConfig)) == 0 {
+		return config, fmt.Errorf("%w: %s is empty, set up the monitoring stack again", ErrConfigMissing, path)
+	}
+
+	// Unmarshal the YAML data into the Config struct
+	if err = yaml.Unmarshal(rawConfig, &config); err != nil {
+		return config, err
+	}
+	return config, nil
 }
 
 // DotEnv returns the dotenv variables and default values for the Prometheus service.
@@ -205,21 +542,32 @@ func (p *PrometheusService) Setup(options map[string]string) error {
 	} else if nodeExporterPort == "" {
 		return fmt.Errorf("%w: %s can't be empty", ErrInvalidOptions, "NODE_EXPORTER_PORT")
 	}
+	if port, err := strconv.ParseUint(nodeExporterPort, 10, 16); err != nil || port == 0 {
+		return fmt.Errorf("%w: %s must be a port between 1 and 65535", ErrInvalidOptions, "NODE_EXPORTER_PORT")
+	}
 
-	// Read config from the embedded FS
-	rawConfig, err := config.ReadFile("config/prometheus.yml")
+	templateData, err := newConfigTemplateData(options)
 	if err != nil {
 		return err
 	}
 
-	// Unmarshal the YAML data into the Config struct
-	var config Config
-	if err = yaml.Unmarshal(rawConfig, &config); err != nil {
+	// Render the config template from the embedded FS
+	rawTemplate, err := config.ReadFile("config/prometheus.yml")
+	if err != nil {
+		return err
+	}
+	config, err := renderConfig(rawTemplate, templateData)
+	if err != nil {
 		return err
 	}
 
 	// Add node exporter target
 	endpoint := fmt.Sprintf("%s:%s", monitoring.NodeExporterContainerName, options["NODE_EXPORTER_PORT"])
+	if p.probeNodeExporter {
+		if err = p.probeEndpoint(endpoint); err != nil {
+			return err
+		}
+	}
 	config.ScrapeConfigs = []ScrapeConfig{
 		{
 			JobName: endpoint,
@@ -230,6 +578,9 @@ func (p *PrometheusService) Setup(options map[string]string) error {
 			},
 		},
 	}
+	if p.discovery == FileDiscovery {
+		config.ScrapeConfigs = append(config.ScrapeConfigs, fileSDScrapeConfig())
+	}
 
 	// Marshal the updated config back to YAML
 	newConfig, err := yaml.Marshal(&config)
@@ -237,17 +588,93 @@ func (p *PrometheusService) Setup(options map[string]string) error {
 		return err
 	}
 
-	// Create config directory
-	if err = p.stack.CreateDir("prometheus"); err != nil {
+	// Create config directory, with the scrape secrets directory
+	if err = p.stack.CreateDir(secretsDir); err != nil {
 		return err
 	}
 
-	// Write the updated YAML data to datadir
-	if err = p.stack.WriteFile("prometheus/prometheus.yml", newConfig); err != nil {
-		return err
+	// Write the config, with an empty targets file for file service discovery,
+	// all or nothing
+	files := map[string][]byte{
+		"prometheus/prometheus.yml": newConfig,
 	}
+	if p.discovery == FileDiscovery {
+		files[fileSDTargetsPath] = []byte("[]")
+	}
+	return p.stack.WriteFiles(files)
+}
 
-	return nil
+// SetScrapeInterval sets the global scrape interval of Prometheus, used by the
+// jobs without their own. It must be between 1s and 1h, in whole milliseconds.
+// The configuration is only reloaded if the interval changed.
+func (p *PrometheusService) SetScrapeInterval(d time.Duration) error {
+	if d < minScrapeInterval || d > maxScrapeInterval {
+		return fmt.Errorf("%w: %s must be between %s and %s", ErrInvalidScrapeInterval, d, minScrapeInterval, maxScrapeInterval)
+	}
+	if d%time.Millisecond != 0 {
+		return fmt.Errorf("%w: %s must be in whole milliseconds", ErrInvalidScrapeInterval, d)
+	}
+	interval := model.Duration(d).String()
+
+	_, err := p.editConfig(func(_ *data.LockedMonitoringStack, config *Config) error {
+		config.Global.ScrapeInterval = interval
+		return nil
+	})
+	return err
+}
+
+// SetProbeNodeExporter enables or disables checking the node exporter endpoint
+// is reachable during Setup. It is disabled by default, so setups without
+// network access keep working.
+func (p *PrometheusService) SetProbeNodeExporter(probe bool) {
+	p.probeNodeExporter = probe
+}
+
+// SetConfigChecker sets the checker used by CheckConfig. A nil checker means
+// promtool, which is the default.
+func (p *PrometheusService) SetConfigChecker(checker ConfigChecker) {
+	p.checker = checker
+}
+
+// SetMetrics sets the metrics recorder for the Prometheus service. A nil
+// recorder disables the metrics, which is the default.
+func (p *PrometheusService) SetMetrics(metrics Metrics) {
+	p.metrics = metrics
+}
+
+// targetsAdded records n targets added to the config or the targets file.
+func (p *PrometheusService) targetsAdded(n int) {
+	for i := 0; p.metrics != nil && i < n; i++ {
+		p.metrics.TargetAdded()
+	}
+}
+
+// targetsRemoved records n targets removed from the config or the targets
+// file.
+func (p *PrometheusService) targetsRemoved(n int) {
+	for i := 0; p.metrics != nil && i < n; i++ {
+		p.metrics.TargetRemoved()
+	}
+}
+
+func (p *PrometheusService) setTargets(count int) {
+	if p.metrics != nil {
+		p.metrics.SetTargets(count)
+	}
+}
+
+// SetHTTPClient sets the client of the HTTP requests of the service, like the
+// config reloads, to set their timeout, proxy or TLS settings. A nil client
+// means a client with a 10s timeout, which is the default.
+func (p *PrometheusService) SetHTTPClient(client *http.Client) {
+	p.httpClient = client
+}
+
+func (p *PrometheusService) client() *http.Client {
+	if p.httpClient != nil {
+		return p.httpClient
+	}
+	return defaultHTTPClient
 }
 
 // SetContainerIP sets the container IP for the Prometheus service.
@@ -263,28 +690,22 @@ func (p *PrometheusService) Endpoint() string {
 	return fmt.Sprintf("http://%s:%d", p.containerIP, p.port)
 }
 
-// reloadConfig reloads the Prometheus config by making a POST request to the /-/reload endpoint
-func (p *PrometheusService) reloadConfig() error {
-	// Adding exponential retry
-	b := backoff.NewExponentialBackOff()
-	b.MaxElapsedTime = time.Minute
-
-	err := backoff.Retry(func() (err error) {
-		resp, err := http.Post(fmt.Sprintf("http://%s:%d/-/reload", p.containerIP, p.port), "", nil)
-		if err != nil {
-			// TODO: Use fields to log the error
-			log.Debug("Retrying request...")
-			return err
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			// TODO: Use fields to log the error
-			log.Debug("Retrying request...")
-			return fmt.Errorf("%w: %s", ErrReloadFailed, resp.Status)
-		}
-		return nil
-	}, b)
-
-	return err
+// probeEndpoint checks the metrics endpoint at the given host:port answers
+// within nodeExporterProbeTimeout.
+func (p *PrometheusService) probeEndpoint(endpoint string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), nodeExporterProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/metrics", endpoint), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNodeExporterUnreachable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", ErrNodeExporterUnreachable, resp.Status)
+	}
+	return nil
 }
@@ -5,15 +5,21 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/NethermindEth/eigenlayer/internal/data"
+	"github.com/NethermindEth/eigenlayer/internal/locker"
 	"github.com/NethermindEth/eigenlayer/internal/locker/mocks"
 	"github.com/NethermindEth/eigenlayer/pkg/monitoring"
 	"github.com/NethermindEth/eigenlayer/pkg/monitoring/services/types"
 	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -195,6 +201,44 @@ func TestSetup(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name:   "node exporter port out of range",
+			mocker: onlyNewLocker,
+			options: map[string]string{
+				"PROM_PORT":          "9999",
+				"NODE_EXPORTER_PORT": "65536",
+			},
+			wantErr: true,
+		},
+		{
+			name:   "zero node exporter port",
+			mocker: onlyNewLocker,
+			options: map[string]string{
+				"PROM_PORT":          "9999",
+				"NODE_EXPORTER_PORT": "0",
+			},
+			wantErr: true,
+		},
+		{
+			name:   "invalid node exporter port",
+			mocker: onlyNewLocker,
+			options: map[string]string{
+				"PROM_PORT":          "9999",
+				"NODE_EXPORTER_PORT": "91OO",
+			},
+			wantErr: true,
+		},
+		{
+			name:   "max node exporter port",
+			mocker: okLocker,
+			options: map[string]string{
+				"PROM_PORT":          "9999",
+				"NODE_EXPORTER_PORT": "65535",
+			},
+			targets: []string{
+				fmt.Sprintf("%s:65535", monitoring.NodeExporterContainerName),
+			},
+		},
 		{
 			name: "lock error",
 			mocker: func(t *testing.T) *mocks.MockLocker {
@@ -297,7 +341,7 @@ func TestAddTarget(t *testing.T) {
 			locker.EXPECT().Locked().Return(true),
 			locker.EXPECT().Unlock().Return(nil),
 		)
-		for i := 0; i < times*2+1; i++ {
+		for i := 0; i < times+1; i++ {
 			gomock.InOrder(
 				locker.EXPECT().Lock().Return(nil),
 				locker.EXPECT().Locked().Return(true),
@@ -748,7 +792,7 @@ func TestRemoveTarget(t *testing.T) {
 			locker.EXPECT().Locked().Return(true),
 			locker.EXPECT().Unlock().Return(nil),
 		)
-		for i := 0; i < times*2+1; i++ {
+		for i := 0; i < times+1; i++ {
 			gomock.InOrder(
 				locker.EXPECT().Lock().Return(nil),
 				locker.EXPECT().Locked().Return(true),
@@ -1091,6 +1135,85 @@ func TestRemoveTarget(t *testing.T) {
 	}
 }
 
+func TestAddTargetConcurrent(t *testing.T) {
+	// Two data dirs on the same directory, with their own file locks, like two
+	// processes sharing the monitoring stack: only the file lock serializes the
+	// changes made through different stacks.
+	afs := afero.NewOsFs()
+	dataDirPath := t.TempDir()
+	options := map[string]string{
+		"PROM_PORT":          "9999",
+		"NODE_EXPORTER_PORT": "9100",
+	}
+
+	// Setup mock http server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	split := strings.Split(server.URL, ":")
+	host, port := split[1][2:], split[2]
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	services := make([]*PrometheusService, 2)
+	for i := range services {
+		dataDir, err := data.NewDataDir(dataDirPath, afs, locker.NewFLock())
+		require.NoError(t, err)
+		stack, err := dataDir.MonitoringStack()
+		require.NoError(t, err)
+		services[i] = NewPrometheus()
+		err = services[i].Init(types.ServiceOptions{
+			Stack:  stack,
+			Dotenv: options,
+		})
+		require.NoError(t, err)
+		services[i].containerIP = net.ParseIP(host)
+		services[i].port = uint16(p)
+	}
+	err = services[0].Setup(options)
+	require.NoError(t, err)
+
+	// Add the targets concurrently, through both stacks
+	const targets = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, targets)
+	for i := 0; i < targets; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			target := types.MonitoringTarget{Host: "localhost", Port: uint16(8000 + i)}
+			errs <- services[i%2].AddTarget(target, nil, fmt.Sprintf("test-avs-%d++testnet", i))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	// Read the prom.yml file
+	var prom Config
+	promYml, err := afero.ReadFile(afs, filepath.Join(dataDirPath, "monitoring", "prometheus", "prometheus.yml"))
+	require.NoError(t, err)
+	err = yaml.Unmarshal(promYml, &prom)
+	require.NoError(t, err)
+
+	// Check that no job was lost, node exporter job included
+	assert.Len(t, prom.ScrapeConfigs, targets+1)
+	for i := 0; i < targets; i++ {
+		jobName := fmt.Sprintf("test-avs-%d++testnet", i)
+		found := false
+		for _, job := range prom.ScrapeConfigs {
+			if job.JobName == jobName {
+				found = true
+				break
+			}
+		}
+		assert.True(t, found, jobName)
+	}
+}
+
 func TestSetContainerIP(t *testing.T) {
 	tests := []struct {
 		name string
@@ -1140,3 +1263,806 @@ func TestEndpoint(t *testing.T) {
 	endpoint := prometheus.Endpoint()
 	assert.Equal(t, want, endpoint)
 }
+
+func TestMetrics(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	options := map[string]string{
+		"PROM_PORT":          "9999",
+		"NODE_EXPORTER_PORT": "9100",
+	}
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: options,
+	})
+	require.NoError(t, err)
+	err = prometheus.Setup(options)
+	require.NoError(t, err)
+	metrics := NewCollectorMetrics()
+	prometheus.SetMetrics(metrics)
+
+	// Setup mock http server, failing reloads on demand
+	var failReload atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failReload.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	split := strings.Split(server.URL, ":")
+	host, port := split[1][2:], split[2]
+	prometheus.containerIP = net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	prometheus.port = uint16(p)
+
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8000}, nil, "test-avs-1++testnet")
+	require.NoError(t, err)
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8001}, nil, "test-avs-2++testnet")
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.targetsAdded))
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.targets))
+
+	// Invalid targets and targets of existing jobs are not counted
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8000, Scheme: "ftp"}, nil, "test-avs-4++testnet")
+	require.ErrorIs(t, err, ErrUnsupportedScheme)
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8000}, nil, "test-avs-1++testnet")
+	require.NoError(t, err)
+	err = prometheus.AddInstanceTargets("test-avs-4", []string{"localhost"}, nil)
+	require.ErrorIs(t, err, types.ErrInvalidMonitoringTarget)
+	err = prometheus.AddInstanceTargets("test-avs-1++testnet", []string{"localhost:8000"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.targetsAdded))
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.targets))
+
+	_, err = prometheus.RemoveTarget("test-avs-1")
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.targetsRemoved))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.targets))
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.reloads.WithLabelValues("success")))
+
+	// Missing targets and targets of existing jobs are not counted
+	_, err = prometheus.RemoveTarget("test-avs-1")
+	require.ErrorIs(t, err, monitoring.ErrNonexistingTarget)
+	_, removed, err := prometheus.RemoveTargetIfExists("test-avs-1")
+	require.NoError(t, err)
+	assert.False(t, removed)
+	err = prometheus.Apply(TargetChangeSet{Add: []TargetAdd{{Target: types.MonitoringTarget{Host: "localhost", Port: 8001}, JobName: "test-avs-2++testnet"}}})
+	require.NoError(t, err)
+	err = prometheus.Apply(TargetChangeSet{Remove: []string{"test-avs-1"}})
+	require.ErrorIs(t, err, monitoring.ErrNonexistingTarget)
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.targetsAdded))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.targetsRemoved))
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.reloads.WithLabelValues("success")))
+
+	// Simulate a reload failure
+	defer func(d time.Duration) { reloadMaxElapsedTime = d }(reloadMaxElapsedTime)
+	reloadMaxElapsedTime = 100 * time.Millisecond
+	failReload.Store(true)
+	_, err = prometheus.RemoveTarget("test-avs-2")
+	require.ErrorIs(t, err, ErrReloadFailed)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.reloads.WithLabelValues("failure")))
+
+	// Metrics are optional
+	prometheus.SetMetrics(nil)
+	failReload.Store(false)
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8002}, nil, "test-avs-3++testnet")
+	require.NoError(t, err)
+}
+
+func TestAddTargetRelabel(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	options := map[string]string{
+		"PROM_PORT":          "9999",
+		"NODE_EXPORTER_PORT": "9100",
+	}
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: options,
+	})
+	require.NoError(t, err)
+	err = prometheus.Setup(options)
+	require.NoError(t, err)
+
+	// Setup mock http server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	split := strings.Split(server.URL, ":")
+	host, port := split[1][2:], split[2]
+	prometheus.containerIP = net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	prometheus.port = uint16(p)
+
+	relabel := []RelabelConfig{
+		{SourceLabels: []string{"__address__"}, TargetLabel: "instance", Replacement: "mock-avs"},
+	}
+	metricRelabel := []RelabelConfig{
+		{SourceLabels: []string{"__name__"}, Regex: "go_gc_.*", Action: "drop"},
+	}
+	target := types.MonitoringTarget{
+		Host:                 "localhost",
+		Port:                 8000,
+		RelabelConfigs:       relabel,
+		MetricRelabelConfigs: metricRelabel,
+	}
+	err = prometheus.AddTarget(target, nil, "test-avs++testnet")
+	require.NoError(t, err)
+
+	promYml, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+	require.NoError(t, err)
+	assert.Contains(t, string(promYml), "relabel_configs:")
+	assert.Contains(t, string(promYml), "action: drop")
+
+	// Check the rules round-trip through the config file
+	var prom Config
+	err = yaml.Unmarshal(promYml, &prom)
+	require.NoError(t, err)
+	require.Len(t, prom.ScrapeConfigs, 2)
+	assert.Equal(t, relabel, prom.ScrapeConfigs[1].RelabelConfigs)
+	assert.Equal(t, metricRelabel, prom.ScrapeConfigs[1].MetricRelabelConfigs)
+	remarshaled, err := yaml.Marshal(&prom)
+	require.NoError(t, err)
+	assert.Equal(t, string(promYml), string(remarshaled))
+}
+
+func TestAddTargetScheme(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		wantScheme string
+		wantPath   string
+		wantErr    error
+	}{
+		{
+			name:       "http",
+			url:        "http://localhost:8000/metrics",
+			wantScheme: "http",
+			wantPath:   "/metrics",
+		},
+		{
+			name:       "https",
+			url:        "https://localhost:8443/custom/metrics",
+			wantScheme: "https",
+			wantPath:   "/custom/metrics",
+		},
+		{
+			name:    "unsupported scheme",
+			url:     "unix://localhost:8000/metrics",
+			wantErr: ErrUnsupportedScheme,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock locker
+			ctrl := gomock.NewController(t)
+			locker := mocks.NewMockLocker(ctrl)
+			locker.EXPECT().New("/monitoring/.lock").Return(locker)
+			locker.EXPECT().Lock().Return(nil).AnyTimes()
+			locker.EXPECT().Locked().Return(true).AnyTimes()
+			locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+			afs := afero.NewMemMapFs()
+			dataDir, err := data.NewDataDir("/", afs, locker)
+			require.NoError(t, err)
+			stack, err := dataDir.MonitoringStack()
+			require.NoError(t, err)
+
+			options := map[string]string{
+				"PROM_PORT":          "9999",
+				"NODE_EXPORTER_PORT": "9100",
+			}
+			prometheus := NewPrometheus()
+			err = prometheus.Init(types.ServiceOptions{
+				Stack:  stack,
+				Dotenv: options,
+			})
+			require.NoError(t, err)
+			err = prometheus.Setup(options)
+			require.NoError(t, err)
+
+			// Setup mock http server
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+			split := strings.Split(server.URL, ":")
+			host, port := split[1][2:], split[2]
+			prometheus.containerIP = net.ParseIP(host)
+			p, err := strconv.Atoi(port)
+			require.NoError(t, err)
+			prometheus.port = uint16(p)
+
+			target, err := types.ParseMonitoringTarget(tt.url)
+			require.NoError(t, err)
+			err = prometheus.AddTarget(target, nil, "test-avs++testnet")
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			promYml, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+			require.NoError(t, err)
+			var prom Config
+			err = yaml.Unmarshal(promYml, &prom)
+			require.NoError(t, err)
+			require.Len(t, prom.ScrapeConfigs, 2)
+			assert.Equal(t, tt.wantScheme, prom.ScrapeConfigs[1].Scheme)
+			assert.Equal(t, tt.wantPath, prom.ScrapeConfigs[1].MetricsPath)
+			assert.Equal(t, []string{target.Endpoint()}, prom.ScrapeConfigs[1].StaticConfigs[0].Targets)
+		})
+	}
+}
+
+func TestRemoveTargetIfExists(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	options := map[string]string{
+		"PROM_PORT":          "9999",
+		"NODE_EXPORTER_PORT": "9100",
+	}
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: options,
+	})
+	require.NoError(t, err)
+	err = prometheus.Setup(options)
+	require.NoError(t, err)
+
+	// Setup mock http server, counting the reloads
+	var reloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reloads.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	split := strings.Split(server.URL, ":")
+	host, port := split[1][2:], split[2]
+	prometheus.containerIP = net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	prometheus.port = uint16(p)
+
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8000}, nil, "test-avs++testnet")
+	require.NoError(t, err)
+	require.EqualValues(t, 1, reloads.Load())
+
+	// Present target
+	network, removed, err := prometheus.RemoveTargetIfExists("test-avs")
+	require.NoError(t, err)
+	assert.True(t, removed)
+	assert.Equal(t, "testnet", network)
+	assert.EqualValues(t, 2, reloads.Load())
+
+	// Absent target, already removed
+	network, removed, err = prometheus.RemoveTargetIfExists("test-avs")
+	require.NoError(t, err)
+	assert.False(t, removed)
+	assert.Empty(t, network)
+	assert.EqualValues(t, 2, reloads.Load(), "config reloaded without changes")
+
+	// The strict variant still fails
+	_, err = prometheus.RemoveTarget("test-avs")
+	assert.ErrorIs(t, err, monitoring.ErrNonexistingTarget)
+}
+
+func TestRemoveTargetPrefixInstanceID(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	options := map[string]string{
+		"PROM_PORT":          "9999",
+		"NODE_EXPORTER_PORT": "9100",
+	}
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: options,
+	})
+	require.NoError(t, err)
+	err = prometheus.Setup(options)
+	require.NoError(t, err)
+
+	// Setup mock http server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	split := strings.Split(server.URL, ":")
+	host, port := split[1][2:], split[2]
+	prometheus.containerIP = net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	prometheus.port = uint16(p)
+
+	// mock-avs-1 is a prefix of mock-avs-10
+	for i, instanceID := range []string{"mock-avs-1", "mock-avs-10"} {
+		target := types.MonitoringTarget{Host: "main", Port: uint16(8000 + i)}
+		err = prometheus.AddTarget(target, map[string]string{monitoring.InstanceIDLabel: instanceID}, instanceID+"--main++eigenlayer")
+		require.NoError(t, err)
+		require.NoError(t, prometheus.SetBearerToken(instanceID, "t0ken"))
+	}
+
+	network, err := prometheus.RemoveTarget("mock-avs-1")
+	require.NoError(t, err)
+	assert.Equal(t, "eigenlayer", network)
+
+	// The jobs and the secrets of mock-avs-10 are kept
+	promYml, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+	require.NoError(t, err)
+	var prom Config
+	require.NoError(t, yaml.Unmarshal(promYml, &prom))
+	require.Len(t, prom.ScrapeConfigs, 2)
+	assert.Equal(t, "mock-avs-10--main++eigenlayer", prom.ScrapeConfigs[1].JobName)
+	exists, err := afero.DirExists(afs, "/monitoring/prometheus/secrets/mock-avs-10")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = afero.DirExists(afs, "/monitoring/prometheus/secrets/mock-avs-1")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestAddTargetWithLabels(t *testing.T) {
+	tests := []struct {
+		name       string
+		labels     map[string]string
+		wantLabels map[string]string
+		wantErr    error
+	}{
+		{
+			name:   "custom labels",
+			labels: map[string]string{"network": "holesky", "region": "eu"},
+			wantLabels: map[string]string{
+				monitoring.InstanceIDLabel: "test-avs",
+				"network":                  "holesky",
+				"region":                   "eu",
+			},
+		},
+		{
+			name:       "no labels",
+			wantLabels: map[string]string{monitoring.InstanceIDLabel: "test-avs"},
+		},
+		{
+			name:       "matching instance id label",
+			labels:     map[string]string{monitoring.InstanceIDLabel: "test-avs"},
+			wantLabels: map[string]string{monitoring.InstanceIDLabel: "test-avs"},
+		},
+		{
+			name:    "other instance id label",
+			labels:  map[string]string{monitoring.InstanceIDLabel: "other-avs"},
+			wantErr: ErrInvalidLabel,
+		},
+		{
+			name:    "reserved label",
+			labels:  map[string]string{"__address__": "localhost:8000"},
+			wantErr: ErrInvalidLabel,
+		},
+		{
+			name:    "invalid label",
+			labels:  map[string]string{"bad-label": "value"},
+			wantErr: ErrInvalidLabel,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock locker
+			ctrl := gomock.NewController(t)
+			locker := mocks.NewMockLocker(ctrl)
+			locker.EXPECT().New("/monitoring/.lock").Return(locker)
+			locker.EXPECT().Lock().Return(nil).AnyTimes()
+			locker.EXPECT().Locked().Return(true).AnyTimes()
+			locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+			afs := afero.NewMemMapFs()
+			dataDir, err := data.NewDataDir("/", afs, locker)
+			require.NoError(t, err)
+			stack, err := dataDir.MonitoringStack()
+			require.NoError(t, err)
+
+			options := map[string]string{
+				"PROM_PORT":          "9999",
+				"NODE_EXPORTER_PORT": "9100",
+			}
+			prometheus := NewPrometheus()
+			err = prometheus.Init(types.ServiceOptions{
+				Stack:  stack,
+				Dotenv: options,
+			})
+			require.NoError(t, err)
+			err = prometheus.Setup(options)
+			require.NoError(t, err)
+
+			// Setup mock http server
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+			split := strings.Split(server.URL, ":")
+			host, port := split[1][2:], split[2]
+			prometheus.containerIP = net.ParseIP(host)
+			p, err := strconv.Atoi(port)
+			require.NoError(t, err)
+			prometheus.port = uint16(p)
+
+			target := types.MonitoringTarget{Host: "localhost", Port: 8000}
+			err = prometheus.AddTargetWithLabels(target, "test-avs", tt.labels, "test-avs++testnet")
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			promYml, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+			require.NoError(t, err)
+			var prom Config
+			err = yaml.Unmarshal(promYml, &prom)
+			require.NoError(t, err)
+			require.Len(t, prom.ScrapeConfigs, 2)
+			assert.Equal(t, tt.wantLabels, prom.ScrapeConfigs[1].StaticConfigs[0].Labels)
+		})
+	}
+}
+
+func TestAddInstanceTargets(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	options := map[string]string{
+		"PROM_PORT":          "9999",
+		"NODE_EXPORTER_PORT": "9100",
+	}
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: options,
+	})
+	require.NoError(t, err)
+	err = prometheus.Setup(options)
+	require.NoError(t, err)
+
+	// Setup mock http server, counting the reloads
+	var reloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reloads.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	split := strings.Split(server.URL, ":")
+	host, port := split[1][2:], split[2]
+	prometheus.containerIP = net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	prometheus.port = uint16(p)
+
+	readConfig := func() Config {
+		promYml, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+		require.NoError(t, err)
+		var prom Config
+		require.NoError(t, yaml.Unmarshal(promYml, &prom))
+		return prom
+	}
+
+	err = prometheus.AddInstanceTargets("test-avs", []string{"localhost:8000"}, map[string]string{"__address__": "x"})
+	assert.ErrorIs(t, err, ErrInvalidLabel)
+	err = prometheus.AddInstanceTargets("test-avs", []string{"localhost"}, nil)
+	assert.ErrorIs(t, err, types.ErrInvalidMonitoringTarget)
+	err = prometheus.AddInstanceTargets("test-avs", nil, nil)
+	assert.ErrorIs(t, err, types.ErrInvalidMonitoringTarget)
+
+	endpoints := []string{"node:8000", "sidecar:9000", "sidecar:9001"}
+	err = prometheus.AddInstanceTargets("test-avs", endpoints, map[string]string{"network": "holesky"})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, reloads.Load())
+
+	prom := readConfig()
+	require.Len(t, prom.ScrapeConfigs, 2)
+	job := prom.ScrapeConfigs[1]
+	assert.Equal(t, "test-avs", job.JobName)
+	assert.Equal(t, "/metrics", job.MetricsPath)
+	require.Len(t, job.StaticConfigs, 1)
+	assert.Equal(t, endpoints, job.StaticConfigs[0].Targets)
+	assert.Equal(t, map[string]string{
+		monitoring.InstanceIDLabel: "test-avs",
+		"network":                  "holesky",
+	}, job.StaticConfigs[0].Labels)
+
+	// A container job of the same instance is removed with the group
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8080}, nil, "test-avs--main++testnet")
+	require.NoError(t, err)
+	err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8081}, nil, "other-avs--main++testnet")
+	require.NoError(t, err)
+	require.Len(t, readConfig().ScrapeConfigs, 4)
+
+	removed, err := prometheus.RemoveTargetsByInstance("test-avs")
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	prom = readConfig()
+	require.Len(t, prom.ScrapeConfigs, 2)
+	assert.Equal(t, "other-avs--main++testnet", prom.ScrapeConfigs[1].JobName)
+
+	_, err = prometheus.RemoveTargetsByInstance("test-avs")
+	assert.ErrorIs(t, err, monitoring.ErrNonexistingTarget)
+
+	// RemoveTarget handles the grouped job, which has no network
+	err = prometheus.AddInstanceTargets("test-avs", endpoints, nil)
+	require.NoError(t, err)
+	network, err := prometheus.RemoveTarget("test-avs")
+	require.NoError(t, err)
+	assert.Empty(t, network)
+}
+
+func TestConfigMissing(t *testing.T) {
+	tests := []struct {
+		name   string
+		breaks func(t *testing.T, afs afero.Fs)
+	}{
+		{
+			name: "deleted",
+			breaks: func(t *testing.T, afs afero.Fs) {
+				require.NoError(t, afs.Remove("/monitoring/prometheus/prometheus.yml"))
+			},
+		},
+		{
+			name: "empty",
+			breaks: func(t *testing.T, afs afero.Fs) {
+				require.NoError(t, afero.WriteFile(afs, "/monitoring/prometheus/prometheus.yml", []byte("\n"), 0o644))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock locker
+			ctrl := gomock.NewController(t)
+			locker := mocks.NewMockLocker(ctrl)
+			locker.EXPECT().New("/monitoring/.lock").Return(locker)
+			locker.EXPECT().Lock().Return(nil).AnyTimes()
+			locker.EXPECT().Locked().Return(true).AnyTimes()
+			locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+			afs := afero.NewMemMapFs()
+			dataDir, err := data.NewDataDir("/", afs, locker)
+			require.NoError(t, err)
+			stack, err := dataDir.MonitoringStack()
+			require.NoError(t, err)
+
+			options := map[string]string{
+				"PROM_PORT":          "9999",
+				"NODE_EXPORTER_PORT": "9100",
+			}
+			prometheus := NewPrometheus()
+			err = prometheus.Init(types.ServiceOptions{
+				Stack:  stack,
+				Dotenv: options,
+			})
+			require.NoError(t, err)
+			err = prometheus.Setup(options)
+			require.NoError(t, err)
+			tt.breaks(t, afs)
+
+			err = prometheus.AddTarget(types.MonitoringTarget{Host: "localhost", Port: 8000}, nil, "test-avs++testnet")
+			assert.ErrorIs(t, err, ErrConfigMissing)
+			_, err = prometheus.RemoveTarget("test-avs")
+			assert.ErrorIs(t, err, ErrConfigMissing)
+
+			// Setting up again recovers
+			err = prometheus.Setup(options)
+			require.NoError(t, err)
+			_, _, err = prometheus.RemoveTargetIfExists("test-avs")
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestHasInstance(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: map[string]string{"PROM_PORT": "9999"},
+	})
+	require.NoError(t, err)
+
+	// No config yet
+	_, err = prometheus.HasInstance("mock-avs-default")
+	assert.ErrorIs(t, err, ErrConfigMissing)
+
+	promYml := `global:
+  scrape_interval: 15s
+scrape_configs:
+  - job_name: node-exporter:9100
+    static_configs:
+      - targets: [node-exporter:9100]
+  - job_name: mock-avs-default--main++eigenlayer
+    static_configs:
+      - targets: [main:8080]
+        labels:
+          instance_id: mock-avs-default
+  - job_name: other-avs-default
+    static_configs:
+      - targets: [other:8080, other:8081]
+        labels:
+          instance_id: other-avs-default
+          avs_name: other-avs
+`
+	require.NoError(t, afero.WriteFile(afs, "/monitoring/prometheus/prometheus.yml", []byte(promYml), 0o644))
+
+	tests := []struct {
+		instanceID string
+		want       bool
+	}{
+		{instanceID: "mock-avs-default", want: true},
+		{instanceID: "other-avs-default", want: true},
+		{instanceID: "mock-avs", want: false},
+		{instanceID: "missing-avs-default", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.instanceID, func(t *testing.T) {
+			got, err := prometheus.HasInstance(tt.instanceID)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSetScrapeInterval(t *testing.T) {
+	// Create a mock locker
+	ctrl := gomock.NewController(t)
+	locker := mocks.NewMockLocker(ctrl)
+	locker.EXPECT().New("/monitoring/.lock").Return(locker)
+	locker.EXPECT().Lock().Return(nil).AnyTimes()
+	locker.EXPECT().Locked().Return(true).AnyTimes()
+	locker.EXPECT().Unlock().Return(nil).AnyTimes()
+
+	afs := afero.NewMemMapFs()
+	dataDir, err := data.NewDataDir("/", afs, locker)
+	require.NoError(t, err)
+	stack, err := dataDir.MonitoringStack()
+	require.NoError(t, err)
+
+	options := map[string]string{
+		"PROM_PORT":          "9999",
+		"NODE_EXPORTER_PORT": "9100",
+	}
+	prometheus := NewPrometheus()
+	err = prometheus.Init(types.ServiceOptions{
+		Stack:  stack,
+		Dotenv: options,
+	})
+	require.NoError(t, err)
+	err = prometheus.Setup(options)
+	require.NoError(t, err)
+
+	// Setup mock http server, counting the reloads
+	var reloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reloads.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	split := strings.Split(server.URL, ":")
+	host, port := split[1][2:], split[2]
+	prometheus.containerIP = net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	prometheus.port = uint16(p)
+
+	readInterval := func() string {
+		promYml, err := afero.ReadFile(afs, "/monitoring/prometheus/prometheus.yml")
+		require.NoError(t, err)
+		var prom Config
+		require.NoError(t, yaml.Unmarshal(promYml, &prom))
+		require.Len(t, prom.ScrapeConfigs, 1, "scrape configs changed")
+		return prom.Global.ScrapeInterval
+	}
+	require.Equal(t, "15s", readInterval())
+
+	tests := []struct {
+		name     string
+		interval time.Duration
+		want     string
+		wantErr  error
+		reloads  int32
+	}{
+		{name: "seconds", interval: 30 * time.Second, want: "30s", reloads: 1},
+		{name: "minutes", interval: 90 * time.Second, want: "1m30s", reloads: 2},
+		{name: "unchanged", interval: 90 * time.Second, want: "1m30s", reloads: 2},
+		{name: "minimum", interval: time.Second, want: "1s", reloads: 3},
+		{name: "maximum", interval: time.Hour, want: "1h", reloads: 4},
+		{name: "zero", interval: 0, want: "1h", wantErr: ErrInvalidScrapeInterval, reloads: 4},
+		{name: "negative", interval: -time.Minute, want: "1h", wantErr: ErrInvalidScrapeInterval, reloads: 4},
+		{name: "too short", interval: 500 * time.Millisecond, want: "1h", wantErr: ErrInvalidScrapeInterval, reloads: 4},
+		{name: "too long", interval: 25 * time.Hour, want: "1h", wantErr: ErrInvalidScrapeInterval, reloads: 4},
+		{name: "sub millisecond", interval: time.Second + time.Microsecond, want: "1h", wantErr: ErrInvalidScrapeInterval, reloads: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := prometheus.SetScrapeInterval(tt.interval)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, readInterval())
+			assert.Equal(t, tt.reloads, reloads.Load())
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package types
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
 	"strconv"
 
 	"github.com/NethermindEth/eigenlayer/internal/data"
@@ -16,17 +19,83 @@ type ServiceOptions struct {
 	Dotenv map[string]string
 }
 
+// ReloadSignaler sends SIGHUP to the process of a monitoring service, for
+// instance through the container runtime, to reload its configuration.
+type ReloadSignaler interface {
+	SignalReload() error
+}
+
 type MonitoringTarget struct {
+	// Scheme is the scheme of the monitoring target endpoint, e.g. https. Empty
+	// means http.
+	Scheme string
 	// Host is the host of the monitoring target endpoint, e.g. localhost
 	Host string
 	// Port is the port of the monitoring target endpoint, e.g. 8080
 	Port uint16
 	// Path is the path of the monitoring target endpoint, e.g. /metrics
 	Path string
+	// RelabelConfigs are the relabeling rules applied to the target before
+	// scraping.
+	RelabelConfigs []RelabelConfig
+	// MetricRelabelConfigs are the relabeling rules applied to the scraped
+	// samples before ingestion, e.g. to drop metrics.
+	MetricRelabelConfigs []RelabelConfig
+}
+
+// RelabelConfig is a Prometheus relabeling rule. Empty fields take the
+// Prometheus defaults.
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels,flow,omitempty"`
+	Separator    string   `yaml:"separator,omitempty"`
+	Regex        string   `yaml:"regex,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty"`
+	Replacement  string   `yaml:"replacement,omitempty"`
+	Action       string   `yaml:"action,omitempty"`
+}
+
+// ErrInvalidMonitoringTarget is returned when a monitoring target URL can't be
+// parsed.
+var ErrInvalidMonitoringTarget = errors.New("invalid monitoring target")
+
+// ParseMonitoringTarget parses a monitoring target from a URL such as
+// https://localhost:8080/metrics, keeping its scheme and path. The port
+// defaults to the scheme default port for http and https. Whether the scheme
+// is supported is up to the monitoring service.
+func ParseMonitoringTarget(rawURL string) (MonitoringTarget, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return MonitoringTarget{}, fmt.Errorf("%w: %s", ErrInvalidMonitoringTarget, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return MonitoringTarget{}, fmt.Errorf("%w: %q is not an absolute URL", ErrInvalidMonitoringTarget, rawURL)
+	}
+	target := MonitoringTarget{
+		Scheme: u.Scheme,
+		Host:   u.Hostname(),
+		Path:   u.Path,
+	}
+	switch port := u.Port(); {
+	case port != "":
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return MonitoringTarget{}, fmt.Errorf("%w: invalid port %q", ErrInvalidMonitoringTarget, port)
+		}
+		target.Port = uint16(p)
+	case u.Scheme == "http":
+		target.Port = 80
+	case u.Scheme == "https":
+		target.Port = 443
+	}
+	return target, nil
 }
 
 func (t MonitoringTarget) String() string {
-	return t.Host + ":" + strconv.Itoa(int(t.Port)) + t.Path
+	s := t.Host + ":" + strconv.Itoa(int(t.Port)) + t.Path
+	if t.Scheme != "" {
+		s = t.Scheme + "://" + s
+	}
+	return s
 }
 
 func (t MonitoringTarget) Endpoint() string {